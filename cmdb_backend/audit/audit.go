@@ -0,0 +1,363 @@
+// Package audit 通过 GORM 回调记录每一次写入：谁在什么时候新建、修改或删除了哪一行，以及变化的列在写入前后的值
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"cmdb/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// SystemActor 为没有请求者的写入（后台任务、迁移以外的内部操作）记录的请求者
+const SystemActor = "system"
+
+// ignoredColumns 只记录时间戳、使用情况或由其他列推导出的列，单独变化时不产生审计记录
+var ignoredColumns = map[string]bool{
+	"idc_name":      true,
+	"created_at":    true,
+	"updated_at":    true,
+	"update_time":   true,
+	"last_used_at":  true,
+	"last_used_ip":  true,
+	"last_login_at": true,
+	"next_run_at":   true,
+}
+
+// redacted 替换不能出现在审计记录中的列（json:"-" 的密码哈希、令牌哈希等）的值
+const redacted = `"[redacted]"`
+
+type actorKey struct{}
+
+// WithActor 返回带有请求者的 context，通过 db.WithContext(ctx) 的写入记在 actor 名下
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor 返回 context 中的请求者。gin.Context 按字符串键读取请求中的值，认证中间件把请求者写在 actor 键中，
+// 所以 db.WithContext(c) 同样可以带上请求者。都没有时为 SystemActor
+func Actor(ctx context.Context) string {
+	if ctx == nil {
+		return SystemActor
+	}
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	if actor, ok := ctx.Value("actor").(string); ok && actor != "" {
+		return actor
+	}
+	return SystemActor
+}
+
+// Config 为审计的配置
+type Config struct {
+	// Exclude 中的表（采样数据、运行记录等本身就是历史的表）不记录审计日志
+	Exclude []string
+	// Versioned 中的表还在 row_versions 中保存每一行的历史版本，用于查询某一时刻的数据
+	Versioned []string
+}
+
+type auditor struct {
+	exclude   map[string]bool
+	versioned map[string]bool
+}
+
+// Register 注册审计回调。审计记录和历史版本与写入在同一个事务中，写入失败时一并回滚
+func Register(db *gorm.DB, config Config) error {
+	a := &auditor{
+		exclude:   map[string]bool{"audit_logs": true, "row_versions": true},
+		versioned: make(map[string]bool),
+	}
+	for _, table := range config.Exclude {
+		a.exclude[table] = true
+	}
+	for _, table := range config.Versioned {
+		a.versioned[table] = true
+	}
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().After("gorm:create").Before("gorm:commit_or_rollback_transaction").Register("audit:create", a.afterCreate),
+		cb.Update().After("gorm:begin_transaction").Before("gorm:update").Register("audit:before_update", a.snapshot),
+		cb.Update().After("gorm:update").Before("gorm:commit_or_rollback_transaction").Register("audit:update", a.afterUpdate),
+		cb.Delete().After("gorm:begin_transaction").Before("gorm:delete").Register("audit:before_delete", a.snapshot),
+		cb.Delete().After("gorm:delete").Before("gorm:commit_or_rollback_transaction").Register("audit:delete", a.afterDelete),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *auditor) enabled(db *gorm.DB) bool {
+	return db.Error == nil && db.Statement.Schema != nil && !a.exclude[db.Statement.Table]
+}
+
+// row 为一行的各列的值（JSON），id 为主键
+type row struct {
+	id     string
+	values map[string]json.RawMessage
+}
+
+func (a *auditor) afterCreate(db *gorm.DB) {
+	if !a.enabled(db) || db.Statement.RowsAffected == 0 {
+		return
+	}
+	var rows []row
+	eachStruct(db.Statement.ReflectValue, func(rv reflect.Value) {
+		// ON CONFLICT DO NOTHING 跳过的行没有主键
+		if r := readRow(db.Statement.Context, db.Statement.Schema, rv); r.id != "" {
+			rows = append(rows, r)
+		}
+	})
+	logs := make([]models.AuditLog, 0, len(rows))
+	for _, r := range rows {
+		logs = append(logs, newLog(db, ActionCreate, r.id, nil, r.values))
+	}
+	a.write(db, logs)
+	a.track(db, nil, rows)
+}
+
+// snapshot 在修改和删除之前读取将受影响的行
+func (a *auditor) snapshot(db *gorm.DB) {
+	if !a.enabled(db) {
+		return
+	}
+	rows, err := load(db, false)
+	if err != nil {
+		db.AddError(fmt.Errorf("audit: %w", err))
+		return
+	}
+	db.InstanceSet("audit:before", rows)
+}
+
+func (a *auditor) afterUpdate(db *gorm.DB) {
+	before, ok := a.before(db)
+	if !ok {
+		return
+	}
+	after, err := load(db, true, before...)
+	if err != nil {
+		db.AddError(fmt.Errorf("audit: %w", err))
+		return
+	}
+	current := make(map[string]row, len(after))
+	for _, r := range after {
+		current[r.id] = r
+	}
+	var logs []models.AuditLog
+	var changed, live []row
+	for _, old := range before {
+		if changedFrom, changedTo := diff(old.values, current[old.id].values); len(changedTo) > 0 {
+			logs = append(logs, newLog(db, ActionUpdate, old.id, changedFrom, changedTo))
+			changed = append(changed, old)
+			// 软删除后的行不再有当前版本
+			if r := current[old.id]; !softDeleted(r) {
+				live = append(live, r)
+			}
+		}
+	}
+	a.write(db, logs)
+	a.track(db, changed, live)
+}
+
+func (a *auditor) afterDelete(db *gorm.DB) {
+	before, ok := a.before(db)
+	if !ok {
+		return
+	}
+	logs := make([]models.AuditLog, 0, len(before))
+	for _, r := range before {
+		logs = append(logs, newLog(db, ActionDelete, r.id, r.values, nil))
+	}
+	a.write(db, logs)
+	a.track(db, before, nil)
+}
+
+func (a *auditor) before(db *gorm.DB) ([]row, bool) {
+	if !a.enabled(db) || db.Statement.RowsAffected == 0 {
+		return nil, false
+	}
+	v, ok := db.InstanceGet("audit:before")
+	if !ok {
+		return nil, false
+	}
+	rows := v.([]row)
+	return rows, len(rows) > 0
+}
+
+func (a *auditor) write(db *gorm.DB, logs []models.AuditLog) {
+	if len(logs) == 0 {
+		return
+	}
+	if err := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Create(&logs).Error; err != nil {
+		db.AddError(fmt.Errorf("audit: %w", err))
+	}
+}
+
+func newLog(db *gorm.DB, action, id string, before, after map[string]json.RawMessage) models.AuditLog {
+	// 统一保存为 UTC，按时间范围查询时可以直接比较
+	log := models.AuditLog{
+		CreatedAt: time.Now().UTC(),
+		Actor:     Actor(db.Statement.Context),
+		Action:    action,
+		Entity:    db.Statement.Table,
+		EntityID:  id,
+	}
+	if before != nil {
+		log.Before = encode(before)
+	}
+	if after != nil {
+		log.After = encode(after)
+	}
+	return log
+}
+
+func encode(values map[string]json.RawMessage) string {
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+// load 读取语句涉及的行。reload 为 false 时按语句的条件（模型的主键和 WHERE）读取修改前的行，
+// 为 true 时按 rows 的主键重新读取修改后的行（包括刚被软删除的）
+func load(db *gorm.DB, reload bool, rows ...row) ([]row, error) {
+	stmt := db.Statement
+	primary := stmt.Schema.PrioritizedPrimaryField
+	if primary == nil {
+		return nil, nil
+	}
+	// 软删除的条件由这里按语句是否 Unscoped 自行添加
+	query := db.Session(&gorm.Session{NewDB: true, SkipHooks: true}).Table(stmt.Table).Unscoped()
+	if reload {
+		ids := make([]string, len(rows))
+		for i, r := range rows {
+			ids[i] = r.id
+		}
+		query = query.Where(clause.IN{Column: clause.Column{Name: primary.DBName}, Values: toValues(ids)})
+	} else {
+		conditions := 0
+		if where, ok := stmt.Clauses["WHERE"]; ok {
+			query = query.Clauses(where.Expression)
+			conditions++
+		}
+		var ids []interface{}
+		eachStruct(stmt.ReflectValue, func(rv reflect.Value) {
+			if id, zero := primary.ValueOf(stmt.Context, rv); !zero {
+				ids = append(ids, id)
+			}
+		})
+		if len(ids) > 0 {
+			query = query.Where(clause.IN{Column: clause.Column{Name: primary.DBName}, Values: ids})
+			conditions++
+		}
+		// 没有条件的语句会被 GORM 拒绝，不必读取整张表
+		if conditions == 0 {
+			return nil, nil
+		}
+		if !stmt.Unscoped {
+			if field := stmt.Schema.LookUpField("deleted_at"); field != nil && field.FieldType == reflect.TypeOf(gorm.DeletedAt{}) {
+				query = query.Where(clause.Eq{Column: clause.Column{Name: "deleted_at"}, Value: nil})
+			}
+		}
+	}
+
+	dest := reflect.New(reflect.SliceOf(stmt.Schema.ModelType))
+	if err := query.Find(dest.Interface()).Error; err != nil {
+		return nil, err
+	}
+	result := make([]row, 0, dest.Elem().Len())
+	for i := 0; i < dest.Elem().Len(); i++ {
+		result = append(result, readRow(stmt.Context, stmt.Schema, dest.Elem().Index(i)))
+	}
+	return result, nil
+}
+
+func toValues(ids []string) []interface{} {
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+	return values
+}
+
+// readRow 读取结构体中各列的值
+func readRow(ctx context.Context, s *schema.Schema, rv reflect.Value) row {
+	r := row{values: make(map[string]json.RawMessage, len(s.DBNames))}
+	for _, name := range s.DBNames {
+		field := s.FieldsByDBName[name]
+		value, zero := field.ValueOf(ctx, rv)
+		if field == s.PrioritizedPrimaryField {
+			if !zero {
+				r.id = fmt.Sprint(value)
+			}
+		}
+		if field.Tag.Get("json") == "-" && isSecret(field) {
+			r.values[name] = json.RawMessage(redacted)
+			if zero {
+				r.values[name] = json.RawMessage(`""`)
+			}
+			continue
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			data = []byte(fmt.Sprintf("%q", fmt.Sprint(value)))
+		}
+		r.values[name] = data
+	}
+	return r
+}
+
+// plainHiddenColumns 为不输出到 JSON 但不含敏感信息的列（内部使用的参数、对象 key 等），审计日志照常记录
+var plainHiddenColumns = map[string]bool{
+	"params":             true,
+	"content_key":        true,
+	"snapshot_key":       true,
+	"active_fingerprint": true,
+}
+
+// isSecret 判断不输出到 JSON 的列是否需要隐藏：除 plainHiddenColumns 外都按密钥或哈希处理，
+// 新增的 json:"-" 列默认不会以明文进入审计日志和历史版本
+func isSecret(field *schema.Field) bool {
+	return !plainHiddenColumns[field.DBName]
+}
+
+// diff 返回 after 相对 before 变化的列在前后的值，忽略只记录时间和使用情况的列
+func diff(before, after map[string]json.RawMessage) (map[string]json.RawMessage, map[string]json.RawMessage) {
+	from, to := map[string]json.RawMessage{}, map[string]json.RawMessage{}
+	for name, old := range before {
+		if ignoredColumns[name] {
+			continue
+		}
+		if current, ok := after[name]; ok && string(current) != string(old) {
+			from[name], to[name] = old, current
+		}
+	}
+	return from, to
+}
+
+// eachStruct 对 rv（结构体、结构体切片或数组）中的每个结构体调用 fn
+func eachStruct(rv reflect.Value, fn func(reflect.Value)) {
+	switch rv.Kind() {
+	case reflect.Struct:
+		fn(rv)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			elem := reflect.Indirect(rv.Index(i))
+			if elem.Kind() == reflect.Struct {
+				fn(elem)
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"cmdb/models"
+
+	"gorm.io/gorm"
+)
+
+// track 维护版本表：closed 中的行结束当前版本，opened 中的行开始新版本
+func (a *auditor) track(db *gorm.DB, closed, opened []row) {
+	if !a.versioned[db.Statement.Table] || db.Error != nil || len(closed)+len(opened) == 0 {
+		return
+	}
+	tx := db.Session(&gorm.Session{NewDB: true, SkipHooks: true})
+	if err := saveVersions(tx, db.Statement.Table, closed, opened, time.Now().UTC()); err != nil {
+		db.AddError(fmt.Errorf("audit: %w", err))
+	}
+}
+
+func saveVersions(tx *gorm.DB, entity string, closed, opened []row, now time.Time) error {
+	if len(closed) > 0 {
+		ids := make([]string, len(closed))
+		for i, r := range closed {
+			ids[i] = r.id
+		}
+		err := tx.Model(&models.RowVersion{}).Where("entity = ? AND entity_id IN ? AND valid_to IS NULL", entity, ids).
+			Update("valid_to", now).Error
+		if err != nil {
+			return err
+		}
+	}
+	if len(opened) == 0 {
+		return nil
+	}
+	versions := make([]models.RowVersion, 0, len(opened))
+	for _, r := range opened {
+		versions = append(versions, models.RowVersion{Entity: entity, EntityID: r.id, ValidFrom: now, Data: encode(r.values)})
+	}
+	return tx.CreateInBatches(&versions, 200).Error
+}
+
+// softDeleted 判断行是否已被 gorm.DeletedAt 软删除
+func softDeleted(r row) bool {
+	value, ok := r.values["deleted_at"]
+	return ok && string(value) != "null"
+}
+
+// Baseline 为 values（模型指针）对应的表中还没有当前版本的行补上从现在开始的版本，返回补上的行数。
+// 启用版本记录之前已经存在的行从这时起才有历史
+func Baseline(db *gorm.DB, values ...interface{}) (int, error) {
+	total := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+		for _, value := range values {
+			stmt := &gorm.Statement{DB: tx}
+			if err := stmt.Parse(value); err != nil {
+				return err
+			}
+			var open []string
+			if err := tx.Model(&models.RowVersion{}).Where("entity = ? AND valid_to IS NULL", stmt.Table).
+				Pluck("entity_id", &open).Error; err != nil {
+				return err
+			}
+			tracked := make(map[string]bool, len(open))
+			for _, id := range open {
+				tracked[id] = true
+			}
+
+			dest := reflect.New(reflect.SliceOf(stmt.Schema.ModelType))
+			if err := tx.Table(stmt.Table).Find(dest.Interface()).Error; err != nil {
+				return err
+			}
+			var missing []row
+			for i := 0; i < dest.Elem().Len(); i++ {
+				if r := readRow(tx.Statement.Context, stmt.Schema, dest.Elem().Index(i)); r.id != "" && !tracked[r.id] {
+					missing = append(missing, r)
+				}
+			}
+			if err := saveVersions(tx, stmt.Table, nil, missing, now); err != nil {
+				return err
+			}
+			total += len(missing)
+		}
+		return nil
+	})
+	return total, err
+}
+
+// AsOf 返回查询 tables 中在 at 时刻有效的版本的语句，调用方可以再用 Value 按版本中的列追加条件
+func AsOf(db *gorm.DB, at time.Time, tables ...string) *gorm.DB {
+	return db.Model(&models.RowVersion{}).
+		Where("entity IN ? AND valid_from <= ? AND (valid_to IS NULL OR valid_to > ?)", tables, at.UTC(), at.UTC())
+}
+
+// Versions 返回 tables 中在 at 时刻有效的版本，同一行按开始时间先后排列
+func Versions(db *gorm.DB, at time.Time, tables ...string) ([]models.RowVersion, error) {
+	var versions []models.RowVersion
+	err := AsOf(db, at, tables...).Order("valid_from, id").Find(&versions).Error
+	return versions, err
+}
+
+// Changes 返回 tables 中在 from 和 to 之间有过写入的行在两个时刻的版本：before 为 from 时有效、to 之前结束的版本，
+// after 为 to 时有效、from 之后开始的版本。两个时刻版本相同的行都不返回，同一行按开始时间先后排列
+func Changes(db *gorm.DB, from, to time.Time, tables ...string) (before, after []models.RowVersion, err error) {
+	err = AsOf(db, from, tables...).Where("valid_to <= ?", to.UTC()).Order("valid_from, id").Find(&before).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	err = AsOf(db, to, tables...).Where("valid_from > ?", from.UTC()).Order("valid_from, id").Find(&after).Error
+	return before, after, err
+}
+
+// Value 返回版本中 column 列的值的 SQL 表达式，字符串为去掉引号的文本。column 只能是模型的列名，不能来自用户输入
+func Value(db *gorm.DB, column string) string {
+	if db.Dialector.Name() == "mysql" {
+		return fmt.Sprintf("JSON_UNQUOTE(JSON_EXTRACT(data, '$.%s'))", column)
+	}
+	return fmt.Sprintf("json_extract(data, '$.%s')", column)
+}
+
+// Decode 把版本中保存的列值填入 dest，dest 为版本所属的表对应的模型指针
+func Decode(db *gorm.DB, version models.RowVersion, dest interface{}) error {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(dest); err != nil {
+		return err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(version.Data), &values); err != nil {
+		return fmt.Errorf("decode %s %s: %w", version.Entity, version.EntityID, err)
+	}
+	rv := reflect.ValueOf(dest).Elem()
+	for name, raw := range values {
+		field := stmt.Schema.LookUpField(name)
+		if field == nil || string(raw) == redacted {
+			continue
+		}
+		value := reflect.New(field.FieldType)
+		if err := json.Unmarshal(raw, value.Interface()); err != nil {
+			return fmt.Errorf("decode %s %s column %s: %w", version.Entity, version.EntityID, name, err)
+		}
+		if err := field.Set(context.Background(), rv, value.Elem().Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Diff 返回 after 相对 before 变化的列在前后的值，忽略只记录时间和使用情况的列
+func Diff(before, after json.RawMessage) (map[string]json.RawMessage, map[string]json.RawMessage, error) {
+	var from, to map[string]json.RawMessage
+	if err := json.Unmarshal(before, &from); err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(after, &to); err != nil {
+		return nil, nil, err
+	}
+	changedFrom, changedTo := diff(from, to)
+	return changedFrom, changedTo, nil
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 这里只实现 LDAP 用到的 BER 子集：单字节标签、定长编码
+
+// 通用标签
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+	berSet         = 0x31
+)
+
+// berMaxLength 为单个报文的长度上限，防止异常的服务器让我们分配过多内存
+const berMaxLength = 16 << 20
+
+type berElement struct {
+	tag     byte
+	content []byte
+}
+
+func berEncode(tag byte, content []byte) []byte {
+	n := len(content)
+	var length []byte
+	switch {
+	case n < 0x80:
+		length = []byte{byte(n)}
+	case n <= 0xff:
+		length = []byte{0x81, byte(n)}
+	case n <= 0xffff:
+		length = []byte{0x82, byte(n >> 8), byte(n)}
+	default:
+		length = []byte{0x83, byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	out := make([]byte, 0, 1+len(length)+n)
+	out = append(out, tag)
+	out = append(out, length...)
+	return append(out, content...)
+}
+
+func berConstructed(tag byte, children ...[]byte) []byte {
+	var content []byte
+	for _, c := range children {
+		content = append(content, c...)
+	}
+	return berEncode(tag, content)
+}
+
+func berString(tag byte, s string) []byte {
+	return berEncode(tag, []byte(s))
+}
+
+func berInt(tag byte, n int) []byte {
+	// 最小的二进制补码表示
+	var content []byte
+	for {
+		content = append([]byte{byte(n)}, content...)
+		if (n >= -128 && n < 128) || len(content) == 8 {
+			break
+		}
+		n >>= 8
+	}
+	return berEncode(tag, content)
+}
+
+func berBool(b bool) []byte {
+	if b {
+		return berEncode(berBoolean, []byte{0xff})
+	}
+	return berEncode(berBoolean, []byte{0})
+}
+
+// berRead 从流中读取一个完整的元素
+func berRead(r *bufio.Reader) (berElement, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return berElement{}, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return berElement{}, err
+	}
+	n := int(first)
+	if first&0x80 != 0 {
+		size := int(first & 0x7f)
+		if size == 0 || size > 3 {
+			return berElement{}, fmt.Errorf("ber: unsupported length encoding 0x%02x", first)
+		}
+		n = 0
+		for i := 0; i < size; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return berElement{}, err
+			}
+			n = n<<8 | int(b)
+		}
+	}
+	if n > berMaxLength {
+		return berElement{}, fmt.Errorf("ber: element of %d bytes is too large", n)
+	}
+	content := make([]byte, n)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return berElement{}, err
+	}
+	return berElement{tag: tag, content: content}, nil
+}
+
+// children 解析构造类型元素中的子元素
+func (e berElement) children() ([]berElement, error) {
+	var list []berElement
+	data := e.content
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, errors.New("ber: truncated element")
+		}
+		tag, first := data[0], data[1]
+		data = data[2:]
+		n := int(first)
+		if first&0x80 != 0 {
+			size := int(first & 0x7f)
+			if size == 0 || size > 3 || len(data) < size {
+				return nil, errors.New("ber: invalid length")
+			}
+			n = 0
+			for _, b := range data[:size] {
+				n = n<<8 | int(b)
+			}
+			data = data[size:]
+		}
+		if n > len(data) {
+			return nil, errors.New("ber: truncated element")
+		}
+		list = append(list, berElement{tag: tag, content: data[:n]})
+		data = data[n:]
+	}
+	return list, nil
+}
+
+func (e berElement) int() int {
+	if len(e.content) == 0 {
+		return 0
+	}
+	n := int(int8(e.content[0]))
+	for _, b := range e.content[1:] {
+		n = n<<8 | int(b)
+	}
+	return n
+}
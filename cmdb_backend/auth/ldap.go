@@ -0,0 +1,429 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LDAP 通过目录服务验证用户：先用服务账号（BindDN 为空时匿名）按 UserFilter 查找用户，
+// 再用找到的 DN 和用户输入的密码绑定。URL 为 ldap://host:389 或 ldaps://host:636，
+// ldap:// 连接在 StartTLS 为 true 时升级为 TLS
+type LDAP struct {
+	URL          string
+	StartTLS     bool
+	TLS          *tls.Config
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter 中的 %s 替换为转义后的用户名，例如 (&(objectClass=person)(uid=%s))
+	UserFilter      string
+	DisplayNameAttr string
+	EmailAttr       string
+	Timeout         time.Duration
+}
+
+// LDAP 协议中的操作标签
+const (
+	ldapBindRequest       = 0x60
+	ldapBindResponse      = 0x61
+	ldapUnbindRequest     = 0x42
+	ldapSearchRequest     = 0x63
+	ldapSearchResultEntry = 0x64
+	ldapSearchResultDone  = 0x65
+	ldapSearchResultRef   = 0x73
+	ldapExtendedRequest   = 0x77
+	ldapExtendedResponse  = 0x78
+)
+
+const (
+	ldapResultSuccess            = 0
+	ldapResultInvalidCredentials = 49
+	ldapStartTLSOID              = "1.3.6.1.4.1.1466.20037"
+)
+
+// LDAPError 为服务器返回的非成功结果
+type LDAPError struct {
+	Code    int
+	Message string
+}
+
+func (e *LDAPError) Error() string {
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// Authenticate 验证用户名和密码，用户不存在、不唯一或密码错误时返回 ErrInvalidCredentials
+func (l *LDAP) Authenticate(username, password string) (*Identity, error) {
+	// 空密码的简单绑定在 LDAP 中是匿名绑定，会被大多数服务器当作成功
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := l.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	if l.BindDN != "" {
+		if err := conn.bind(l.BindDN, l.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap service account bind: %w", err)
+		}
+	}
+	filter, err := parseLDAPFilter(fmt.Sprintf(l.UserFilter, EscapeLDAPFilter(username)))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := conn.search(l.BaseDN, filter, []string{l.DisplayNameAttr, l.EmailAttr})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := entries[0]
+
+	err = conn.bind(entry.dn, password)
+	var ldapErr *LDAPError
+	if errors.As(err, &ldapErr) && ldapErr.Code == ldapResultInvalidCredentials {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{
+		Provider:    ProviderLDAP,
+		Subject:     username,
+		Username:    username,
+		DisplayName: entry.first(l.DisplayNameAttr),
+		Email:       entry.first(l.EmailAttr),
+	}, nil
+}
+
+type ldapConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	id   int
+}
+
+type ldapEntry struct {
+	dn    string
+	attrs map[string][]string
+}
+
+func (e ldapEntry) first(attr string) string {
+	for name, values := range e.attrs {
+		if strings.EqualFold(name, attr) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+func (l *LDAP) dial() (*ldapConn, error) {
+	u, err := url.Parse(l.URL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	tlsConfig := l.TLS
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = u.Hostname()
+	}
+	dialer := &net.Dialer{Timeout: l.Timeout}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(host, "636")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(host, "389")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	default:
+		return nil, fmt.Errorf("ldap: unsupported url scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 整个验证过程共用一个超时
+	if l.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(l.Timeout))
+	}
+	c := &ldapConn{conn: conn, r: bufio.NewReader(conn)}
+
+	if u.Scheme == "ldap" && l.StartTLS {
+		if err := c.startTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap starttls: %w", err)
+		}
+	}
+	return c, nil
+}
+
+func (c *ldapConn) close() {
+	c.send(berEncode(ldapUnbindRequest, nil))
+	c.conn.Close()
+}
+
+// send 把操作包装为 LDAPMessage 发送，返回消息 ID
+func (c *ldapConn) send(op []byte) (int, error) {
+	c.id++
+	_, err := c.conn.Write(berConstructed(berSequence, berInt(berInteger, c.id), op))
+	return c.id, err
+}
+
+// receive 读取一条对 id 的响应，返回其中的操作
+func (c *ldapConn) receive(id int) (berElement, error) {
+	for {
+		msg, err := berRead(c.r)
+		if err != nil {
+			return berElement{}, err
+		}
+		parts, err := msg.children()
+		if err != nil || len(parts) < 2 || parts[0].tag != berInteger {
+			return berElement{}, errors.New("ldap: malformed message")
+		}
+		// 消息 ID 为 0 的是服务器主动发出的通知（例如断开连接），直接按错误处理
+		if parts[0].int() == 0 {
+			return berElement{}, ldapResult(parts[1])
+		}
+		if parts[0].int() == id {
+			return parts[1], nil
+		}
+	}
+}
+
+// ldapResult 把 LDAPResult 转换为错误，成功时返回 nil
+func ldapResult(op berElement) error {
+	parts, err := op.children()
+	if err != nil || len(parts) < 3 || parts[0].tag != berEnumerated {
+		return errors.New("ldap: malformed result")
+	}
+	if code := parts[0].int(); code != ldapResultSuccess {
+		return &LDAPError{Code: code, Message: string(parts[2].content)}
+	}
+	return nil
+}
+
+func (c *ldapConn) startTLS(config *tls.Config) error {
+	id, err := c.send(berConstructed(ldapExtendedRequest, berString(0x80, ldapStartTLSOID)))
+	if err != nil {
+		return err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != ldapExtendedResponse {
+		return fmt.Errorf("unexpected response 0x%02x", op.tag)
+	}
+	if err := ldapResult(op); err != nil {
+		return err
+	}
+	tlsConn := tls.Client(c.conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	c.conn, c.r = tlsConn, bufio.NewReader(tlsConn)
+	return nil
+}
+
+func (c *ldapConn) bind(dn, password string) error {
+	id, err := c.send(berConstructed(ldapBindRequest,
+		berInt(berInteger, 3),
+		berString(berOctetString, dn),
+		berString(0x80, password),
+	))
+	if err != nil {
+		return err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != ldapBindResponse {
+		return fmt.Errorf("ldap: unexpected response 0x%02x to bind", op.tag)
+	}
+	return ldapResult(op)
+}
+
+// search 在 base 下整个子树中查找，最多返回两条，调用方只需要判断是否唯一
+func (c *ldapConn) search(base string, filter []byte, attrs []string) ([]ldapEntry, error) {
+	var attrList [][]byte
+	for _, a := range attrs {
+		if a != "" {
+			attrList = append(attrList, berString(berOctetString, a))
+		}
+	}
+	id, err := c.send(berConstructed(ldapSearchRequest,
+		berString(berOctetString, base),
+		berInt(berEnumerated, 2), // wholeSubtree
+		berInt(berEnumerated, 0), // neverDerefAliases
+		berInt(berInteger, 2),
+		berInt(berInteger, 0),
+		berBool(false),
+		filter,
+		berConstructed(berSequence, attrList...),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ldapEntry
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case ldapSearchResultEntry:
+			entry, err := parseLDAPEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case ldapSearchResultRef:
+		case ldapSearchResultDone:
+			err := ldapResult(op)
+			var ldapErr *LDAPError
+			// sizeLimitExceeded 说明匹配到多个用户，已经收到的条目足以判断
+			if errors.As(err, &ldapErr) && ldapErr.Code == 4 {
+				err = nil
+			}
+			return entries, err
+		default:
+			return nil, fmt.Errorf("ldap: unexpected response 0x%02x to search", op.tag)
+		}
+	}
+}
+
+func parseLDAPEntry(op berElement) (ldapEntry, error) {
+	parts, err := op.children()
+	if err != nil || len(parts) != 2 {
+		return ldapEntry{}, errors.New("ldap: malformed search entry")
+	}
+	entry := ldapEntry{dn: string(parts[0].content), attrs: make(map[string][]string)}
+	attrs, err := parts[1].children()
+	if err != nil {
+		return ldapEntry{}, err
+	}
+	for _, attr := range attrs {
+		fields, err := attr.children()
+		if err != nil || len(fields) != 2 {
+			return ldapEntry{}, errors.New("ldap: malformed attribute")
+		}
+		values, err := fields[1].children()
+		if err != nil {
+			return ldapEntry{}, err
+		}
+		name := string(fields[0].content)
+		for _, v := range values {
+			entry.attrs[name] = append(entry.attrs[name], string(v.content))
+		}
+	}
+	return entry, nil
+}
+
+// EscapeLDAPFilter 按 RFC 4515 转义过滤条件中的值
+func EscapeLDAPFilter(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// parseLDAPFilter 把字符串形式的过滤条件编码为 BER，支持 &、|、!、相等和存在（attr=*）条件
+func parseLDAPFilter(s string) ([]byte, error) {
+	filter, rest, err := parseFilterAt(s)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: unexpected %q after filter", rest)
+	}
+	return filter, nil
+}
+
+func parseFilterAt(s string) ([]byte, string, error) {
+	if len(s) < 2 || s[0] != '(' {
+		return nil, "", fmt.Errorf("ldap: filter must start with '(': %q", s)
+	}
+	switch s[1] {
+	case '&', '|', '!':
+		tag := map[byte]byte{'&': 0xa0, '|': 0xa1, '!': 0xa2}[s[1]]
+		rest := s[2:]
+		var children [][]byte
+		for len(rest) > 0 && rest[0] == '(' {
+			child, next, err := parseFilterAt(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			children = append(children, child)
+			rest = next
+		}
+		if len(rest) == 0 || rest[0] != ')' || len(children) == 0 || (s[1] == '!' && len(children) != 1) {
+			return nil, "", fmt.Errorf("ldap: invalid filter %q", s)
+		}
+		return berConstructed(tag, children...), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("ldap: unterminated filter %q", s)
+	}
+	attr, value, ok := strings.Cut(s[1:end], "=")
+	if !ok || attr == "" || strings.ContainsAny(attr, "<>~:") {
+		return nil, "", fmt.Errorf("ldap: unsupported filter item %q", s[:end+1])
+	}
+	if value == "*" {
+		return berString(0x87, attr), s[end+1:], nil
+	}
+	if strings.Contains(value, "*") {
+		return nil, "", fmt.Errorf("ldap: substring filters are not supported: %q", s[:end+1])
+	}
+	raw, err := unescapeLDAPFilter(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return berConstructed(0xa3, berString(berOctetString, attr), berString(berOctetString, raw)), s[end+1:], nil
+}
+
+func unescapeLDAPFilter(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("ldap: invalid escape in %q", s)
+		}
+		n, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("ldap: invalid escape in %q", s)
+		}
+		b.WriteByte(byte(n))
+		i += 2
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testBaseDN    = "ou=people,dc=example,dc=com"
+	testServiceDN = "cn=cmdb,dc=example,dc=com"
+)
+
+// ldapStandIn 为本机的 LDAP 替身，只实现 Authenticate 用到的简单绑定、子树搜索（相等、存在和 &|! 条件）和解绑。
+// 与真实服务器一样，空密码的绑定按匿名绑定处理并返回成功
+type ldapStandIn struct {
+	net.Listener
+	entries   map[string]map[string][]string // dn -> 属性
+	passwords map[string]string              // dn -> 密码
+
+	mu     sync.Mutex
+	conns  int
+	binds  []string // 绑定的 DN
+	values []string // 搜索条件中相等比较的值
+}
+
+func newLDAPStandIn(t *testing.T) *ldapStandIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ldapStandIn{
+		Listener: ln,
+		entries: map[string]map[string][]string{
+			"uid=alice," + testBaseDN: {"objectClass": {"person"}, "uid": {"alice"}, "cn": {"Alice Liu"}, "mail": {"alice@example.com"}},
+			"uid=bob," + testBaseDN:   {"objectClass": {"person"}, "uid": {"bob"}, "cn": {"Bob"}},
+			"uid=ops1," + testBaseDN:  {"objectClass": {"person"}, "uid": {"ops(1)*"}, "cn": {"Ops"}},
+		},
+		passwords: map[string]string{
+			testServiceDN:             "service-secret",
+			"uid=alice," + testBaseDN: "alice-secret",
+			"uid=bob," + testBaseDN:   "bob-secret",
+			"uid=ops1," + testBaseDN:  "ops-secret",
+		},
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *ldapStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(id int, ops ...[]byte) {
+		for _, op := range ops {
+			conn.Write(berConstructed(berSequence, berInt(berInteger, id), op))
+		}
+	}
+	result := func(tag byte, code int, msg string) []byte {
+		return berConstructed(tag, berInt(berEnumerated, code), berString(berOctetString, ""), berString(berOctetString, msg))
+	}
+	for {
+		msg, err := berRead(r)
+		if err != nil {
+			return
+		}
+		parts, err := msg.children()
+		if err != nil || len(parts) < 2 {
+			return
+		}
+		id, op := parts[0].int(), parts[1]
+		fields, err := op.children()
+		if err != nil {
+			return
+		}
+		switch op.tag {
+		case ldapBindRequest:
+			dn, password := string(fields[1].content), string(fields[2].content)
+			s.mu.Lock()
+			s.binds = append(s.binds, dn)
+			s.mu.Unlock()
+			want, ok := s.passwords[dn]
+			if password == "" || (ok && password == want) {
+				reply(id, result(ldapBindResponse, ldapResultSuccess, ""))
+			} else {
+				reply(id, result(ldapBindResponse, ldapResultInvalidCredentials, "invalid credentials"))
+			}
+		case ldapSearchRequest:
+			base, sizeLimit, filter := string(fields[0].content), fields[3].int(), fields[6]
+			var ops [][]byte
+			code := ldapResultSuccess
+			for dn, attrs := range s.entries {
+				if !strings.HasSuffix(dn, ","+base) || !s.match(filter, attrs) {
+					continue
+				}
+				if len(ops) == sizeLimit {
+					code = 4 // sizeLimitExceeded
+					break
+				}
+				var list [][]byte
+				for name, values := range attrs {
+					var vals [][]byte
+					for _, v := range values {
+						vals = append(vals, berString(berOctetString, v))
+					}
+					list = append(list, berConstructed(berSequence, berString(berOctetString, name), berConstructed(berSet, vals...)))
+				}
+				ops = append(ops, berConstructed(ldapSearchResultEntry, berString(berOctetString, dn), berConstructed(berSequence, list...)))
+			}
+			reply(id, append(ops, result(ldapSearchResultDone, code, ""))...)
+		case ldapUnbindRequest:
+			return
+		}
+	}
+}
+
+// match 计算 BER 编码的搜索条件
+func (s *ldapStandIn) match(filter berElement, attrs map[string][]string) bool {
+	children, _ := filter.children()
+	switch filter.tag {
+	case 0xa0, 0xa1:
+		for _, c := range children {
+			if s.match(c, attrs) != (filter.tag == 0xa0) {
+				return filter.tag != 0xa0
+			}
+		}
+		return filter.tag == 0xa0
+	case 0xa2:
+		return !s.match(children[0], attrs)
+	case 0x87:
+		return len(attrs[string(filter.content)]) > 0
+	case 0xa3:
+		value := string(children[1].content)
+		s.mu.Lock()
+		s.values = append(s.values, value)
+		s.mu.Unlock()
+		for _, v := range attrs[string(children[0].content)] {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// seen 返回连接数、绑定的 DN 和最后一次相等比较的值
+func (s *ldapStandIn) seen() (int, []string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var last string
+	if len(s.values) > 0 {
+		last = s.values[len(s.values)-1]
+	}
+	return s.conns, append([]string(nil), s.binds...), last
+}
+
+func (s *ldapStandIn) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns, s.binds, s.values = 0, nil, nil
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	srv := newLDAPStandIn(t)
+	l := &LDAP{
+		URL:             "ldap://" + srv.Addr().String(),
+		BindDN:          testServiceDN,
+		BindPassword:    "service-secret",
+		BaseDN:          testBaseDN,
+		UserFilter:      "(&(objectClass=person)(uid=%s))",
+		DisplayNameAttr: "cn",
+		EmailAttr:       "mail",
+		Timeout:         5 * time.Second,
+	}
+
+	id, err := l.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Provider: ProviderLDAP, Subject: "alice", Username: "alice", DisplayName: "Alice Liu", Email: "alice@example.com"}
+	if *id != want {
+		t.Errorf("identity = %+v, want %+v", *id, want)
+	}
+	if _, binds, _ := srv.seen(); strings.Join(binds, "|") != testServiceDN+"|uid=alice,"+testBaseDN {
+		t.Errorf("binds = %q", binds)
+	}
+
+	// 用户名中的特殊字符经过转义后按原样比较
+	if id, err := l.Authenticate("ops(1)*", "ops-secret"); err != nil || id.DisplayName != "Ops" {
+		t.Errorf("ops(1)*: %+v, %v", id, err)
+	}
+
+	for _, tc := range []struct{ name, username, password string }{
+		{"wrong password", "alice", "bob-secret"},
+		{"unknown user", "carol", "alice-secret"},
+		// 注入的条件只作为 uid 的值，匹配不到任何用户；不转义时会匹配所有用户
+		{"wildcard", "*", "alice-secret"},
+		{"injection", "*)(uid=*", "alice-secret"},
+		{"injection with or", "alice)(|(uid=*", "alice-secret"},
+	} {
+		srv.reset()
+		if _, err := l.Authenticate(tc.username, tc.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s: err = %v, want ErrInvalidCredentials", tc.name, err)
+		}
+		if _, _, got := srv.seen(); got != tc.username {
+			t.Errorf("%s: server compared uid with %q, want %q", tc.name, got, tc.username)
+		}
+	}
+
+	// 空密码在服务器上是匿名绑定，必须在连接之前拒绝
+	srv.reset()
+	for _, username := range []string{"alice", ""} {
+		if _, err := l.Authenticate(username, ""); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("empty password for %q: err = %v", username, err)
+		}
+	}
+	if conns, _, _ := srv.seen(); conns != 0 {
+		t.Errorf("empty password opened %d connections", conns)
+	}
+
+	// 服务账号密码错误时不当作用户密码错误
+	l.BindPassword = "wrong"
+	if _, err := l.Authenticate("alice", "alice-secret"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("service account bind failure: err = %v", err)
+	}
+}
+
+func TestLDAPFilterEscaping(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"alice", "alice"},
+		{"*", `\2a`},
+		{"a(b)c", `a\28b\29c`},
+		{`back\slash`, `back\5cslash`},
+		{"nul\x00", `nul\00`},
+		{"*)(uid=*", `\2a\29\28uid=\2a`},
+		{"张三", "张三"},
+	} {
+		got := EscapeLDAPFilter(tc.in)
+		if got != tc.want {
+			t.Errorf("EscapeLDAPFilter(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+		if raw, err := unescapeLDAPFilter(got); err != nil || raw != tc.in {
+			t.Errorf("unescapeLDAPFilter(%q) = %q, %v, want %q", got, raw, err, tc.in)
+		}
+		// 转义后的值总是构成一个相等条件
+		filter, err := parseLDAPFilter("(uid=" + got + ")")
+		if err != nil {
+			t.Errorf("parseLDAPFilter(%q): %v", got, err)
+			continue
+		}
+		if filter[0] != 0xa3 {
+			t.Errorf("(uid=%s): tag 0x%02x, want an equality match", got, filter[0])
+		}
+	}
+
+	for _, s := range []string{`\`, `\2`, `\zz`, `a\2`} {
+		if _, err := unescapeLDAPFilter(s); err == nil {
+			t.Errorf("unescapeLDAPFilter(%q): no error", s)
+		}
+	}
+	// 未转义的输入会改变条件的结构，解析器拒绝不支持或不完整的条件
+	for _, s := range []string{"(uid=a*)", "(uid=*)(cn=x)", "(&(uid=a)", "uid=a", "(uid~=a)", "(!(uid=a)(cn=b))", "(&)"} {
+		if _, err := parseLDAPFilter(s); err == nil {
+			t.Errorf("parseLDAPFilter(%q): no error", s)
+		}
+	}
+}
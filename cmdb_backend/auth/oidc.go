@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDC 实现 OpenID Connect 授权码流程：用户在身份提供方登录后带着 code 回到 RedirectURL，
+// 用 code 换取 ID 令牌并校验签名（RS256）、签发方、受众、有效期和 nonce。
+// 提供方的地址和公钥从 Issuer 下的 /.well-known/openid-configuration 发现并缓存
+type OIDC struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// UsernameClaim 为作为用户名的声明，例如 preferred_username 或 email
+	UsernameClaim string
+	Client        *http.Client
+
+	mu          sync.Mutex
+	meta        *oidcMetadata
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// AuthCodeURL 返回把用户重定向到身份提供方登录的地址
+func (o *OIDC) AuthCodeURL(state, nonce string) (string, error) {
+	meta, err := o.metadata()
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.ClientID},
+		"redirect_uri":  {o.RedirectURL},
+		"scope":         {strings.Join(o.Scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange 用授权码换取并校验 ID 令牌，nonce 为发起登录时生成的值
+func (o *OIDC) Exchange(code, nonce string, now time.Time) (*Identity, error) {
+	meta, err := o.metadata()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {o.RedirectURL},
+	}
+	req, err := http.NewRequest(http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("oidc: token endpoint returned status %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return nil, fmt.Errorf("oidc: token endpoint returned status %d: %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+
+	claims, err := o.verifyIDToken(body.IDToken, meta.Issuer, nonce, now)
+	if err != nil {
+		return nil, err
+	}
+	subject, _ := claims["sub"].(string)
+	username, _ := claims[o.UsernameClaim].(string)
+	if subject == "" || username == "" {
+		return nil, fmt.Errorf("oidc: id token has no sub or %s claim", o.UsernameClaim)
+	}
+	name, _ := claims["name"].(string)
+	email, _ := claims["email"].(string)
+	return &Identity{Provider: ProviderOIDC, Subject: subject, Username: username, DisplayName: name, Email: email}, nil
+}
+
+func (o *OIDC) verifyIDToken(raw, issuer, nonce string, now time.Time) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: malformed id token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.New("oidc: malformed id token header")
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("oidc: unsupported id token algorithm %q", header.Alg)
+	}
+	key, err := o.publicKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("oidc: malformed id token signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("oidc: invalid id token signature")
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.New("oidc: malformed id token claims")
+	}
+	if iss, _ := claims["iss"].(string); iss != issuer {
+		return nil, fmt.Errorf("oidc: id token issuer %q does not match %q", iss, issuer)
+	}
+	var audience []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audience = []string{aud}
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audience = append(audience, s)
+			}
+		}
+	}
+	if !slices.Contains(audience, o.ClientID) {
+		return nil, errors.New("oidc: id token was not issued for this client")
+	}
+	exp, _ := claims["exp"].(float64)
+	if now.Unix() >= int64(exp) {
+		return nil, ErrTokenExpired
+	}
+	if n, _ := claims["nonce"].(string); n == "" || n != nonce {
+		return nil, errors.New("oidc: id token nonce does not match")
+	}
+	return claims, nil
+}
+
+func (o *OIDC) metadata() (*oidcMetadata, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.meta != nil {
+		return o.meta, nil
+	}
+	var meta oidcMetadata
+	if err := o.getJSON(strings.TrimSuffix(o.Issuer, "/")+"/.well-known/openid-configuration", &meta); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if meta.Issuer != o.Issuer || meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery: incomplete metadata for issuer %q", meta.Issuer)
+	}
+	o.meta = &meta
+	return o.meta, nil
+}
+
+// publicKey 返回签名公钥，遇到未知的 kid 时重新获取 JWKS（提供方轮换了密钥），但每分钟最多一次
+func (o *OIDC) publicKey(kid string) (*rsa.PublicKey, error) {
+	meta, err := o.metadata()
+	if err != nil {
+		return nil, err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(o.keysFetched) < time.Minute {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := o.getJSON(meta.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+	o.keysFetched = time.Now()
+	o.keys = make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		o.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+}
+
+func (o *OIDC) getJSON(url string, v any) error {
+	resp, err := o.Client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testIssuer = "https://idp.example.com"
+
+// signRS256 以 RS256 签名 ID 令牌，header 中的 alg 和 kid 由调用方给出
+func signRS256(t *testing.T, key *rsa.PrivateKey, header, claims map[string]any) string {
+	t.Helper()
+	encode := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 预置发现结果和公钥，刚获取过 JWKS 时遇到未知 kid 不会重新请求
+	o := &OIDC{
+		Issuer:      testIssuer,
+		ClientID:    "cmdb",
+		meta:        &oidcMetadata{Issuer: testIssuer},
+		keys:        map[string]*rsa.PublicKey{"k1": &key.PublicKey},
+		keysFetched: time.Now(),
+	}
+	now := time.Unix(1700000000, 0)
+
+	header := func(alg, kid string) map[string]any { return map[string]any{"alg": alg, "kid": kid, "typ": "JWT"} }
+	claims := func(edit func(map[string]any)) map[string]any {
+		c := map[string]any{"iss": testIssuer, "aud": "cmdb", "sub": "u1", "nonce": "n1",
+			"iat": now.Unix() - 10, "exp": now.Unix() + 300}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+	valid := signRS256(t, key, header("RS256", "k1"), claims(nil))
+	hs256 := signHS256(`{"alg":"HS256","kid":"k1"}`, `{"iss":"`+testIssuer+`","aud":"cmdb","sub":"u1","nonce":"n1","exp":1700000300}`, []byte("secret"))
+	parts := strings.Split(valid, ".")
+
+	cases := []struct {
+		name  string
+		token string
+		want  string // 错误信息中的片段，为空表示校验通过
+	}{
+		{"valid", valid, ""},
+		{"audience list", signRS256(t, key, header("RS256", "k1"), claims(func(c map[string]any) { c["aud"] = []string{"other", "cmdb"} })), ""},
+		{"alg HS256", hs256, "unsupported id token algorithm"},
+		{"alg none", base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"k1"}`)) + "." + parts[1] + ".", "unsupported id token algorithm"},
+		{"unknown kid", signRS256(t, key, header("RS256", "k2"), claims(nil)), "unknown signing key"},
+		{"signed by another key", signRS256(t, other, header("RS256", "k1"), claims(nil)), "invalid id token signature"},
+		{"tampered claims", parts[0] + "." + strings.Split(signRS256(t, key, header("RS256", "k1"), claims(func(c map[string]any) { c["sub"] = "admin" })), ".")[1] + "." + parts[2], "invalid id token signature"},
+		{"other issuer", signRS256(t, key, header("RS256", "k1"), claims(func(c map[string]any) { c["iss"] = "https://evil.example.com" })), "issuer"},
+		{"no issuer", signRS256(t, key, header("RS256", "k1"), claims(func(c map[string]any) { delete(c, "iss") })), "issuer"},
+		{"other audience", signRS256(t, key, header("RS256", "k1"), claims(func(c map[string]any) { c["aud"] = "other" })), "not issued for this client"},
+		{"audience list without client", signRS256(t, key, header("RS256", "k1"), claims(func(c map[string]any) { c["aud"] = []string{"a", "b"} })), "not issued for this client"},
+		{"no audience", signRS256(t, key, header("RS256", "k1"), claims(func(c map[string]any) { delete(c, "aud") })), "not issued for this client"},
+		{"expires now", signRS256(t, key, header("RS256", "k1"), claims(func(c map[string]any) { c["exp"] = now.Unix() })), ErrTokenExpired.Error()},
+		{"expired", signRS256(t, key, header("RS256", "k1"), claims(func(c map[string]any) { c["exp"] = now.Unix() - 1 })), ErrTokenExpired.Error()},
+		{"no expiry", signRS256(t, key, header("RS256", "k1"), claims(func(c map[string]any) { delete(c, "exp") })), ErrTokenExpired.Error()},
+		{"other nonce", signRS256(t, key, header("RS256", "k1"), claims(func(c map[string]any) { c["nonce"] = "n2" })), "nonce does not match"},
+		{"no nonce", signRS256(t, key, header("RS256", "k1"), claims(func(c map[string]any) { delete(c, "nonce") })), "nonce does not match"},
+		{"malformed", parts[0] + "." + parts[1], "malformed id token"},
+	}
+	for _, tc := range cases {
+		got, err := o.verifyIDToken(tc.token, testIssuer, "n1", now)
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.want == "" && got["sub"] != "u1":
+			t.Errorf("%s: claims = %v", tc.name, got)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+
+	// 登录时的 nonce 为空（例如会话丢失）时，即使令牌中也没有 nonce 也不能通过
+	noNonce := signRS256(t, key, header("RS256", "k1"), claims(func(c map[string]any) { delete(c, "nonce") }))
+	if _, err := o.verifyIDToken(noNonce, testIssuer, "", now); err == nil {
+		t.Error("empty nonce on both sides: no error")
+	}
+	if _, err := o.verifyIDToken(valid, testIssuer, "n1", now.Add(time.Hour)); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("an hour later: err = %v, want ErrTokenExpired", err)
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Identity 为身份提供方确认的用户信息。Subject 为提供方中的唯一标识，本地用户和 LDAP 用户为用户名
+type Identity struct {
+	Provider    string
+	Subject     string
+	Username    string
+	DisplayName string
+	Email       string
+}
+
+// 身份提供方
+const (
+	ProviderLocal = "local"
+	ProviderLDAP  = "ldap"
+	ProviderOIDC  = "oidc"
+)
+
+// ErrInvalidCredentials 表示用户名或密码错误，调用方不应区分用户不存在和密码错误
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// MinPasswordLength 为本地用户密码的最小长度
+const MinPasswordLength = 8
+
+// HashPassword 用 bcrypt 计算密码摘要。bcrypt 只使用前 72 字节，更长的密码直接拒绝
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", errors.New("password must be at least 8 characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return "", errors.New("password must not exceed 72 bytes")
+	}
+	return string(hash), err
+}
+
+// CheckPassword 比较密码和摘要，不一致时返回 ErrInvalidCredentials
+func CheckPassword(hash, password string) error {
+	if hash == "" || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signHS256 以指定的头部签名任意声明，用于构造 Sign 不会生成的令牌
+func signHS256(header, payload string, key []byte) string {
+	signed := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(payload))
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerify(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Unix(1700000000, 0)
+	claims := Claims{Issuer: "cmdb", Subject: "1", Username: "admin", Provider: ProviderLocal, ID: "j1",
+		IssuedAt: now.Unix() - 60, ExpiresAt: now.Unix() + 60}
+	sign := func(c Claims) string {
+		token, err := Sign(c, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := sign(claims)
+	with := func(edit func(*Claims)) string {
+		c := claims
+		edit(&c)
+		return sign(c)
+	}
+	parts := strings.Split(valid, ".")
+	other := strings.Split(with(func(c *Claims) { c.Subject = "2" }), ".")
+	unsigned := strings.Split(signHS256(`{"alg":"none","typ":"JWT"}`, `{"iss":"cmdb","sub":"1","exp":1700000060}`, key), ".")
+
+	cases := []struct {
+		name  string
+		token string
+		key   []byte
+		want  error
+	}{
+		{"valid", valid, key, nil},
+		{"wrong key", valid, []byte("another key of thirty-two bytes!"), ErrInvalidToken},
+		{"tampered payload", parts[0] + "." + other[1] + "." + parts[2], key, ErrInvalidToken},
+		{"alg none", unsigned[0] + "." + unsigned[1] + ".", key, ErrInvalidToken},
+		{"alg HS512", signHS256(`{"alg":"HS512","typ":"JWT"}`, `{"iss":"cmdb","sub":"1","exp":1700000060}`, key), key, ErrInvalidToken},
+		{"alg RS256", signHS256(`{"alg":"RS256","typ":"JWT"}`, `{"iss":"cmdb","sub":"1","exp":1700000060}`, key), key, ErrInvalidToken},
+		{"other issuer", with(func(c *Claims) { c.Issuer = "other" }), key, ErrInvalidToken},
+		{"no subject", with(func(c *Claims) { c.Subject = "" }), key, ErrInvalidToken},
+		{"expires now", with(func(c *Claims) { c.ExpiresAt = now.Unix() }), key, ErrTokenExpired},
+		{"expired", with(func(c *Claims) { c.ExpiresAt = now.Unix() - 1 }), key, ErrTokenExpired},
+		{"no expiry", signHS256(`{"alg":"HS256","typ":"JWT"}`, `{"iss":"cmdb","sub":"1"}`, key), key, ErrTokenExpired},
+		{"two segments", parts[0] + "." + parts[1], key, ErrInvalidToken},
+		{"four segments", valid + ".x", key, ErrInvalidToken},
+		{"bad base64 signature", valid[:len(valid)-1] + "!", key, ErrInvalidToken},
+		{"claims not json", signHS256(`{"alg":"HS256","typ":"JWT"}`, `not json`, key), key, ErrInvalidToken},
+		{"empty", "", key, ErrInvalidToken},
+	}
+	for _, tc := range cases {
+		got, err := Verify(tc.token, tc.key, "cmdb", now)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, tc.want)
+			continue
+		}
+		if err == nil && *got != claims {
+			t.Errorf("%s: claims = %+v, want %+v", tc.name, *got, claims)
+		}
+	}
+}
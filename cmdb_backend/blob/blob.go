@@ -0,0 +1,101 @@
+// Package blob 保存报告存档等不适合放在数据库中的文件
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cmdb/config"
+)
+
+// ErrNotFound 表示 key 对应的对象不存在
+var ErrNotFound = errors.New("blob: not found")
+
+// Store 为按 key 读写的对象存储，key 为以 / 分隔的相对路径
+type Store interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+// Open 按配置创建存储
+func Open(cfg config.ArchiveConfig) (Store, error) {
+	switch cfg.Backend {
+	case "local":
+		return NewLocal(cfg.Dir)
+	default:
+		return nil, fmt.Errorf("blob: unknown backend %q", cfg.Backend)
+	}
+}
+
+// Local 把对象保存为本地目录下的文件
+type Local struct {
+	Dir string
+}
+
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Local{Dir: dir}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	// 拒绝 ..、绝对路径和反斜杠，key 不能逃出目录
+	if key == "" || !fs.ValidPath(key) || strings.Contains(key, `\`) {
+		return "", fmt.Errorf("blob: invalid key %q", key)
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(key)), nil
+}
+
+// Put 先写入临时文件再重命名，读取方不会看到写了一半的文件
+func (l *Local) Put(key string, data []byte) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (l *Local) Get(key string) ([]byte, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return data, err
+}
+
+// Delete 删除对象，对象不存在时不报错
+func (l *Local) Delete(key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cmdb/audit"
+	"cmdb/migrations"
+	"cmdb/models"
+	"cmdb/services"
+)
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, `Usage:
+  cmdb [-config FILE] [serve]            启动 HTTP 服务
+  cmdb [-config FILE] migrate up         执行所有未执行的迁移
+  cmdb [-config FILE] migrate down [N]   回滚最近 N 个迁移（默认 1）
+  cmdb [-config FILE] migrate status     查看迁移状态
+  cmdb [-config FILE] seed               写入模拟数据
+  cmdb [-config FILE] user add NAME      创建本地用户
+  cmdb [-config FILE] user passwd NAME   重置本地用户的密码
+  cmdb [-config FILE] user grant NAME ROLE [DEPARTMENT]
+                                         授予用户角色（viewer、operator、admin），
+                                         不指定部门时对所有部门生效
+  cmdb [-config FILE] token add NAME SCOPES [DAYS]
+                                         创建 API 令牌并输出到标准输出，SCOPES 以逗号分隔，
+                                         DAYS 为有效天数，不指定时永不过期
+
+用户密码从 $CMDB_USER_PASSWORD 读取，未设置时从标准输入读取一行。
+
+配置文件默认读取 $CMDB_CONFIG，其次是当前目录下的 config.yaml / config.toml，
+文件中的配置可以被 CMDB_* 环境变量覆盖。`)
+}
+
+func defaultConfigPath() string {
+	if path := os.Getenv("CMDB_CONFIG"); path != "" {
+		return path
+	}
+	for _, path := range []string{"config.yaml", "config.yml", "config.toml"} {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	initDB()
+	migrator := migrations.NewMigrator(db)
+
+	switch args[0] {
+	case "up":
+		done, err := migrator.Up()
+		for _, m := range done {
+			log.Printf("Applied %04d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(done) == 0 {
+			log.Println("Schema is up to date")
+		}
+		// 升级前已经存在的主机、应用和集群补上版本，之后的写入都由审计回调记录版本
+		if err := services.BaselineInventory(db); err != nil {
+			log.Fatal("Failed to record inventory baseline: ", err)
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatalf("Invalid step count %q", args[1])
+			}
+			steps = n
+		}
+		done, err := migrator.Down(steps)
+		for _, m := range done {
+			log.Printf("Rolled back %04d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Fatal(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, st := range statuses {
+			appliedAt := "pending"
+			if st.Applied {
+				appliedAt = st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", st.Version, st.Name, appliedAt)
+		}
+		w.Flush()
+	default:
+		printUsage()
+		os.Exit(2)
+	}
+}
+
+func runSeed() {
+	initDB()
+	if err := migrations.NewMigrator(db).CheckCurrent(); err != nil {
+		log.Fatal(err)
+	}
+	initAudit("seed")
+	metricsStore = services.NewMetricsStore(db, cfg)
+	var err error
+	if idcResolver, err = services.NewIDCResolver(db); err != nil {
+		log.Fatal(err)
+	}
+	if err := generateMockData(db); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Mock data has been generated.")
+}
+
+func runUser(args []string) {
+	valid := false
+	if len(args) > 0 {
+		switch args[0] {
+		case "add", "passwd":
+			valid = len(args) == 2
+		case "grant":
+			valid = len(args) == 3 || len(args) == 4
+		}
+	}
+	if !valid {
+		printUsage()
+		os.Exit(2)
+	}
+	initDB()
+	if err := migrations.NewMigrator(db).CheckCurrent(); err != nil {
+		log.Fatal(err)
+	}
+	initAudit("cli")
+	authService := services.NewAuthService(db, cfg)
+	ctx := audit.WithActor(context.Background(), "cli")
+
+	switch args[0] {
+	case "add":
+		user, err := authService.CreateLocalUser(ctx, args[1], mustReadPassword(), "", "")
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("User %s created (id %d)", user.Username, user.ID)
+	case "passwd":
+		user := mustFindUser(args[1])
+		if err := authService.SetPassword(ctx, user, mustReadPassword()); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Password of %s changed, existing sessions revoked", user.Username)
+	case "grant":
+		user := mustFindUser(args[1])
+		department := ""
+		if len(args) == 4 {
+			department = args[3]
+		}
+		binding, err := services.NewAccessService(db).Grant(user.ID, args[2], department, "cli")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if binding.DepartmentName == "" {
+			log.Printf("Granted %s to %s for all departments", binding.Role, user.Username)
+		} else {
+			log.Printf("Granted %s to %s in department %s", binding.Role, user.Username, binding.DepartmentName)
+		}
+	}
+}
+
+func mustFindUser(username string) *models.User {
+	var user models.User
+	if err := db.Where("username = ?", username).Limit(1).Find(&user).Error; err != nil {
+		log.Fatal(err)
+	}
+	if user.ID == 0 {
+		log.Fatalf("User %s not found", username)
+	}
+	return &user
+}
+
+func mustReadPassword() string {
+	password, err := readPassword()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return password
+}
+
+func runToken(args []string) {
+	if (len(args) != 3 && len(args) != 4) || args[0] != "add" {
+		printUsage()
+		os.Exit(2)
+	}
+	var expiresAt *time.Time
+	if len(args) == 4 {
+		days, err := strconv.Atoi(args[3])
+		if err != nil || days < 1 {
+			log.Fatalf("Invalid number of days %q", args[3])
+		}
+		t := time.Now().AddDate(0, 0, days)
+		expiresAt = &t
+	}
+	initDB()
+	if err := migrations.NewMigrator(db).CheckCurrent(); err != nil {
+		log.Fatal(err)
+	}
+	initAudit("cli")
+	token, secret, err := services.NewAuthService(db, cfg).IssueAPIToken(args[1], args[2], expiresAt, "cli")
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("API token %s created (id %d, scopes %s)", token.Name, token.ID, token.Scopes)
+	fmt.Println(secret)
+}
+
+// readPassword 从 $CMDB_USER_PASSWORD 或标准输入读取密码，避免密码出现在命令行参数里
+func readPassword() (string, error) {
+	if password := os.Getenv("CMDB_USER_PASSWORD"); password != "" {
+		return password, nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,573 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Secret 用于保存密码等敏感配置，打印和序列化时都会被遮盖
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "******"
+}
+
+func (s Secret) GoString() string {
+	return `"` + s.String() + `"`
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + s.String() + `"`), nil
+}
+
+// Value 返回原始值，只应在真正需要使用密钥的地方调用
+func (s Secret) Value() string {
+	return string(s)
+}
+
+// Duration 支持在配置文件中使用 "10s"、"5m" 这样的写法，另外支持以天为单位的 "30d"
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	s := string(text)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		d.Duration = time.Duration(n) * 24 * time.Hour
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// Config 是后端服务的全部配置
+type Config struct {
+	Server   ServerConfig   `yaml:"server" toml:"server"`
+	Database DatabaseConfig `yaml:"database" toml:"database"`
+	SMTP     SMTPConfig     `yaml:"smtp" toml:"smtp"`
+	CORS     CORSConfig     `yaml:"cors" toml:"cors"`
+	Metrics  MetricsConfig  `yaml:"metrics" toml:"metrics"`
+	Alerts   AlertsConfig   `yaml:"alerts" toml:"alerts"`
+	// Notifications 为 webhook 和机器人渠道的公共设置，渠道本身保存在数据库中
+	Notifications NotificationsConfig `yaml:"notifications" toml:"notifications"`
+	Reports       ReportsConfig       `yaml:"reports" toml:"reports"`
+	Auth          AuthConfig          `yaml:"auth" toml:"auth"`
+}
+
+type ServerConfig struct {
+	Addr string `yaml:"addr" toml:"addr" env:"CMDB_SERVER_ADDR"`
+	// ReloadInterval 为配置文件变更检查的周期，0 表示关闭热加载
+	ReloadInterval Duration `yaml:"reload_interval" toml:"reload_interval" env:"CMDB_SERVER_RELOAD_INTERVAL"`
+	// IDCRefreshInterval 为检查 idc 表是否被其他实例修改的周期，0 表示只在本实例修改网段后重新加载
+	IDCRefreshInterval Duration `yaml:"idc_refresh_interval" toml:"idc_refresh_interval" env:"CMDB_SERVER_IDC_REFRESH_INTERVAL"`
+}
+
+type DatabaseConfig struct {
+	// Driver 为 mysql 或 sqlite
+	Driver string `yaml:"driver" toml:"driver" env:"CMDB_DATABASE_DRIVER"`
+	DSN    Secret `yaml:"dsn" toml:"dsn" env:"CMDB_DATABASE_DSN"`
+}
+
+// SMTP 连接的加密方式
+const (
+	SMTPSecurityTLS      = "tls"      // 隐式 TLS，通常为 465 端口
+	SMTPSecurityStartTLS = "starttls" // 明文连接后必须升级为 TLS，通常为 587 端口
+	SMTPSecurityNone     = "none"     // 不加密，只应用于本机或内网中继
+)
+
+// SMTP 认证方式
+const (
+	SMTPAuthPassword = "password" // 用户名和密码（或应用专用密码）
+	SMTPAuthXOAUTH2  = "xoauth2"  // OAuth2 访问令牌
+	SMTPAuthNone     = "none"
+)
+
+// SMTPAccount 为一个发件账号。默认账号的字段可以用环境变量覆盖
+type SMTPAccount struct {
+	Host     string `yaml:"host" toml:"host" env:"CMDB_SMTP_HOST"`
+	Port     int    `yaml:"port" toml:"port" env:"CMDB_SMTP_PORT"`
+	User     string `yaml:"user" toml:"user" env:"CMDB_SMTP_USER"`
+	Password Secret `yaml:"password" toml:"password" env:"CMDB_SMTP_PASSWORD"`
+	// From 为发件人地址，为空时使用 User
+	From string `yaml:"from" toml:"from" env:"CMDB_SMTP_FROM"`
+	// Security 为 tls、starttls 或 none，为空时 465 端口使用 tls，其余端口使用 starttls
+	Security string `yaml:"security" toml:"security" env:"CMDB_SMTP_SECURITY"`
+	// CAFile 为额外信任的 CA 证书（PEM），用于自签名或内部 CA 签发的服务器证书
+	CAFile string `yaml:"ca_file" toml:"ca_file" env:"CMDB_SMTP_CA_FILE"`
+	// ServerName 为校验服务器证书时使用的名称，为空时使用 Host
+	ServerName string `yaml:"server_name" toml:"server_name" env:"CMDB_SMTP_SERVER_NAME"`
+	// Auth 为 password、xoauth2 或 none，为空时设置了 User 即使用 password
+	Auth   string       `yaml:"auth" toml:"auth" env:"CMDB_SMTP_AUTH"`
+	OAuth2 OAuth2Config `yaml:"oauth2" toml:"oauth2"`
+}
+
+// OAuth2Config 为 XOAUTH2 认证使用的令牌。设置了 TokenURL 时用 RefreshToken（为空时用客户端凭据）换取访问令牌，
+// 否则直接使用 AccessToken
+type OAuth2Config struct {
+	TokenURL     string   `yaml:"token_url" toml:"token_url" env:"CMDB_SMTP_OAUTH2_TOKEN_URL"`
+	ClientID     string   `yaml:"client_id" toml:"client_id" env:"CMDB_SMTP_OAUTH2_CLIENT_ID"`
+	ClientSecret Secret   `yaml:"client_secret" toml:"client_secret" env:"CMDB_SMTP_OAUTH2_CLIENT_SECRET"`
+	RefreshToken Secret   `yaml:"refresh_token" toml:"refresh_token" env:"CMDB_SMTP_OAUTH2_REFRESH_TOKEN"`
+	AccessToken  Secret   `yaml:"access_token" toml:"access_token" env:"CMDB_SMTP_OAUTH2_ACCESS_TOKEN"`
+	Scopes       []string `yaml:"scopes" toml:"scopes" env:"CMDB_SMTP_OAUTH2_SCOPES"`
+}
+
+// SMTPProfile 为命名的发件账号，Departments 中部门的邮件默认使用该账号发送。未设置的字段沿用默认账号
+type SMTPProfile struct {
+	Name        string   `yaml:"name" toml:"name"`
+	Departments []string `yaml:"departments" toml:"departments"`
+	SMTPAccount `yaml:",inline"`
+}
+
+type SMTPConfig struct {
+	// 默认发件账号
+	SMTPAccount `yaml:",inline"`
+	Timeout     Duration `yaml:"timeout" toml:"timeout" env:"CMDB_SMTP_TIMEOUT"`
+	// Profiles 为其他命名发件账号
+	Profiles []SMTPProfile `yaml:"profiles" toml:"profiles"`
+	// Outbox 为发件队列的投递设置
+	Outbox OutboxConfig `yaml:"outbox" toml:"outbox"`
+}
+
+// DefaultSMTPProfile 为默认发件账号的名称
+const DefaultSMTPProfile = "default"
+
+// Account 返回指定名称的发件账号，名称为空或 default 时返回默认账号。命名账号中未设置的字段沿用默认账号，
+// 安全方式和认证方式的默认值在合并后确定
+func (c *SMTPConfig) Account(name string) (SMTPAccount, bool) {
+	account := c.SMTPAccount
+	if name != "" && name != DefaultSMTPProfile {
+		i := slices.IndexFunc(c.Profiles, func(p SMTPProfile) bool { return p.Name == name })
+		if i < 0 {
+			return SMTPAccount{}, false
+		}
+		account = c.Profiles[i].SMTPAccount.inherit(c.SMTPAccount)
+	}
+	if account.From == "" {
+		account.From = account.User
+	}
+	if account.Security == "" {
+		account.Security = SMTPSecurityStartTLS
+		if account.Port == 465 {
+			account.Security = SMTPSecurityTLS
+		}
+	}
+	if account.Auth == "" {
+		account.Auth = SMTPAuthNone
+		if account.User != "" {
+			account.Auth = SMTPAuthPassword
+		}
+	}
+	return account, true
+}
+
+// ProfileForDepartment 返回部门使用的发件账号名称，没有为该部门配置账号时返回 default
+func (c *SMTPConfig) ProfileForDepartment(department string) string {
+	for _, p := range c.Profiles {
+		if department != "" && slices.Contains(p.Departments, department) {
+			return p.Name
+		}
+	}
+	return DefaultSMTPProfile
+}
+
+// inherit 用 base 填充未设置的字段
+func (a SMTPAccount) inherit(base SMTPAccount) SMTPAccount {
+	fill := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	fill(&a.Host, base.Host)
+	if a.Port == 0 {
+		a.Port = base.Port
+	}
+	fill(&a.User, base.User)
+	if a.Password == "" {
+		a.Password = base.Password
+	}
+	fill(&a.From, base.From)
+	fill(&a.Security, base.Security)
+	fill(&a.CAFile, base.CAFile)
+	fill(&a.ServerName, base.ServerName)
+	fill(&a.Auth, base.Auth)
+	if a.OAuth2.TokenURL == "" && a.OAuth2.AccessToken == "" {
+		a.OAuth2 = base.OAuth2
+	}
+	return a
+}
+
+// validate 检查合并后的发件账号，prefix 为错误信息中的配置路径
+func (a SMTPAccount) validate(prefix string) []error {
+	var errs []error
+	if a.Port < 1 || a.Port > 65535 {
+		errs = append(errs, fmt.Errorf("%s.port %d is out of range", prefix, a.Port))
+	}
+	if a.User != "" && a.Host == "" {
+		errs = append(errs, fmt.Errorf("%s.host is required when %s.user is set", prefix, prefix))
+	}
+	switch a.Security {
+	case SMTPSecurityTLS, SMTPSecurityStartTLS, SMTPSecurityNone:
+	default:
+		errs = append(errs, fmt.Errorf("%s.security must be tls, starttls or none, got %q", prefix, a.Security))
+	}
+	switch a.Auth {
+	case SMTPAuthPassword, SMTPAuthNone:
+	case SMTPAuthXOAUTH2:
+		if a.User == "" {
+			errs = append(errs, fmt.Errorf("%s.user is required for xoauth2", prefix))
+		}
+		if a.OAuth2.TokenURL == "" && a.OAuth2.AccessToken == "" {
+			errs = append(errs, fmt.Errorf("%s.oauth2.token_url or %s.oauth2.access_token is required for xoauth2", prefix, prefix))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("%s.auth must be password, xoauth2 or none, got %q", prefix, a.Auth))
+	}
+	return errs
+}
+
+// OutboxConfig 控制后台投递邮件的频率和失败重试：第 n 次失败后等待 Backoff*2^(n-1)，最长 MaxBackoff
+type OutboxConfig struct {
+	PollInterval Duration `yaml:"poll_interval" toml:"poll_interval" env:"CMDB_SMTP_OUTBOX_POLL_INTERVAL"`
+	MaxAttempts  int      `yaml:"max_attempts" toml:"max_attempts" env:"CMDB_SMTP_OUTBOX_MAX_ATTEMPTS"`
+	Backoff      Duration `yaml:"backoff" toml:"backoff" env:"CMDB_SMTP_OUTBOX_BACKOFF"`
+	MaxBackoff   Duration `yaml:"max_backoff" toml:"max_backoff" env:"CMDB_SMTP_OUTBOX_MAX_BACKOFF"`
+}
+
+type CORSConfig struct {
+	AllowOrigins []string `yaml:"allow_origins" toml:"allow_origins" env:"CMDB_CORS_ALLOW_ORIGINS"`
+}
+
+type MetricsConfig struct {
+	// RollupInterval 为降采样和清理过期数据的执行周期
+	RollupInterval Duration `yaml:"rollup_interval" toml:"rollup_interval" env:"CMDB_METRICS_ROLLUP_INTERVAL"`
+	// LockTTL 为降采样任务租约的有效期，多实例部署时只有持有租约的实例执行降采样和清理
+	LockTTL   Duration         `yaml:"lock_ttl" toml:"lock_ttl" env:"CMDB_METRICS_LOCK_TTL"`
+	Retention MetricsRetention `yaml:"retention" toml:"retention"`
+}
+
+// MetricsRetention 为各个精度数据的保留时长
+type MetricsRetention struct {
+	Raw        Duration `yaml:"raw" toml:"raw" env:"CMDB_METRICS_RETENTION_RAW"`
+	FiveMinute Duration `yaml:"5m" toml:"5m" env:"CMDB_METRICS_RETENTION_5M"`
+	Hour       Duration `yaml:"1h" toml:"1h" env:"CMDB_METRICS_RETENTION_1H"`
+	Day        Duration `yaml:"1d" toml:"1d" env:"CMDB_METRICS_RETENTION_1D"`
+}
+
+type AlertsConfig struct {
+	// EvaluationInterval 为评估告警规则、更新告警状态的周期
+	EvaluationInterval Duration `yaml:"evaluation_interval" toml:"evaluation_interval" env:"CMDB_ALERTS_EVALUATION_INTERVAL"`
+	// LockTTL 为告警评估租约的有效期，多实例部署时只有持有租约的实例评估规则和更新告警
+	LockTTL Duration `yaml:"lock_ttl" toml:"lock_ttl" env:"CMDB_ALERTS_LOCK_TTL"`
+}
+
+type NotificationsConfig struct {
+	// Timeout 为调用 webhook 和机器人接口的超时时间
+	Timeout Duration `yaml:"timeout" toml:"timeout" env:"CMDB_NOTIFICATIONS_TIMEOUT"`
+}
+
+type ReportsConfig struct {
+	// UsageThreshold 为报表中高亮显示的使用率阈值（百分比）
+	UsageThreshold int `yaml:"usage_threshold" toml:"usage_threshold" env:"CMDB_REPORTS_USAGE_THRESHOLD"`
+	// FullWithin 为高亮显示的磁盘预测写满时间范围
+	FullWithin Duration `yaml:"full_within" toml:"full_within" env:"CMDB_REPORTS_FULL_WITHIN"`
+	// ScheduleInterval 为检查到期的报告订阅的周期
+	ScheduleInterval Duration `yaml:"schedule_interval" toml:"schedule_interval" env:"CMDB_REPORTS_SCHEDULE_INTERVAL"`
+	// LockTTL 为调度租约的有效期，持有租约的实例失联超过这段时间后由其他实例接管
+	LockTTL Duration `yaml:"lock_ttl" toml:"lock_ttl" env:"CMDB_REPORTS_LOCK_TTL"`
+	// Archive 为生成的报告的存档位置
+	Archive ArchiveConfig `yaml:"archive" toml:"archive"`
+}
+
+// ArchiveConfig 选择保存报告文件的存储，目前只支持本地目录
+type ArchiveConfig struct {
+	Backend string `yaml:"backend" toml:"backend" env:"CMDB_REPORTS_ARCHIVE_BACKEND"`
+	Dir     string `yaml:"dir" toml:"dir" env:"CMDB_REPORTS_ARCHIVE_DIR"`
+}
+
+// AuthConfig 为登录设置。访问令牌用 JWTSecret 以 HS256 签名，过期后用刷新令牌换取新的令牌
+type AuthConfig struct {
+	JWTSecret  Secret     `yaml:"jwt_secret" toml:"jwt_secret" env:"CMDB_AUTH_JWT_SECRET"`
+	Issuer     string     `yaml:"issuer" toml:"issuer" env:"CMDB_AUTH_ISSUER"`
+	AccessTTL  Duration   `yaml:"access_ttl" toml:"access_ttl" env:"CMDB_AUTH_ACCESS_TTL"`
+	RefreshTTL Duration   `yaml:"refresh_ttl" toml:"refresh_ttl" env:"CMDB_AUTH_REFRESH_TTL"`
+	LDAP       LDAPConfig `yaml:"ldap" toml:"ldap"`
+	OIDC       OIDCConfig `yaml:"oidc" toml:"oidc"`
+}
+
+// LDAPConfig 为 LDAP 登录。ldap:// 地址只有在 StartTLS 为 true 或服务器在本机时才允许使用
+type LDAPConfig struct {
+	Enabled      bool   `yaml:"enabled" toml:"enabled" env:"CMDB_AUTH_LDAP_ENABLED"`
+	URL          string `yaml:"url" toml:"url" env:"CMDB_AUTH_LDAP_URL"`
+	StartTLS     bool   `yaml:"start_tls" toml:"start_tls" env:"CMDB_AUTH_LDAP_START_TLS"`
+	CAFile       string `yaml:"ca_file" toml:"ca_file" env:"CMDB_AUTH_LDAP_CA_FILE"`
+	BindDN       string `yaml:"bind_dn" toml:"bind_dn" env:"CMDB_AUTH_LDAP_BIND_DN"`
+	BindPassword Secret `yaml:"bind_password" toml:"bind_password" env:"CMDB_AUTH_LDAP_BIND_PASSWORD"`
+	BaseDN       string `yaml:"base_dn" toml:"base_dn" env:"CMDB_AUTH_LDAP_BASE_DN"`
+	// UserFilter 中的 %s 替换为用户名
+	UserFilter           string   `yaml:"user_filter" toml:"user_filter" env:"CMDB_AUTH_LDAP_USER_FILTER"`
+	DisplayNameAttribute string   `yaml:"display_name_attribute" toml:"display_name_attribute" env:"CMDB_AUTH_LDAP_DISPLAY_NAME_ATTRIBUTE"`
+	EmailAttribute       string   `yaml:"email_attribute" toml:"email_attribute" env:"CMDB_AUTH_LDAP_EMAIL_ATTRIBUTE"`
+	Timeout              Duration `yaml:"timeout" toml:"timeout" env:"CMDB_AUTH_LDAP_TIMEOUT"`
+}
+
+// OIDCConfig 为 OpenID Connect 登录，RedirectURL 指向本服务的 /api/cmdb/v1/auth/oidc/callback
+type OIDCConfig struct {
+	Enabled       bool     `yaml:"enabled" toml:"enabled" env:"CMDB_AUTH_OIDC_ENABLED"`
+	Issuer        string   `yaml:"issuer" toml:"issuer" env:"CMDB_AUTH_OIDC_ISSUER"`
+	ClientID      string   `yaml:"client_id" toml:"client_id" env:"CMDB_AUTH_OIDC_CLIENT_ID"`
+	ClientSecret  Secret   `yaml:"client_secret" toml:"client_secret" env:"CMDB_AUTH_OIDC_CLIENT_SECRET"`
+	RedirectURL   string   `yaml:"redirect_url" toml:"redirect_url" env:"CMDB_AUTH_OIDC_REDIRECT_URL"`
+	Scopes        []string `yaml:"scopes" toml:"scopes" env:"CMDB_AUTH_OIDC_SCOPES"`
+	UsernameClaim string   `yaml:"username_claim" toml:"username_claim" env:"CMDB_AUTH_OIDC_USERNAME_CLAIM"`
+	// PostLoginRedirect 为登录完成后前端的地址，令牌放在 URL 片段中；为空时回调直接返回 JSON
+	PostLoginRedirect string `yaml:"post_login_redirect" toml:"post_login_redirect" env:"CMDB_AUTH_OIDC_POST_LOGIN_REDIRECT"`
+}
+
+// Default 返回未提供配置文件时使用的默认值
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:               ":8080",
+			ReloadInterval:     Duration{10 * time.Second},
+			IDCRefreshInterval: Duration{30 * time.Second},
+		},
+		Database: DatabaseConfig{
+			Driver: "mysql",
+		},
+		SMTP: SMTPConfig{
+			SMTPAccount: SMTPAccount{
+				Host: "smtp.163.com",
+				Port: 465,
+			},
+			Timeout: Duration{20 * time.Second},
+			Outbox: OutboxConfig{
+				PollInterval: Duration{5 * time.Second},
+				MaxAttempts:  8,
+				Backoff:      Duration{30 * time.Second},
+				MaxBackoff:   Duration{time.Hour},
+			},
+		},
+		CORS: CORSConfig{
+			AllowOrigins: []string{"http://localhost:3000"},
+		},
+		Metrics: MetricsConfig{
+			RollupInterval: Duration{5 * time.Minute},
+			LockTTL:        Duration{15 * time.Minute},
+			Retention: MetricsRetention{
+				Raw:        Duration{7 * 24 * time.Hour},
+				FiveMinute: Duration{30 * 24 * time.Hour},
+				Hour:       Duration{180 * 24 * time.Hour},
+				Day:        Duration{3 * 365 * 24 * time.Hour},
+			},
+		},
+		Alerts: AlertsConfig{
+			EvaluationInterval: Duration{time.Minute},
+			LockTTL:            Duration{3 * time.Minute},
+		},
+		Notifications: NotificationsConfig{
+			Timeout: Duration{10 * time.Second},
+		},
+		Reports: ReportsConfig{
+			UsageThreshold:   80,
+			FullWithin:       Duration{30 * 24 * time.Hour},
+			ScheduleInterval: Duration{30 * time.Second},
+			LockTTL:          Duration{2 * time.Minute},
+			Archive: ArchiveConfig{
+				Backend: "local",
+				Dir:     "data/reports",
+			},
+		},
+		Auth: AuthConfig{
+			Issuer:     "cmdb",
+			AccessTTL:  Duration{15 * time.Minute},
+			RefreshTTL: Duration{7 * 24 * time.Hour},
+			LDAP: LDAPConfig{
+				UserFilter:           "(uid=%s)",
+				DisplayNameAttribute: "cn",
+				EmailAttribute:       "mail",
+				Timeout:              Duration{10 * time.Second},
+			},
+			OIDC: OIDCConfig{
+				Scopes:        []string{"openid", "profile", "email"},
+				UsernameClaim: "preferred_username",
+			},
+		},
+	}
+}
+
+// Validate 检查配置是否完整，返回所有发现的问题
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr is required"))
+	}
+	if c.Server.ReloadInterval.Duration < 0 {
+		errs = append(errs, errors.New("server.reload_interval must not be negative"))
+	}
+	if c.Server.IDCRefreshInterval.Duration < 0 {
+		errs = append(errs, errors.New("server.idc_refresh_interval must not be negative"))
+	}
+	if c.Database.Driver != "mysql" && c.Database.Driver != "sqlite" {
+		errs = append(errs, fmt.Errorf("database.driver must be mysql or sqlite, got %q", c.Database.Driver))
+	}
+	if c.Database.DSN == "" {
+		errs = append(errs, errors.New("database.dsn is required"))
+	}
+
+	account, _ := c.SMTP.Account(DefaultSMTPProfile)
+	errs = append(errs, account.validate("smtp")...)
+	seen := map[string]bool{DefaultSMTPProfile: true}
+	for i, p := range c.SMTP.Profiles {
+		prefix := fmt.Sprintf("smtp.profiles[%d]", i)
+		if p.Name == "" || seen[p.Name] {
+			errs = append(errs, fmt.Errorf("%s.name must be unique and not %q", prefix, DefaultSMTPProfile))
+			continue
+		}
+		seen[p.Name] = true
+		account, _ := c.SMTP.Account(p.Name)
+		errs = append(errs, account.validate(prefix)...)
+	}
+	if c.SMTP.Timeout.Duration <= 0 {
+		errs = append(errs, errors.New("smtp.timeout must be positive"))
+	}
+	if c.SMTP.Outbox.PollInterval.Duration <= 0 {
+		errs = append(errs, errors.New("smtp.outbox.poll_interval must be positive"))
+	}
+	if c.SMTP.Outbox.MaxAttempts < 1 {
+		errs = append(errs, errors.New("smtp.outbox.max_attempts must be at least 1"))
+	}
+	if c.SMTP.Outbox.Backoff.Duration <= 0 || c.SMTP.Outbox.MaxBackoff.Duration < c.SMTP.Outbox.Backoff.Duration {
+		errs = append(errs, errors.New("smtp.outbox.backoff must be positive and not exceed smtp.outbox.max_backoff"))
+	}
+
+	if c.Metrics.RollupInterval.Duration <= 0 || c.Metrics.LockTTL.Duration <= c.Metrics.RollupInterval.Duration {
+		errs = append(errs, errors.New("metrics.rollup_interval must be positive and less than metrics.lock_ttl"))
+	}
+	retention := c.Metrics.Retention
+	if retention.Raw.Duration <= 0 || retention.FiveMinute.Duration <= 0 || retention.Hour.Duration <= 0 || retention.Day.Duration <= 0 {
+		errs = append(errs, errors.New("metrics.retention values must be positive"))
+	}
+
+	if c.Alerts.EvaluationInterval.Duration <= 0 || c.Alerts.LockTTL.Duration <= c.Alerts.EvaluationInterval.Duration {
+		errs = append(errs, errors.New("alerts.evaluation_interval must be positive and less than alerts.lock_ttl"))
+	}
+	if c.Notifications.Timeout.Duration <= 0 {
+		errs = append(errs, errors.New("notifications.timeout must be positive"))
+	}
+	if c.Reports.UsageThreshold <= 0 || c.Reports.UsageThreshold > 100 {
+		errs = append(errs, errors.New("reports.usage_threshold must be between 1 and 100"))
+	}
+	if c.Reports.FullWithin.Duration <= 0 {
+		errs = append(errs, errors.New("reports.full_within must be positive"))
+	}
+	if c.Reports.ScheduleInterval.Duration <= 0 || c.Reports.LockTTL.Duration <= c.Reports.ScheduleInterval.Duration {
+		errs = append(errs, errors.New("reports.schedule_interval must be positive and less than reports.lock_ttl"))
+	}
+	if c.Reports.Archive.Backend != "local" {
+		errs = append(errs, fmt.Errorf("reports.archive.backend must be local, got %q", c.Reports.Archive.Backend))
+	}
+	if c.Reports.Archive.Dir == "" {
+		errs = append(errs, errors.New("reports.archive.dir is required"))
+	}
+
+	errs = append(errs, c.Auth.validate()...)
+
+	for _, origin := range c.CORS.AllowOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("cors.allow_origins: invalid origin %q", origin))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// AllowsOrigin 判断请求来源是否在 CORS 白名单中
+func (c *CORSConfig) AllowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a AuthConfig) validate() []error {
+	var errs []error
+	if len(a.JWTSecret) < 32 {
+		errs = append(errs, errors.New("auth.jwt_secret must be at least 32 characters"))
+	}
+	if a.Issuer == "" {
+		errs = append(errs, errors.New("auth.issuer is required"))
+	}
+	if a.AccessTTL.Duration <= 0 || a.RefreshTTL.Duration <= a.AccessTTL.Duration {
+		errs = append(errs, errors.New("auth.access_ttl must be positive and less than auth.refresh_ttl"))
+	}
+	if l := a.LDAP; l.Enabled {
+		u, err := url.Parse(l.URL)
+		switch {
+		case err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "":
+			errs = append(errs, fmt.Errorf("auth.ldap.url must be ldap://host[:port] or ldaps://host[:port], got %q", l.URL))
+		case u.Scheme == "ldap" && !l.StartTLS && !isLoopback(u.Hostname()):
+			errs = append(errs, errors.New("auth.ldap.url must use ldaps:// or start_tls unless the server is on localhost"))
+		}
+		if l.BaseDN == "" {
+			errs = append(errs, errors.New("auth.ldap.base_dn is required"))
+		}
+		if strings.Count(l.UserFilter, "%s") != 1 || !strings.HasPrefix(l.UserFilter, "(") {
+			errs = append(errs, errors.New("auth.ldap.user_filter must be a parenthesized filter containing one %s"))
+		}
+		if l.Timeout.Duration <= 0 {
+			errs = append(errs, errors.New("auth.ldap.timeout must be positive"))
+		}
+	}
+	if o := a.OIDC; o.Enabled {
+		if u, err := url.Parse(o.Issuer); err != nil || u.Scheme != "https" && !(u.Scheme == "http" && isLoopback(u.Hostname())) {
+			errs = append(errs, fmt.Errorf("auth.oidc.issuer must be an https URL, got %q", o.Issuer))
+		}
+		if o.ClientID == "" || o.RedirectURL == "" {
+			errs = append(errs, errors.New("auth.oidc.client_id and auth.oidc.redirect_url are required"))
+		}
+		if !slices.Contains(o.Scopes, "openid") {
+			errs = append(errs, errors.New("auth.oidc.scopes must include openid"))
+		}
+		if o.UsernameClaim == "" {
+			errs = append(errs, errors.New("auth.oidc.username_claim is required"))
+		}
+	}
+	return errs
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
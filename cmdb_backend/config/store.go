@@ -0,0 +1,102 @@
+package config
+
+import (
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Store 保存当前生效的配置，并在配置文件变化时热加载。
+// 数据库和监听地址这类连接配置只在启动时生效，热加载时会保留旧值。
+type Store struct {
+	path    string
+	current atomic.Pointer[Config]
+	modTime time.Time
+
+	mu        sync.Mutex
+	listeners []func(*Config)
+}
+
+func NewStore(path string) (*Store, error) {
+	cfg, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{path: path}
+	s.current.Store(cfg)
+	if path != "" {
+		if info, err := os.Stat(path); err == nil {
+			s.modTime = info.ModTime()
+		}
+	}
+	return s, nil
+}
+
+// Current 返回当前生效的配置，调用方不应修改返回值
+func (s *Store) Current() *Config {
+	return s.current.Load()
+}
+
+// OnChange 注册配置热加载后的回调
+func (s *Store) OnChange(fn func(*Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// Watch 周期性检查配置文件，直到 stop 被关闭
+func (s *Store) Watch(interval time.Duration, stop <-chan struct{}) {
+	if s.path == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.reloadIfChanged()
+		}
+	}
+}
+
+func (s *Store) reloadIfChanged() {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		log.Printf("Config watch: %v", err)
+		return
+	}
+	if !info.ModTime().After(s.modTime) {
+		return
+	}
+	s.modTime = info.ModTime()
+
+	next, err := Load(s.path)
+	if err != nil {
+		log.Printf("Config reload rejected, keeping previous settings: %v", err)
+		return
+	}
+
+	prev := s.Current()
+	if next.Server.Addr != prev.Server.Addr || next.Database != prev.Database || next.Reports.Archive != prev.Reports.Archive {
+		log.Println("Config reload: server.addr, database and reports.archive settings require a restart and were not applied")
+	}
+	next.Server.Addr = prev.Server.Addr
+	next.Database = prev.Database
+	next.Reports.Archive = prev.Reports.Archive
+
+	s.current.Store(next)
+	log.Printf("Configuration reloaded from %s", s.path)
+
+	s.mu.Lock()
+	listeners := append([]func(*Config){}, s.listeners...)
+	s.mu.Unlock()
+	for _, fn := range listeners {
+		fn(next)
+	}
+}
@@ -0,0 +1,110 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, expr := range []string{
+		"* * * * *", "0 9 * * 1-5", "*/15 0-6/2 1,15 jan-mar sun", "5/15 * * * *", " 0 0 * * 7 ",
+		"@daily", "@HOURLY", "0 0 * DEC SAT",
+	} {
+		if _, err := Parse(expr); err != nil {
+			t.Errorf("Parse(%q): %v", expr, err)
+		}
+	}
+	for _, expr := range []string{
+		"", "* * * *", "* * * * * *", "@every 5m",
+		"60 * * * *", "* 24 * * *", "* * 0 * *", "* * 32 * *", "* * * 0 * ", "* * * 13 *", "* * * * 8",
+		"5-1 * * * *", "*/0 * * * *", "*/x * * * *", "a * * * *", "1- * * * *", "* * * foo *", "* * * * sunday",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q): no error", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shanghai := time.FixedZone("CST", 8*3600)
+
+	cases := []struct {
+		name, expr string
+		loc        *time.Location
+		from       string
+		want       []string // 依次调用 Next 的结果，空字符串表示零值
+	}{
+		{"every minute excludes from", "* * * * *", shanghai, "2024-03-01T10:07:30+08:00", []string{"2024-03-01T10:08:00+08:00", "2024-03-01T10:09:00+08:00"}},
+		{"step", "*/15 * * * *", shanghai, "2024-03-01T10:07:00+08:00", []string{"2024-03-01T10:15:00+08:00", "2024-03-01T10:30:00+08:00"}},
+		{"step from value", "5/20 * * * *", shanghai, "2024-03-01T10:30:00+08:00", []string{"2024-03-01T10:45:00+08:00", "2024-03-01T11:05:00+08:00"}},
+		{"weekdays", "0 9 * * 1-5", shanghai, "2024-03-01T10:00:00+08:00", []string{"2024-03-04T09:00:00+08:00", "2024-03-05T09:00:00+08:00"}},
+		{"month names", "0 12 1 jan,jul *", shanghai, "2024-03-01T00:00:00+08:00", []string{"2024-07-01T12:00:00+08:00", "2025-01-01T12:00:00+08:00"}},
+		{"next year", "0 0 1 1 *", shanghai, "2024-12-31T23:59:00+08:00", []string{"2025-01-01T00:00:00+08:00"}},
+		{"timezone of from", "0 9 * * *", shanghai, "2024-03-01T02:00:00Z", []string{"2024-03-02T09:00:00+08:00"}},
+
+		// 日和星期都有限制时满足其一即可，其中一个为 * 或 */n 时两者都要满足
+		{"dom or dow", "0 0 13 * 5", shanghai, "2024-03-01T00:00:00+08:00", []string{"2024-03-08T00:00:00+08:00", "2024-03-13T00:00:00+08:00", "2024-03-15T00:00:00+08:00"}},
+		{"dow only", "0 0 * * 5", shanghai, "2024-03-08T00:00:00+08:00", []string{"2024-03-15T00:00:00+08:00"}},
+		{"dom only", "0 0 13 * *", shanghai, "2024-03-08T00:00:00+08:00", []string{"2024-03-13T00:00:00+08:00", "2024-04-13T00:00:00+08:00"}},
+		{"dom step and dow", "0 0 */10 * 1", shanghai, "2024-03-01T00:00:00+08:00", []string{"2024-03-11T00:00:00+08:00", "2024-04-01T00:00:00+08:00"}},
+
+		// 星期中 7 和 0 都表示星期日
+		{"7 is sunday", "0 0 * * 7", shanghai, "2024-03-01T00:00:00+08:00", []string{"2024-03-03T00:00:00+08:00", "2024-03-10T00:00:00+08:00"}},
+		{"range to 7", "0 0 * * 6-7", shanghai, "2024-03-01T00:00:00+08:00", []string{"2024-03-02T00:00:00+08:00", "2024-03-03T00:00:00+08:00", "2024-03-09T00:00:00+08:00"}},
+		{"sun", "0 0 * * sun", shanghai, "2024-03-01T00:00:00+08:00", []string{"2024-03-03T00:00:00+08:00"}},
+		{"weekly", "@weekly", shanghai, "2024-03-01T00:00:00+08:00", []string{"2024-03-03T00:00:00+08:00"}},
+
+		// 不存在的日期
+		{"february 30", "0 0 30 2 *", shanghai, "2024-01-01T00:00:00+08:00", []string{""}},
+		{"april 31", "0 0 31 4 *", shanghai, "2024-01-01T00:00:00+08:00", []string{""}},
+		{"leap day", "0 0 29 2 *", shanghai, "2024-03-01T00:00:00+08:00", []string{"2028-02-29T00:00:00+08:00"}},
+		{"31st skips short months", "0 0 31 * *", shanghai, "2024-03-31T00:00:00+08:00", []string{"2024-05-31T00:00:00+08:00"}},
+
+		// 夏令时开始，纽约 2024-03-10 02:00 跳到 03:00
+		{"spring forward skips the day", "30 2 * * *", newYork, "2024-03-10T00:00:00-05:00", []string{"2024-03-11T02:30:00-04:00"}},
+		{"spring forward hourly", "*/30 * * * *", newYork, "2024-03-10T01:15:00-05:00", []string{"2024-03-10T01:30:00-05:00", "2024-03-10T03:00:00-04:00"}},
+		{"spring forward midnight", "0 0 * * *", newYork, "2024-03-09T12:00:00-05:00", []string{"2024-03-10T00:00:00-05:00", "2024-03-11T00:00:00-04:00"}},
+
+		// 夏令时结束，纽约 2024-11-03 02:00 回到 01:00，柏林 2024-10-27 03:00 回到 02:00
+		{"fall back runs once", "30 1 * * *", newYork, "2024-11-03T00:00:00-04:00", []string{"2024-11-03T01:30:00-04:00", "2024-11-04T01:30:00-05:00"}},
+		{"fall back runs once east of utc", "30 2 * * *", berlin, "2024-10-27T00:00:00+02:00", []string{"2024-10-27T02:30:00+02:00", "2024-10-28T02:30:00+01:00"}},
+		{"fall back hourly runs twice", "30 * * * *", newYork, "2024-11-03T00:45:00-04:00", []string{"2024-11-03T01:30:00-04:00", "2024-11-03T01:30:00-05:00", "2024-11-03T02:30:00-05:00"}},
+		{"fall back every 30 minutes", "*/30 * * * *", newYork, "2024-11-03T01:15:00-04:00", []string{"2024-11-03T01:30:00-04:00", "2024-11-03T01:00:00-05:00", "2024-11-03T01:30:00-05:00", "2024-11-03T02:00:00-05:00"}},
+	}
+	for _, tc := range cases {
+		s, err := Parse(tc.expr)
+		if err != nil {
+			t.Fatalf("%s: Parse(%q): %v", tc.name, tc.expr, err)
+		}
+		from, err := time.Parse(time.RFC3339, tc.from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := from.In(tc.loc)
+		for i, want := range tc.want {
+			got = s.Next(got)
+			if want == "" {
+				if !got.IsZero() {
+					t.Errorf("%s: Next #%d = %v, want zero", tc.name, i+1, got)
+				}
+				break
+			}
+			w, err := time.Parse(time.RFC3339, want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(w) || got.Location() != tc.loc {
+				t.Errorf("%s: Next #%d = %v, want %v", tc.name, i+1, got, w.In(tc.loc))
+				break
+			}
+		}
+	}
+}
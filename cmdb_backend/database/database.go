@@ -0,0 +1,90 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+
+	"cmdb/config"
+)
+
+const (
+	DriverMySQL  = "mysql"
+	DriverSQLite = "sqlite"
+)
+
+// Open 根据配置中的驱动打开数据库连接。
+// 生产环境使用 MySQL，本地开发可以使用 SQLite 文件或 file::memory:?cache=shared。
+func Open(cfg config.DatabaseConfig) (*gorm.DB, error) {
+	switch cfg.Driver {
+	case DriverMySQL, "":
+		return gorm.Open(mysql.Open(cfg.DSN.Value()), gormConfig())
+	case DriverSQLite:
+		db, err := gorm.Open(sqliteDialector{sqlite.Open(cfg.DSN.Value()).(*sqlite.Dialector)}, gormConfig())
+		if err != nil {
+			return nil, err
+		}
+		// SQLite 只允许一个写入者，内存库在多个连接之间也不共享
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", cfg.Driver)
+	}
+}
+
+// gormConfig 为两种数据库共用的 GORM 配置。违反唯一索引的错误统一转换为 gorm.ErrDuplicatedKey，
+// 先查重再写入的接口据此把并发写入的冲突报告为 409
+func gormConfig() *gorm.Config {
+	return &gorm.Config{TranslateError: true}
+}
+
+// sqliteDialector 让迁移中按 MySQL 编写的列定义也能在 SQLite 中建表
+type sqliteDialector struct {
+	*sqlite.Dialector
+}
+
+func (d sqliteDialector) Migrator(db *gorm.DB) gorm.Migrator {
+	return sqliteMigrator{d.Dialector.Migrator(db).(sqlite.Migrator)}
+}
+
+type sqliteMigrator struct {
+	sqlite.Migrator
+}
+
+// FullDataTypeOf 去掉 SQLite 不支持的 ON UPDATE CURRENT_TIMESTAMP，SQLite 中的更新时间由 GORM 的 autoUpdateTime 维护
+func (m sqliteMigrator) FullDataTypeOf(field *schema.Field) clause.Expr {
+	expr := m.Migrator.FullDataTypeOf(field)
+	expr.SQL = strings.Replace(expr.SQL, " ON UPDATE CURRENT_TIMESTAMP", "", 1)
+	return expr
+}
+
+// DropColumn 使用 SQLite 3.35 起支持的 ALTER TABLE DROP COLUMN。
+// 驱动默认的实现会重建整张表，重建时表上其他列的索引会丢失；带索引的列需要先删除索引
+func (m sqliteMigrator) DropColumn(value interface{}, name string) error {
+	return m.RunWithValue(value, func(stmt *gorm.Statement) error {
+		if field := stmt.Schema.LookUpField(name); field != nil {
+			name = field.DBName
+		}
+		return m.DB.Exec("ALTER TABLE ? DROP COLUMN ?", m.CurrentTable(stmt), clause.Column{Name: name}).Error
+	})
+}
+
+// IsMySQL 判断当前连接是否为 MySQL，用于少数需要区分方言的 DDL
+func IsMySQL(db *gorm.DB) bool {
+	return db.Dialector.Name() == DriverMySQL
+}
+
+// Ratio 返回 used/total 的比值表达式，total 为 0 时结果为 NULL。
+// 乘以 1.0 保证在两种数据库中都按浮点数相除。
+func Ratio(used, total string) string {
+	return fmt.Sprintf("(%s * 1.0 / NULLIF(%s, 0))", used, total)
+}
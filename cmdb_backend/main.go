@@ -1,138 +1,318 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
-	"strings"
+	"os"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	_ "github.com/go-sql-driver/mysql"
-	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
+	"cmdb/audit"
+	"cmdb/blob"
+	"cmdb/config"
+	"cmdb/database"
+	"cmdb/migrations"
 	"cmdb/models"
 	"cmdb/services"
+)
 
-	"context"
+var (
+	db           *gorm.DB
+	cfg          *config.Store
+	emailService *services.EmailService
+	idcResolver  *services.IDCResolver
+	metricsStore *services.MetricsStore
+	alertService *services.AlertService
+	hostService  *services.HostService
 
-	"github.com/chromedp/chromedp"
+	subscriptionService *services.SubscriptionService
 )
 
-var db *gorm.DB
-
 func main() {
 	// 初始化随机数生成器
 	rand.Seed(time.Now().UnixNano())
 
-	// 初始化数据库连接和生成模拟数据
+	configPath := flag.String("config", defaultConfigPath(), "配置文件路径（YAML 或 TOML）")
+	flag.Usage = printUsage
+	flag.Parse()
+
+	var err error
+	if cfg, err = config.NewStore(*configPath); err != nil {
+		log.Fatal("Failed to load configuration: ", err)
+	}
+
+	switch flag.Arg(0) {
+	case "", "serve":
+		serve()
+	case "migrate":
+		runMigrate(flag.Args()[1:])
+	case "seed":
+		runSeed()
+	case "user":
+		runUser(flag.Args()[1:])
+	case "token":
+		runToken(flag.Args()[1:])
+	default:
+		printUsage()
+		os.Exit(2)
+	}
+}
+
+func serve() {
+	// 初始化数据库连接，数据库结构落后时拒绝启动
 	initDB()
+	if err := migrations.NewMigrator(db).CheckCurrent(); err != nil {
+		log.Fatalf("Refusing to serve: %v (run `cmdb migrate up` first)", err)
+	}
+	initAudit("")
+
+	// 热加载非连接类配置（CORS、SMTP 等）
+	go cfg.Watch(cfg.Current().Server.ReloadInterval.Duration, nil)
+
+	r := newRouter()
 
-	r := gin.Default()
+	// 后台任务：同步其他实例修改的机房网段、历史数据降采样和过期清理、发送邮件、评估告警和执行报告订阅
+	go idcResolver.Run(cfg.Current().Server.IDCRefreshInterval.Duration, nil)
+	go metricsStore.Run(nil)
+	go emailService.Run(nil)
+	go alertService.Run(nil)
+	go subscriptionService.Run(nil)
 
-	// 添加CORS中间件
-	config := cors.DefaultConfig()
-	config.AllowOrigins = []string{"http://localhost:3000"} // 前端运行的地址
-	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type"}
-	r.Use(cors.New(config))
+	// 启动服务器
+	if err := r.Run(cfg.Current().Server.Addr); err != nil {
+		log.Fatal("Failed to start server:", err)
+	}
+}
+
+// newRouter 创建各个服务并注册路由，后台任务由调用方启动
+func newRouter() *gin.Engine {
+	metricsStore = services.NewMetricsStore(db, cfg)
+
+	r := gin.New()
+	r.Use(gin.LoggerWithFormatter(requestLogFormat), gin.Recovery())
+
+	// 添加CORS中间件，允许的来源每次请求时从当前配置读取
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowOriginFunc = func(origin string) bool {
+		return cfg.Current().CORS.AllowsOrigin(origin)
+	}
+	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
+	corsConfig.ExposeHeaders = []string{"Content-Disposition", "X-Report-Archive-Id", "X-Checksum-SHA256"}
+	r.Use(cors.New(corsConfig))
+
+	// 除登录相关接口外，/api/cmdb/v1 下的接口都需要携带访问令牌或 API 令牌
+	authService := services.NewAuthService(db, cfg)
+	r.Use(authService.Middleware())
+	// 按角色和部门检查权限
+	accessService := services.NewAccessService(db)
+	r.Use(accessService.Middleware())
 
 	// 初始化服务
-	resourceService := &services.ResourceService{DB: db}
-	reportService := &services.ReportService{DB: db}
-	emailService := &services.EmailService{
-		SMTPHost:     "smtp.163.com",
-		SMTPPort:     465,
-		SMTPUser:     "xjxyxgq@163.com",
-		SMTPPassword: "JSSHABDKJMXUHARS",
+	var err error
+	if idcResolver, err = services.NewIDCResolver(db); err != nil {
+		log.Fatal("Failed to load IDC networks:", err)
+	}
+	idcService := services.NewIDCService(db, idcResolver)
+	resourceService := services.NewResourceService(db, metricsStore)
+	metricsService := services.NewMetricsService(db)
+	forecastService := services.NewForecastService(db)
+	emailService = services.NewEmailService(db, cfg)
+	notificationService := services.NewNotificationService(db, emailService, cfg)
+	alertService = services.NewAlertService(db, forecastService, notificationService, cfg)
+	archive, err := blob.Open(cfg.Current().Reports.Archive)
+	if err != nil {
+		log.Fatal("Failed to open report archive: ", err)
 	}
+	reportService := services.NewReportService(db, idcResolver, forecastService, alertService, emailService, archive, cfg)
+	subscriptionService = services.NewSubscriptionService(db, reportService, cfg)
+	hostService = services.NewHostService(db, idcResolver.Resolve)
+	applicationService := services.NewApplicationService(db)
+	auditService := services.NewAuditService(db)
+	clusterGroupService := services.NewClusterGroupService(db)
 
 	// 设置路由
-	r.GET("/api/cmdb/v1/get_hosts_pool_detail", getHostsPoolDetail)
+	r.POST("/api/cmdb/v1/auth/login", authService.Login)
+	r.POST("/api/cmdb/v1/auth/refresh", authService.Refresh)
+	r.POST("/api/cmdb/v1/auth/logout", authService.Logout)
+	r.GET("/api/cmdb/v1/auth/me", authService.Me)
+	r.POST("/api/cmdb/v1/auth/password", authService.ChangePassword)
+	r.GET("/api/cmdb/v1/auth/oidc/login", authService.OIDCLogin)
+	r.GET("/api/cmdb/v1/auth/oidc/callback", authService.OIDCCallback)
+
+	// 用户管理
+	r.GET("/api/cmdb/v1/users", authService.ListUsers)
+	r.POST("/api/cmdb/v1/users", authService.CreateUser)
+	r.GET("/api/cmdb/v1/users/:id", authService.GetUser)
+	r.PATCH("/api/cmdb/v1/users/:id", authService.UpdateUser)
+	r.DELETE("/api/cmdb/v1/users/:id", authService.DeleteUser)
+	r.GET("/api/cmdb/v1/role-bindings", accessService.ListRoleBindings)
+	r.POST("/api/cmdb/v1/role-bindings", accessService.CreateRoleBinding)
+	r.PATCH("/api/cmdb/v1/role-bindings/:id", accessService.UpdateRoleBinding)
+	r.DELETE("/api/cmdb/v1/role-bindings/:id", accessService.DeleteRoleBinding)
+	r.GET("/api/cmdb/v1/api-tokens", authService.ListAPITokens)
+	r.POST("/api/cmdb/v1/api-tokens", authService.CreateAPIToken)
+	r.GET("/api/cmdb/v1/api-tokens/:id", authService.GetAPIToken)
+	r.DELETE("/api/cmdb/v1/api-tokens/:id", authService.RevokeAPIToken)
+	r.GET("/api/cmdb/v1/audit-logs", auditService.ListAuditLogs)
+	r.GET("/api/cmdb/v1/audit-logs/export", auditService.ExportAuditLogs)
+
+	r.GET("/api/cmdb/v1/get_hosts_pool_detail", hostService.ListHosts)
+	r.GET("/api/cmdb/v1/host-filter-options", hostService.GetHostFilterOptions)
 	r.GET("/api/cmdb/v1/get_host_detail/:id", getHostDetail)
+	r.GET("/api/cmdb/v1/inventory/diff", hostService.DiffInventory)
 	r.POST("/api/cmdb/v1/collect_applications", collectApplications)
 	r.GET("/api/cmdb/v1/get_cluster_usage", getClusterUsage)
 
+	// 机房网段
+	r.GET("/api/cmdb/v1/idcs", idcService.ListIDCs)
+	r.POST("/api/cmdb/v1/idcs", idcService.CreateIDC)
+	r.GET("/api/cmdb/v1/idcs/resolve", idcService.ResolveIP)
+	r.PATCH("/api/cmdb/v1/idcs/:id", idcService.UpdateIDC)
+	r.DELETE("/api/cmdb/v1/idcs/:id", idcService.DeleteIDC)
+
+	// 主机增删改
+	r.POST("/api/cmdb/v1/hosts", hostService.CreateHost)
+	r.PUT("/api/cmdb/v1/hosts/:id", hostService.UpdateHost)
+	r.PATCH("/api/cmdb/v1/hosts/:id", hostService.PatchHost)
+	r.DELETE("/api/cmdb/v1/hosts/:id", hostService.DeleteHost)
+
+	// 主机上的应用
+	r.GET("/api/cmdb/v1/hosts/:id/applications", applicationService.ListApplications)
+	r.POST("/api/cmdb/v1/hosts/:id/applications", applicationService.CreateApplication)
+	r.PUT("/api/cmdb/v1/hosts/:id/applications/:app_id", applicationService.UpdateApplication)
+	r.PATCH("/api/cmdb/v1/hosts/:id/applications/:app_id", applicationService.PatchApplication)
+	r.DELETE("/api/cmdb/v1/hosts/:id/applications/:app_id", applicationService.DeleteApplication)
+	r.GET("/api/cmdb/v1/get_application_detail/:id", applicationService.GetApplicationDetail)
+
+	// 告警规则
+	r.GET("/api/cmdb/v1/alert-rules", alertService.ListAlertRules)
+	r.POST("/api/cmdb/v1/alert-rules", alertService.CreateAlertRule)
+	r.GET("/api/cmdb/v1/alert-rules/:id", alertService.GetAlertRule)
+	r.PATCH("/api/cmdb/v1/alert-rules/:id", alertService.UpdateAlertRule)
+	r.DELETE("/api/cmdb/v1/alert-rules/:id", alertService.DeleteAlertRule)
+
+	// 告警处理
+	r.GET("/api/cmdb/v1/alerts", alertService.ListAlerts)
+	r.GET("/api/cmdb/v1/alerts/history", alertService.GetAlertHistory)
+	r.POST("/api/cmdb/v1/alerts/evaluate", alertService.EvaluateAlerts)
+	r.GET("/api/cmdb/v1/alerts/:id", alertService.GetAlert)
+	r.POST("/api/cmdb/v1/alerts/:id/ack", alertService.AcknowledgeAlert)
+	r.POST("/api/cmdb/v1/alerts/:id/unack", alertService.UnacknowledgeAlert)
+	r.POST("/api/cmdb/v1/alerts/:id/silence", alertService.SilenceAlert)
+	r.DELETE("/api/cmdb/v1/alerts/:id/silence", alertService.UnsilenceAlert)
+	r.POST("/api/cmdb/v1/alerts/:id/comments", alertService.CommentAlert)
+
+	// 通知渠道和路由
+	r.GET("/api/cmdb/v1/notification-channels", notificationService.ListChannels)
+	r.POST("/api/cmdb/v1/notification-channels", notificationService.CreateChannel)
+	r.GET("/api/cmdb/v1/notification-channels/:id", notificationService.GetChannel)
+	r.PATCH("/api/cmdb/v1/notification-channels/:id", notificationService.UpdateChannel)
+	r.DELETE("/api/cmdb/v1/notification-channels/:id", notificationService.DeleteChannel)
+	r.POST("/api/cmdb/v1/notification-channels/:id/test", notificationService.TestChannel)
+	r.GET("/api/cmdb/v1/notification-routes", notificationService.ListRoutes)
+	r.POST("/api/cmdb/v1/notification-routes", notificationService.CreateRoute)
+	r.PATCH("/api/cmdb/v1/notification-routes/:id", notificationService.UpdateRoute)
+	r.DELETE("/api/cmdb/v1/notification-routes/:id", notificationService.DeleteRoute)
+
 	// 添加新的接口
 	r.GET("/api/cmdb/v1/cluster-resource-usage", resourceService.GetClusterResourceUsage)
-	r.GET("/api/cmdb/v1/resource-alerts", resourceService.GetResourceAlerts)
-	r.GET("/api/cmdb/v1/disk-full-prediction", resourceService.PredictDiskFullDate)
+	r.GET("/api/cmdb/v1/resource-alerts", alertService.GetResourceAlerts)
+	r.GET("/api/cmdb/v1/disk-full-prediction", forecastService.PredictDiskFullDate)
 	r.GET("/api/cmdb/v1/cluster-group-report", reportService.GenerateClusterGroupReport)
 	r.GET("/api/cmdb/v1/idc-report", reportService.GenerateIDCReport)
+	r.GET("/api/cmdb/v1/alert-report", reportService.GenerateAlertReport)
+	r.POST("/api/cmdb/v1/send-report", reportService.SendReport)
+	r.GET("/api/cmdb/v1/report-archives", reportService.ListArchives)
+	r.GET("/api/cmdb/v1/report-archives/compare", reportService.CompareArchives)
+	r.GET("/api/cmdb/v1/report-archives/:id", reportService.GetArchive)
+	r.GET("/api/cmdb/v1/report-archives/:id/download", reportService.DownloadArchive)
+	r.GET("/api/cmdb/v1/report-subscriptions", subscriptionService.ListSubscriptions)
+	r.POST("/api/cmdb/v1/report-subscriptions", subscriptionService.CreateSubscription)
+	r.GET("/api/cmdb/v1/report-subscriptions/:id", subscriptionService.GetSubscription)
+	r.PATCH("/api/cmdb/v1/report-subscriptions/:id", subscriptionService.UpdateSubscription)
+	r.DELETE("/api/cmdb/v1/report-subscriptions/:id", subscriptionService.DeleteSubscription)
+	r.POST("/api/cmdb/v1/report-subscriptions/:id/run", subscriptionService.RunSubscription)
+	r.GET("/api/cmdb/v1/report-subscriptions/:id/runs", subscriptionService.ListRuns)
 	r.GET("/api/cmdb/v1/server-resources", resourceService.GetServerResources)
 	r.POST("/api/cmdb/v1/insert-server-resource", resourceService.InsertServerResource)
+	r.GET("/api/cmdb/v1/metrics/series", metricsService.GetSeries)
 	r.POST("/api/cmdb/v1/send-email", emailService.SendEmail)
+	r.GET("/api/cmdb/v1/emails", emailService.ListEmails)
+	r.GET("/api/cmdb/v1/emails/:id", emailService.GetEmail)
+	r.POST("/api/cmdb/v1/emails/:id/resend", emailService.ResendEmail)
+	r.GET("/api/cmdb/v1/email-profiles", emailService.ListProfiles)
+	r.POST("/api/cmdb/v1/email-profiles/:name/verify", emailService.VerifyProfile)
 	r.GET("/api/cmdb/v1/cluster-groups", resourceService.GetClusterGroups)
-	r.POST("/api/cmdb/v1/generate-and-send-report", generateAndSendReport)
-	r.POST("/api/cmdb/v1/trigger-report", triggerReport)
-
-	// 启动服务器
-	if err := r.Run(":8080"); err != nil {
-		log.Fatal("Failed to start server:", err)
-	}
+	r.POST("/api/cmdb/v1/cluster-groups", clusterGroupService.CreateClusterGroup)
+	r.GET("/api/cmdb/v1/cluster-groups/:id", clusterGroupService.GetClusterGroup)
+	r.PATCH("/api/cmdb/v1/cluster-groups/:id", clusterGroupService.UpdateClusterGroup)
+	r.POST("/api/cmdb/v1/cluster-groups/:id/merge", clusterGroupService.MergeClusterGroup)
+	r.DELETE("/api/cmdb/v1/cluster-groups/:id", clusterGroupService.DeleteClusterGroup)
+	r.GET("/api/cmdb/v1/usage-report", reportService.PreviewUsageReport)
+	r.POST("/api/cmdb/v1/generate-and-send-report", reportService.SendUsageReport)
+	r.POST("/api/cmdb/v1/trigger-report", reportService.SendUsageReport)
+	return r
 }
 
-func getHostsPoolDetail(c *gin.Context) {
-	var hosts []models.HostPool
-	if err := db.Preload("HostApplications").Find(&hosts).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch hosts"})
-		return
-	}
-
-	// 获取所有的 ClusterGroup
-	var clusterGroups []models.ClusterGroup
-	if err := db.Find(&clusterGroups).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cluster groups"})
-		return
-	}
-
-	// 创建 ClusterGroup 映射
-	clusterGroupMap := make(map[string]string)
-	for _, cg := range clusterGroups {
-		clusterGroupMap[cg.ClusterName] = cg.DepartmentName
+// requestLogFormat 在 gin 默认的访问日志格式后加上请求者：登录用户为用户名，API 令牌为 token:<名称>
+func requestLogFormat(param gin.LogFormatterParams) string {
+	actor, _ := param.Keys["actor"].(string)
+	if actor == "" {
+		actor = "-"
 	}
-
-	// 为每个 HostApplication 添加 DepartmentName
-	for i := range hosts {
-		for j := range hosts[i].HostApplications {
-			hosts[i].HostApplications[j].DepartmentName = clusterGroupMap[hosts[i].HostApplications[j].ClusterName]
-		}
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
 	}
-
-	c.JSON(http.StatusOK, hosts)
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v | %s\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		param.Path,
+		actor,
+		param.ErrorMessage,
+	)
 }
 
 func collectApplications(c *gin.Context) {
-	// 这里我们将模拟数据生成
-	generateMockData()
+	// 这里我们将模拟数据生成，写入记在请求者名下
+	if err := generateMockData(db.WithContext(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Mock data generated successfully"})
 }
 
 func initDB() {
 	var err error
-	dsn := "root:nov24feb11@tcp(127.0.0.1:3311)/cmdb?charset=utf8mb4&parseTime=True&loc=Local"
-	db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	db, err = database.Open(cfg.Current().Database)
 	if err != nil {
 		log.Fatal("Failed to connect database:", err)
 	}
+}
 
-	// 删除现有的表
-	db.Migrator().DropTable(&models.HostPool{}, &models.HostApplication{}, &models.ServerResource{}, &models.ClusterGroup{})
-
-	// 重新创建表
-	if err := db.AutoMigrate(&models.HostPool{}, &models.HostApplication{}, &models.ServerResource{}, &models.ClusterGroup{}); err != nil {
-		log.Fatal("Failed to auto migrate:", err)
+// initAudit 注册审计回调。actor 不为空时（命令行）全局 db 上的写入都记在 actor 名下，
+// HTTP 服务中的写入通过 db.WithContext(c) 记在请求者名下
+func initAudit(actor string) {
+	if err := services.RegisterAudit(db); err != nil {
+		log.Fatal("Failed to register audit callbacks: ", err)
+	}
+	if actor != "" {
+		db = db.WithContext(audit.WithActor(context.Background(), actor))
 	}
-
-	// 生成模拟数据
-	generateMockData()
-	log.Println("Mock data has been generated.")
 }
 
-func generateMockData() {
+func generateMockData(db *gorm.DB) error {
 	// 1. 生成 cluster_group 数据
 	clusterGroups := []models.ClusterGroup{}
 	for i := 1; i <= 7; i++ {
@@ -144,8 +324,11 @@ func generateMockData() {
 			})
 		}
 	}
-	if err := db.Create(&clusterGroups).Error; err != nil {
-		log.Fatal("Failed to create cluster groups:", err)
+	// cluster_name 唯一，重复生成时沿用已存在的集群
+	for i := range clusterGroups {
+		if err := db.Where(models.ClusterGroup{ClusterName: clusterGroups[i].ClusterName}).FirstOrCreate(&clusterGroups[i]).Error; err != nil {
+			return fmt.Errorf("failed to create cluster groups: %w", err)
+		}
 	}
 
 	// 2. 生成 hosts_pool 数据
@@ -162,35 +345,41 @@ func generateMockData() {
 			RackNumber:   fmt.Sprintf("R%02d", (i-1)/6+1),
 			RackHeight:   uint(rand.Intn(4) + 1),
 		}
+		host.IDCName = idcResolver.Resolve(host.HostIP)
+		// host_ip 唯一，重复生成时沿用已存在的主机
+		if err := db.Where(models.HostPool{HostIP: host.HostIP}).FirstOrCreate(&host).Error; err != nil {
+			return fmt.Errorf("failed to create hosts: %w", err)
+		}
 		hosts = append(hosts, host)
 	}
-	if err := db.Create(&hosts).Error; err != nil {
-		log.Fatal("Failed to create hosts:", err)
-	}
 
 	// 3. 生成 hosts_applications 数据
 	for _, host := range hosts {
 		numApps := rand.Intn(3) + 1
 		for j := 0; j < numApps; j++ {
 			clusterGroup := clusterGroups[rand.Intn(len(clusterGroups))]
+			// 同一主机上的应用使用不同端口，满足 (pool_id, server_addr, server_protocol) 唯一
+			port := 3000 + rand.Intn(1000)*3 + j
 			app := models.HostApplication{
 				PoolID:         host.ID,
 				ServerType:     []string{"MySQL", "Redis", "MongoDB", "Nginx", "Kafka", "Elasticsearch"}[rand.Intn(6)],
 				ServerVersion:  fmt.Sprintf("%d.%d.%d", rand.Intn(5)+1, rand.Intn(10), rand.Intn(20)),
 				ServerProtocol: []string{"TCP", "HTTP", "HTTPS"}[rand.Intn(3)],
-				ServerAddr:     fmt.Sprintf("%s:%d", host.HostIP, 3000+rand.Intn(3000)),
+				ServerAddr:     fmt.Sprintf("%s:%d", host.HostIP, port),
 				ClusterName:    clusterGroup.ClusterName,
-				ServerPort:     3000 + rand.Intn(3000),
+				ServerPort:     port,
 				ServerRole:     []string{"master", "slave"}[rand.Intn(2)],
 				ServerStatus:   []string{"running", "stopped", "maintenance"}[rand.Intn(3)],
 			}
-			if err := db.Create(&app).Error; err != nil {
-				log.Fatal("Failed to create application:", err)
+			if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&app).Error; err != nil {
+				return fmt.Errorf("failed to create application: %w", err)
 			}
 		}
 	}
 
-	// 4. 生成 server_resources 数据
+	// 4. 生成 server_resources 数据和最近 14 天的每小时采样，磁盘用量随时间增长
+	const historyHours = 14 * 24
+	now := time.Now().Truncate(time.Minute)
 	for _, host := range hosts {
 		// 随机选择一个 ClusterGroup
 		clusterGroup := clusterGroups[rand.Intn(len(clusterGroups))]
@@ -208,18 +397,57 @@ func generateMockData() {
 			UsedDisk:     float64(host.DiskSize*1024) * (0.2 + rand.Float64()*0.7),
 			CPUCores:     int(host.VCPUs),
 			CPULoad:      20.0 + rand.Float64()*70.0,
-			DateTime:     time.Now().Add(-time.Duration(rand.Intn(24)) * time.Hour),
+			DateTime:     now,
+		}
+
+		growth := rand.Float64() * 0.3
+		samples := make([]models.MetricSample, 0, historyHours)
+		for h := historyHours; h > 0; h-- {
+			age := float64(h) / historyHours
+			samples = append(samples, models.MetricSample{
+				PoolID:      host.ID,
+				IP:          serverResource.IP,
+				Port:        serverResource.Port,
+				ClusterName: serverResource.ClusterName,
+				GroupName:   serverResource.GroupName,
+				TotalMemory: serverResource.TotalMemory,
+				UsedMemory:  serverResource.UsedMemory * (0.85 + rand.Float64()*0.3),
+				TotalDisk:   serverResource.TotalDisk,
+				UsedDisk:    serverResource.UsedDisk * (1 - growth*age) * (0.99 + rand.Float64()*0.02),
+				CPUCores:    serverResource.CPUCores,
+				CPULoad:     20.0 + rand.Float64()*70.0,
+				SampledAt:   now.Add(-time.Duration(h) * time.Hour).UTC(),
+			})
 		}
-		if err := db.Create(&serverResource).Error; err != nil {
-			log.Fatal("Failed to create server resource:", err)
+		if err := db.CreateInBatches(&samples, 200).Error; err != nil {
+			return fmt.Errorf("failed to create metric samples: %w", err)
 		}
+		if err := metricsStore.Record(&serverResource); err != nil {
+			return fmt.Errorf("failed to create server resource: %w", err)
+		}
+	}
+	if err := metricsStore.Rebuild(now.Add(-historyHours*time.Hour), now); err != nil {
+		return fmt.Errorf("failed to roll up metric samples: %w", err)
 	}
+	return nil
 }
 
 func getHostDetail(c *gin.Context) {
+	// 指定 as_of 时返回主机在该时刻的信息
+	if c.Query("as_of") != "" {
+		hostService.GetHostAsOf(c)
+		return
+	}
 	id := c.Param("id")
+	access := services.CurrentAccess(c)
+	// 只返回当前用户可见部门的应用
+	query := db.Preload("HostApplications", access.ApplicationScope).Scopes(access.HostScope)
+	if !services.IncludeDeleted(c) {
+		query = query.Scopes(services.NotDeletedHosts)
+	}
+
 	var host models.HostPool
-	if err := db.Preload("HostApplications").First(&host, id).Error; err != nil {
+	if err := query.First(&host, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Host not found"})
 		return
 	}
@@ -228,7 +456,7 @@ func getHostDetail(c *gin.Context) {
 
 func getClusterUsage(c *gin.Context) {
 	var serverResources []models.ServerResource
-	if err := db.Find(&serverResources).Error; err != nil {
+	if err := db.Scopes(services.CurrentAccess(c).ClusterScope("cluster_name")).Find(&serverResources).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch server resources"})
 		return
 	}
@@ -237,12 +465,12 @@ func getClusterUsage(c *gin.Context) {
 
 	for _, resource := range serverResources {
 		var host models.HostPool
-		if err := db.First(&host, resource.PoolID).Error; err != nil {
+		if err := db.Scopes(services.NotDeletedHosts).First(&host, resource.PoolID).Error; err != nil {
 			log.Printf("Failed to find host for PoolID %d: %v", resource.PoolID, err)
 			continue
 		}
 
-		idcName := getIDCNameFromIP(host.HostIP)
+		idcName := idcResolver.Resolve(host.HostIP)
 
 		if _, exists := idcUsageMap[idcName]; !exists {
 			idcUsageMap[idcName] = &models.IDCUsage{IDCName: idcName}
@@ -267,242 +495,3 @@ func getClusterUsage(c *gin.Context) {
 
 	c.JSON(http.StatusOK, idcUsages)
 }
-
-func getIDCNameFromIP(ip string) string {
-	// 这里实现根据 IP 地址判断 IDC 的逻辑
-	// 例如，可以根据 IP 地址的前两位来判断
-	parts := strings.Split(ip, ".")
-	if len(parts) >= 2 {
-		switch parts[1] {
-		case "1":
-			return "P1"
-		case "2":
-			return "P2"
-		case "3":
-			return "P3"
-		case "4":
-			return "P4"
-		case "5":
-			return "P5"
-		case "6":
-			return "P6"
-		default:
-			return "Unknown-IDC"
-		}
-	}
-	return "Unknown-IDC"
-}
-
-func generateAndSendReport(c *gin.Context) {
-	var request struct {
-		Email string `json:"email"`
-		HTML  string `json:"html"`
-	}
-
-	if err := c.BindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	// 使用 chromedp 生成截图
-	ctx, cancel := chromedp.NewContext(context.Background())
-	defer cancel()
-
-	var buf []byte
-	if err := chromedp.Run(ctx,
-		chromedp.EmulateViewport(1920, 1080),
-		chromedp.Navigate("data:text/html,"+request.HTML),
-		chromedp.CaptureScreenshot(&buf),
-	); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate screenshot"})
-		return
-	}
-
-	// 创建邮件内容
-	emailContent := fmt.Sprintf(`
-        <html>
-            <body>
-                <h1>服务器资源使用情况报告</h1>
-                <img src="cid:screenshot" alt="Server Resources" style="max-width: 100%%;" />
-            </body>
-        </html>
-    `)
-
-	// 发送邮件
-	err := sendEmail(request.Email, "服务器资源使用情况报告", emailContent, buf)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send email"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Report generated and sent successfully"})
-}
-
-func sendEmail(to, subject, body string, attachment []byte) error {
-	// 这里使用您的邮件服务配置
-	emailService := &services.EmailService{
-		SMTPHost:     "smtp.163.com",
-		SMTPPort:     465,
-		SMTPUser:     "xjxyxgq@163.com",
-		SMTPPassword: "JSSHABDKJMXUHARS",
-	}
-
-	return emailService.SendEmailWithAttachment(to, subject, body, attachment)
-}
-
-func triggerReport(c *gin.Context) {
-	var request struct {
-		Email string `json:"email"`
-	}
-
-	if err := c.BindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	htmlContent := generateHTMLContent()
-
-	err := generateAndSendReportLogic(request.Email, htmlContent)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate and send report"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Report triggered successfully"})
-}
-
-func generateHTMLContent() string {
-	var serverResources []models.ServerResource
-	db.Find(&serverResources)
-
-	var clusterGroups []models.ClusterGroup
-	db.Find(&clusterGroups)
-
-	var html strings.Builder
-	html.WriteString(`
-    <html>
-    <head>
-        <style>
-            body { font-family: Arial, sans-serif; }
-            table { border-collapse: collapse; width: 100%; }
-            th, td { border: 1px solid #ddd; padding: 8px; text-align: left; }
-            th { background-color: #f2f2f2; }
-            .alert { padding: 10px; margin-bottom: 10px; border-radius: 4px; }
-            .alert-warning { background-color: #fff3cd; color: #856404; }
-            .alert-danger { background-color: #f8d7da; color: #721c24; }
-        </style>
-    </head>
-    <body>
-        <h1>服务器资源使用情况报告</h1>
-    `)
-
-	// 资源警报
-	html.WriteString("<h2>资源警报</h2>")
-	for _, resource := range serverResources {
-		memoryUsage := (resource.UsedMemory / resource.TotalMemory) * 100
-		diskUsage := (resource.UsedDisk / resource.TotalDisk) * 100
-		cpuUsage := resource.CPULoad
-
-		if memoryUsage > 80 || diskUsage > 80 || cpuUsage > 80 {
-			html.WriteString(fmt.Sprintf(`
-                <div class="alert alert-danger">
-                    %s (%s %s) | 内存: %.2f%% | 磁盘: %.2f%% | CPU: %.2f%%
-                </div>
-            `, resource.IP, resource.GroupName, resource.ClusterName, memoryUsage, diskUsage, cpuUsage))
-		} else if memoryUsage < 10 || diskUsage < 10 || cpuUsage < 10 {
-			html.WriteString(fmt.Sprintf(`
-                <div class="alert alert-warning">
-                    %s (%s %s) | 内存: %.2f%% | 磁盘: %.2f%% | CPU: %.2f%%
-                </div>
-            `, resource.IP, resource.GroupName, resource.ClusterName, memoryUsage, diskUsage, cpuUsage))
-		}
-	}
-
-	// 集群资源使用情况
-	html.WriteString("<h2>集群资源使用情况</h2>")
-	for _, group := range clusterGroups {
-		html.WriteString(fmt.Sprintf("<h3>%s-%s</h3>", group.GroupName, group.ClusterName))
-		html.WriteString("<table>")
-		html.WriteString("<tr><th>资源</th><th>使用率</th></tr>")
-
-		var memoryUsage, diskUsage, cpuUsage float64
-		var count int
-		for _, resource := range serverResources {
-			if resource.GroupName == group.GroupName {
-				memoryUsage += (resource.UsedMemory / resource.TotalMemory) * 100
-				diskUsage += (resource.UsedDisk / resource.TotalDisk) * 100
-				cpuUsage += resource.CPULoad
-				count++
-			}
-		}
-		if count > 0 {
-			html.WriteString(fmt.Sprintf("<tr><td>内存</td><td>%.2f%%</td></tr>", memoryUsage/float64(count)))
-			html.WriteString(fmt.Sprintf("<tr><td>磁盘</td><td>%.2f%%</td></tr>", diskUsage/float64(count)))
-			html.WriteString(fmt.Sprintf("<tr><td>CPU</td><td>%.2f%%</td></tr>", cpuUsage/float64(count)))
-		}
-		html.WriteString("</table>")
-	}
-
-	// 服务器资源详情
-	html.WriteString("<h2>服务器资源详情</h2>")
-	html.WriteString(`
-        <table>
-            <tr>
-                <th>Instance ID</th>
-                <th>IP</th>
-                <th>Cluster Name</th>
-                <th>Group Name</th>
-                <th>CPU Usage</th>
-                <th>Memory Usage</th>
-                <th>Disk Usage</th>
-            </tr>
-    `)
-	for _, resource := range serverResources {
-		html.WriteString(fmt.Sprintf(`
-            <tr>
-                <td>%s</td>
-                <td>%s</td>
-                <td>%s</td>
-                <td>%s</td>
-                <td>%.2f%%</td>
-                <td>%.2f%%</td>
-                <td>%.2f%%</td>
-            </tr>
-        `,
-			resource.IP,
-			resource.ClusterName,
-			resource.GroupName,
-			resource.CPULoad,
-			(resource.UsedMemory/resource.TotalMemory)*100,
-			(resource.UsedDisk/resource.TotalDisk)*100))
-	}
-	html.WriteString("</table>")
-
-	html.WriteString("</body></html>")
-	return html.String()
-}
-
-func generateAndSendReportLogic(email, htmlContent string) error {
-	ctx, cancel := chromedp.NewContext(context.Background())
-	defer cancel()
-
-	var buf []byte
-	if err := chromedp.Run(ctx,
-		chromedp.EmulateViewport(1920, 1080),
-		chromedp.Navigate("data:text/html,"+htmlContent),
-		chromedp.CaptureScreenshot(&buf),
-	); err != nil {
-		return err
-	}
-
-	emailBody := fmt.Sprintf(`
-        <html>
-            <body>
-                <h1>服务器资源使用情况报告</h1>
-                <img src="cid:screenshot" alt="Server Resources" style="max-width: 100%%;" />
-            </body>
-        </html>
-    `)
-
-	return sendEmail(email, "服务器资源使用情况报告", emailBody, buf)
-}
@@ -0,0 +1,1127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"cmdb/audit"
+	"cmdb/config"
+	"cmdb/migrations"
+	"cmdb/models"
+	"cmdb/services"
+)
+
+const (
+	testAdmin    = "admin"
+	testPassword = "correct horse battery staple"
+)
+
+// newTestServer 在内存 SQLite 上执行全部迁移并创建路由，返回管理员登录后的令牌
+func newTestServer(t *testing.T) (*gin.Engine, services.TokenResponse) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("CMDB_DATABASE_DRIVER", "sqlite")
+	t.Setenv("CMDB_DATABASE_DSN", ":memory:")
+	t.Setenv("CMDB_AUTH_JWT_SECRET", "0123456789abcdef0123456789abcdef-test")
+	t.Setenv("CMDB_REPORTS_ARCHIVE_DIR", t.TempDir())
+
+	var err error
+	if cfg, err = config.NewStore(""); err != nil {
+		t.Fatal(err)
+	}
+	initDB()
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if _, err := migrations.NewMigrator(db).Up(); err != nil {
+		t.Fatal(err)
+	}
+	if err := services.RegisterAudit(db); err != nil {
+		t.Fatal(err)
+	}
+	r := newRouter()
+
+	authService := services.NewAuthService(db, cfg)
+	user, err := authService.CreateLocalUser(audit.WithActor(context.Background(), "test"), testAdmin, testPassword, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := services.NewAccessService(db).Grant(user.ID, services.RoleAdmin, "", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := request(r, http.MethodPost, "/api/cmdb/v1/auth/login", "", `{"username":"admin","password":"`+testPassword+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", w.Code, w.Body)
+	}
+	var tokens services.TokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &tokens); err != nil {
+		t.Fatal(err)
+	}
+	return r, tokens
+}
+
+func request(r http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// matchRoute 判断 path 是否匹配路由 pattern，返回匹配的参数个数，静态路由优先
+func matchRoute(pattern, path string) (int, bool) {
+	ps, xs := strings.Split(pattern, "/"), strings.Split(path, "/")
+	if len(ps) != len(xs) {
+		return 0, false
+	}
+	params := 0
+	for i := range ps {
+		if strings.HasPrefix(ps[i], ":") {
+			params++
+			continue
+		}
+		if ps[i] != xs[i] {
+			return 0, false
+		}
+	}
+	return params, true
+}
+
+func routeOf(routes gin.RoutesInfo, method, path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	best, bestParams := "", -1
+	for _, route := range routes {
+		if route.Method != method {
+			continue
+		}
+		if params, ok := matchRoute(route.Path, path); ok && (bestParams < 0 || params < bestParams) {
+			best, bestParams = route.Path, params
+		}
+	}
+	return best
+}
+
+// TestRoutes 按顺序调用每一个注册的接口，后面的用例依赖前面创建的数据
+func TestRoutes(t *testing.T) {
+	r, tokens := newTestServer(t)
+
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer webhook.Close()
+
+	now := time.Now().UTC()
+	from := now.Add(-time.Hour).Format(time.RFC3339)
+	to := now.Add(time.Hour).Format(time.RFC3339)
+	silenceUntil := now.Add(time.Hour).Format(time.RFC3339)
+
+	const v1 = "/api/cmdb/v1"
+	cases := []struct {
+		method, path, body string
+		want               int
+	}{
+		// 登录和当前用户
+		{"POST", "/auth/login", `{"username":"admin","password":"wrong"}`, 401},
+		{"GET", "/auth/me", "", 200},
+		{"POST", "/auth/refresh", `{"refresh_token":"` + tokens.RefreshToken + `"}`, 200},
+		{"POST", "/auth/password", `{"current_password":"` + testPassword + `","new_password":"another horse battery staple"}`, 200},
+		{"GET", "/auth/oidc/login", "", 404},
+		{"GET", "/auth/oidc/callback", "", 404},
+
+		// 用户、角色和 API 令牌
+		{"POST", "/users", `{"username":"viewer","password":"viewer horse battery staple"}`, 201},
+		{"GET", "/users", "", 200},
+		{"GET", "/users/2", "", 200},
+		{"PATCH", "/users/2", `{"display_name":"Viewer"}`, 200},
+		{"POST", "/role-bindings", `{"user_id":2,"role":"viewer","department_name":"IT"}`, 201},
+		{"GET", "/role-bindings", "", 200},
+		{"PATCH", "/role-bindings/2", `{"role":"operator"}`, 200},
+		{"POST", "/api-tokens", `{"name":"collector","scopes":"metrics:write"}`, 201},
+		{"GET", "/api-tokens", "", 200},
+		{"GET", "/api-tokens/1", "", 200},
+		{"DELETE", "/api-tokens/1", "", 200},
+
+		// 集群、机房网段、主机和应用
+		{"POST", "/cluster-groups", `{"group_name":"G1","cluster_name":"c1","department_name":"IT"}`, 201},
+		{"POST", "/cluster-groups", `{"group_name":"G1","cluster_name":"c2","department_name":"IT"}`, 201},
+		{"GET", "/cluster-groups", "", 200},
+		{"GET", "/cluster-groups/1", "", 200},
+		{"PATCH", "/cluster-groups/2", `{"group_name":"G2"}`, 200},
+		{"POST", "/idcs", `{"name":"BJ","cidr":"10.1.0.0/16"}`, 201},
+		{"GET", "/idcs", "", 200},
+		{"GET", "/idcs/resolve?ip=10.1.0.5", "", 200},
+		{"PATCH", "/idcs/1", `{"description":"Beijing"}`, 200},
+		{"POST", "/hosts", `{"host_name":"h1","host_ip":"10.1.0.5","vcpus":8,"ram":32,"disk_size":500}`, 201},
+		{"POST", "/hosts", `{"host_name":"h2","host_ip":"10.1.0.6","vcpus":8,"ram":32,"disk_size":500}`, 201},
+		{"PUT", "/hosts/1", `{"host_name":"h1","host_ip":"10.1.0.5","vcpus":16,"ram":64,"disk_size":500}`, 200},
+		{"PATCH", "/hosts/2", `{"rack_number":"R01"}`, 200},
+		{"GET", "/get_hosts_pool_detail", "", 200},
+		{"GET", "/get_hosts_pool_detail?as_of=" + to, "", 200},
+		{"GET", "/host-filter-options", "", 200},
+		{"GET", "/get_host_detail/1", "", 200},
+		{"GET", "/inventory/diff?from=" + from + "&to=" + to, "", 200},
+		{"POST", "/hosts/1/applications", `{"server_type":"MySQL","server_protocol":"TCP","server_addr":"10.1.0.5:3306","server_port":3306,"cluster_name":"c1"}`, 201},
+		{"GET", "/hosts/1/applications", "", 200},
+		{"PUT", "/hosts/1/applications/1", `{"server_type":"MySQL","server_protocol":"TCP","server_addr":"10.1.0.5:3306","server_port":3306,"cluster_name":"c1","server_role":"master"}`, 200},
+		{"PATCH", "/hosts/1/applications/1", `{"server_status":"running"}`, 200},
+		{"GET", "/get_application_detail/1", "", 200},
+
+		// 资源数据和预测
+		{"POST", "/insert-server-resource", `{"pool_id":1,"cluster_name":"c1","ip":"10.1.0.5","port":3306,"total_memory":65536,"used_memory":60000,"total_disk":512000,"used_disk":500000,"cpu_cores":16,"cpu_load":50}`, 200},
+		{"GET", "/server-resources", "", 200},
+		{"GET", "/cluster-resource-usage", "", 200},
+		{"GET", "/get_cluster_usage", "", 200},
+		{"GET", "/cluster-groups", "", 200},
+		{"GET", "/metrics/series?scope=host&id=1", "", 200},
+		{"GET", "/disk-full-prediction", "", 200},
+		{"GET", "/resource-alerts", "", 200},
+
+		// 告警
+		{"POST", "/alert-rules", `{"name":"disk","expression":"disk_usage > 90","severity":"critical"}`, 201},
+		{"GET", "/alert-rules", "", 200},
+		{"GET", "/alert-rules/1", "", 200},
+		{"PATCH", "/alert-rules/1", `{"description":"disk almost full"}`, 200},
+		{"POST", "/alerts/evaluate", "", 200},
+		{"GET", "/alerts", "", 200},
+		{"GET", "/alerts/history", "", 200},
+		{"GET", "/alerts/1", "", 200},
+		{"POST", "/alerts/1/ack", `{"comment":"looking"}`, 200},
+		{"POST", "/alerts/1/unack", "", 200},
+		{"POST", "/alerts/1/silence", `{"until":"` + silenceUntil + `"}`, 200},
+		{"DELETE", "/alerts/1/silence", "", 200},
+		{"POST", "/alerts/1/comments", `{"comment":"disk cleaned"}`, 201},
+
+		// 通知
+		{"POST", "/notification-channels", `{"name":"hook","type":"webhook","target":"` + webhook.URL + `"}`, 201},
+		{"GET", "/notification-channels", "", 200},
+		{"GET", "/notification-channels/1", "", 200},
+		{"PATCH", "/notification-channels/1", `{"description":"ops"}`, 200},
+		{"POST", "/notification-channels/1/test", "", 200},
+		{"POST", "/notification-routes", `{"channel_id":1,"min_severity":"warning","department_name":"IT"}`, 201},
+		{"GET", "/notification-routes", "", 200},
+		{"PATCH", "/notification-routes/1", `{"min_severity":"critical"}`, 200},
+		{"DELETE", "/notification-routes/1", "", 200},
+		{"DELETE", "/notification-channels/1", "", 200},
+
+		// 报告、存档和订阅
+		{"GET", "/cluster-group-report?format=html", "", 200},
+		{"GET", "/idc-report?format=xlsx", "", 200},
+		{"GET", "/alert-report", "", 200},
+		{"POST", "/send-report", `{"report":"idc","format":"html","email":"ops@example.com"}`, 202},
+		{"GET", "/report-archives", "", 200},
+		{"GET", "/report-archives/1", "", 200},
+		{"GET", "/report-archives/1/download", "", 200},
+		{"GET", "/report-archives/compare?base=1&target=4", "", 200},
+		{"POST", "/report-subscriptions", `{"name":"daily","report":"idc","format":"html","recipients":"ops@example.com","schedule":"0 8 * * *"}`, 201},
+		{"GET", "/report-subscriptions", "", 200},
+		{"GET", "/report-subscriptions/1", "", 200},
+		{"PATCH", "/report-subscriptions/1", `{"enabled":false}`, 200},
+		{"POST", "/report-subscriptions/1/run", "", 201},
+		{"GET", "/report-subscriptions/1/runs", "", 200},
+		{"DELETE", "/report-subscriptions/1", "", 200},
+		{"GET", "/usage-report", "", 200},
+		{"POST", "/generate-and-send-report", `{"email":"ops@example.com"}`, 202},
+		{"POST", "/trigger-report", `{"email":"ops@example.com","pdf":true}`, 202},
+
+		// 邮件
+		{"POST", "/send-email", `{"to":"ops@example.com","subject":"hi","content":"<p>hi</p>"}`, 202},
+		{"GET", "/emails", "", 200},
+		{"GET", "/emails/1", "", 200},
+		{"POST", "/emails/1/resend", "", 409},
+		{"GET", "/email-profiles", "", 200},
+		{"POST", "/email-profiles/missing/verify", "", 404},
+
+		// 审计
+		{"GET", "/audit-logs", "", 200},
+		{"GET", "/audit-logs/export", "", 200},
+
+		// 合并和删除
+		{"POST", "/cluster-groups/2/merge", `{"into":1}`, 200},
+		{"DELETE", "/hosts/1/applications/1", "", 200},
+		{"DELETE", "/hosts/2", "", 200},
+		{"DELETE", "/idcs/1", "", 200},
+		{"DELETE", "/alert-rules/1", "", 200},
+		{"DELETE", "/role-bindings/2", "", 200},
+		{"DELETE", "/users/2", "", 200},
+		{"POST", "/collect_applications", "", 200},
+		{"DELETE", "/cluster-groups/1", "", 409},
+		{"POST", "/auth/logout", `{"refresh_token":"` + tokens.RefreshToken + `"}`, 200},
+	}
+
+	routes := r.Routes()
+	covered := make(map[string]bool)
+	for _, tc := range cases {
+		path := v1 + tc.path
+		w := request(r, tc.method, path, tokens.AccessToken, tc.body)
+		if w.Code != tc.want {
+			t.Errorf("%s %s: got %d, want %d: %s", tc.method, tc.path, w.Code, tc.want, w.Body)
+		}
+		if route := routeOf(routes, tc.method, path); route != "" {
+			covered[tc.method+" "+route] = true
+		}
+		// 刷新令牌使用后即被替换，后续请求使用新的令牌
+		if tc.path == "/auth/refresh" && w.Code == http.StatusOK {
+			json.Unmarshal(w.Body.Bytes(), &tokens)
+		}
+	}
+	for _, route := range routes {
+		if !covered[route.Method+" "+route.Path] {
+			t.Errorf("route %s %s is not covered", route.Method, route.Path)
+		}
+	}
+}
+
+// TestRoutesRequireAuthentication 检查未登录的请求除登录接口外都被拒绝
+func TestRoutesRequireAuthentication(t *testing.T) {
+	r, _ := newTestServer(t)
+	public := map[string]bool{
+		"POST /api/cmdb/v1/auth/login":        true,
+		"POST /api/cmdb/v1/auth/refresh":      true,
+		"POST /api/cmdb/v1/auth/logout":       true,
+		"GET /api/cmdb/v1/auth/oidc/login":    true,
+		"GET /api/cmdb/v1/auth/oidc/callback": true,
+	}
+	for _, route := range r.Routes() {
+		if public[route.Method+" "+route.Path] {
+			continue
+		}
+		path := strings.NewReplacer(":id", "1", ":app_id", "1", ":name", "default").Replace(route.Path)
+		if w := request(r, route.Method, path, "", ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without token: got %d, want 401", route.Method, route.Path, w.Code)
+		}
+	}
+}
+
+// TestDeletedHostResources 检查软删除的主机不再出现在资源、历史和预测数据中，也不再接收采样
+func TestDeletedHostResources(t *testing.T) {
+	r, tokens := newTestServer(t)
+	call := func(method, path, body string, want int) string {
+		t.Helper()
+		w := request(r, method, "/api/cmdb/v1"+path, tokens.AccessToken, body)
+		if w.Code != want {
+			t.Fatalf("%s %s: got %d, want %d: %s", method, path, w.Code, want, w.Body)
+		}
+		return w.Body.String()
+	}
+	const sample = `{"pool_id":%d,"cluster_name":"c1","ip":"10.1.0.%d","port":3306,"total_memory":1024,"used_memory":512,"total_disk":1000,"used_disk":500,"cpu_cores":4,"cpu_load":10}`
+
+	call("POST", "/cluster-groups", `{"group_name":"G1","cluster_name":"c1","department_name":"IT"}`, 201)
+	call("POST", "/hosts", `{"host_name":"h1","host_ip":"10.1.0.1"}`, 201)
+	call("POST", "/hosts", `{"host_name":"h2","host_ip":"10.1.0.2"}`, 201)
+	call("POST", "/insert-server-resource", fmt.Sprintf(sample, 1, 1), 200)
+	call("POST", "/insert-server-resource", fmt.Sprintf(sample, 2, 2), 200)
+	call("DELETE", "/hosts/2", "", 200)
+
+	call("POST", "/insert-server-resource", fmt.Sprintf(sample, 2, 2), 422)
+	call("POST", "/insert-server-resource", fmt.Sprintf(sample, 3, 3), 404)
+
+	for _, path := range []string{"/server-resources", "/cluster-resource-usage", "/metrics/series?scope=cluster&id=c1&resolution=raw"} {
+		body := call("GET", path, "", 200)
+		if !strings.Contains(body, `"10.1.0.1"`) || strings.Contains(body, `"10.1.0.2"`) {
+			t.Errorf("GET %s: want only the live host, got %s", path, body)
+		}
+	}
+	if body := call("GET", "/metrics/series?scope=host&id=2&resolution=raw", "", 200); strings.Contains(body, `"10.1.0.2"`) {
+		t.Errorf("series of a deleted host: %s", body)
+	}
+
+	// 报告按集群汇总，集群 c1 中只剩一个实例
+	for _, path := range []string{"/idc-report?format=html", "/cluster-group-report?format=html"} {
+		if body := call("GET", path, "", 200); !strings.Contains(body, `c1</td><td class="num">1</td>`) {
+			t.Errorf("GET %s: want one instance in c1, got %s", path, body)
+		}
+	}
+
+	call("POST", "/alert-rules", `{"name":"busy","expression":"cpu_load > 5","severity":"warning"}`, 201)
+	call("POST", "/alerts/evaluate", "", 200)
+	if body := call("GET", "/alerts", "", 200); !strings.Contains(body, `"10.1.0.1"`) || strings.Contains(body, `"10.1.0.2"`) {
+		t.Errorf("alerts must only fire for the live host, got %s", body)
+	}
+}
+
+// TestDuplicateKeyRace 模拟查重之后、写入之前其他请求抢先写入了相同的记录，唯一索引拒绝写入时应返回 409
+func TestDuplicateKeyRace(t *testing.T) {
+	r, tokens := newTestServer(t)
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		return request(r, method, "/api/cmdb/v1"+path, tokens.AccessToken, body)
+	}
+	if w := call("POST", "/hosts", `{"host_name":"h1","host_ip":"10.1.0.1"}`); w.Code != http.StatusCreated {
+		t.Fatalf("create host: %d %s", w.Code, w.Body)
+	}
+
+	// 下一次查询 race 中的表之后（接口查重之后）立即执行对应的语句，模拟另一个请求抢先写入
+	race := map[string]string{}
+	err := db.Callback().Query().After("gorm:query").Register("test:race", func(tx *gorm.DB) {
+		if stmt, ok := race[tx.Statement.Table]; ok {
+			delete(race, tx.Statement.Table)
+			if err := db.Exec(stmt).Error; err != nil {
+				t.Errorf("race %s: %v", tx.Statement.Table, err)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		table, stmt, path, body string
+	}{
+		{"hosts_pool", "INSERT INTO hosts_pool (host_name, host_ip) VALUES ('other', '10.1.0.2')",
+			"/hosts", `{"host_name":"h2","host_ip":"10.1.0.2"}`},
+		{"cluster_groups", "INSERT INTO cluster_groups (group_name, cluster_name, department_name) VALUES ('G1', 'c1', 'IT')",
+			"/cluster-groups", `{"group_name":"G1","cluster_name":"c1","department_name":"IT"}`},
+		{"hosts_applications", "INSERT INTO hosts_applications (pool_id, server_addr, server_protocol, server_port) VALUES (1, '10.1.0.1:80', 'HTTP', 80)",
+			"/hosts/1/applications", `{"server_addr":"10.1.0.1:80","server_protocol":"HTTP"}`},
+	}
+	for _, tc := range cases {
+		race[tc.table] = tc.stmt
+		if w := call("POST", tc.path, tc.body); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"id"`) {
+			t.Errorf("POST %s: got %d %s, want 409 with the existing id", tc.path, w.Code, w.Body)
+		}
+	}
+}
+
+// TestClusterRenameCascade 检查集群改名、换组换部门和合并时，告警、告警规则、通知路由和报告订阅中的引用跟随修改
+func TestClusterRenameCascade(t *testing.T) {
+	r, tokens := newTestServer(t)
+	call := func(method, path, body string, want int) {
+		t.Helper()
+		if w := request(r, method, "/api/cmdb/v1"+path, tokens.AccessToken, body); w.Code != want {
+			t.Fatalf("%s %s: got %d, want %d: %s", method, path, w.Code, want, w.Body)
+		}
+	}
+	call("POST", "/cluster-groups", `{"group_name":"G1","cluster_name":"c1","department_name":"IT"}`, 201)
+	call("POST", "/cluster-groups", `{"group_name":"G1","cluster_name":"c2","department_name":"IT"}`, 201)
+	call("POST", "/cluster-groups", `{"group_name":"G3","cluster_name":"c3","department_name":"Ops"}`, 201)
+	call("POST", "/alert-rules", `{"name":"r1","expression":"disk_usage > 90","severity":"critical","scope_type":"cluster","scope_value":"c1"}`, 201)
+	call("POST", "/alert-rules", `{"name":"r2","expression":"disk_usage > 90","severity":"critical","scope_type":"group","scope_value":"G3"}`, 201)
+	call("POST", "/alert-rules", `{"name":"r3","expression":"disk_usage > 90","severity":"critical","scope_type":"department","scope_value":"Ops"}`, 201)
+	call("POST", "/notification-channels", `{"name":"hook","type":"webhook","target":"http://127.0.0.1:9/hook"}`, 201)
+	call("POST", "/notification-routes", `{"channel_id":1,"department_name":"Ops"}`, 201)
+	call("POST", "/report-subscriptions", `{"name":"daily","report":"idc","format":"html","recipients":"ops@example.com","schedule":"0 8 * * *","groups":"G1,G3","departments":"Ops"}`, 201)
+	alert := models.Alert{Fingerprint: "fp", RuleID: 1, PoolID: 1, ClusterName: "c1", GroupName: "G1", DepartmentName: "IT",
+		State: "firing", StartsAt: time.Now(), LastSeenAt: time.Now()}
+	if err := db.Create(&alert).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 改名为已删除集群的名称
+	call("DELETE", "/cluster-groups/2", "", 200)
+	call("PATCH", "/cluster-groups/1", `{"cluster_name":"c2"}`, 200)
+	// 换组和部门后原来的组和部门不再有集群
+	call("PATCH", "/cluster-groups/3", `{"group_name":"G4","department_name":"Finance"}`, 200)
+
+	var rules []models.AlertRule
+	db.Where("name IN ?", []string{"r1", "r2", "r3"}).Order("id").Find(&rules)
+	for i, want := range []string{"c2", "G4", "Finance"} {
+		if rules[i].ScopeValue != want {
+			t.Errorf("rule %s: scope_value %q, want %q", rules[i].Name, rules[i].ScopeValue, want)
+		}
+	}
+	var route models.NotificationRoute
+	db.First(&route)
+	var sub models.ReportSubscription
+	db.First(&sub)
+	if route.DepartmentName != "Finance" || sub.Groups != "G1,G4" || sub.Departments != "Finance" {
+		t.Errorf("route department %q, subscription groups %q departments %q", route.DepartmentName, sub.Groups, sub.Departments)
+	}
+
+	// 合并后告警跟随成员移到目标集群，G1 不再有集群
+	call("POST", "/cluster-groups/1/merge", `{"into":3}`, 200)
+	db.First(&alert, alert.ID)
+	if alert.ClusterName != "c3" || alert.GroupName != "G4" || alert.DepartmentName != "Finance" {
+		t.Errorf("alert moved to %s/%s/%s, want c3/G4/Finance", alert.ClusterName, alert.GroupName, alert.DepartmentName)
+	}
+	db.Where("name IN ?", []string{"r1", "r2", "r3"}).Order("id").Find(&rules)
+	if rules[0].ScopeValue != "c3" {
+		t.Errorf("rule r1 scope_value %q after merge, want c3", rules[0].ScopeValue)
+	}
+	db.First(&sub)
+	if sub.Groups != "G4" {
+		t.Errorf("subscription groups %q after merge, want G4", sub.Groups)
+	}
+}
+
+func TestHostIDCFilter(t *testing.T) {
+	r, tokens := newTestServer(t)
+	call := func(method, path, body string, want int) string {
+		t.Helper()
+		w := request(r, method, "/api/cmdb/v1"+path, tokens.AccessToken, body)
+		if w.Code != want {
+			t.Fatalf("%s %s: got %d, want %d: %s", method, path, w.Code, want, w.Body)
+		}
+		return w.Body.String()
+	}
+	hostsIn := func(idc string) []string {
+		t.Helper()
+		var page services.Page[models.HostPool]
+		if err := json.Unmarshal([]byte(call("GET", "/get_hosts_pool_detail?idc="+idc, "", 200)), &page); err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, host := range page.Items {
+			names = append(names, host.HostName)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	call("POST", "/hosts", `{"host_name":"h1","host_ip":"192.1.0.1"}`, 201)
+	call("POST", "/hosts", `{"host_name":"h2","host_ip":"10.9.0.1"}`, 201)
+	call("POST", "/hosts", `{"host_name":"h3","host_ip":"10.9.1.1"}`, 201)
+	call("PATCH", "/hosts/3", `{"host_ip":"192.2.0.1"}`, 200)
+
+	if got := hostsIn("P1,P2"); strings.Join(got, ",") != "h1,h3" {
+		t.Errorf("idc=P1,P2: got %v", got)
+	}
+	if got := hostsIn(services.UnassignedIDC); strings.Join(got, ",") != "h2" {
+		t.Errorf("idc=unassigned: got %v", got)
+	}
+
+	// 新增、修改、删除网段后，已有主机的所属机房随之更新
+	body := call("POST", "/idcs", `{"name":"Lab","cidr":"10.9.0.0/16"}`, 201)
+	var lab models.IDC
+	if err := json.Unmarshal([]byte(body), &lab); err != nil {
+		t.Fatal(err)
+	}
+	if got := hostsIn("Lab"); strings.Join(got, ",") != "h2" {
+		t.Errorf("idc=Lab after create: got %v", got)
+	}
+	call("PATCH", fmt.Sprintf("/idcs/%d", lab.ID), `{"cidr":"192.2.0.0/24"}`, 200)
+	if got := hostsIn("Lab"); strings.Join(got, ",") != "h3" {
+		t.Errorf("idc=Lab after update: got %v", got)
+	}
+	if got := hostsIn(services.UnassignedIDC); strings.Join(got, ",") != "h2" {
+		t.Errorf("idc=unassigned after update: got %v", got)
+	}
+	call("DELETE", fmt.Sprintf("/idcs/%d", lab.ID), "", 200)
+	if got := hostsIn("P2"); strings.Join(got, ",") != "h3" {
+		t.Errorf("idc=P2 after delete: got %v", got)
+	}
+}
+
+func TestIDCResolverRefresh(t *testing.T) {
+	r, tokens := newTestServer(t)
+	resolve := func(ip string) string {
+		t.Helper()
+		w := request(r, "GET", "/api/cmdb/v1/idcs/resolve?ip="+ip, tokens.AccessToken, "")
+		var body struct{ IDC string }
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("resolve %s: %d %s", ip, w.Code, w.Body)
+		}
+		return body.IDC
+	}
+
+	// 模拟其他实例直接修改 idc 表，包括一条无法解析的网段
+	if err := db.Create(&[]models.IDC{{Name: "Lab", CIDR: "10.9.0.0/16"}, {Name: "Bad", CIDR: "10.8.0.0/33"}}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := resolve("10.9.0.1"); got != services.UnassignedIDC {
+		t.Fatalf("before refresh: got %q", got)
+	}
+	if err := idcResolver.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got := resolve("10.9.0.1"); got != "Lab" {
+		t.Errorf("after create: got %q, want Lab", got)
+	}
+	if got := resolve("192.1.0.1"); got != "P1" {
+		t.Errorf("invalid row must not hide the others: got %q, want P1", got)
+	}
+
+	if err := db.Where("name = ?", "P1").Delete(&models.IDC{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := idcResolver.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got := resolve("192.1.0.1"); got != services.UnassignedIDC {
+		t.Errorf("after delete: got %q, want %s", got, services.UnassignedIDC)
+	}
+}
+
+func TestRawSeriesCap(t *testing.T) {
+	r, tokens := newTestServer(t)
+	if w := request(r, "POST", "/api/cmdb/v1/hosts", tokens.AccessToken, `{"host_name":"h1","host_ip":"10.1.0.1"}`); w.Code != 201 {
+		t.Fatalf("create host: %d %s", w.Code, w.Body)
+	}
+	// 每秒一个采样，共 20001 个，结束于 to
+	to := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	samples := make([]models.MetricSample, 20001)
+	for i := range samples {
+		samples[i] = models.MetricSample{PoolID: 1, IP: "10.1.0.1", Port: 3306, SampledAt: to.Add(-time.Duration(i+1) * time.Second)}
+	}
+	if err := db.CreateInBatches(samples, 1000).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	series := func(from string) *httptest.ResponseRecorder {
+		return request(r, "GET", "/api/cmdb/v1/metrics/series?scope=host&id=1&resolution=raw&from="+from+"&to="+to.Format(time.RFC3339), tokens.AccessToken, "")
+	}
+	if w := series(to.Add(-6 * time.Hour).Format(time.RFC3339)); w.Code != http.StatusBadRequest {
+		t.Errorf("over the cap: got %d, want 400: %s", w.Code, w.Body)
+	}
+	w := series(to.Add(-time.Hour).Format(time.RFC3339))
+	if w.Code != http.StatusOK {
+		t.Fatalf("within the cap: got %d: %s", w.Code, w.Body)
+	}
+	var result services.SeriesResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Instances) != 1 || len(result.Instances[0].Points) != 3600 {
+		t.Errorf("within the cap: got %d instances", len(result.Instances))
+	}
+}
+
+func TestCreateDisabled(t *testing.T) {
+	r, tokens := newTestServer(t)
+	for _, tc := range []struct{ create, get, body string }{
+		{"/alert-rules", "/alert-rules/%d", `{"name":"off","expression":"cpu_load > 90","severity":"warning","enabled":false}`},
+		{"/report-subscriptions", "/report-subscriptions/%d", `{"name":"off","report":"idc","format":"html","recipients":"ops@example.com","schedule":"0 9 * * 1","enabled":false}`},
+		{"/notification-channels", "/notification-channels/%d", `{"name":"off","type":"webhook","target":"http://127.0.0.1/hook","enabled":false}`},
+	} {
+		w := request(r, "POST", "/api/cmdb/v1"+tc.create, tokens.AccessToken, tc.body)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("POST %s: %d %s", tc.create, w.Code, w.Body)
+		}
+		var created struct {
+			ID        uint
+			Enabled   bool
+			NextRunAt *time.Time `json:"next_run_at"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+			t.Fatal(err)
+		}
+		w = request(r, "GET", "/api/cmdb/v1"+fmt.Sprintf(tc.get, created.ID), tokens.AccessToken, "")
+		var stored struct {
+			Enabled   bool
+			NextRunAt *time.Time `json:"next_run_at"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &stored); err != nil {
+			t.Fatal(err)
+		}
+		if created.Enabled || stored.Enabled || stored.NextRunAt != nil {
+			t.Errorf("POST %s with enabled=false: created %+v, stored %+v", tc.create, created, stored)
+		}
+	}
+
+	w := request(r, "POST", "/api/cmdb/v1/notification-routes", tokens.AccessToken, `{"channel_id":1,"send_resolved":false,"enabled":false}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /notification-routes: %d %s", w.Code, w.Body)
+	}
+	w = request(r, "GET", "/api/cmdb/v1/notification-routes", tokens.AccessToken, "")
+	var routes []struct {
+		SendResolved bool `json:"send_resolved"`
+		Enabled      bool
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &routes); err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 1 || routes[0].SendResolved || routes[0].Enabled {
+		t.Errorf("POST /notification-routes with send_resolved=false, enabled=false: stored %+v", routes)
+	}
+}
+
+func TestAuditLogRedaction(t *testing.T) {
+	r, tokens := newTestServer(t)
+	call := func(method, path, body string, want int) string {
+		t.Helper()
+		w := request(r, method, "/api/cmdb/v1"+path, tokens.AccessToken, body)
+		if w.Code != want {
+			t.Fatalf("%s %s: got %d, want %d: %s", method, path, w.Code, want, w.Body)
+		}
+		return w.Body.String()
+	}
+	const secret = "TOPSECRETVALUE"
+
+	call("POST", "/notification-channels", `{"name":"hook","type":"webhook","target":"http://127.0.0.1/hook","secret":"`+secret+`"}`, 201)
+	call("PATCH", "/notification-channels/1", `{"secret":"`+secret+`2"}`, 200)
+	call("POST", "/cluster-groups", `{"group_name":"G1","cluster_name":"c1","department_name":"IT"}`, 201)
+	call("POST", "/hosts", `{"host_name":"h1","host_ip":"10.1.0.1"}`, 201)
+	call("POST", "/hosts/1/applications", `{"server_type":"MySQL","server_protocol":"TCP","server_addr":"10.1.0.5:3306","server_port":3306,"cluster_name":"c1"}`, 201)
+
+	// 渠道密钥在日志中只记为 [redacted]，接口和导出都看不到明文
+	for _, path := range []string{"/audit-logs?entity=notification_channels", "/audit-logs/export"} {
+		body := call("GET", path, "", 200)
+		if strings.Contains(body, secret) {
+			t.Errorf("GET %s leaks the channel secret: %s", path, body)
+		}
+		if !strings.Contains(body, "[redacted]") {
+			t.Errorf("GET %s: secret not recorded as [redacted]: %s", path, body)
+		}
+	}
+
+	var logs struct{ Items []models.AuditLog }
+	if err := json.Unmarshal([]byte(call("GET", "/audit-logs?entity=hosts_applications&action=create", "", 200)), &logs); err != nil {
+		t.Fatal(err)
+	}
+	if len(logs.Items) != 1 || logs.Items[0].Actor != testAdmin {
+		t.Errorf("application create logs = %+v, want one by %q", logs.Items, testAdmin)
+	}
+}
+
+func TestAlertSyncRace(t *testing.T) {
+	r, tokens := newTestServer(t)
+	call := func(method, path, body string, want int) string {
+		t.Helper()
+		w := request(r, method, "/api/cmdb/v1"+path, tokens.AccessToken, body)
+		if w.Code != want {
+			t.Fatalf("%s %s: got %d, want %d: %s", method, path, w.Code, want, w.Body)
+		}
+		return w.Body.String()
+	}
+	call("POST", "/cluster-groups", `{"group_name":"G1","cluster_name":"c1","department_name":"IT"}`, 201)
+	call("POST", "/hosts", `{"host_name":"h1","host_ip":"10.1.0.1"}`, 201)
+	call("POST", "/insert-server-resource", `{"pool_id":1,"cluster_name":"c1","ip":"10.1.0.1","port":3306,"total_memory":1024,"used_memory":512,"total_disk":1000,"used_disk":500,"cpu_cores":4,"cpu_load":10}`, 200)
+	var rule models.AlertRule
+	if err := json.Unmarshal([]byte(call("POST", "/alert-rules", `{"name":"busy","expression":"cpu_load > 5","severity":"warning"}`, 201)), &rule); err != nil {
+		t.Fatal(err)
+	}
+	call("POST", "/alerts/evaluate", "", 200)
+
+	// Sync 读取未恢复的告警之后立即执行 stmt，模拟同时发生的确认或静默
+	var race string
+	err := db.Callback().Query().After("gorm:query").Register("test:alert-race", func(tx *gorm.DB) {
+		if stmt := race; stmt != "" && tx.Statement.Table == "alerts" {
+			race = ""
+			if err := db.Exec(stmt).Error; err != nil {
+				t.Errorf("race: %v", err)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := func() models.Alert {
+		t.Helper()
+		var alert models.Alert
+		if err := db.First(&alert, 1).Error; err != nil {
+			t.Fatal(err)
+		}
+		return alert
+	}
+
+	// 仍在触发：刷新指标不能覆盖确认
+	race = "UPDATE alerts SET state = 'acknowledged', acknowledged_by = 'other' WHERE id = 1"
+	call("POST", "/alerts/evaluate", "", 200)
+	if alert := state(); alert.State != services.AlertAcknowledged || alert.AcknowledgedBy != "other" {
+		t.Errorf("refresh overwrote the acknowledgement: state %s, by %q", alert.State, alert.AcknowledgedBy)
+	}
+
+	// 不再触发：读取后被静默的告警不会被标记为恢复
+	call("PATCH", fmt.Sprintf("/alert-rules/%d", rule.ID), `{"expression":"cpu_load > 50"}`, 200)
+	race = "UPDATE alerts SET state = 'silenced', silenced_by = 'other' WHERE id = 1"
+	call("POST", "/alerts/evaluate", "", 200)
+	if alert := state(); alert.State != services.AlertSilenced || alert.ResolvedAt != nil {
+		t.Errorf("resolve overwrote the silence: state %s, resolved at %v", alert.State, alert.ResolvedAt)
+	}
+	var resolved int64
+	db.Model(&models.AlertEvent{}).Where("alert_id = 1 AND action = 'resolved'").Count(&resolved)
+	if resolved != 0 {
+		t.Errorf("got %d resolved events for a skipped transition", resolved)
+	}
+
+	// 租约由其他实例持有时不评估
+	if err := db.Model(&models.SchedulerLock{}).Where("name = ?", "alert_evaluation").
+		Updates(map[string]any{"holder": "other:1", "expires_at": time.Now().Add(time.Hour)}).Error; err != nil {
+		t.Fatal(err)
+	}
+	call("POST", "/alerts/evaluate", "", http.StatusConflict)
+}
+
+// smtpStandIn 为本机的 SMTP 替身：不支持 STARTTLS 和认证，RCPT 命令返回 rcptReply，收下的邮件记录在 messages 中
+type smtpStandIn struct {
+	net.Listener
+	mu        sync.Mutex
+	rcptReply string
+	messages  []string
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStandIn{Listener: ln, rcptReply: "250 OK"}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(textproto.NewConn(conn))
+		}
+	}()
+	return s
+}
+
+func (s *smtpStandIn) serve(conn *textproto.Conn) {
+	defer conn.Close()
+	conn.PrintfLine("220 localhost ESMTP stand-in")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		switch cmd, _, _ := strings.Cut(strings.ToUpper(line), " "); cmd {
+		case "EHLO", "HELO":
+			conn.PrintfLine("250 localhost")
+		case "MAIL", "RSET", "NOOP":
+			conn.PrintfLine("250 OK")
+		case "RCPT":
+			s.mu.Lock()
+			reply := s.rcptReply
+			s.mu.Unlock()
+			conn.PrintfLine("%s", reply)
+		case "DATA":
+			conn.PrintfLine("354 go ahead")
+			data, err := conn.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, string(data))
+			s.mu.Unlock()
+			conn.PrintfLine("250 queued")
+		case "QUIT":
+			conn.PrintfLine("221 bye")
+			return
+		default:
+			conn.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func (s *smtpStandIn) reply(rcpt string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rcptReply = rcpt
+}
+
+func (s *smtpStandIn) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.messages)
+}
+
+func TestEmailOutbox(t *testing.T) {
+	smtpd := newSMTPStandIn(t)
+	t.Setenv("CMDB_SMTP_HOST", "127.0.0.1")
+	t.Setenv("CMDB_SMTP_PORT", strconv.Itoa(smtpd.Addr().(*net.TCPAddr).Port))
+	t.Setenv("CMDB_SMTP_FROM", "cmdb@example.com")
+	t.Setenv("CMDB_SMTP_SECURITY", "none")
+	t.Setenv("CMDB_SMTP_TIMEOUT", "5s")
+	t.Setenv("CMDB_SMTP_OUTBOX_MAX_ATTEMPTS", "3")
+	t.Setenv("CMDB_SMTP_OUTBOX_BACKOFF", "30s")
+	t.Setenv("CMDB_SMTP_OUTBOX_MAX_BACKOFF", "1m")
+	newTestServer(t)
+	email := services.NewEmailService(db, cfg)
+
+	enqueue := func(subject string) uint {
+		t.Helper()
+		id, err := email.Enqueue(services.OutboundEmail{To: []string{"ops@example.com"}, Subject: subject, HTML: "<p>hi</p>"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	deliver := func(now time.Time) {
+		t.Helper()
+		if err := email.DeliverDue(now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	load := func(id uint) models.EmailMessage {
+		t.Helper()
+		var msg models.EmailMessage
+		if err := db.First(&msg, id).Error; err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	// 投递成功
+	id := enqueue("sent")
+	deliver(time.Now())
+	if msg := load(id); msg.Status != services.EmailSent || msg.Attempts != 1 || msg.SentAt == nil {
+		t.Errorf("sent: status %s, attempts %d, sent_at %v", msg.Status, msg.Attempts, msg.SentAt)
+	}
+	if got := smtpd.received(); len(got) != 1 || !strings.Contains(got[0], "Subject: sent") {
+		t.Fatalf("stand-in received %q", got)
+	}
+
+	// 5xx 拒收：不再重试
+	smtpd.reply("550 5.1.1 no such user")
+	id = enqueue("bounced")
+	deliver(time.Now())
+	if msg := load(id); msg.Status != services.EmailBounced || msg.Attempts != 1 || !strings.Contains(msg.LastError, "no such user") {
+		t.Errorf("bounced: status %s, attempts %d, last_error %q", msg.Status, msg.Attempts, msg.LastError)
+	}
+
+	// 4xx：按 30s、60s 退避重试，第 3 次失败后为 failed
+	smtpd.reply("451 4.3.0 try again later")
+	id = enqueue("failed")
+	now := time.Now()
+	for attempt, wait := range []time.Duration{30 * time.Second, time.Minute} {
+		start := time.Now()
+		deliver(now)
+		msg := load(id)
+		if msg.Status != services.EmailQueued || msg.Attempts != attempt+1 {
+			t.Fatalf("attempt %d: status %s, attempts %d", attempt+1, msg.Status, msg.Attempts)
+		}
+		// 重试时间从投递完成时算起
+		if d := msg.NextAttemptAt.Sub(start); d < wait || d > wait+10*time.Second {
+			t.Errorf("attempt %d: retried after %v, want %v", attempt+1, d, wait)
+		}
+		// 退避时间未到时不投递
+		deliver(msg.NextAttemptAt.Add(-time.Second))
+		if load(id).Attempts != attempt+1 {
+			t.Fatalf("attempt %d: delivered before next_attempt_at", attempt+1)
+		}
+		now = msg.NextAttemptAt
+	}
+	deliver(now)
+	if msg := load(id); msg.Status != services.EmailFailed || msg.Attempts != 3 {
+		t.Errorf("failed: status %s, attempts %d", msg.Status, msg.Attempts)
+	}
+
+	// 发件账号已从配置中删除：不重试
+	orphan := models.EmailMessage{Profile: "removed", To: "ops@example.com", Subject: "orphan", Status: services.EmailQueued, NextAttemptAt: time.Now().UTC()}
+	if err := db.Create(&orphan).Error; err != nil {
+		t.Fatal(err)
+	}
+	deliver(time.Now())
+	if msg := load(orphan.ID); msg.Status != services.EmailFailed || msg.Attempts != 1 {
+		t.Errorf("unknown profile: status %s, attempts %d", msg.Status, msg.Attempts)
+	}
+}
+
+func TestEmailOutboxClaim(t *testing.T) {
+	smtpd := newSMTPStandIn(t)
+	t.Setenv("CMDB_SMTP_HOST", "127.0.0.1")
+	t.Setenv("CMDB_SMTP_PORT", strconv.Itoa(smtpd.Addr().(*net.TCPAddr).Port))
+	t.Setenv("CMDB_SMTP_FROM", "cmdb@example.com")
+	t.Setenv("CMDB_SMTP_SECURITY", "none")
+	newTestServer(t)
+	first, second := services.NewEmailService(db, cfg), services.NewEmailService(db, cfg)
+	id, err := first.Enqueue(services.OutboundEmail{To: []string{"ops@example.com"}, Subject: "once", HTML: "<p>hi</p>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// first 查出到期邮件之后、占用之前，second 完成一轮投递，模拟两个实例同时运行
+	raced := false
+	err = db.Callback().Query().After("gorm:query").Register("test:outbox-race", func(tx *gorm.DB) {
+		if !raced && tx.Statement.Table == "email_messages" {
+			raced = true
+			if err := second.DeliverDue(time.Now()); err != nil {
+				t.Errorf("second worker: %v", err)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.DeliverDue(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg models.EmailMessage
+	if err := db.First(&msg, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got := smtpd.received(); len(got) != 1 || msg.Status != services.EmailSent || msg.Attempts != 1 {
+		t.Errorf("delivered %d times, status %s, attempts %d", len(got), msg.Status, msg.Attempts)
+	}
+}
+
+func TestReportSchedulerClaim(t *testing.T) {
+	r, tokens := newTestServer(t)
+	w := request(r, "POST", "/api/cmdb/v1/report-subscriptions", tokens.AccessToken,
+		`{"name":"daily","report":"idc","format":"html","recipients":"ops@example.com","schedule":"0 9 * * *"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /report-subscriptions: %d %s", w.Code, w.Body)
+	}
+	var sub models.ReportSubscription
+	if err := json.Unmarshal(w.Body.Bytes(), &sub); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	scheduled := now.Add(-time.Minute).UTC().Truncate(time.Second)
+	if err := db.Model(&sub).Update("next_run_at", scheduled).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// first 查出到期订阅之后、占用之前，second 完成一轮检查，两个实例看到的是同一个 next_run_at
+	raced := false
+	err := db.Callback().Query().After("gorm:query").Register("test:scheduler-race", func(tx *gorm.DB) {
+		if !raced && tx.Statement.Table == "report_subscriptions" {
+			raced = true
+			if err := subscriptionService.RunDue(now); err != nil {
+				t.Errorf("second scheduler: %v", err)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := subscriptionService.RunDue(now); err != nil {
+		t.Fatal(err)
+	}
+	if !raced {
+		t.Fatal("second scheduler did not run")
+	}
+
+	var runs []models.ReportRun
+	if err := db.Where("subscription_id = ?", sub.ID).Find(&runs).Error; err != nil {
+		t.Fatal(err)
+	}
+	var emails int64
+	if err := db.Model(&models.EmailMessage{}).Count(&emails).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || emails != 1 {
+		t.Fatalf("%d runs, %d queued emails, want 1 of each", len(runs), emails)
+	}
+	if runs[0].Status != services.RunSucceeded || !runs[0].ScheduledAt.Equal(scheduled) || runs[0].MessageID == nil {
+		t.Errorf("run = %+v", runs[0])
+	}
+	if err := db.First(&sub, sub.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if sub.NextRunAt == nil || !sub.NextRunAt.After(now) {
+		t.Errorf("next_run_at = %v, want after %v", sub.NextRunAt, now)
+	}
+}
+
+func TestInventoryHistory(t *testing.T) {
+	r, tokens := newTestServer(t)
+	call := func(method, path, body string, want int) []byte {
+		t.Helper()
+		w := request(r, method, "/api/cmdb/v1"+path, tokens.AccessToken, body)
+		if w.Code != want {
+			t.Fatalf("%s %s: got %d, want %d: %s", method, path, w.Code, want, w.Body)
+		}
+		return w.Body.Bytes()
+	}
+	// 版本的时间精确到纳秒，两次写入之间留出间隔
+	mark := func() string {
+		time.Sleep(5 * time.Millisecond)
+		at := time.Now().UTC().Format(time.RFC3339Nano)
+		time.Sleep(5 * time.Millisecond)
+		return at
+	}
+
+	t0 := mark()
+	call("POST", "/cluster-groups", `{"group_name":"G1","cluster_name":"c1","department_name":"IT"}`, 201)
+	call("POST", "/cluster-groups", `{"group_name":"G1","cluster_name":"c2","department_name":"HR"}`, 201)
+	call("POST", "/hosts", `{"host_name":"h1","host_ip":"10.1.0.5"}`, 201)
+	call("POST", "/hosts", `{"host_name":"h2","host_ip":"10.2.0.6"}`, 201)
+	call("POST", "/hosts/1/applications", `{"server_type":"MySQL","server_protocol":"TCP","server_addr":"10.1.0.5:3306","server_port":3306,"cluster_name":"c1"}`, 201)
+	call("POST", "/hosts/2/applications", `{"server_type":"Redis","server_protocol":"TCP","server_addr":"10.2.0.6:6379","server_port":6379,"cluster_name":"c2"}`, 201)
+	t1 := mark()
+	call("PATCH", "/cluster-groups/1", `{"department_name":"Finance"}`, 200)
+	call("PATCH", "/hosts/1", `{"rack_number":"R01"}`, 200)
+	call("PATCH", "/hosts/1", `{"host_ip":"10.1.0.7"}`, 200)
+	t2 := mark()
+
+	type host struct {
+		ID               uint
+		HostIP           string `json:"host_ip"`
+		RackNumber       string `json:"rack_number"`
+		HostApplications []struct {
+			DepartmentName string `json:"department_name"`
+		} `json:"host_applications"`
+	}
+	var page struct {
+		Items []host
+		Total int
+	}
+	// ip 条件下推到版本表，只加载 h1 的应用和 c1 的登记，应用的部门仍按当时的登记计算
+	if err := json.Unmarshal(call("GET", "/get_hosts_pool_detail?ip=10.1.0&as_of="+t1, "", 200), &page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || page.Items[0].HostIP != "10.1.0.5" || page.Items[0].RackNumber != "" ||
+		len(page.Items[0].HostApplications) != 1 || page.Items[0].HostApplications[0].DepartmentName != "IT" {
+		t.Errorf("hosts as of t1 with ip 10.1.0: %+v", page)
+	}
+	if err := json.Unmarshal(call("GET", "/get_hosts_pool_detail?ip=10.1.0.5&as_of="+t2, "", 200), &page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 0 {
+		t.Errorf("hosts as of t2 with the old ip: %+v", page)
+	}
+	var detail host
+	if err := json.Unmarshal(call("GET", "/get_host_detail/1?as_of="+t2, "", 200), &detail); err != nil {
+		t.Fatal(err)
+	}
+	if detail.HostIP != "10.1.0.7" || detail.RackNumber != "R01" || len(detail.HostApplications) != 1 ||
+		detail.HostApplications[0].DepartmentName != "Finance" {
+		t.Errorf("host 1 as of t2: %+v", detail)
+	}
+	call("GET", "/get_host_detail/2?as_of="+t0, "", 404)
+
+	var diff services.InventoryDiff
+	summary := func(from, to string) map[string]map[string]int {
+		t.Helper()
+		if err := json.Unmarshal(call("GET", "/inventory/diff?from="+from+"&to="+to, "", 200), &diff); err != nil {
+			t.Fatal(err)
+		}
+		return diff.Summary
+	}
+	// h1 改过两次只算一次修改，c1 的部门同步到 h1 的应用；没有变化的 h2、c2 和 h2 的应用不出现
+	got := summary(t1, t2)
+	want := map[string]map[string]int{
+		"hosts_pool":         {"added": 0, "removed": 0, "changed": 1},
+		"hosts_applications": {"added": 0, "removed": 0, "changed": 1},
+		"cluster_groups":     {"added": 0, "removed": 0, "changed": 1},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("diff t1..t2 summary = %v, want %v", got, want)
+	}
+	for _, change := range diff.Changes {
+		if change.Entity != "hosts_pool" && change.EntityID != "1" {
+			t.Errorf("unexpected change: %+v", change)
+		}
+		if change.Entity == "hosts_pool" && (change.EntityID != "1" || string(change.Before["host_ip"]) != `"10.1.0.5"` ||
+			string(change.After["host_ip"]) != `"10.1.0.7"` || string(change.After["rack_number"]) != `"R01"`) {
+			t.Errorf("host change: %+v", change)
+		}
+	}
+	got = summary(t0, t2)
+	want = map[string]map[string]int{
+		"hosts_pool":         {"added": 2, "removed": 0, "changed": 0},
+		"hosts_applications": {"added": 2, "removed": 0, "changed": 0},
+		"cluster_groups":     {"added": 2, "removed": 0, "changed": 0},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("diff t0..t2 summary = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,115 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 以下结构体是 0001 版本时的表结构快照，之后模型的修改不应影响这个迁移
+
+type hostPool0001 struct {
+	ID              uint      `gorm:"primaryKey"`
+	HostName        string    `gorm:"size:50;not null"`
+	HostIP          string    `gorm:"size:50;not null"`
+	HostType        string    `gorm:"size:10"`
+	H3cID           string    `gorm:"size:50"`
+	H3cStatus       string    `gorm:"size:20"`
+	DiskSize        uint      `gorm:"default:null"`
+	RAM             uint      `gorm:"default:null"`
+	VCPUs           uint      `gorm:"default:null"`
+	IfH3cSync       string    `gorm:"size:10"`
+	H3cImgID        string    `gorm:"size:50"`
+	H3cHmName       string    `gorm:"size:1000"`
+	IsDelete        string    `gorm:"size:10"`
+	LeafNumber      string    `gorm:"size:50"`
+	RackNumber      string    `gorm:"size:10"`
+	RackHeight      uint      `gorm:"default:null"`
+	RackStartNumber uint      `gorm:"default:null"`
+	FromFactor      uint      `gorm:"default:null"`
+	SerialNumber    string    `gorm:"size:50"`
+	IsDeleted       bool      `gorm:"not null;default:false"`
+	IsStatic        bool      `gorm:"not null;default:false"`
+	CreateTime      time.Time `gorm:"type:datetime;not null;default:CURRENT_TIMESTAMP"`
+	UpdateTime      time.Time `gorm:"type:datetime;not null;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"`
+}
+
+func (hostPool0001) TableName() string { return "hosts_pool" }
+
+type hostApplication0001 struct {
+	ID             uint `gorm:"primaryKey"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	DeletedAt      gorm.DeletedAt `gorm:"index"`
+	PoolID         uint           `gorm:"not null"`
+	ServerType     string         `gorm:"size:30"`
+	ServerVersion  string         `gorm:"size:30"`
+	ServerSubtitle string         `gorm:"size:30"`
+	ClusterName    string         `gorm:"size:64"`
+	ServerProtocol string         `gorm:"size:64"`
+	ServerAddr     string         `gorm:"size:100"`
+	ServerPort     int            `gorm:"not null"`
+	ServerRole     string         `gorm:"size:100"`
+	ServerStatus   string         `gorm:"size:100"`
+	DepartmentName string         `gorm:"size:100"`
+	CreateTime     time.Time      `gorm:"type:datetime;not null;default:CURRENT_TIMESTAMP"`
+	UpdateTime     time.Time      `gorm:"type:datetime;not null;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"`
+}
+
+func (hostApplication0001) TableName() string { return "hosts_applications" }
+
+type serverResource0001 struct {
+	ID           uint `gorm:"primaryKey"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	DeletedAt    gorm.DeletedAt `gorm:"index"`
+	PoolID       uint           `gorm:"not null"`
+	ClusterName  string
+	GroupName    string
+	IP           string
+	Port         uint
+	InstanceRole string
+	TotalMemory  float64
+	UsedMemory   float64
+	TotalDisk    float64
+	UsedDisk     float64
+	CPUCores     int
+	CPULoad      float64
+	DateTime     time.Time
+}
+
+func (serverResource0001) TableName() string { return "server_resources" }
+
+type clusterGroup0001 struct {
+	ID             uint `gorm:"primaryKey"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	DeletedAt      gorm.DeletedAt `gorm:"index"`
+	GroupName      string         `gorm:"not null"`
+	ClusterName    string         `gorm:"not null"`
+	DepartmentName string         `gorm:"not null"`
+}
+
+func (clusterGroup0001) TableName() string { return "cluster_groups" }
+
+func init() {
+	register(Migration{
+		Version: 1,
+		Name:    "initial_schema",
+		// 旧版本通过 AutoMigrate 建表，已存在的表直接沿用
+		Up: func(tx *gorm.DB) error {
+			for _, table := range []interface{}{&hostPool0001{}, &hostApplication0001{}, &serverResource0001{}, &clusterGroup0001{}} {
+				if tx.Migrator().HasTable(table) {
+					continue
+				}
+				if err := tx.Migrator().CreateTable(table); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&clusterGroup0001{}, &serverResource0001{}, &hostApplication0001{}, &hostPool0001{})
+		},
+	})
+}
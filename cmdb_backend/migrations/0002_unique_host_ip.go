@@ -0,0 +1,21 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+func init() {
+	register(Migration{
+		Version: 2,
+		Name:    "unique_host_ip",
+		Up: func(tx *gorm.DB) error {
+			if err := checkUnique(tx, "hosts_pool", "host_ip"); err != nil {
+				return err
+			}
+			return tx.Exec("CREATE UNIQUE INDEX idx_hosts_pool_host_ip ON hosts_pool (host_ip)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropIndex("hosts_pool", "idx_hosts_pool_host_ip")
+		},
+	})
+}
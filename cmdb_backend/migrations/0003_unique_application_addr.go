@@ -0,0 +1,21 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+func init() {
+	register(Migration{
+		Version: 3,
+		Name:    "unique_application_addr",
+		Up: func(tx *gorm.DB) error {
+			if err := checkUnique(tx, "hosts_applications", "pool_id", "server_addr", "server_protocol"); err != nil {
+				return err
+			}
+			return tx.Exec("CREATE UNIQUE INDEX idx_hosts_applications_addr ON hosts_applications (pool_id, server_addr, server_protocol)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropIndex("hosts_applications", "idx_hosts_applications_addr")
+		},
+	})
+}
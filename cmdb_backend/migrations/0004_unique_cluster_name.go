@@ -0,0 +1,21 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+func init() {
+	register(Migration{
+		Version: 4,
+		Name:    "unique_cluster_name",
+		Up: func(tx *gorm.DB) error {
+			if err := checkUnique(tx, "cluster_groups", "cluster_name"); err != nil {
+				return err
+			}
+			return tx.Exec("CREATE UNIQUE INDEX idx_cluster_groups_cluster_name ON cluster_groups (cluster_name)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropIndex("cluster_groups", "idx_cluster_groups_cluster_name")
+		},
+	})
+}
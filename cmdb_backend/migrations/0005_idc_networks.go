@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type idc0005 struct {
+	ID          uint `gorm:"primaryKey"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Name        string `gorm:"size:64;not null;index"`
+	CIDR        string `gorm:"column:cidr;size:64;not null;uniqueIndex"`
+	Description string `gorm:"size:255"`
+}
+
+func (idc0005) TableName() string { return "idc" }
+
+func init() {
+	register(Migration{
+		Version: 5,
+		Name:    "idc_networks",
+		// 初始数据沿用原来写死的规则：192.1 表示 P1，……，192.6 表示 P6
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().CreateTable(&idc0005{}); err != nil {
+				return err
+			}
+			var rows []idc0005
+			for i := 1; i <= 6; i++ {
+				rows = append(rows, idc0005{
+					Name: fmt.Sprintf("P%d", i),
+					CIDR: fmt.Sprintf("192.%d.0.0/16", i),
+				})
+			}
+			return tx.Create(&rows).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&idc0005{})
+		},
+	})
+}
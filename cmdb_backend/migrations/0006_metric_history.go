@@ -0,0 +1,76 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type metricSample0006 struct {
+	ID          uint   `gorm:"primaryKey"`
+	PoolID      uint   `gorm:"not null;index:idx_metric_samples_instance,priority:1"`
+	IP          string `gorm:"size:64;index:idx_metric_samples_instance,priority:2"`
+	Port        uint   `gorm:"index:idx_metric_samples_instance,priority:3"`
+	ClusterName string `gorm:"size:64;index"`
+	GroupName   string `gorm:"size:64;index"`
+	TotalMemory float64
+	UsedMemory  float64
+	TotalDisk   float64
+	UsedDisk    float64
+	CPUCores    int
+	CPULoad     float64
+	SampledAt   time.Time `gorm:"not null;index"`
+}
+
+func (metricSample0006) TableName() string { return "metric_samples" }
+
+type metricRollup0006 struct {
+	ID            uint      `gorm:"primaryKey"`
+	Resolution    string    `gorm:"size:8;not null;uniqueIndex:idx_metric_rollups_bucket,priority:1"`
+	PoolID        uint      `gorm:"not null;uniqueIndex:idx_metric_rollups_bucket,priority:2"`
+	IP            string    `gorm:"size:64;uniqueIndex:idx_metric_rollups_bucket,priority:3"`
+	Port          uint      `gorm:"uniqueIndex:idx_metric_rollups_bucket,priority:4"`
+	BucketStart   time.Time `gorm:"not null;uniqueIndex:idx_metric_rollups_bucket,priority:5;index"`
+	ClusterName   string    `gorm:"size:64;index"`
+	GroupName     string    `gorm:"size:64;index"`
+	Samples       int
+	AvgCPULoad    float64
+	MaxCPULoad    float64
+	AvgUsedMemory float64
+	MaxUsedMemory float64
+	TotalMemory   float64
+	AvgUsedDisk   float64
+	MaxUsedDisk   float64
+	TotalDisk     float64
+	CPUCores      int
+}
+
+func (metricRollup0006) TableName() string { return "metric_rollups" }
+
+type metricWatermark0006 struct {
+	Resolution  string    `gorm:"primaryKey;size:8"`
+	RolledUntil time.Time `gorm:"not null"`
+}
+
+func (metricWatermark0006) TableName() string { return "metric_watermarks" }
+
+func init() {
+	register(Migration{
+		Version: 6,
+		Name:    "metric_history",
+		// 已有的 server_resources 记录作为原始采样导入，之后由降采样任务生成各精度数据
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().CreateTable(&metricSample0006{}, &metricRollup0006{}, &metricWatermark0006{}); err != nil {
+				return err
+			}
+			return tx.Exec(`INSERT INTO metric_samples
+				(pool_id, ip, port, cluster_name, group_name, total_memory, used_memory, total_disk, used_disk, cpu_cores, cpu_load, sampled_at)
+				SELECT pool_id, COALESCE(ip, ''), COALESCE(port, 0), COALESCE(cluster_name, ''), COALESCE(group_name, ''),
+					total_memory, used_memory, total_disk, used_disk, cpu_cores, cpu_load, date_time
+				FROM server_resources WHERE deleted_at IS NULL AND date_time IS NOT NULL`).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&metricWatermark0006{}, &metricRollup0006{}, &metricSample0006{})
+		},
+	})
+}
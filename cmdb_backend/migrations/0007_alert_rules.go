@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type alertRule0007 struct {
+	ID          uint `gorm:"primaryKey"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	Name        string         `gorm:"size:100;not null"`
+	Expression  string         `gorm:"size:500;not null"`
+	Severity    string         `gorm:"size:16;not null"`
+	For         string         `gorm:"column:for_duration;size:32"`
+	ScopeType   string         `gorm:"size:16;not null;default:all"`
+	ScopeValue  string         `gorm:"size:100"`
+	Enabled     bool           `gorm:"not null;default:true"`
+	Description string         `gorm:"size:255"`
+}
+
+func (alertRule0007) TableName() string { return "alert_rules" }
+
+func init() {
+	register(Migration{
+		Version: 7,
+		Name:    "alert_rules",
+		// 初始规则沿用原来写死的阈值：高于 80% 为严重告警，低于 10% 为资源闲置提示
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().CreateTable(&alertRule0007{}); err != nil {
+				return err
+			}
+			rules := []alertRule0007{
+				{Name: "内存使用率过高", Expression: "memory_usage > 80", Severity: "critical"},
+				{Name: "磁盘使用率过高", Expression: "disk_usage > 80", Severity: "critical"},
+				{Name: "CPU 负载过高", Expression: "cpu_load > 80", Severity: "critical"},
+				{Name: "资源闲置", Expression: "memory_usage < 10 || disk_usage < 10 || cpu_load < 10", Severity: "info"},
+				{Name: "磁盘即将写满", Expression: "days_to_full < 14", Severity: "warning"},
+			}
+			for i := range rules {
+				rules[i].ScopeType = "all"
+				rules[i].Enabled = true
+			}
+			return tx.Create(&rules).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&alertRule0007{})
+		},
+	})
+}
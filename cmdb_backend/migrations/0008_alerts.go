@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type alert0008 struct {
+	ID                uint `gorm:"primaryKey"`
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	Fingerprint       string  `gorm:"size:64;not null;index"`
+	ActiveFingerprint *string `gorm:"size:64;uniqueIndex"`
+	RuleID            uint    `gorm:"not null;index"`
+	RuleName          string  `gorm:"size:100"`
+	Severity          string  `gorm:"size:16;index"`
+	Expression        string  `gorm:"size:500"`
+	PoolID            uint    `gorm:"not null"`
+	IP                string  `gorm:"size:64"`
+	Port              uint
+	ClusterName       string `gorm:"size:64;index"`
+	GroupName         string `gorm:"size:64;index"`
+	DepartmentName    string `gorm:"size:100;index"`
+	State             string `gorm:"size:16;not null;index"`
+	MemoryUsage       *float64
+	DiskUsage         *float64
+	CPULoad           *float64
+	DaysToFull        *float64
+	StartsAt          time.Time `gorm:"not null;index"`
+	LastSeenAt        time.Time `gorm:"not null"`
+	ResolvedAt        *time.Time
+	AcknowledgedAt    *time.Time
+	AcknowledgedBy    string `gorm:"size:100"`
+	SilencedUntil     *time.Time
+	SilencedBy        string `gorm:"size:100"`
+}
+
+func (alert0008) TableName() string { return "alerts" }
+
+type alertEvent0008 struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+	AlertID   uint      `gorm:"not null;index"`
+	Action    string    `gorm:"size:32;not null"`
+	Actor     string    `gorm:"size:100"`
+	FromState string    `gorm:"size:16"`
+	ToState   string    `gorm:"size:16"`
+	Comment   string    `gorm:"size:1000"`
+}
+
+func (alertEvent0008) TableName() string { return "alert_events" }
+
+func init() {
+	register(Migration{
+		Version: 8,
+		Name:    "alerts",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&alert0008{}, &alertEvent0008{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&alertEvent0008{}, &alert0008{})
+		},
+	})
+}
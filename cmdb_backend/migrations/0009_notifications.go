@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type notificationChannel0009 struct {
+	ID          uint `gorm:"primaryKey"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	Name        string         `gorm:"size:100;not null"`
+	Type        string         `gorm:"size:16;not null"`
+	Target      string         `gorm:"size:1000;not null"`
+	Secret      string         `gorm:"size:255"`
+	Template    string         `gorm:"type:text"`
+	Enabled     bool           `gorm:"not null;default:true"`
+	Description string         `gorm:"size:255"`
+}
+
+func (notificationChannel0009) TableName() string { return "notification_channels" }
+
+type notificationRoute0009 struct {
+	ID             uint `gorm:"primaryKey"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	DeletedAt      gorm.DeletedAt `gorm:"index"`
+	DepartmentName string         `gorm:"size:100;index"`
+	MinSeverity    string         `gorm:"size:16;not null;default:info"`
+	ChannelID      uint           `gorm:"not null;index"`
+	SendResolved   bool           `gorm:"not null;default:true"`
+	Enabled        bool           `gorm:"not null;default:true"`
+}
+
+func (notificationRoute0009) TableName() string { return "notification_routes" }
+
+func init() {
+	register(Migration{
+		Version: 9,
+		Name:    "notifications",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&notificationChannel0009{}, &notificationRoute0009{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&notificationRoute0009{}, &notificationChannel0009{})
+		},
+	})
+}
@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type emailMessage0010 struct {
+	ID            uint      `gorm:"primaryKey"`
+	CreatedAt     time.Time `gorm:"index"`
+	UpdatedAt     time.Time
+	To            string    `gorm:"column:recipients;size:1000;not null"`
+	Subject       string    `gorm:"size:255"`
+	Body          string    `gorm:"type:longtext"`
+	Status        string    `gorm:"size:16;not null;index"`
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"not null;index"`
+	LastError     string    `gorm:"size:1000"`
+	SentAt        *time.Time
+}
+
+func (emailMessage0010) TableName() string { return "email_messages" }
+
+type emailAttachment0010 struct {
+	ID          uint   `gorm:"primaryKey"`
+	MessageID   uint   `gorm:"not null;index"`
+	Filename    string `gorm:"size:255;not null"`
+	ContentType string `gorm:"size:100"`
+	Inline      bool   `gorm:"not null;default:false"`
+	Size        int
+	Content     []byte `gorm:"type:longblob"`
+}
+
+func (emailAttachment0010) TableName() string { return "email_attachments" }
+
+func init() {
+	register(Migration{
+		Version: 10,
+		Name:    "email_outbox",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&emailMessage0010{}, &emailAttachment0010{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&emailAttachment0010{}, &emailMessage0010{})
+		},
+	})
+}
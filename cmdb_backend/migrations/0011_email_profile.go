@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+type emailMessage0011 struct {
+	Profile string `gorm:"size:100;not null;default:default"`
+}
+
+func (emailMessage0011) TableName() string { return "email_messages" }
+
+func init() {
+	register(Migration{
+		Version: 11,
+		Name:    "email_profile",
+		// 已有的邮件都使用默认发件账号
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&emailMessage0011{}, "Profile")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&emailMessage0011{}, "Profile")
+		},
+	})
+}
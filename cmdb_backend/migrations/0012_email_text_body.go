@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+type emailMessage0012 struct {
+	Text string `gorm:"column:text_body;type:longtext"`
+}
+
+func (emailMessage0012) TableName() string { return "email_messages" }
+
+func init() {
+	register(Migration{
+		Version: 12,
+		Name:    "email_text_body",
+		// 纯文本备选正文，已有的邮件只有 HTML 正文
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().AddColumn(&emailMessage0012{}, "Text")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&emailMessage0012{}, "Text")
+		},
+	})
+}
@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type reportSubscription0013 struct {
+	ID          uint `gorm:"primaryKey"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	Name        string         `gorm:"size:100;not null"`
+	Report      string         `gorm:"size:32;not null"`
+	Format      string         `gorm:"size:16;not null"`
+	Recipients  string         `gorm:"size:1000;not null"`
+	Groups      string         `gorm:"size:1000"`
+	Departments string         `gorm:"size:1000"`
+	Schedule    string         `gorm:"size:100;not null"`
+	Timezone    string         `gorm:"size:64;not null"`
+	Profile     string         `gorm:"size:100"`
+	Enabled     bool           `gorm:"not null;default:true"`
+	NextRunAt   *time.Time     `gorm:"index"`
+}
+
+func (reportSubscription0013) TableName() string { return "report_subscriptions" }
+
+type reportRun0013 struct {
+	ID             uint `gorm:"primaryKey"`
+	CreatedAt      time.Time
+	SubscriptionID uint      `gorm:"not null;uniqueIndex:idx_report_runs_schedule"`
+	ScheduledAt    time.Time `gorm:"not null;uniqueIndex:idx_report_runs_schedule"`
+	Trigger        string    `gorm:"size:16;not null"`
+	Instance       string    `gorm:"size:255"`
+	Status         string    `gorm:"size:16;not null;index"`
+	StartedAt      time.Time
+	FinishedAt     *time.Time
+	MessageID      *uint
+	Filename       string `gorm:"size:255"`
+	Size           int
+	Error          string `gorm:"size:1000"`
+}
+
+func (reportRun0013) TableName() string { return "report_runs" }
+
+type schedulerLock0013 struct {
+	Name      string    `gorm:"primaryKey;size:64"`
+	Holder    string    `gorm:"size:255;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+func (schedulerLock0013) TableName() string { return "scheduler_locks" }
+
+func init() {
+	register(Migration{
+		Version: 13,
+		Name:    "report_subscriptions",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&reportSubscription0013{}, &reportRun0013{}, &schedulerLock0013{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&schedulerLock0013{}, &reportRun0013{}, &reportSubscription0013{})
+		},
+	})
+}
@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type reportArchive0014 struct {
+	ID          uint `gorm:"primaryKey"`
+	CreatedAt   time.Time
+	Report      string    `gorm:"size:32;not null;index"`
+	Format      string    `gorm:"size:16;not null"`
+	Params      string    `gorm:"type:text"`
+	GeneratedAt time.Time `gorm:"not null;index"`
+	Filename    string    `gorm:"size:255;not null"`
+	ContentType string    `gorm:"size:100;not null"`
+	Size        int
+	Checksum    string `gorm:"size:64;not null;index"`
+	ContentKey  string `gorm:"size:255;not null"`
+	SnapshotKey string `gorm:"size:255;not null"`
+}
+
+func (reportArchive0014) TableName() string { return "report_archives" }
+
+type reportRun0014 struct {
+	ArchiveID *uint
+}
+
+func (reportRun0014) TableName() string { return "report_runs" }
+
+func init() {
+	register(Migration{
+		Version: 14,
+		Name:    "report_archives",
+		// 订阅的执行记录指向生成的存档
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().CreateTable(&reportArchive0014{}); err != nil {
+				return err
+			}
+			return tx.Migrator().AddColumn(&reportRun0014{}, "ArchiveID")
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropColumn(&reportRun0014{}, "ArchiveID"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable(&reportArchive0014{})
+		},
+	})
+}
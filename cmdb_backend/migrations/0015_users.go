@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type user0015 struct {
+	ID           uint `gorm:"primaryKey"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Username     string `gorm:"size:100;not null;uniqueIndex"`
+	DisplayName  string `gorm:"size:100"`
+	Email        string `gorm:"size:255"`
+	Provider     string `gorm:"size:16;not null;uniqueIndex:idx_users_identity"`
+	Subject      string `gorm:"size:255;not null;uniqueIndex:idx_users_identity"`
+	PasswordHash string `gorm:"size:100"`
+	Disabled     bool   `gorm:"not null;default:false"`
+	LastLoginAt  *time.Time
+}
+
+func (user0015) TableName() string { return "users" }
+
+type refreshToken0015 struct {
+	ID           uint `gorm:"primaryKey"`
+	CreatedAt    time.Time
+	UserID       uint      `gorm:"not null;index"`
+	TokenHash    string    `gorm:"size:64;not null;uniqueIndex"`
+	ExpiresAt    time.Time `gorm:"not null"`
+	RevokedAt    *time.Time
+	ReplacedByID *uint
+	ClientIP     string `gorm:"size:64"`
+	UserAgent    string `gorm:"size:255"`
+}
+
+func (refreshToken0015) TableName() string { return "refresh_tokens" }
+
+func init() {
+	register(Migration{
+		Version: 15,
+		Name:    "users",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&user0015{}, &refreshToken0015{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&refreshToken0015{}, &user0015{})
+		},
+	})
+}
@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type roleBinding0016 struct {
+	ID             uint `gorm:"primaryKey"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	UserID         uint   `gorm:"not null;uniqueIndex:idx_role_bindings_scope,priority:1"`
+	Role           string `gorm:"size:16;not null"`
+	DepartmentName string `gorm:"size:100;not null;default:'';uniqueIndex:idx_role_bindings_scope,priority:2"`
+	GrantedBy      string `gorm:"size:100"`
+}
+
+func (roleBinding0016) TableName() string { return "role_bindings" }
+
+func init() {
+	register(Migration{
+		Version: 16,
+		Name:    "role_bindings",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().CreateTable(&roleBinding0016{}); err != nil {
+				return err
+			}
+			// 升级前只有 cmdb user add 创建的本地用户拥有全部权限，继续授予他们管理员角色，避免升级后无人可以管理
+			var ids []uint
+			if err := tx.Table("users").Where("provider = ?", "local").Pluck("id", &ids).Error; err != nil {
+				return err
+			}
+			now := time.Now()
+			for _, id := range ids {
+				binding := roleBinding0016{CreatedAt: now, UpdatedAt: now, UserID: id, Role: "admin", GrantedBy: "migration"}
+				if err := tx.Create(&binding).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&roleBinding0016{})
+		},
+	})
+}
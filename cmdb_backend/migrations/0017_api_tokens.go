@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type apiToken0017 struct {
+	ID         uint `gorm:"primaryKey"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Name       string `gorm:"size:100;not null;index"`
+	Prefix     string `gorm:"size:16;not null"`
+	TokenHash  string `gorm:"size:64;not null;uniqueIndex"`
+	Scopes     string `gorm:"size:255;not null"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	LastUsedIP string `gorm:"size:64"`
+	CreatedBy  string `gorm:"size:100"`
+	RevokedAt  *time.Time
+	RevokedBy  string `gorm:"size:100"`
+}
+
+func (apiToken0017) TableName() string { return "api_tokens" }
+
+func init() {
+	register(Migration{
+		Version: 17,
+		Name:    "api_tokens",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&apiToken0017{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&apiToken0017{})
+		},
+	})
+}
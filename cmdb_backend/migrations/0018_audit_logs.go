@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type auditLog0018 struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+	Actor     string    `gorm:"size:100;not null;index"`
+	Action    string    `gorm:"size:16;not null"`
+	Entity    string    `gorm:"size:64;not null;index:idx_audit_logs_entity,priority:1"`
+	EntityID  string    `gorm:"size:64;not null;index:idx_audit_logs_entity,priority:2"`
+	Before    string    `gorm:"type:text"`
+	After     string    `gorm:"type:text"`
+}
+
+func (auditLog0018) TableName() string { return "audit_logs" }
+
+func init() {
+	register(Migration{
+		Version: 18,
+		Name:    "audit_logs",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&auditLog0018{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&auditLog0018{})
+		},
+	})
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type rowVersion0019 struct {
+	ID        uint       `gorm:"primaryKey"`
+	Entity    string     `gorm:"size:64;not null;index:idx_row_versions_entity,priority:1"`
+	EntityID  string     `gorm:"size:64;not null;index:idx_row_versions_entity,priority:2"`
+	ValidFrom time.Time  `gorm:"not null;index"`
+	ValidTo   *time.Time `gorm:"index"`
+	Data      string     `gorm:"type:text;not null"`
+}
+
+func (rowVersion0019) TableName() string { return "row_versions" }
+
+func init() {
+	register(Migration{
+		Version: 19,
+		Name:    "row_versions",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&rowVersion0019{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&rowVersion0019{})
+		},
+	})
+}
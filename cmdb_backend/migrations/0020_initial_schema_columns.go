@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"gorm.io/gorm"
+)
+
+func init() {
+	register(Migration{
+		Version: 20,
+		Name:    "initial_schema_columns",
+		// 0001 直接沿用旧版本 AutoMigrate 建好的表，这里为这些表补齐 0001 快照中缺失的列。
+		// 只添加列，不修改已有列，避免覆盖之后迁移对列的调整
+		Up: func(tx *gorm.DB) error {
+			for _, table := range []interface{}{&hostPool0001{}, &hostApplication0001{}, &serverResource0001{}, &clusterGroup0001{}} {
+				stmt := &gorm.Statement{DB: tx}
+				if err := stmt.Parse(table); err != nil {
+					return err
+				}
+				for _, name := range stmt.Schema.DBNames {
+					if tx.Migrator().HasColumn(table, name) {
+						continue
+					}
+					if err := tx.Migrator().AddColumn(table, name); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+		// 补齐的列属于 0001 的表结构，回滚时保留
+		Down: func(tx *gorm.DB) error { return nil },
+	})
+}
@@ -0,0 +1,89 @@
+package migrations
+
+import (
+	"net/netip"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+type hostPool0021 struct {
+	ID      uint   `gorm:"primaryKey"`
+	HostIP  string `gorm:"size:50;not null"`
+	IDCName string `gorm:"column:idc_name;size:64;not null;default:'';index"`
+}
+
+func (hostPool0021) TableName() string { return "hosts_pool" }
+
+func init() {
+	register(Migration{
+		Version: 21,
+		Name:    "host_idc_name",
+		// 把主机所属机房保存到 hosts_pool，按机房筛选时不再需要逐台计算。
+		// 已有主机按 idc 表中的网段回填，规则与 IDCResolver 相同：最长前缀优先，未命中为 unassigned
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().AddColumn(&hostPool0021{}, "IDCName"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().CreateIndex(&hostPool0021{}, "IDCName"); err != nil {
+				return err
+			}
+
+			var idcs []idc0005
+			if err := tx.Find(&idcs).Error; err != nil {
+				return err
+			}
+			type network struct {
+				prefix netip.Prefix
+				name   string
+			}
+			var networks []network
+			for _, idc := range idcs {
+				if prefix, err := netip.ParsePrefix(idc.CIDR); err == nil {
+					networks = append(networks, network{prefix: prefix, name: idc.Name})
+				}
+			}
+			sort.SliceStable(networks, func(i, j int) bool {
+				return networks[i].prefix.Bits() > networks[j].prefix.Bits()
+			})
+			resolve := func(ip string) string {
+				if addr, err := netip.ParseAddr(ip); err == nil {
+					for _, n := range networks {
+						if n.prefix.Contains(addr.Unmap()) {
+							return n.name
+						}
+					}
+				}
+				return "unassigned"
+			}
+
+			assigned := make(map[string][]uint)
+			var hosts []hostPool0021
+			err := tx.Select("id", "host_ip").FindInBatches(&hosts, 1000, func(*gorm.DB, int) error {
+				for _, host := range hosts {
+					name := resolve(host.HostIP)
+					assigned[name] = append(assigned[name], host.ID)
+				}
+				return nil
+			}).Error
+			if err != nil {
+				return err
+			}
+			for name, ids := range assigned {
+				for start := 0; start < len(ids); start += 500 {
+					batch := ids[start:min(start+500, len(ids))]
+					if err := tx.Model(&hostPool0021{}).Where("id IN ?", batch).Update("idc_name", name).Error; err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&hostPool0021{}, "IDCName"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&hostPool0021{}, "IDCName")
+		},
+	})
+}
@@ -0,0 +1,239 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration 表示一个带版本号的数据库结构变更
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration 记录已经执行过的迁移版本
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false" json:"version"`
+	Name      string    `gorm:"size:255;not null" json:"name"`
+	AppliedAt time.Time `gorm:"not null" json:"applied_at"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Status 表示单个迁移的执行状态
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// ErrSchemaBehind 表示数据库中还有未执行的迁移
+var ErrSchemaBehind = errors.New("database schema is behind")
+
+var registry []Migration
+
+// register 由各个迁移文件在 init 中调用
+func register(m Migration) {
+	registry = append(registry, m)
+}
+
+// All 返回按版本号排序的全部迁移
+func All() []Migration {
+	all := make([]Migration, len(registry))
+	copy(all, registry)
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+	return all
+}
+
+type Migrator struct {
+	DB *gorm.DB
+}
+
+func NewMigrator(db *gorm.DB) *Migrator {
+	return &Migrator{DB: db}
+}
+
+func (m *Migrator) ensureTable() error {
+	if m.DB.Migrator().HasTable(&SchemaMigration{}) {
+		return nil
+	}
+	return m.DB.Migrator().CreateTable(&SchemaMigration{})
+}
+
+func (m *Migrator) applied() (map[int]SchemaMigration, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+	var rows []SchemaMigration
+	if err := m.DB.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// Status 返回所有已知迁移以及它们是否已经执行
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, mig := range All() {
+		st := Status{Version: mig.Version, Name: mig.Name}
+		if row, ok := applied[mig.Version]; ok {
+			appliedAt := row.AppliedAt
+			st.Applied = true
+			st.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+// Pending 返回尚未执行的迁移
+func (m *Migrator) Pending() ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, mig := range All() {
+		if _, ok := applied[mig.Version]; !ok {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Up 依次执行所有未执行的迁移，每个迁移在独立的事务中完成
+func (m *Migrator) Up() ([]Migration, error) {
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, mig := range pending {
+		err := m.DB.Transaction(func(tx *gorm.DB) error {
+			if err := mig.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: mig.Version, Name: mig.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %04d_%s failed: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// Down 按版本号倒序回滚最近执行的 steps 个迁移
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	all := All()
+	var done []Migration
+	for i := len(all) - 1; i >= 0 && len(done) < steps; i-- {
+		mig := all[i]
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		err := m.DB.Transaction(func(tx *gorm.DB) error {
+			if err := mig.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, mig.Version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("rollback of %04d_%s failed: %w", mig.Version, mig.Name, err)
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// CheckCurrent 在数据库结构落后于当前程序时返回 ErrSchemaBehind
+func (m *Migrator) CheckCurrent() error {
+	pending, err := m.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d pending migration(s), first is %04d_%s", ErrSchemaBehind, len(pending), pending[0].Version, pending[0].Name)
+	}
+	return nil
+}
+
+// maxReportedDuplicates 为 checkUnique 在错误中最多列出的重复值个数
+const maxReportedDuplicates = 20
+
+// checkUnique 在创建唯一索引之前检查 columns 的组合在 table 中是否有重复（包括已软删除的行），
+// 有重复时返回列出冲突行 id 的错误，需要先人工清理再重新执行迁移
+func checkUnique(tx *gorm.DB, table string, columns ...string) error {
+	list := strings.Join(columns, ", ")
+	rows, err := tx.Table(table).
+		Select(list + ", COUNT(*) AS duplicates, GROUP_CONCAT(id) AS ids").
+		Group(list).Having("COUNT(*) > 1").
+		Order("duplicates DESC").Limit(maxReportedDuplicates).
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var conflicts []string
+	for rows.Next() {
+		values := make([]interface{}, len(columns)+2)
+		dest := make([]interface{}, len(values))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		pairs := make([]string, len(columns))
+		for i, column := range columns {
+			pairs[i] = fmt.Sprintf("%s=%s", column, formatValue(values[i]))
+		}
+		conflicts = append(conflicts, fmt.Sprintf("  %s: ids %s", strings.Join(pairs, ", "), values[len(values)-1]))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s has duplicate (%s) values, resolve them and run the migration again (showing at most %d):\n%s",
+		table, list, maxReportedDuplicates, strings.Join(conflicts, "\n"))
+}
+
+// formatValue 输出错误信息中的列值，字符串加上引号
+func formatValue(value interface{}) string {
+	if b, ok := value.([]byte); ok {
+		return fmt.Sprintf("%q", b)
+	}
+	if s, ok := value.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprint(value)
+}
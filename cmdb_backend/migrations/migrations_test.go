@@ -0,0 +1,78 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"cmdb/config"
+	"cmdb/database"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := database.Open(config.DatabaseConfig{Driver: database.DriverSQLite, DSN: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func TestUpDown(t *testing.T) {
+	db := openTestDB(t)
+	m := NewMigrator(db)
+	done, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != len(All()) {
+		t.Fatalf("applied %d of %d migrations", len(done), len(All()))
+	}
+	if err := m.CheckCurrent(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Down(len(All())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("up after a full rollback: %v", err)
+	}
+}
+
+func TestUniqueIndexPreflight(t *testing.T) {
+	db := openTestDB(t)
+	m := NewMigrator(db)
+	// 建表前的旧版本允许重复的 host_ip
+	if err := db.Migrator().CreateTable(&hostPool0001{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("INSERT INTO hosts_pool (id, host_name, host_ip) VALUES (1, 'a', '10.0.0.1'), (2, 'b', '10.0.0.2'), (3, 'c', '10.0.0.1')").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := m.Up()
+	if err == nil {
+		t.Fatal("expected duplicate host_ip to stop the migration")
+	}
+	for _, want := range []string{"0002_unique_host_ip", `host_ip="10.0.0.1": ids 1,3`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+	if db.Migrator().HasIndex("hosts_pool", "idx_hosts_pool_host_ip") {
+		t.Error("index created despite duplicates")
+	}
+
+	if err := db.Exec("UPDATE hosts_pool SET host_ip = '10.0.0.3' WHERE id = 3").Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("up after resolving duplicates: %v", err)
+	}
+}
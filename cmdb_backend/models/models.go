@@ -1,35 +1,38 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"gorm.io/gorm"
 )
 
 type HostPool struct {
-	ID               uint              `gorm:"primaryKey" json:"id"`
-	HostName         string            `gorm:"size:50;not null" json:"host_name"`
-	HostIP           string            `gorm:"size:50;not null" json:"host_ip"`
-	HostType         string            `gorm:"size:10" json:"host_type"`
-	H3cID            string            `gorm:"size:50" json:"h3c_id"`
-	H3cStatus        string            `gorm:"size:20" json:"h3c_status"`
-	DiskSize         uint              `gorm:"default:null" json:"disk_size"`
-	RAM              uint              `gorm:"default:null" json:"ram"`
-	VCPUs            uint              `gorm:"default:null" json:"vcpus"`
-	IfH3cSync        string            `gorm:"size:10" json:"if_h3c_sync"`
-	H3cImgID         string            `gorm:"size:50" json:"h3c_img_id"`
-	H3cHmName        string            `gorm:"size:1000" json:"h3c_hm_name"`
-	IsDelete         string            `gorm:"size:10" json:"is_delete"`
-	LeafNumber       string            `gorm:"size:50" json:"leaf_number"`
-	RackNumber       string            `gorm:"size:10" json:"rack_number"`
-	RackHeight       uint              `gorm:"default:null" json:"rack_height"`
-	RackStartNumber  uint              `gorm:"default:null" json:"rack_start_number"`
-	FromFactor       uint              `gorm:"default:null" json:"from_factor"`
-	SerialNumber     string            `gorm:"size:50" json:"serial_number"`
+	ID              uint   `gorm:"primaryKey" json:"id"`
+	HostName        string `gorm:"size:50;not null" json:"host_name"`
+	HostIP          string `gorm:"size:50;not null;uniqueIndex:idx_hosts_pool_host_ip" json:"host_ip"`
+	HostType        string `gorm:"size:10" json:"host_type"`
+	H3cID           string `gorm:"size:50" json:"h3c_id"`
+	H3cStatus       string `gorm:"size:20" json:"h3c_status"`
+	DiskSize        uint   `gorm:"default:null" json:"disk_size"`
+	RAM             uint   `gorm:"default:null" json:"ram"`
+	VCPUs           uint   `gorm:"default:null" json:"vcpus"`
+	IfH3cSync       string `gorm:"size:10" json:"if_h3c_sync"`
+	H3cImgID        string `gorm:"size:50" json:"h3c_img_id"`
+	H3cHmName       string `gorm:"size:1000" json:"h3c_hm_name"`
+	IsDelete        string `gorm:"size:10" json:"is_delete"`
+	LeafNumber      string `gorm:"size:50" json:"leaf_number"`
+	RackNumber      string `gorm:"size:10" json:"rack_number"`
+	RackHeight      uint   `gorm:"default:null" json:"rack_height"`
+	RackStartNumber uint   `gorm:"default:null" json:"rack_start_number"`
+	FromFactor      uint   `gorm:"default:null" json:"from_factor"`
+	SerialNumber    string `gorm:"size:50" json:"serial_number"`
+	// IDCName 是按 idc 表网段计算出的所属机房，主机 IP 或网段变化时由服务端重新计算
+	IDCName          string            `gorm:"column:idc_name;size:64;not null;default:'';index" json:"idc_name"`
 	IsDeleted        bool              `gorm:"not null;default:false" json:"is_deleted"`
 	IsStatic         bool              `gorm:"not null;default:false" json:"is_static"`
-	CreateTime       time.Time         `gorm:"type:datetime;not null;default:CURRENT_TIMESTAMP" json:"create_time"`
-	UpdateTime       time.Time         `gorm:"type:datetime;not null;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP" json:"update_time"`
+	CreateTime       time.Time         `gorm:"type:datetime;not null;default:CURRENT_TIMESTAMP;autoCreateTime" json:"create_time"`
+	UpdateTime       time.Time         `gorm:"type:datetime;not null;default:CURRENT_TIMESTAMP;autoUpdateTime" json:"update_time"`
 	HostApplications []HostApplication `gorm:"foreignKey:PoolID" json:"host_applications"`
 }
 
@@ -42,20 +45,20 @@ type HostApplication struct {
 	CreatedAt      time.Time      `json:"created_at"`
 	UpdatedAt      time.Time      `json:"updated_at"`
 	DeletedAt      gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
-	PoolID         uint           `gorm:"not null" json:"pool_id"`
+	PoolID         uint           `gorm:"not null;uniqueIndex:idx_hosts_applications_addr,priority:1" json:"pool_id"`
 	HostPool       HostPool       `gorm:"foreignKey:PoolID" json:"-"`
 	ServerType     string         `gorm:"size:30" json:"server_type"`
 	ServerVersion  string         `gorm:"size:30" json:"server_version"`
 	ServerSubtitle string         `gorm:"size:30" json:"server_subtitle"`
 	ClusterName    string         `gorm:"size:64" json:"cluster_name"`
-	ServerProtocol string         `gorm:"size:64" json:"server_protocol"`
-	ServerAddr     string         `gorm:"size:100" json:"server_addr"`
+	ServerProtocol string         `gorm:"size:64;uniqueIndex:idx_hosts_applications_addr,priority:3" json:"server_protocol"`
+	ServerAddr     string         `gorm:"size:100;uniqueIndex:idx_hosts_applications_addr,priority:2" json:"server_addr"`
 	ServerPort     int            `gorm:"not null" json:"server_port"`
 	ServerRole     string         `gorm:"size:100" json:"server_role"`
 	ServerStatus   string         `gorm:"size:100" json:"server_status"`
 	DepartmentName string         `gorm:"size:100" json:"department_name"`
-	CreateTime     time.Time      `gorm:"type:datetime;not null;default:CURRENT_TIMESTAMP" json:"create_time"`
-	UpdateTime     time.Time      `gorm:"type:datetime;not null;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP" json:"update_time"`
+	CreateTime     time.Time      `gorm:"type:datetime;not null;default:CURRENT_TIMESTAMP;autoCreateTime" json:"create_time"`
+	UpdateTime     time.Time      `gorm:"type:datetime;not null;default:CURRENT_TIMESTAMP;autoUpdateTime" json:"update_time"`
 }
 
 func (HostApplication) TableName() string {
@@ -90,10 +93,131 @@ type ClusterGroup struct {
 	UpdatedAt      time.Time      `json:"updated_at"`
 	DeletedAt      gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
 	GroupName      string         `json:"group_name" gorm:"not null"`
-	ClusterName    string         `json:"cluster_name" gorm:"not null"`
+	ClusterName    string         `json:"cluster_name" gorm:"not null;uniqueIndex:idx_cluster_groups_cluster_name"`
 	DepartmentName string         `json:"department_name" gorm:"not null"` // 新增字段
 }
 
+// IDC 表示机房及其网段，一个机房可以有多个网段
+type IDC struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Name        string    `gorm:"size:64;not null;index" json:"name"`
+	CIDR        string    `gorm:"column:cidr;size:64;not null;uniqueIndex" json:"cidr"`
+	Description string    `gorm:"size:255" json:"description"`
+}
+
+func (IDC) TableName() string {
+	return "idc"
+}
+
+// MetricSample 表示某个实例在某一时刻的原始资源采样，实例由 (pool_id, ip, port) 确定
+type MetricSample struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	PoolID      uint      `gorm:"not null;index:idx_metric_samples_instance,priority:1" json:"pool_id"`
+	IP          string    `gorm:"size:64;index:idx_metric_samples_instance,priority:2" json:"ip"`
+	Port        uint      `gorm:"index:idx_metric_samples_instance,priority:3" json:"port"`
+	ClusterName string    `gorm:"size:64;index" json:"cluster_name"`
+	GroupName   string    `gorm:"size:64;index" json:"group_name"`
+	TotalMemory float64   `json:"total_memory"`
+	UsedMemory  float64   `json:"used_memory"`
+	TotalDisk   float64   `json:"total_disk"`
+	UsedDisk    float64   `json:"used_disk"`
+	CPUCores    int       `json:"cpu_cores"`
+	CPULoad     float64   `json:"cpu_load"`
+	SampledAt   time.Time `gorm:"not null;index" json:"sampled_at"`
+}
+
+// MetricRollup 表示按 5m/1h/1d 降采样后的实例资源数据，容量取桶内最后一个值
+type MetricRollup struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Resolution    string    `gorm:"size:8;not null;uniqueIndex:idx_metric_rollups_bucket,priority:1" json:"resolution"`
+	PoolID        uint      `gorm:"not null;uniqueIndex:idx_metric_rollups_bucket,priority:2" json:"pool_id"`
+	IP            string    `gorm:"size:64;uniqueIndex:idx_metric_rollups_bucket,priority:3" json:"ip"`
+	Port          uint      `gorm:"uniqueIndex:idx_metric_rollups_bucket,priority:4" json:"port"`
+	BucketStart   time.Time `gorm:"not null;uniqueIndex:idx_metric_rollups_bucket,priority:5;index" json:"bucket_start"`
+	ClusterName   string    `gorm:"size:64;index" json:"cluster_name"`
+	GroupName     string    `gorm:"size:64;index" json:"group_name"`
+	Samples       int       `json:"samples"`
+	AvgCPULoad    float64   `json:"avg_cpu_load"`
+	MaxCPULoad    float64   `json:"max_cpu_load"`
+	AvgUsedMemory float64   `json:"avg_used_memory"`
+	MaxUsedMemory float64   `json:"max_used_memory"`
+	TotalMemory   float64   `json:"total_memory"`
+	AvgUsedDisk   float64   `json:"avg_used_disk"`
+	MaxUsedDisk   float64   `json:"max_used_disk"`
+	TotalDisk     float64   `json:"total_disk"`
+	CPUCores      int       `json:"cpu_cores"`
+}
+
+// MetricWatermark 记录每个精度已降采样到的时间点
+type MetricWatermark struct {
+	Resolution  string    `gorm:"primaryKey;size:8" json:"resolution"`
+	RolledUntil time.Time `gorm:"not null" json:"rolled_until"`
+}
+
+// AlertRule 表示一条告警规则。Expression 为指标表达式，For 为条件需要持续的时长（如 "15m"，为空表示立即触发），
+// ScopeType 为 all/group/cluster/department/server_type/host，ScopeValue 为对应的组名、集群名、部门、应用类型或主机 ID
+type AlertRule struct {
+	ID         uint           `gorm:"primaryKey" json:"id"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	Name       string         `gorm:"size:100;not null" json:"name"`
+	Expression string         `gorm:"size:500;not null" json:"expression"`
+	Severity   string         `gorm:"size:16;not null" json:"severity"`
+	For        string         `gorm:"column:for_duration;size:32" json:"for"`
+	ScopeType  string         `gorm:"size:16;not null;default:all" json:"scope_type"`
+	ScopeValue string         `gorm:"size:100" json:"scope_value"`
+	// Enabled 为指针，GORM 创建记录时会把零值 false 替换为默认值 true
+	Enabled     *bool  `gorm:"not null;default:true" json:"enabled"`
+	Description string `gorm:"size:255" json:"description"`
+}
+
+// Alert 表示持久化的告警实例，State 为 firing/acknowledged/silenced/resolved。
+// ActiveFingerprint 在告警未恢复时等于 Fingerprint，恢复后置空，用唯一索引保证同一指纹只有一条未恢复的告警
+type Alert struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	Fingerprint       string     `gorm:"size:64;not null;index" json:"fingerprint"`
+	ActiveFingerprint *string    `gorm:"size:64;uniqueIndex" json:"-"`
+	RuleID            uint       `gorm:"not null;index" json:"rule_id"`
+	RuleName          string     `gorm:"size:100" json:"rule_name"`
+	Severity          string     `gorm:"size:16;index" json:"severity"`
+	Expression        string     `gorm:"size:500" json:"expression"`
+	PoolID            uint       `gorm:"not null" json:"pool_id"`
+	IP                string     `gorm:"size:64" json:"ip"`
+	Port              uint       `json:"port"`
+	ClusterName       string     `gorm:"size:64;index" json:"cluster_name"`
+	GroupName         string     `gorm:"size:64;index" json:"group_name"`
+	DepartmentName    string     `gorm:"size:100;index" json:"department_name"`
+	State             string     `gorm:"size:16;not null;index" json:"state"`
+	MemoryUsage       *float64   `json:"memory_usage"`
+	DiskUsage         *float64   `json:"disk_usage"`
+	CPULoad           *float64   `json:"cpu_load"`
+	DaysToFull        *float64   `json:"days_to_full"`
+	StartsAt          time.Time  `gorm:"not null;index" json:"starts_at"`
+	LastSeenAt        time.Time  `gorm:"not null" json:"last_seen_at"`
+	ResolvedAt        *time.Time `json:"resolved_at"`
+	AcknowledgedAt    *time.Time `json:"acknowledged_at"`
+	AcknowledgedBy    string     `gorm:"size:100" json:"acknowledged_by"`
+	SilencedUntil     *time.Time `json:"silenced_until"`
+	SilencedBy        string     `gorm:"size:100" json:"silenced_by"`
+}
+
+// AlertEvent 表示告警的一次状态变化或评论
+type AlertEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	AlertID   uint      `gorm:"not null;index" json:"alert_id"`
+	Action    string    `gorm:"size:32;not null" json:"action"`
+	Actor     string    `gorm:"size:100" json:"actor"`
+	FromState string    `gorm:"size:16" json:"from_state"`
+	ToState   string    `gorm:"size:16" json:"to_state"`
+	Comment   string    `gorm:"size:1000" json:"comment"`
+}
+
 // IDCUsage 表示 IDC 使用情况的结构
 type IDCUsage struct {
 	IDCName        string  `json:"idc_name"`
@@ -102,3 +226,252 @@ type IDCUsage struct {
 	AvgMemoryUsage float64 `json:"avg_memory_usage"`
 	AvgDiskUsage   float64 `json:"avg_disk_usage"`
 }
+
+// NotificationChannel 为告警和报告的发送渠道，Target 为机器人或 webhook 地址，email 渠道为逗号分隔的收件人。
+// Enabled 为指针，原因同 AlertRule.Enabled
+type NotificationChannel struct {
+	ID          uint           `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	Name        string         `gorm:"size:100;not null" json:"name"`
+	Type        string         `gorm:"size:16;not null" json:"type"`
+	Target      string         `gorm:"size:1000;not null" json:"target"`
+	Secret      string         `gorm:"size:255" json:"-"`
+	HasSecret   bool           `gorm:"-" json:"has_secret"`
+	Template    string         `gorm:"type:text" json:"template"`
+	Enabled     *bool          `gorm:"not null;default:true" json:"enabled"`
+	Description string         `gorm:"size:255" json:"description"`
+}
+
+// AfterFind 只对外暴露是否配置了签名密钥，不返回密钥本身
+func (c *NotificationChannel) AfterFind(tx *gorm.DB) error {
+	c.HasSecret = c.Secret != ""
+	return nil
+}
+
+func (c *NotificationChannel) AfterSave(tx *gorm.DB) error {
+	c.HasSecret = c.Secret != ""
+	return nil
+}
+
+// NotificationRoute 把部门（为空时匹配所有部门）中不低于 MinSeverity 的告警发送到渠道。
+// SendResolved、Enabled 为指针，原因同 AlertRule.Enabled
+type NotificationRoute struct {
+	ID             uint           `gorm:"primaryKey" json:"id"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	DepartmentName string         `gorm:"size:100;index" json:"department_name"`
+	MinSeverity    string         `gorm:"size:16;not null;default:info" json:"min_severity"`
+	ChannelID      uint           `gorm:"not null;index" json:"channel_id"`
+	SendResolved   *bool          `gorm:"not null;default:true" json:"send_resolved"`
+	Enabled        *bool          `gorm:"not null;default:true" json:"enabled"`
+}
+
+// EmailMessage 为发件队列中的邮件，To 为逗号分隔的收件人。后台任务投递 NextAttemptAt 已到的 queued 邮件
+type EmailMessage struct {
+	ID            uint              `gorm:"primaryKey" json:"id"`
+	CreatedAt     time.Time         `gorm:"index" json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+	Profile       string            `gorm:"size:100;not null;default:default" json:"profile"`
+	To            string            `gorm:"column:recipients;size:1000;not null" json:"to"`
+	Subject       string            `gorm:"size:255" json:"subject"`
+	Body          string            `gorm:"type:longtext" json:"body,omitempty"`
+	Text          string            `gorm:"column:text_body;type:longtext" json:"text,omitempty"`
+	Status        string            `gorm:"size:16;not null;index" json:"status"`
+	Attempts      int               `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time         `gorm:"not null;index" json:"next_attempt_at"`
+	LastError     string            `gorm:"size:1000" json:"last_error"`
+	SentAt        *time.Time        `json:"sent_at"`
+	Attachments   []EmailAttachment `gorm:"foreignKey:MessageID" json:"attachments,omitempty"`
+}
+
+// EmailAttachment 为邮件附件，Inline 的附件可以在正文中通过 cid:文件名 引用
+type EmailAttachment struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	MessageID   uint   `gorm:"not null;index" json:"message_id"`
+	Filename    string `gorm:"size:255;not null" json:"filename"`
+	ContentType string `gorm:"size:100" json:"content_type"`
+	Inline      bool   `gorm:"not null;default:false" json:"inline"`
+	Size        int    `json:"size"`
+	Content     []byte `gorm:"type:longblob" json:"-"`
+}
+
+// ReportSubscription 按 cron 表达式定时生成报告并发送给 Recipients（逗号分隔）。
+// Groups、Departments 为逗号分隔的过滤条件，为空时不限；Schedule 在 Timezone 时区中计算，NextRunAt 为下一次执行时间
+type ReportSubscription struct {
+	ID          uint           `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+	Name        string         `gorm:"size:100;not null" json:"name"`
+	Report      string         `gorm:"size:32;not null" json:"report"`
+	Format      string         `gorm:"size:16;not null" json:"format"`
+	Recipients  string         `gorm:"size:1000;not null" json:"recipients"`
+	Groups      string         `gorm:"size:1000" json:"groups"`
+	Departments string         `gorm:"size:1000" json:"departments"`
+	Schedule    string         `gorm:"size:100;not null" json:"schedule"`
+	Timezone    string         `gorm:"size:64;not null" json:"timezone"`
+	Profile     string         `gorm:"size:100" json:"profile"`
+	// Enabled 为指针，原因同 AlertRule.Enabled
+	Enabled   *bool      `gorm:"not null;default:true" json:"enabled"`
+	NextRunAt *time.Time `gorm:"index" json:"next_run_at"`
+}
+
+// ReportRun 为订阅的一次执行，(SubscriptionID, ScheduledAt) 唯一，多个实例不会重复执行同一次计划。
+// Status 为 running/succeeded/failed，成功时 MessageID 为发件队列中的邮件
+type ReportRun struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	SubscriptionID uint       `gorm:"not null;uniqueIndex:idx_report_runs_schedule" json:"subscription_id"`
+	ScheduledAt    time.Time  `gorm:"not null;uniqueIndex:idx_report_runs_schedule" json:"scheduled_at"`
+	Trigger        string     `gorm:"size:16;not null" json:"trigger"`
+	Instance       string     `gorm:"size:255" json:"instance"`
+	Status         string     `gorm:"size:16;not null;index" json:"status"`
+	StartedAt      time.Time  `json:"started_at"`
+	FinishedAt     *time.Time `json:"finished_at"`
+	MessageID      *uint      `json:"message_id"`
+	ArchiveID      *uint      `json:"archive_id"`
+	Filename       string     `gorm:"size:255" json:"filename"`
+	Size           int        `json:"size"`
+	Error          string     `gorm:"size:1000" json:"error"`
+}
+
+// ReportArchive 为一份生成过的报告。文件和生成时的数据快照保存在 blob 存储中，ContentKey、SnapshotKey 为对象的 key；
+// Params 为生成参数（JSON），Checksum 为文件内容的 SHA-256
+type ReportArchive struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Report      string          `gorm:"size:32;not null;index" json:"report"`
+	Format      string          `gorm:"size:16;not null" json:"format"`
+	Params      string          `gorm:"type:text" json:"-"`
+	Parameters  json.RawMessage `gorm:"-" json:"params"`
+	GeneratedAt time.Time       `gorm:"not null;index" json:"generated_at"`
+	Filename    string          `gorm:"size:255;not null" json:"filename"`
+	ContentType string          `gorm:"size:100;not null" json:"content_type"`
+	Size        int             `json:"size"`
+	Checksum    string          `gorm:"size:64;not null;index" json:"checksum"`
+	ContentKey  string          `gorm:"size:255;not null" json:"-"`
+	SnapshotKey string          `gorm:"size:255;not null" json:"-"`
+}
+
+// AfterFind 把保存为文本的参数原样作为 JSON 对象输出
+func (a *ReportArchive) AfterFind(tx *gorm.DB) error {
+	if a.Params != "" {
+		a.Parameters = json.RawMessage(a.Params)
+	}
+	return nil
+}
+
+// SchedulerLock 为后台任务的租约锁，Holder 在 ExpiresAt 之前独占 Name
+type SchedulerLock struct {
+	Name      string    `gorm:"primaryKey;size:64" json:"name"`
+	Holder    string    `gorm:"size:255;not null" json:"holder"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+}
+
+// User 为可以登录的用户。Provider 为 local、ldap 或 oidc，Subject 为提供方中的唯一标识；
+// 只有本地用户有 PasswordHash（bcrypt）
+type User struct {
+	ID           uint          `gorm:"primaryKey" json:"id"`
+	CreatedAt    time.Time     `json:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+	Username     string        `gorm:"size:100;not null;uniqueIndex" json:"username"`
+	DisplayName  string        `gorm:"size:100" json:"display_name"`
+	Email        string        `gorm:"size:255" json:"email"`
+	Provider     string        `gorm:"size:16;not null;uniqueIndex:idx_users_identity" json:"provider"`
+	Subject      string        `gorm:"size:255;not null;uniqueIndex:idx_users_identity" json:"subject"`
+	PasswordHash string        `gorm:"size:100" json:"-"`
+	Disabled     bool          `gorm:"not null;default:false" json:"disabled"`
+	LastLoginAt  *time.Time    `json:"last_login_at"`
+	Roles        []RoleBinding `gorm:"foreignKey:UserID" json:"roles,omitempty"`
+}
+
+// RefreshToken 为登录会话的刷新令牌，只保存令牌的 SHA-256。每次刷新都会吊销旧令牌并换发新令牌，
+// ReplacedByID 指向新令牌；已吊销的令牌再次使用时吊销该用户的全部会话
+type RefreshToken struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UserID       uint       `gorm:"not null;index" json:"user_id"`
+	TokenHash    string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	ExpiresAt    time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	ReplacedByID *uint      `json:"replaced_by_id"`
+	ClientIP     string     `gorm:"size:64" json:"client_ip"`
+	UserAgent    string     `gorm:"size:255" json:"user_agent"`
+}
+
+// RoleBinding 授予用户在 DepartmentName 部门中的角色（viewer、operator 或 admin），DepartmentName 为空时适用于所有部门。
+// 同一用户在同一部门只有一个角色
+type RoleBinding struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	UserID         uint      `gorm:"not null;uniqueIndex:idx_role_bindings_scope,priority:1" json:"user_id"`
+	Role           string    `gorm:"size:16;not null" json:"role"`
+	DepartmentName string    `gorm:"size:100;not null;default:'';uniqueIndex:idx_role_bindings_scope,priority:2" json:"department_name"`
+	GrantedBy      string    `gorm:"size:100" json:"granted_by"`
+}
+
+// APIToken 为采集器等程序调用接口使用的令牌，只保存令牌的 SHA-256。Scopes 为逗号分隔的权限范围，
+// Prefix 为令牌开头的几个字符，用于在列表中辨认令牌。吊销后保留记录，便于追查
+type APIToken struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Name       string     `gorm:"size:100;not null;index" json:"name"`
+	Prefix     string     `gorm:"size:16;not null" json:"prefix"`
+	TokenHash  string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Scopes     string     `gorm:"size:255;not null" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `gorm:"size:64" json:"last_used_ip"`
+	CreatedBy  string     `gorm:"size:100" json:"created_by"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	RevokedBy  string     `gorm:"size:100" json:"revoked_by"`
+}
+
+// AuditLog 为一次写入的审计记录。Entity 为表名，Action 为 create、update 或 delete；
+// Before、After 为变化的列在写入前后的值（JSON 对象），新建时只有 After，删除时只有 Before
+type AuditLog struct {
+	ID           uint            `gorm:"primaryKey" json:"id"`
+	CreatedAt    time.Time       `gorm:"index" json:"created_at"`
+	Actor        string          `gorm:"size:100;not null;index" json:"actor"`
+	Action       string          `gorm:"size:16;not null" json:"action"`
+	Entity       string          `gorm:"size:64;not null;index:idx_audit_logs_entity,priority:1" json:"entity"`
+	EntityID     string          `gorm:"size:64;not null;index:idx_audit_logs_entity,priority:2" json:"entity_id"`
+	Before       string          `gorm:"type:text" json:"-"`
+	After        string          `gorm:"type:text" json:"-"`
+	BeforeValues json.RawMessage `gorm:"-" json:"before"`
+	AfterValues  json.RawMessage `gorm:"-" json:"after"`
+}
+
+// AfterFind 把保存为文本的前后值原样作为 JSON 对象输出
+func (a *AuditLog) AfterFind(tx *gorm.DB) error {
+	if a.Before != "" {
+		a.BeforeValues = json.RawMessage(a.Before)
+	}
+	if a.After != "" {
+		a.AfterValues = json.RawMessage(a.After)
+	}
+	return nil
+}
+
+// RowVersion 为一行数据在 [ValidFrom, ValidTo) 期间的内容，ValidTo 为空表示当前版本。
+// Data 为各列的值（JSON 对象，键为列名），用于查询某一时刻的数据
+type RowVersion struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	Entity    string          `gorm:"size:64;not null;index:idx_row_versions_entity,priority:1" json:"entity"`
+	EntityID  string          `gorm:"size:64;not null;index:idx_row_versions_entity,priority:2" json:"entity_id"`
+	ValidFrom time.Time       `gorm:"not null;index" json:"valid_from"`
+	ValidTo   *time.Time      `gorm:"index" json:"valid_to"`
+	Data      string          `gorm:"type:text;not null" json:"-"`
+	Values    json.RawMessage `gorm:"-" json:"data"`
+}
+
+// AfterFind 把保存为文本的列值原样作为 JSON 对象输出
+func (v *RowVersion) AfterFind(tx *gorm.DB) error {
+	v.Values = json.RawMessage(v.Data)
+	return nil
+}
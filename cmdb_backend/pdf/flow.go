@@ -0,0 +1,315 @@
+package pdf
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// 排版使用的颜色
+var (
+	Black     = color.RGBA{0, 0, 0, 255}
+	Gray      = color.RGBA{110, 110, 110, 255}
+	LightGray = color.RGBA{221, 221, 221, 255}
+	HeaderBg  = color.RGBA{242, 242, 242, 255}
+	StripeBg  = color.RGBA{250, 250, 250, 255}
+)
+
+// Flow 从上到下依次排版内容，剩余高度不够时自动换页
+type Flow struct {
+	Doc    *Document
+	Page   *Page
+	Margin float64
+	Y      float64
+	// Footer 不为空时在 Finish 中为每一页写页脚，参数为页码和总页数
+	Footer func(p *Page, n, total int)
+}
+
+// NewFlow 创建排版器，第一页在第一次写入内容时创建
+func NewFlow(doc *Document) *Flow {
+	return &Flow{Doc: doc, Margin: 48}
+}
+
+// Width 为页面中可用于排版的宽度
+func (f *Flow) Width() float64 {
+	return PageWidth - 2*f.Margin
+}
+
+// NewPage 另起一页
+func (f *Flow) NewPage() *Page {
+	f.Page = f.Doc.AddPage()
+	f.Y = f.Margin
+	return f.Page
+}
+
+// Ensure 保证当前页还剩 h 的高度，不够时换页。返回是否换了页
+func (f *Flow) Ensure(h float64) bool {
+	if f.Page == nil || f.Y+h > PageHeight-f.Margin {
+		f.NewPage()
+		return true
+	}
+	return false
+}
+
+// Mark 返回当前排版位置，用于目录和书签的跳转
+func (f *Flow) Mark() Dest {
+	f.Ensure(0)
+	return Dest{Page: f.Page, Y: f.Y}
+}
+
+// Space 留出垂直间距，到页面底部时不换页
+func (f *Flow) Space(h float64) {
+	if f.Page != nil {
+		f.Y = math.Min(f.Y+h, PageHeight-f.Margin)
+	}
+}
+
+func headingSize(level int) float64 {
+	if size, ok := map[int]float64{1: 20, 2: 15, 3: 12}[level]; ok {
+		return size
+	}
+	return 12
+}
+
+// headingHeight 为标题本身加上其后至少一行内容的高度
+func headingHeight(level int) float64 {
+	return headingSize(level)*1.6 + 24
+}
+
+// Heading 写标题，level 为 1-3。标题后至少还能放下一行内容，否则换页
+func (f *Flow) Heading(level int, s string) {
+	size := headingSize(level)
+	f.Ensure(headingHeight(level))
+	f.Y += size
+	f.Page.Text(f.Margin, f.Y, Font{Size: size, Bold: true}, s)
+	f.Y += size * 0.6
+	if level == 1 {
+		f.Page.Line(f.Margin, f.Y, f.Margin+f.Width(), f.Y, 0.8, LightGray)
+		f.Y += 6
+	}
+}
+
+// Paragraph 写一段文字，超出宽度时自动换行
+func (f *Flow) Paragraph(font Font, s string) {
+	lineHeight := font.Size * 1.5
+	for _, line := range Wrap(s, font.Size, f.Width()) {
+		f.Ensure(lineHeight)
+		f.Y += lineHeight
+		f.Page.Text(f.Margin, f.Y-font.Size*0.4, font, line)
+	}
+}
+
+// Wrap 按宽度把文字拆成多行，原有的换行保留
+func Wrap(s string, size, width float64) []string {
+	var lines []string
+	for _, para := range strings.Split(s, "\n") {
+		var line strings.Builder
+		var w float64
+		for _, r := range para {
+			rw := TextWidth(string(r), size)
+			if w+rw > width && line.Len() > 0 {
+				lines = append(lines, line.String())
+				line.Reset()
+				w = 0
+			}
+			line.WriteRune(r)
+			w += rw
+		}
+		lines = append(lines, line.String())
+	}
+	return lines
+}
+
+// Align 为表格单元格的对齐方式
+type Align int
+
+const (
+	AlignLeft Align = iota
+	AlignRight
+)
+
+// Column 为表格的一列，Width 为相对宽度
+type Column struct {
+	Title string
+	Width float64
+	Align Align
+}
+
+// Table 为一个表格。跨页时在新页面上重复表头；单元格超出列宽的文字被截断
+type Table struct {
+	Columns  []Column
+	Rows     [][]string
+	FontSize float64
+	// RowColor 不为空时返回每行文字的颜色，零值为黑色
+	RowColor func(row int) color.RGBA
+}
+
+// Table 排版表格
+func (f *Flow) Table(t Table) {
+	size := t.FontSize
+	if size == 0 {
+		size = 9
+	}
+	rowHeight := size * 2
+	var total float64
+	for _, col := range t.Columns {
+		total += col.Width
+	}
+	widths := make([]float64, len(t.Columns))
+	for i, col := range t.Columns {
+		widths[i] = f.Width() * col.Width / total
+	}
+
+	row := func(cells []string, font Font, fill color.RGBA) {
+		f.Page.Rect(f.Margin, f.Y, f.Width(), rowHeight, fill)
+		x := f.Margin
+		for i, col := range t.Columns {
+			var text string
+			if i < len(cells) {
+				text = Fit(cells[i], size, widths[i]-8)
+			}
+			tx := x + 4
+			if col.Align == AlignRight {
+				tx = x + widths[i] - 4 - TextWidth(text, size)
+			}
+			f.Page.Text(tx, f.Y+rowHeight/2+size*0.35, font, text)
+			x += widths[i]
+		}
+		f.Y += rowHeight
+		f.Page.Line(f.Margin, f.Y, f.Margin+f.Width(), f.Y, 0.5, LightGray)
+	}
+	header := func() {
+		titles := make([]string, len(t.Columns))
+		for i, col := range t.Columns {
+			titles[i] = col.Title
+		}
+		row(titles, Font{Size: size, Bold: true}, HeaderBg)
+	}
+
+	f.Ensure(rowHeight * 2)
+	header()
+	for i, cells := range t.Rows {
+		if f.Ensure(rowHeight) {
+			header()
+		}
+		fill := color.RGBA{255, 255, 255, 255}
+		if i%2 == 1 {
+			fill = StripeBg
+		}
+		font := Font{Size: size}
+		if t.RowColor != nil {
+			font.Color = t.RowColor(i)
+		}
+		row(cells, font, fill)
+	}
+}
+
+// Series 为条形图中的一个系列
+type Series struct {
+	Name  string
+	Color color.RGBA
+}
+
+// BarChart 为横向分组条形图：每个标签一组，每组内每个系列一根条
+type BarChart struct {
+	Series []Series
+	Labels []string
+	// Values[i][j] 为第 i 个标签在第 j 个系列上的值
+	Values [][]float64
+	// Max 为坐标轴的最大值，为 0 时取 100
+	Max  float64
+	Unit string
+	// Format 为条末尾数值的格式，为空时保留一位小数
+	Format string
+}
+
+// NiceMax 把 v 向上取整到 1、2、5 乘以 10 的整数次幂，用作计数类图表的坐标轴最大值
+func NiceMax(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	base := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if v <= m*base {
+			return m * base
+		}
+	}
+	return 10 * base
+}
+
+// BarChart 排版条形图，一组条不会被拆到两页；换页后在新页面上重画刻度
+func (f *Flow) BarChart(c BarChart) {
+	const (
+		labelWidth = 130
+		barHeight  = 7
+		fontSize   = 8
+	)
+	maxValue := c.Max
+	if maxValue == 0 {
+		maxValue = 100
+	}
+	format := c.Format
+	if format == "" {
+		format = "%.1f"
+	}
+	plotX := f.Margin + labelWidth
+	plotWidth := f.Width() - labelWidth - 40
+	bandHeight := float64(len(c.Series))*barHeight + 8
+
+	legend := func() {
+		x := plotX
+		for _, s := range c.Series {
+			f.Page.Rect(x, f.Y+2, 8, 8, s.Color)
+			f.Page.Text(x+11, f.Y+9, Font{Size: fontSize}, s.Name)
+			x += 11 + TextWidth(s.Name, fontSize) + 14
+		}
+		f.Y += 14
+	}
+	axis := func() {
+		for i := 0; i <= 4; i++ {
+			v := maxValue * float64(i) / 4
+			label := fmt.Sprintf("%g%s", math.Round(v*10)/10, c.Unit)
+			x := plotX + plotWidth*float64(i)/4
+			f.Page.Text(x-TextWidth(label, fontSize)/2, f.Y+fontSize, Font{Size: fontSize, Color: Gray}, label)
+		}
+		f.Y += fontSize + 4
+	}
+
+	f.Ensure(14 + fontSize + 4 + bandHeight)
+	legend()
+	axis()
+	for i, label := range c.Labels {
+		if f.Ensure(bandHeight) {
+			axis()
+		}
+		top := f.Y
+		for j := 0; j <= 4; j++ {
+			x := plotX + plotWidth*float64(j)/4
+			f.Page.Line(x, top, x, top+bandHeight, 0.4, LightGray)
+		}
+		f.Page.Text(f.Margin, top+bandHeight/2+fontSize*0.35, Font{Size: fontSize}, Fit(label, fontSize, labelWidth-6))
+		for j, s := range c.Series {
+			var v float64
+			if i < len(c.Values) && j < len(c.Values[i]) {
+				v = c.Values[i][j]
+			}
+			w := plotWidth * math.Max(0, math.Min(v, maxValue)) / maxValue
+			y := top + 4 + float64(j)*barHeight
+			f.Page.Rect(plotX, y, w, barHeight-1, s.Color)
+			f.Page.Text(plotX+w+3, y+barHeight-1.5, Font{Size: 6, Color: Gray}, fmt.Sprintf(format, v))
+		}
+		f.Y += bandHeight
+	}
+}
+
+// Finish 为每一页写页脚，所有内容写完后调用
+func (f *Flow) Finish() {
+	if f.Footer == nil {
+		return
+	}
+	pages := f.Doc.Pages()
+	for i, p := range pages {
+		f.Footer(p, i+1, len(pages))
+	}
+}
@@ -0,0 +1,467 @@
+// Package pdf 生成报表使用的 PDF 文档，不依赖第三方库和字体文件。
+//
+// 中文使用阅读器自带的 STSong-Light（Adobe-GB1）字体，文件中不嵌入字形。文档中不写入随机 ID，
+// 页面内容不压缩，同样的输入总是得到完全相同的字节，可以直接和基准文件比较。
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// A4 纵向页面尺寸，单位为 pt
+const (
+	PageWidth  = 595.0
+	PageHeight = 842.0
+)
+
+// Font 为文字的字号、粗细和颜色，Color 为零值时为黑色
+type Font struct {
+	Size  float64
+	Bold  bool
+	Color color.RGBA
+}
+
+// TextWidth 返回文字在给定字号下的宽度：ASCII 字符为半角，其余为全角
+func TextWidth(s string, size float64) float64 {
+	var units float64
+	for _, r := range s {
+		if r < 0x80 {
+			units += 0.5
+		} else {
+			units++
+		}
+	}
+	return units * size
+}
+
+// Fit 截断超出宽度的文字并以 "..." 结尾
+func Fit(s string, size, width float64) string {
+	if TextWidth(s, size) <= width {
+		return s
+	}
+	limit := width - TextWidth("...", size)
+	var b strings.Builder
+	var w float64
+	for _, r := range s {
+		rw := TextWidth(string(r), size)
+		if w+rw > limit {
+			break
+		}
+		w += rw
+		b.WriteRune(r)
+	}
+	return b.String() + "..."
+}
+
+// Document 为一个 PDF 文档
+type Document struct {
+	Title  string
+	Author string
+	// Created 为零值时不写入创建时间，便于生成可重复的输出
+	Created time.Time
+
+	pages     []*Page
+	images    []*Image
+	bookmarks []bookmark
+}
+
+func New() *Document {
+	return &Document{}
+}
+
+// Pages 返回已添加的页面
+func (d *Document) Pages() []*Page {
+	return d.pages
+}
+
+// AddPage 在文档末尾添加一个 A4 页面
+func (d *Document) AddPage() *Page {
+	p := &Page{doc: d, Number: len(d.pages) + 1}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Image 为文档中的一张图片，可在多个页面中重复使用
+type Image struct {
+	Width, Height int
+	name          string
+	data          []byte
+}
+
+// AddImage 把图片转换为 RGB 并压缩后加入文档
+func (d *Document) AddImage(img image.Image) (*Image, error) {
+	b := img.Bounds()
+	raw := make([]byte, 0, b.Dx()*b.Dy()*3)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			// 透明像素按白色背景合成
+			a := uint32(c.A)
+			raw = append(raw,
+				byte((uint32(c.R)*a+255*(255-a))/255),
+				byte((uint32(c.G)*a+255*(255-a))/255),
+				byte((uint32(c.B)*a+255*(255-a))/255))
+		}
+	}
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	im := &Image{Width: b.Dx(), Height: b.Dy(), name: fmt.Sprintf("Im%d", len(d.images)+1), data: buf.Bytes()}
+	d.images = append(d.images, im)
+	return im, nil
+}
+
+// Page 为一个页面。坐标原点在页面左上角，y 轴向下，文字的 y 为基线位置
+type Page struct {
+	Number  int
+	doc     *Document
+	content bytes.Buffer
+	images  []*Image
+	links   []link
+}
+
+func (p *Page) printf(format string, args ...any) {
+	fmt.Fprintf(&p.content, format, args...)
+}
+
+// Text 在 (x, y) 处写一行文字
+func (p *Page) Text(x, y float64, f Font, s string) {
+	if s == "" {
+		return
+	}
+	p.printf("BT %s rg /F1 %s Tf ", rgb(f.Color), num(f.Size))
+	if f.Bold {
+		// 没有粗体字形，用描边模拟加粗
+		p.printf("2 Tr %s w %s RG ", num(f.Size/30), rgb(f.Color))
+	} else {
+		p.printf("0 Tr ")
+	}
+	p.printf("%s %s Td <%s> Tj ET\n", num(x), num(PageHeight-y), encodeText(s))
+}
+
+// Rect 填充矩形，(x, y) 为左上角
+func (p *Page) Rect(x, y, w, h float64, fill color.RGBA) {
+	p.printf("%s rg %s %s %s %s re f\n", rgb(fill), num(x), num(PageHeight-y-h), num(w), num(h))
+}
+
+// StrokeRect 画矩形边框
+func (p *Page) StrokeRect(x, y, w, h, width float64, stroke color.RGBA) {
+	p.printf("%s RG %s w %s %s %s %s re S\n", rgb(stroke), num(width), num(x), num(PageHeight-y-h), num(w), num(h))
+}
+
+// Line 画一条直线
+func (p *Page) Line(x1, y1, x2, y2, width float64, stroke color.RGBA) {
+	p.printf("%s RG %s w %s %s m %s %s l S\n", rgb(stroke), num(width), num(x1), num(PageHeight-y1), num(x2), num(PageHeight-y2))
+}
+
+// DashedLine 画一条虚线，on、off 为每段实线和间隔的长度
+func (p *Page) DashedLine(x1, y1, x2, y2, width, on, off float64, stroke color.RGBA) {
+	p.printf("q [%s %s] 0 d ", num(on), num(off))
+	p.Line(x1, y1, x2, y2, width, stroke)
+	p.printf("Q\n")
+}
+
+// Image 把图片缩放到 w×h 画在 (x, y) 处
+func (p *Page) Image(img *Image, x, y, w, h float64) {
+	found := false
+	for _, im := range p.images {
+		found = found || im == img
+	}
+	if !found {
+		p.images = append(p.images, img)
+	}
+	p.printf("q %s 0 0 %s %s %s cm /%s Do Q\n", num(w), num(h), num(x), num(PageHeight-y-h), img.name)
+}
+
+// Dest 为文档中的一个位置：页面和该页中的 y 坐标
+type Dest struct {
+	Page *Page
+	Y    float64
+}
+
+type link struct {
+	x, y, w, h float64
+	dest       Dest
+}
+
+// Link 使 (x, y, w, h) 区域可以点击跳转到 dest
+func (p *Page) Link(x, y, w, h float64, dest Dest) {
+	p.links = append(p.links, link{x, y, w, h, dest})
+}
+
+// bookmark 为阅读器书签栏中的一项，Level 从 1 开始
+type bookmark struct {
+	title string
+	level int
+	dest  Dest
+}
+
+// Bookmark 添加书签。书签按添加顺序排列，level 比前一项大时成为前一项的子项
+func (d *Document) Bookmark(title string, level int, dest Dest) {
+	d.bookmarks = append(d.bookmarks, bookmark{title, max(level, 1), dest})
+}
+
+// WriteTo 输出整个文档
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	ow := &objectWriter{}
+	ow.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// 对象编号按固定顺序分配：目录、页面树、字体、文档信息、图片、页面及其内容、链接、书签
+	const (
+		catalogObj = 1
+		pagesObj   = 2
+		fontObj    = 3
+		cidFontObj = 4
+		descObj    = 5
+		infoObj    = 6
+	)
+	next := infoObj + 1
+	alloc := func() int {
+		next++
+		return next - 1
+	}
+	imageObjs := make(map[*Image]int)
+	for _, img := range d.images {
+		imageObjs[img] = alloc()
+	}
+	pageObjs := make(map[*Page]int)
+	for _, p := range d.pages {
+		pageObjs[p] = alloc()
+		alloc()
+	}
+	linkObjs := make([][]int, len(d.pages))
+	for i, p := range d.pages {
+		for range p.links {
+			linkObjs[i] = append(linkObjs[i], alloc())
+		}
+	}
+	var outlinesObj int
+	bookmarkObjs := make([]int, len(d.bookmarks))
+	if len(d.bookmarks) > 0 {
+		outlinesObj = alloc()
+		for i := range d.bookmarks {
+			bookmarkObjs[i] = alloc()
+		}
+	}
+	dest := func(dst Dest) string {
+		return fmt.Sprintf("[%d 0 R /XYZ 0 %s 0]", pageObjs[dst.Page], num(PageHeight-dst.Y))
+	}
+
+	catalog := "<< /Type /Catalog /Pages 2 0 R"
+	if outlinesObj != 0 {
+		catalog += fmt.Sprintf(" /Outlines %d 0 R /PageMode /UseOutlines", outlinesObj)
+	}
+	ow.object(catalogObj, catalog+" >>")
+	kids := make([]string, len(d.pages))
+	for i, p := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", pageObjs[p])
+	}
+	ow.object(pagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d /MediaBox [0 0 %s %s] >>",
+		strings.Join(kids, " "), len(d.pages), num(PageWidth), num(PageHeight)))
+	ow.object(fontObj, "<< /Type /Font /Subtype /Type0 /BaseFont /STSong-Light /Encoding /UniGB-UCS2-H /DescendantFonts [4 0 R] >>")
+	// UniGB-UCS2-H 把 ASCII 映射到 1-95 或 814-939 号半角字形，两段都声明为半角宽度
+	ow.object(cidFontObj, "<< /Type /Font /Subtype /CIDFontType0 /BaseFont /STSong-Light "+
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (GB1) /Supplement 2 >> /FontDescriptor 5 0 R "+
+		"/DW 1000 /W [1 95 500 814 939 500] >>")
+	ow.object(descObj, "<< /Type /FontDescriptor /FontName /STSong-Light /Flags 6 /FontBBox [-25 -254 1000 880] "+
+		"/ItalicAngle 0 /Ascent 880 /Descent -120 /CapHeight 880 /StemV 93 >>")
+
+	info := "<< /Producer (cmdb)"
+	if d.Title != "" {
+		info += " /Title <" + encodeInfo(d.Title) + ">"
+	}
+	if d.Author != "" {
+		info += " /Author <" + encodeInfo(d.Author) + ">"
+	}
+	if !d.Created.IsZero() {
+		info += " /CreationDate (D:" + d.Created.UTC().Format("20060102150405") + "Z)"
+	}
+	ow.object(infoObj, info+" >>")
+
+	for _, img := range d.images {
+		ow.stream(imageObjs[img], fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d "+
+			"/ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode", img.Width, img.Height), img.data)
+	}
+
+	for i, p := range d.pages {
+		resources := "/Font << /F1 3 0 R >>"
+		if len(p.images) > 0 {
+			xobjects := make([]string, len(p.images))
+			for j, img := range p.images {
+				xobjects[j] = fmt.Sprintf("/%s %d 0 R", img.name, imageObjs[img])
+			}
+			resources += " /XObject << " + strings.Join(xobjects, " ") + " >>"
+		}
+		annots := ""
+		if len(linkObjs[i]) > 0 {
+			refs := make([]string, len(linkObjs[i]))
+			for j, id := range linkObjs[i] {
+				refs[j] = fmt.Sprintf("%d 0 R", id)
+			}
+			annots = " /Annots [" + strings.Join(refs, " ") + "]"
+		}
+		ow.object(pageObjs[p], fmt.Sprintf("<< /Type /Page /Parent 2 0 R /Resources << %s >> /Contents %d 0 R%s >>",
+			resources, pageObjs[p]+1, annots))
+		ow.stream(pageObjs[p]+1, "", p.content.Bytes())
+	}
+
+	for i, p := range d.pages {
+		for j, l := range p.links {
+			ow.object(linkObjs[i][j], fmt.Sprintf("<< /Type /Annot /Subtype /Link /Rect [%s %s %s %s] /Border [0 0 0] /Dest %s >>",
+				num(l.x), num(PageHeight-l.y-l.h), num(l.x+l.w), num(PageHeight-l.y), dest(l.dest)))
+		}
+	}
+
+	if outlinesObj != 0 {
+		d.writeOutlines(ow, outlinesObj, bookmarkObjs, dest)
+	}
+
+	ow.finish(catalogObj, infoObj)
+	return ow.buf.WriteTo(w)
+}
+
+// writeOutlines 按层级把书签写成树：每项记录父项、前后兄弟和首末子项
+func (d *Document) writeOutlines(ow *objectWriter, root int, ids []int, dest func(Dest) string) {
+	n := len(d.bookmarks)
+	parent := make([]int, n)
+	prev := make([]int, n)
+	next := make([]int, n)
+	first := make([]int, n+1)
+	last := make([]int, n+1)
+	count := make([]int, n+1)
+	// 下标 n 表示根节点
+	var stack []int
+	for i, b := range d.bookmarks {
+		for len(stack) > 0 && d.bookmarks[stack[len(stack)-1]].level >= b.level {
+			stack = stack[:len(stack)-1]
+		}
+		p := n
+		if len(stack) > 0 {
+			p = stack[len(stack)-1]
+		}
+		parent[i] = p
+		prev[i], next[i] = -1, -1
+		if count[p] == 0 {
+			first[p] = i
+		} else {
+			prev[i] = last[p]
+			next[last[p]] = i
+		}
+		last[p] = i
+		count[p]++
+		stack = append(stack, i)
+	}
+	ref := func(i int) int {
+		if i == n {
+			return root
+		}
+		return ids[i]
+	}
+
+	ow.object(root, fmt.Sprintf("<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>",
+		ref(first[n]), ref(last[n]), count[n]))
+	for i, b := range d.bookmarks {
+		obj := fmt.Sprintf("<< /Title <%s> /Parent %d 0 R /Dest %s", encodeInfo(b.title), ref(parent[i]), dest(b.dest))
+		if prev[i] >= 0 {
+			obj += fmt.Sprintf(" /Prev %d 0 R", ref(prev[i]))
+		}
+		if next[i] >= 0 {
+			obj += fmt.Sprintf(" /Next %d 0 R", ref(next[i]))
+		}
+		if count[i] > 0 {
+			obj += fmt.Sprintf(" /First %d 0 R /Last %d 0 R /Count %d", ref(first[i]), ref(last[i]), count[i])
+		}
+		ow.object(ids[i], obj+" >>")
+	}
+}
+
+// Bytes 返回整个文档
+func (d *Document) Bytes() []byte {
+	var buf bytes.Buffer
+	d.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// objectWriter 按编号写入对象并记录偏移，最后写出交叉引用表
+type objectWriter struct {
+	buf     bytes.Buffer
+	offsets map[int]int
+}
+
+func (ow *objectWriter) object(id int, body string) {
+	if ow.offsets == nil {
+		ow.offsets = make(map[int]int)
+	}
+	ow.offsets[id] = ow.buf.Len()
+	fmt.Fprintf(&ow.buf, "%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+func (ow *objectWriter) stream(id int, dict string, data []byte) {
+	if dict != "" {
+		dict += " "
+	}
+	ow.object(id, fmt.Sprintf("<< %s/Length %d >>\nstream\n%s\nendstream", dict, len(data), data))
+}
+
+func (ow *objectWriter) finish(root, info int) {
+	xref := ow.buf.Len()
+	size := len(ow.offsets) + 1
+	fmt.Fprintf(&ow.buf, "xref\n0 %d\n0000000000 65535 f \n", size)
+	for id := 1; id < size; id++ {
+		fmt.Fprintf(&ow.buf, "%010d 00000 n \n", ow.offsets[id])
+	}
+	fmt.Fprintf(&ow.buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", size, root, info, xref)
+}
+
+// encodeText 把文字编码为 UCS-2 大端序的十六进制串，BMP 以外的字符替换为问号
+func encodeText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < 0x20 {
+			continue
+		}
+		if r > 0xFFFF {
+			r = '?'
+		}
+		fmt.Fprintf(&b, "%04X", r)
+	}
+	return b.String()
+}
+
+// encodeInfo 把文档信息编码为带 BOM 的 UTF-16BE 十六进制串
+func encodeInfo(s string) string {
+	var b strings.Builder
+	b.WriteString("FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	return b.String()
+}
+
+// num 以最多两位小数输出数字
+func num(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 2, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" || s == "" {
+		return "0"
+	}
+	return s
+}
+
+func rgb(c color.RGBA) string {
+	return fmt.Sprintf("%s %s %s", num(float64(c.R)/255), num(float64(c.G)/255), num(float64(c.B)/255))
+}
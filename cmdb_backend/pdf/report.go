@@ -0,0 +1,119 @@
+package pdf
+
+import (
+	"fmt"
+	"math"
+)
+
+// 目录每一行的高度
+const tocLineHeight = 20
+
+// Report 为带封面和目录的报告。依次调用 Cover、Contents 和 Section 写入内容，最后调用 Finish
+// 补写目录和页脚。目录页在写正文之前预留，章节数不能超过 Contents 时给出的数量
+type Report struct {
+	*Flow
+	Title string
+
+	cover    *Page
+	tocPages []*Page
+	entries  []tocEntry
+}
+
+type tocEntry struct {
+	title string
+	level int
+	dest  Dest
+}
+
+func NewReport(doc *Document, title string) *Report {
+	doc.Title = title
+	return &Report{Flow: NewFlow(doc), Title: title}
+}
+
+// Cover 写封面：标题和若干行说明
+func (r *Report) Cover(subtitle string, lines ...string) {
+	r.cover = r.NewPage()
+	y := PageHeight * 0.36
+	r.cover.Rect(0, y-60, PageWidth, 4, Black)
+	r.cover.Text(r.Margin, y, Font{Size: 28, Bold: true}, r.Title)
+	y += 30
+	if subtitle != "" {
+		r.cover.Text(r.Margin, y, Font{Size: 14, Color: Gray}, subtitle)
+		y += 40
+	}
+	for _, line := range lines {
+		r.cover.Text(r.Margin, y, Font{Size: 11}, line)
+		y += 20
+	}
+}
+
+// tocPerPage 为每个目录页能放下的行数
+func (r *Report) tocPerPage() int {
+	return int((PageHeight - 2*r.Margin - 40) / tocLineHeight)
+}
+
+// Contents 为 entries 个章节预留目录页
+func (r *Report) Contents(entries int) {
+	pages := max(1, int(math.Ceil(float64(entries)/float64(r.tocPerPage()))))
+	for i := 0; i < pages; i++ {
+		r.tocPages = append(r.tocPages, r.NewPage())
+	}
+	r.Page = nil
+}
+
+// Section 开始一个章节：level 1 的章节从新的一页开始。章节同时写入目录和书签
+func (r *Report) Section(level int, title string) {
+	if level == 1 && r.Page != nil && r.Y > r.Margin {
+		r.Page = nil
+	}
+	r.Ensure(headingHeight(level))
+	dest := r.Mark()
+	r.Heading(level, title)
+	r.entries = append(r.entries, tocEntry{title, level, dest})
+	r.Doc.Bookmark(title, level, dest)
+}
+
+// Finish 写目录和除封面以外每一页的页脚
+func (r *Report) Finish() {
+	perPage := r.tocPerPage()
+	for i, e := range r.entries {
+		if i/perPage >= len(r.tocPages) {
+			break
+		}
+		page := r.tocPages[i/perPage]
+		y := r.Margin
+		if i%perPage == 0 {
+			page.Text(r.Margin, y+20, Font{Size: 20, Bold: true}, "目录")
+		}
+		y += 40 + float64(i%perPage)*tocLineHeight + 14
+		indent := float64(e.level-1) * 16
+		font := Font{Size: 11, Bold: e.level == 1}
+		if e.level > 1 {
+			font.Size = 10
+		}
+		number := fmt.Sprint(e.dest.Page.Number)
+		right := r.Margin + r.Width()
+		title := Fit(e.title, font.Size, r.Width()-indent-60)
+		page.Text(r.Margin+indent, y, font, title)
+		page.Text(right-TextWidth(number, font.Size), y, font, number)
+		// 标题和页码之间的引导点
+		from := r.Margin + indent + TextWidth(title, font.Size) + 6
+		to := right - TextWidth(number, font.Size) - 6
+		if from < to {
+			page.DashedLine(from, y-1, to, y-1, 0.8, 1, 3, Gray)
+		}
+		page.Link(r.Margin, y-font.Size, r.Width(), tocLineHeight, e.dest)
+	}
+
+	pages := r.Doc.Pages()
+	for _, p := range pages {
+		if p == r.cover {
+			continue
+		}
+		font := Font{Size: 8, Color: Gray}
+		y := PageHeight - 24
+		p.Text(r.Margin, y, font, r.Title)
+		label := fmt.Sprintf("第 %d / %d 页", p.Number, len(pages))
+		p.Text(PageWidth-r.Margin-TextWidth(label, font.Size), y, font, label)
+	}
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 角色从低到高：viewer 只读；operator 还可以修改主机、应用、集群，处理告警和发送报告；
+// admin 还可以管理用户、角色、机房、告警规则和通知配置
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var roleRank = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// errDepartmentDenied 表示请求涉及了没有权限的部门
+var errDepartmentDenied = errors.New("access to department denied")
+
+// Access 为当前用户的权限。Global 为适用于所有部门的角色，Departments 为各部门单独授予的角色，
+// 用户在某个部门的角色取两者中较高的一个
+type Access struct {
+	Global      string
+	Departments map[string]string
+}
+
+// NewAccess 由用户的角色绑定计算权限
+func NewAccess(bindings []models.RoleBinding) *Access {
+	a := &Access{Departments: make(map[string]string)}
+	for _, b := range bindings {
+		if b.DepartmentName == "" {
+			a.Global = higherRole(a.Global, b.Role)
+		} else {
+			a.Departments[b.DepartmentName] = higherRole(a.Departments[b.DepartmentName], b.Role)
+		}
+	}
+	return a
+}
+
+func higherRole(a, b string) string {
+	if roleRank[b] > roleRank[a] {
+		return b
+	}
+	return a
+}
+
+// Role 返回用户在 department 中的角色，没有权限时为空。department 为空表示不属于任何部门的数据，只看全局角色
+func (a *Access) Role(department string) string {
+	if department == "" {
+		return a.Global
+	}
+	return higherRole(a.Global, a.Departments[department])
+}
+
+// Allows 判断用户在 department 中是否至少拥有 role
+func (a *Access) Allows(department, role string) bool {
+	return roleRank[a.Role(department)] >= roleRank[role]
+}
+
+// Unrestricted 判断用户是否在所有部门都至少拥有 role
+func (a *Access) Unrestricted(role string) bool {
+	return roleRank[a.Global] >= roleRank[role]
+}
+
+// HasAny 判断用户是否至少在一个部门拥有 role
+func (a *Access) HasAny(role string) bool {
+	if a.Unrestricted(role) {
+		return true
+	}
+	for _, r := range a.Departments {
+		if roleRank[r] >= roleRank[role] {
+			return true
+		}
+	}
+	return false
+}
+
+// Visible 返回用户可以查看的部门，all 为 true 时不限部门
+func (a *Access) Visible() (departments []string, all bool) {
+	if a.Unrestricted(RoleViewer) {
+		return nil, true
+	}
+	departments = []string{}
+	for d, r := range a.Departments {
+		if roleRank[r] >= roleRank[RoleViewer] {
+			departments = append(departments, d)
+		}
+	}
+	sort.Strings(departments)
+	return departments, false
+}
+
+// DepartmentScope 把查询限定在可见部门，column 为部门名称列
+func (a *Access) DepartmentScope(column string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		departments, all := a.Visible()
+		if all {
+			return db
+		}
+		return db.Where(column+" IN ?", departments)
+	}
+}
+
+// ClusterScope 把查询限定在可见部门的集群，column 为集群名称列
+func (a *Access) ClusterScope(column string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		departments, all := a.Visible()
+		if all {
+			return db
+		}
+		return db.Where(column+` IN (SELECT cluster_name FROM cluster_groups
+			WHERE deleted_at IS NULL AND department_name IN ?)`, departments)
+	}
+}
+
+// HostScope 把 hosts_pool 查询限定在部署了可见部门应用的主机
+func (a *Access) HostScope(db *gorm.DB) *gorm.DB {
+	departments, all := a.Visible()
+	if all {
+		return db
+	}
+	return db.Scopes(hostsInDepartments(departments))
+}
+
+// ApplicationScope 把 hosts_applications 查询限定在可见部门的应用，应用的部门以集群登记的部门为准
+func (a *Access) ApplicationScope(db *gorm.DB) *gorm.DB {
+	departments, all := a.Visible()
+	if all {
+		return db
+	}
+	return db.Where(`COALESCE((SELECT cg.department_name FROM cluster_groups cg
+		WHERE cg.cluster_name = hosts_applications.cluster_name AND cg.deleted_at IS NULL LIMIT 1),
+		hosts_applications.department_name) IN ?`, departments)
+}
+
+// ReportFilter 把报告的部门过滤条件限制在可见部门内：未指定部门时改为全部可见部门，指定了不可见的部门时返回错误
+func (a *Access) ReportFilter(filter ReportFilter) (ReportFilter, error) {
+	departments, all := a.Visible()
+	if all {
+		return filter, nil
+	}
+	if len(filter.Departments) == 0 {
+		if len(departments) == 0 {
+			return filter, errDepartmentDenied
+		}
+		filter.Departments = departments
+		return filter, nil
+	}
+	for _, d := range filter.Departments {
+		if !slices.Contains(departments, d) {
+			return filter, fmt.Errorf("%w: %s", errDepartmentDenied, d)
+		}
+	}
+	return filter, nil
+}
+
+// CurrentAccess 返回权限中间件写入上下文的当前用户权限，没有时返回不含任何角色的权限
+func CurrentAccess(c *gin.Context) *Access {
+	if a, ok := c.Get("access"); ok {
+		return a.(*Access)
+	}
+	return NewAccess(nil)
+}
+
+// requireDepartment 检查当前用户在 department 中是否至少拥有 role，否则返回 403
+func requireDepartment(c *gin.Context, department, role string) bool {
+	if CurrentAccess(c).Allows(department, role) {
+		return true
+	}
+	if department == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s role for all departments is required", role)})
+	} else {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s role in department %q is required", role, department)})
+	}
+	return false
+}
+
+// scopedReportFilter 按当前用户的权限限制报告的过滤条件，越权时返回 403
+func scopedReportFilter(c *gin.Context, filter ReportFilter) (ReportFilter, bool) {
+	filter, err := CurrentAccess(c).ReportFilter(filter)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return filter, false
+	}
+	return filter, true
+}
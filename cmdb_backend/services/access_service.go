@@ -0,0 +1,321 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cmdb/audit"
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AccessService 维护角色绑定，并按角色和部门检查每个请求的权限
+type AccessService struct {
+	DB *gorm.DB
+}
+
+func NewAccessService(db *gorm.DB) *AccessService {
+	return &AccessService{DB: db}
+}
+
+// routePolicy 为访问一个接口需要的角色。Global 为 true 时需要适用于所有部门的角色，
+// 否则在任一部门拥有该角色即可，接口再按数据所属的部门进一步检查
+type routePolicy struct {
+	Role   string
+	Global bool
+}
+
+// routePolicies 列出与默认规则不同的接口。默认规则：GET 需要任一部门的 viewer，其余方法需要所有部门的 operator
+var routePolicies = map[string]routePolicy{
+	// 当前用户自己的信息，登录即可
+	"GET /api/cmdb/v1/auth/me":        {},
+	"POST /api/cmdb/v1/auth/password": {},
+
+	// 按数据所属部门检查的写操作
+	"POST /api/cmdb/v1/hosts/:id/applications":           {Role: RoleOperator},
+	"PUT /api/cmdb/v1/hosts/:id/applications/:app_id":    {Role: RoleOperator},
+	"PATCH /api/cmdb/v1/hosts/:id/applications/:app_id":  {Role: RoleOperator},
+	"DELETE /api/cmdb/v1/hosts/:id/applications/:app_id": {Role: RoleOperator},
+	"POST /api/cmdb/v1/alerts/:id/ack":                   {Role: RoleOperator},
+	"POST /api/cmdb/v1/alerts/:id/unack":                 {Role: RoleOperator},
+	"POST /api/cmdb/v1/alerts/:id/silence":               {Role: RoleOperator},
+	"DELETE /api/cmdb/v1/alerts/:id/silence":             {Role: RoleOperator},
+	"POST /api/cmdb/v1/alerts/:id/comments":              {Role: RoleOperator},
+	"POST /api/cmdb/v1/cluster-groups":                   {Role: RoleOperator},
+	"PATCH /api/cmdb/v1/cluster-groups/:id":              {Role: RoleOperator},
+	"POST /api/cmdb/v1/cluster-groups/:id/merge":         {Role: RoleOperator},
+	"DELETE /api/cmdb/v1/cluster-groups/:id":             {Role: RoleOperator},
+	"POST /api/cmdb/v1/send-report":                      {Role: RoleOperator},
+	"POST /api/cmdb/v1/generate-and-send-report":         {Role: RoleOperator},
+	"POST /api/cmdb/v1/trigger-report":                   {Role: RoleOperator},
+	"POST /api/cmdb/v1/report-subscriptions":             {Role: RoleOperator},
+	"PATCH /api/cmdb/v1/report-subscriptions/:id":        {Role: RoleOperator},
+	"DELETE /api/cmdb/v1/report-subscriptions/:id":       {Role: RoleOperator},
+	"POST /api/cmdb/v1/report-subscriptions/:id/run":     {Role: RoleOperator},
+
+	// 存档参数、邮件和库存历史的比较不按部门区分，只对全局角色开放
+	"GET /api/cmdb/v1/report-archives":              {Role: RoleViewer, Global: true},
+	"GET /api/cmdb/v1/report-archives/compare":      {Role: RoleViewer, Global: true},
+	"GET /api/cmdb/v1/report-archives/:id":          {Role: RoleViewer, Global: true},
+	"GET /api/cmdb/v1/report-archives/:id/download": {Role: RoleViewer, Global: true},
+	"GET /api/cmdb/v1/emails":                       {Role: RoleViewer, Global: true},
+	"GET /api/cmdb/v1/emails/:id":                   {Role: RoleViewer, Global: true},
+	"GET /api/cmdb/v1/inventory/diff":               {Role: RoleViewer, Global: true},
+
+	// 系统配置和用户管理
+	"POST /api/cmdb/v1/collect_applications":           {Role: RoleAdmin, Global: true},
+	"POST /api/cmdb/v1/idcs":                           {Role: RoleAdmin, Global: true},
+	"PATCH /api/cmdb/v1/idcs/:id":                      {Role: RoleAdmin, Global: true},
+	"DELETE /api/cmdb/v1/idcs/:id":                     {Role: RoleAdmin, Global: true},
+	"POST /api/cmdb/v1/alert-rules":                    {Role: RoleAdmin, Global: true},
+	"PATCH /api/cmdb/v1/alert-rules/:id":               {Role: RoleAdmin, Global: true},
+	"DELETE /api/cmdb/v1/alert-rules/:id":              {Role: RoleAdmin, Global: true},
+	"GET /api/cmdb/v1/notification-channels":           {Role: RoleAdmin, Global: true},
+	"POST /api/cmdb/v1/notification-channels":          {Role: RoleAdmin, Global: true},
+	"GET /api/cmdb/v1/notification-channels/:id":       {Role: RoleAdmin, Global: true},
+	"PATCH /api/cmdb/v1/notification-channels/:id":     {Role: RoleAdmin, Global: true},
+	"DELETE /api/cmdb/v1/notification-channels/:id":    {Role: RoleAdmin, Global: true},
+	"POST /api/cmdb/v1/notification-channels/:id/test": {Role: RoleAdmin, Global: true},
+	"GET /api/cmdb/v1/notification-routes":             {Role: RoleAdmin, Global: true},
+	"POST /api/cmdb/v1/notification-routes":            {Role: RoleAdmin, Global: true},
+	"PATCH /api/cmdb/v1/notification-routes/:id":       {Role: RoleAdmin, Global: true},
+	"DELETE /api/cmdb/v1/notification-routes/:id":      {Role: RoleAdmin, Global: true},
+	"GET /api/cmdb/v1/email-profiles":                  {Role: RoleAdmin, Global: true},
+	"POST /api/cmdb/v1/email-profiles/:name/verify":    {Role: RoleAdmin, Global: true},
+	"GET /api/cmdb/v1/users":                           {Role: RoleAdmin, Global: true},
+	"POST /api/cmdb/v1/users":                          {Role: RoleAdmin, Global: true},
+	"GET /api/cmdb/v1/users/:id":                       {Role: RoleAdmin, Global: true},
+	"PATCH /api/cmdb/v1/users/:id":                     {Role: RoleAdmin, Global: true},
+	"DELETE /api/cmdb/v1/users/:id":                    {Role: RoleAdmin, Global: true},
+	"GET /api/cmdb/v1/role-bindings":                   {Role: RoleAdmin, Global: true},
+	"POST /api/cmdb/v1/role-bindings":                  {Role: RoleAdmin, Global: true},
+	"PATCH /api/cmdb/v1/role-bindings/:id":             {Role: RoleAdmin, Global: true},
+	"DELETE /api/cmdb/v1/role-bindings/:id":            {Role: RoleAdmin, Global: true},
+	"GET /api/cmdb/v1/api-tokens":                      {Role: RoleAdmin, Global: true},
+	"POST /api/cmdb/v1/api-tokens":                     {Role: RoleAdmin, Global: true},
+	"GET /api/cmdb/v1/api-tokens/:id":                  {Role: RoleAdmin, Global: true},
+	"DELETE /api/cmdb/v1/api-tokens/:id":               {Role: RoleAdmin, Global: true},
+	"GET /api/cmdb/v1/audit-logs":                      {Role: RoleAdmin, Global: true},
+	"GET /api/cmdb/v1/audit-logs/export":               {Role: RoleAdmin, Global: true},
+}
+
+func policyFor(method, route string) routePolicy {
+	if p, ok := routePolicies[method+" "+route]; ok {
+		return p
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return routePolicy{Role: RoleViewer}
+	}
+	return routePolicy{Role: RoleOperator, Global: true}
+}
+
+// Middleware 加载当前用户的角色并检查接口需要的角色，须放在认证中间件之后。
+// 未登录的请求（登录相关接口）不做检查
+func (s *AccessService) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// API 令牌不关联角色，按令牌的权限范围检查，可以访问所有部门的数据
+		if token := CurrentAPIToken(c); token != nil {
+			scope, ok := routeScopes[c.Request.Method+" "+c.FullPath()]
+			if !ok {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "this endpoint is not available to API tokens"})
+				return
+			}
+			if !tokenHasScope(token, scope) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("API token lacks the %s scope", scope)})
+				return
+			}
+			c.Set("access", &Access{Global: RoleOperator, Departments: map[string]string{}})
+			c.Next()
+			return
+		}
+		user := CurrentUser(c)
+		if user == nil {
+			c.Next()
+			return
+		}
+		var bindings []models.RoleBinding
+		if err := s.DB.Where("user_id = ?", user.ID).Find(&bindings).Error; err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		access := NewAccess(bindings)
+		c.Set("access", access)
+
+		policy := policyFor(c.Request.Method, c.FullPath())
+		allowed := policy.Role == "" ||
+			(policy.Global && access.Unrestricted(policy.Role)) ||
+			(!policy.Global && access.HasAny(policy.Role))
+		if !allowed {
+			scope := "any department"
+			if policy.Global {
+				scope = "all departments"
+			}
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s role for %s is required", policy.Role, scope)})
+			return
+		}
+		c.Next()
+	}
+}
+
+// errLastAdmin 表示操作会移除最后一个全局管理员
+var errLastAdmin = errors.New("cannot remove the last admin for all departments")
+
+// checkNotLastAdmin 在撤销或降级全局管理员绑定前确认还有其他启用的全局管理员
+func checkNotLastAdmin(tx *gorm.DB, binding *models.RoleBinding) error {
+	if binding.Role != RoleAdmin || binding.DepartmentName != "" {
+		return nil
+	}
+	var others int64
+	err := tx.Model(&models.RoleBinding{}).
+		Joins("JOIN users ON users.id = role_bindings.user_id AND users.disabled = ?", false).
+		Where("role_bindings.role = ? AND role_bindings.department_name = '' AND role_bindings.id <> ?", RoleAdmin, binding.ID).
+		Count(&others).Error
+	if err != nil {
+		return err
+	}
+	if others == 0 {
+		return errLastAdmin
+	}
+	return nil
+}
+
+// Grant 授予用户在部门中的角色，已有绑定时修改角色
+func (s *AccessService) Grant(userID uint, role, department, grantedBy string) (*models.RoleBinding, error) {
+	if _, ok := roleRank[role]; !ok {
+		return nil, fmt.Errorf("unknown role %q, expected viewer, operator or admin", role)
+	}
+	binding := &models.RoleBinding{UserID: userID, Role: role, DepartmentName: strings.TrimSpace(department), GrantedBy: grantedBy}
+	err := s.DB.WithContext(audit.WithActor(context.Background(), grantedBy)).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "department_name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role", "granted_by", "updated_at"}),
+	}).Create(binding).Error
+	if err != nil {
+		return nil, err
+	}
+	return binding, s.DB.Where("user_id = ? AND department_name = ?", userID, binding.DepartmentName).First(binding).Error
+}
+
+func (s *AccessService) findBinding(c *gin.Context) (*models.RoleBinding, bool) {
+	var binding models.RoleBinding
+	err := s.DB.First(&binding, c.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role binding not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &binding, true
+}
+
+// ListRoleBindings 返回角色绑定，可按 user_id、role、department_name 过滤
+func (s *AccessService) ListRoleBindings(c *gin.Context) {
+	query := s.DB.Model(&models.RoleBinding{})
+	for key, column := range map[string]string{"user_id": "user_id", "role": "role", "department_name": "department_name"} {
+		if values := QueryList(c, key); len(values) > 0 {
+			query = query.Where(column+" IN ?", values)
+		}
+	}
+	bindings := []models.RoleBinding{}
+	if err := query.Order("user_id, department_name").Find(&bindings).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, bindings)
+}
+
+// CreateRoleBinding 授予用户角色，department_name 为空时适用于所有部门。同一用户在同一部门已有角色时返回 409
+func (s *AccessService) CreateRoleBinding(c *gin.Context) {
+	var req struct {
+		UserID         uint   `json:"user_id" binding:"required"`
+		Role           string `json:"role" binding:"required,oneof=viewer operator admin"`
+		DepartmentName string `json:"department_name" binding:"max=100"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.DepartmentName = strings.TrimSpace(req.DepartmentName)
+
+	var user models.User
+	if err := s.DB.Limit(1).Find(&user, req.UserID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if user.ID == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("user %d does not exist", req.UserID)})
+		return
+	}
+	var existing models.RoleBinding
+	if err := s.DB.Where("user_id = ? AND department_name = ?", req.UserID, req.DepartmentName).Limit(1).Find(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if existing.ID != 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "user already has a role in this department, update it instead", "id": existing.ID})
+		return
+	}
+
+	binding := models.RoleBinding{
+		UserID:         req.UserID,
+		Role:           req.Role,
+		DepartmentName: req.DepartmentName,
+		GrantedBy:      requestActor(c, ""),
+	}
+	if err := s.DB.WithContext(c).Create(&binding).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, binding)
+}
+
+// UpdateRoleBinding 修改绑定的角色
+func (s *AccessService) UpdateRoleBinding(c *gin.Context) {
+	binding, ok := s.findBinding(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Role string `json:"role" binding:"required,oneof=viewer operator admin"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Role != RoleAdmin {
+		if err := checkNotLastAdmin(s.DB, binding); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	binding.Role, binding.GrantedBy = req.Role, requestActor(c, "")
+	if err := s.DB.WithContext(c).Model(binding).Select("role", "granted_by").Updates(binding).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, binding)
+}
+
+// DeleteRoleBinding 撤销角色绑定，不能撤销最后一个全局管理员
+func (s *AccessService) DeleteRoleBinding(c *gin.Context) {
+	binding, ok := s.findBinding(c)
+	if !ok {
+		return
+	}
+	if err := checkNotLastAdmin(s.DB, binding); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.DB.WithContext(c).Delete(binding).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Role binding deleted successfully"})
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// 告警表达式可以使用的指标
+const (
+	MetricMemoryUsage = "memory_usage" // 内存使用率，0-100
+	MetricDiskUsage   = "disk_usage"   // 磁盘使用率，0-100
+	MetricCPULoad     = "cpu_load"     // CPU 负载
+	MetricDaysToFull  = "days_to_full" // 预测磁盘写满的剩余天数
+)
+
+// AlertMetrics 为一个实例在某一时刻的指标值，NaN 表示没有数据（例如无法预测写满时间），
+// 与 NaN 比较的条件一律不成立
+type AlertMetrics struct {
+	MemoryUsage float64 `json:"memory_usage"`
+	DiskUsage   float64 `json:"disk_usage"`
+	CPULoad     float64 `json:"cpu_load"`
+	DaysToFull  float64 `json:"days_to_full"`
+}
+
+// MarshalJSON 把无法计算的指标输出为 null
+func (m AlertMetrics) MarshalJSON() ([]byte, error) {
+	field := func(v float64) string {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "null"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return []byte(fmt.Sprintf(`{"memory_usage":%s,"disk_usage":%s,"cpu_load":%s,"days_to_full":%s}`,
+		field(m.MemoryUsage), field(m.DiskUsage), field(m.CPULoad), field(m.DaysToFull))), nil
+}
+
+func (m AlertMetrics) value(name string) float64 {
+	switch name {
+	case MetricMemoryUsage:
+		return m.MemoryUsage
+	case MetricDiskUsage:
+		return m.DiskUsage
+	case MetricCPULoad:
+		return m.CPULoad
+	case MetricDaysToFull:
+		return m.DaysToFull
+	}
+	return math.NaN()
+}
+
+// AlertExpr 为解析后的告警表达式，例如 "disk_usage > 90 || (disk_usage > 80 && days_to_full < 14)"
+type AlertExpr struct {
+	root    exprNode
+	metrics map[string]bool
+}
+
+// Uses 返回表达式是否引用了指定指标
+func (e *AlertExpr) Uses(metric string) bool {
+	return e.metrics[metric]
+}
+
+// Eval 计算表达式在给定指标下是否成立
+func (e *AlertExpr) Eval(m AlertMetrics) bool {
+	return e.root.eval(m)
+}
+
+type exprNode interface {
+	eval(m AlertMetrics) bool
+}
+
+type compareNode struct {
+	metric string
+	op     string
+	value  float64
+}
+
+func (n compareNode) eval(m AlertMetrics) bool {
+	v := m.value(n.metric)
+	if math.IsNaN(v) {
+		return false
+	}
+	switch n.op {
+	case ">":
+		return v > n.value
+	case ">=":
+		return v >= n.value
+	case "<":
+		return v < n.value
+	case "<=":
+		return v <= n.value
+	case "==":
+		return v == n.value
+	case "!=":
+		return v != n.value
+	}
+	return false
+}
+
+type logicNode struct {
+	and         bool
+	left, right exprNode
+}
+
+func (n logicNode) eval(m AlertMetrics) bool {
+	if n.and {
+		return n.left.eval(m) && n.right.eval(m)
+	}
+	return n.left.eval(m) || n.right.eval(m)
+}
+
+// ParseAlertExpr 解析告警表达式。语法：比较 "指标 运算符 数值"，运算符为 > >= < <= == !=，
+// 比较之间可以用 &&（and）、||（or）和括号组合
+func ParseAlertExpr(s string) (*AlertExpr, error) {
+	tokens, err := tokenizeExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, expr: &AlertExpr{metrics: make(map[string]bool)}}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in expression", p.tokens[p.pos])
+	}
+	p.expr.root = root
+	return p.expr, nil
+}
+
+func tokenizeExpr(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, string(r))
+			i++
+		case strings.ContainsRune("<>=!&|", r):
+			j := i + 1
+			for j < len(s) && strings.ContainsRune("=&|", rune(s[j])) && j-i < 2 {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		case unicode.IsLetter(r) || r == '_' || unicode.IsDigit(r) || r == '.' || r == '-':
+			// 数值可以写成科学计数法，例如 1e-3，指数的符号属于同一个数值
+			number := unicode.IsDigit(r) || r == '.' || r == '-'
+			j := i + 1
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_' || s[j] == '.' ||
+				number && (s[j] == '-' || s[j] == '+') && (s[j-1] == 'e' || s[j-1] == 'E')) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q in expression", r)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens []string
+	pos    int
+	expr   *AlertExpr
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for t := strings.ToLower(p.peek()); t == "||" || t == "or"; t = strings.ToLower(p.peek()) {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for t := strings.ToLower(p.peek()); t == "&&" || t == "and"; t = strings.ToLower(p.peek()) {
+		p.next()
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicNode{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	if p.peek() == "(" {
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return node, nil
+	}
+
+	metric := p.next()
+	switch metric {
+	case MetricMemoryUsage, MetricDiskUsage, MetricCPULoad, MetricDaysToFull:
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	default:
+		return nil, fmt.Errorf("unknown metric %q, expected memory_usage, disk_usage, cpu_load or days_to_full", metric)
+	}
+	op := p.next()
+	switch op {
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return nil, fmt.Errorf("expected comparison operator after %s, got %q", metric, op)
+	}
+	raw := p.next()
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("expected number after %s %s, got %q", metric, op, raw)
+	}
+	p.expr.metrics[metric] = true
+	return compareNode{metric: metric, op: op, value: value}, nil
+}
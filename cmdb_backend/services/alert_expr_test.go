@@ -0,0 +1,90 @@
+package services
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseAlertExpr(t *testing.T) {
+	metrics := AlertMetrics{MemoryUsage: 85, DiskUsage: 70, CPULoad: 0.5, DaysToFull: 10}
+	noForecast := metrics
+	noForecast.DaysToFull = math.NaN()
+
+	cases := []struct {
+		expr    string
+		metrics AlertMetrics
+		want    bool
+		uses    []string
+	}{
+		{"memory_usage > 80", metrics, true, []string{MetricMemoryUsage}},
+		{"memory_usage>=85", metrics, true, nil},
+		{"memory_usage < 85", metrics, false, nil},
+		{"memory_usage <= 85", metrics, true, nil},
+		{"disk_usage == 70", metrics, true, nil},
+		{"disk_usage != 70", metrics, false, nil},
+		{"cpu_load > .25", metrics, true, nil},
+		{"cpu_load > 5e-1", metrics, false, nil},
+		{"cpu_load >= 5E-1", metrics, true, nil},
+		{"cpu_load > -1", metrics, true, nil},
+		{"memory_usage > 80&&disk_usage > 80", metrics, false, []string{MetricMemoryUsage, MetricDiskUsage}},
+		{"memory_usage > 80 || disk_usage > 80", metrics, true, nil},
+		{"memory_usage > 80 AND disk_usage > 60 or cpu_load > 1", metrics, true, nil},
+		// && 的优先级高于 ||
+		{"memory_usage > 90 && disk_usage > 60 || cpu_load < 1", metrics, true, nil},
+		{"memory_usage > 90 && (disk_usage > 60 || cpu_load < 1)", metrics, false, nil},
+		{"((disk_usage > 60))", metrics, true, nil},
+		{"disk_usage > 60 && days_to_full < 14", metrics, true, []string{MetricDiskUsage, MetricDaysToFull}},
+		// 没有预测值时与之比较的条件一律不成立，包括 !=
+		{"days_to_full < 14", noForecast, false, nil},
+		{"days_to_full != 14", noForecast, false, nil},
+		{"days_to_full < 14 || disk_usage > 60", noForecast, true, nil},
+	}
+	for _, tc := range cases {
+		expr, err := ParseAlertExpr(tc.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tc.expr, err)
+			continue
+		}
+		if got := expr.Eval(tc.metrics); got != tc.want {
+			t.Errorf("%q: Eval = %v, want %v", tc.expr, got, tc.want)
+		}
+		for _, metric := range tc.uses {
+			if !expr.Uses(metric) {
+				t.Errorf("%q: Uses(%s) = false", tc.expr, metric)
+			}
+		}
+		if tc.uses != nil && expr.Uses(MetricCPULoad) {
+			t.Errorf("%q: Uses(%s) = true", tc.expr, MetricCPULoad)
+		}
+	}
+}
+
+func TestParseAlertExprErrors(t *testing.T) {
+	cases := []struct{ expr, err string }{
+		{"", "empty expression"},
+		{"   ", "empty expression"},
+		{"load > 1", `unknown metric "load"`},
+		{"memory_usage", "expected comparison operator"},
+		{"memory_usage = 80", "expected comparison operator"},
+		{"memory_usage => 80", "expected comparison operator"},
+		{"memory_usage > ", "expected number"},
+		{"memory_usage > high", "expected number"},
+		{"memory_usage > nan", "expected number"},
+		{"memory_usage > inf", "expected number"},
+		{"memory_usage > 1e999", "expected number"},
+		{"memory_usage > 80 &&", "unexpected end of expression"},
+		{"memory_usage > 80 & disk_usage > 80", `unexpected "&"`},
+		{"memory_usage > 80 disk_usage > 80", `unexpected "disk_usage"`},
+		{"(memory_usage > 80", "missing closing parenthesis"},
+		{"memory_usage > 80)", `unexpected ")"`},
+		{"memory_usage > 80 ; drop", "unexpected character"},
+		{"内存 > 80", "unexpected character"},
+	}
+	for _, tc := range cases {
+		_, err := ParseAlertExpr(tc.expr)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: error %v, want %q", tc.expr, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,578 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 告警状态
+const (
+	AlertFiring       = "firing"
+	AlertAcknowledged = "acknowledged"
+	AlertSilenced     = "silenced"
+	AlertResolved     = "resolved"
+)
+
+// 系统自动执行的状态变化记录的操作人
+const systemActor = "system"
+
+// errAlertStateChanged 表示告警在读取之后已被其他请求或实例修改了状态
+var errAlertStateChanged = errors.New("alert state changed concurrently")
+
+// AlertFingerprint 由规则和实例计算，同一规则在同一实例上的告警指纹相同
+func AlertFingerprint(ruleID uint, poolID uint, ip string, port uint) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%d|%s|%d", ruleID, poolID, ip, port)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// requestActor 返回执行操作的人：优先使用认证中间件写入上下文的用户，其次为请求中提交的 actor
+func requestActor(c *gin.Context, submitted string) string {
+	if actor := c.GetString("actor"); actor != "" {
+		return actor
+	}
+	if submitted != "" {
+		return submitted
+	}
+	return "anonymous"
+}
+
+func finiteOrNil(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
+
+// metricColumns 为每次评估时刷新的列
+func metricColumns(alert *models.Alert) map[string]any {
+	return map[string]any{
+		"rule_name":       alert.RuleName,
+		"severity":        alert.Severity,
+		"expression":      alert.Expression,
+		"cluster_name":    alert.ClusterName,
+		"group_name":      alert.GroupName,
+		"department_name": alert.DepartmentName,
+		"memory_usage":    alert.MemoryUsage,
+		"disk_usage":      alert.DiskUsage,
+		"cpu_load":        alert.CPULoad,
+		"days_to_full":    alert.DaysToFull,
+		"last_seen_at":    alert.LastSeenAt,
+	}
+}
+
+// stateColumns 为状态变化时写入的列
+func stateColumns(alert *models.Alert) map[string]any {
+	return map[string]any{
+		"state":              alert.State,
+		"active_fingerprint": alert.ActiveFingerprint,
+		"resolved_at":        alert.ResolvedAt,
+		"acknowledged_at":    alert.AcknowledgedAt,
+		"acknowledged_by":    alert.AcknowledgedBy,
+		"silenced_until":     alert.SilencedUntil,
+		"silenced_by":        alert.SilencedBy,
+	}
+}
+
+func applyFiring(alert *models.Alert, f FiringAlert) {
+	alert.RuleName = f.RuleName
+	alert.Severity = f.Severity
+	alert.Expression = f.Expression
+	alert.ClusterName = f.ClusterName
+	alert.GroupName = f.GroupName
+	alert.DepartmentName = f.DepartmentName
+	alert.MemoryUsage = finiteOrNil(f.Values.MemoryUsage)
+	alert.DiskUsage = finiteOrNil(f.Values.DiskUsage)
+	alert.CPULoad = finiteOrNil(f.Values.CPULoad)
+	alert.DaysToFull = finiteOrNil(f.Values.DaysToFull)
+}
+
+// Run 按配置的周期评估规则并更新告警状态，直到 stop 被关闭。多个实例中只有持有租约的实例执行
+func (s *AlertService) Run(stop <-chan struct{}) {
+	for {
+		settings := s.store.Current().Alerts
+		leader, err := s.lock.Acquire(time.Now(), settings.LockTTL.Duration)
+		if err != nil {
+			log.Printf("alert evaluation lock: %v", err)
+		} else if leader {
+			if err := s.Sync(time.Now()); err != nil {
+				log.Printf("alert sync failed: %v", err)
+			}
+		}
+		select {
+		case <-stop:
+			if err := s.lock.Release(); err != nil {
+				log.Printf("release alert evaluation lock: %v", err)
+			}
+			return
+		case <-time.After(settings.EvaluationInterval.Duration):
+		}
+	}
+}
+
+// Sync 评估规则并同步持久化的告警：新触发的告警按指纹去重后创建，不再触发的告警标记为恢复，
+// 静默到期的告警恢复为 firing。读取之后被确认、静默的告警不会被覆盖，下一个周期再同步
+func (s *AlertService) Sync(now time.Time) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	firing, err := s.Evaluate(now, 0)
+	if err != nil {
+		return err
+	}
+	var open []models.Alert
+	if err := s.DB.Where("active_fingerprint IS NOT NULL").Find(&open).Error; err != nil {
+		return err
+	}
+	openByFingerprint := make(map[string]*models.Alert, len(open))
+	for i := range open {
+		openByFingerprint[open[i].Fingerprint] = &open[i]
+	}
+
+	seen := make(map[string]bool, len(firing))
+	for _, f := range firing {
+		fp := AlertFingerprint(f.RuleID, f.PoolID, f.IP, f.Port)
+		seen[fp] = true
+		if alert, ok := openByFingerprint[fp]; ok {
+			if err := s.refresh(alert, f, now); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.open(fp, f, now); err != nil {
+			return err
+		}
+	}
+
+	for fp, alert := range openByFingerprint {
+		if seen[fp] {
+			continue
+		}
+		// 静默中的告警恢复时不发送通知
+		silenced := alert.State == AlertSilenced
+		err := s.transition(alert, AlertResolved, "resolved", systemActor, "", func(a *models.Alert) {
+			a.ResolvedAt = &now
+			a.ActiveFingerprint = nil
+		})
+		if errors.Is(err, errAlertStateChanged) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !silenced {
+			s.notify(alert, NotifyResolved)
+		}
+	}
+	return nil
+}
+
+// notify 在后台把告警发送到路由匹配的渠道，避免慢的渠道拖延评估周期
+func (s *AlertService) notify(alert *models.Alert, event string) {
+	if s.Notifications == nil {
+		return
+	}
+	go s.Notifications.NotifyAlert(*alert, event)
+}
+
+// open 创建新的告警；其他副本已经创建了同一指纹的告警时什么也不做
+func (s *AlertService) open(fp string, f FiringAlert, now time.Time) error {
+	alert := models.Alert{
+		Fingerprint:       fp,
+		ActiveFingerprint: &fp,
+		RuleID:            f.RuleID,
+		PoolID:            f.PoolID,
+		IP:                f.IP,
+		Port:              f.Port,
+		State:             AlertFiring,
+		StartsAt:          f.Since,
+		LastSeenAt:        now,
+	}
+	applyFiring(&alert, f)
+	created := false
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&alert)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		created = true
+		return tx.Create(&models.AlertEvent{AlertID: alert.ID, Action: "fired", Actor: systemActor, ToState: AlertFiring}).Error
+	})
+	if err == nil && created {
+		s.notify(&alert, NotifyFiring)
+	}
+	return err
+}
+
+// refresh 更新仍在触发的告警的指标值，静默到期时恢复为 firing。
+// 只写入指标列，并且要求状态未变，避免覆盖同时发生的确认、静默等操作
+func (s *AlertService) refresh(alert *models.Alert, f FiringAlert, now time.Time) error {
+	applyFiring(alert, f)
+	alert.LastSeenAt = now
+	result := s.DB.Model(&models.Alert{}).Where("id = ? AND state = ?", alert.ID, alert.State).Updates(metricColumns(alert))
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+
+	if alert.State == AlertSilenced && alert.SilencedUntil != nil && !now.Before(*alert.SilencedUntil) {
+		err := s.transition(alert, AlertFiring, "silence_expired", systemActor, "", endSilence)
+		if errors.Is(err, errAlertStateChanged) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// 静默到期后仍未确认的告警重新通知
+		if alert.State == AlertFiring {
+			s.notify(alert, NotifyFiring)
+		}
+	}
+	return nil
+}
+
+// transition 修改告警状态并记录事件，mutate 可以再调整目标状态。
+// 只在告警仍处于读取时的状态时写入状态相关的列，否则返回 errAlertStateChanged
+func (s *AlertService) transition(alert *models.Alert, to, action, actor, comment string, mutate func(*models.Alert)) error {
+	from := alert.State
+	alert.State = to
+	if mutate != nil {
+		mutate(alert)
+	}
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Alert{}).Where("id = ? AND state = ?", alert.ID, from).Updates(stateColumns(alert))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errAlertStateChanged
+		}
+		return tx.Create(&models.AlertEvent{
+			AlertID:   alert.ID,
+			Action:    action,
+			Actor:     actor,
+			FromState: from,
+			ToState:   alert.State,
+			Comment:   comment,
+		}).Error
+	})
+}
+
+// endSilence 结束静默，静默前已确认的告警回到 acknowledged
+func endSilence(alert *models.Alert) {
+	alert.SilencedUntil, alert.SilencedBy = nil, ""
+	if alert.AcknowledgedAt != nil {
+		alert.State = AlertAcknowledged
+	}
+}
+
+// findAlert 查找路径中的告警，并检查当前用户在告警所属部门中至少拥有 role。不可见部门的告警按不存在处理
+func (s *AlertService) findAlert(c *gin.Context, role string) (*models.Alert, bool) {
+	var alert models.Alert
+	err := s.DB.Scopes(CurrentAccess(c).DepartmentScope("department_name")).First(&alert, c.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if !requireDepartment(c, alert.DepartmentName, role) {
+		return nil, false
+	}
+	return &alert, true
+}
+
+// alertFilters 按 state、severity、group_name、cluster_name、department_name、rule_id 过滤告警，并限定在当前用户可见的部门
+func alertFilters(c *gin.Context, query *gorm.DB) *gorm.DB {
+	query = query.Scopes(CurrentAccess(c).DepartmentScope("department_name"))
+	for key, column := range map[string]string{
+		"state":           "state",
+		"severity":        "severity",
+		"group_name":      "group_name",
+		"cluster_name":    "cluster_name",
+		"department_name": "department_name",
+		"rule_id":         "rule_id",
+	} {
+		if values := QueryList(c, key); len(values) > 0 {
+			query = query.Where(column+" IN ?", values)
+		}
+	}
+	return query
+}
+
+// ListAlerts 分页返回告警，默认只返回未恢复的告警，传 state=resolved 等参数可查询指定状态
+func (s *AlertService) ListAlerts(c *gin.Context) {
+	page, pageSize, ok := pagination(c)
+	if !ok {
+		return
+	}
+	query := alertFilters(c, s.DB.Model(&models.Alert{}))
+	if len(QueryList(c, "state")) == 0 {
+		query = query.Where("state <> ?", AlertResolved)
+	}
+
+	result := Page[models.Alert]{Items: []models.Alert{}, Page: page, PageSize: pageSize}
+	if err := query.Session(&gorm.Session{}).Count(&result.Total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := query.Order("starts_at desc, id desc").Offset((page - 1) * pageSize).Limit(pageSize).
+		Find(&result.Items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GetAlert 返回告警及其全部事件
+func (s *AlertService) GetAlert(c *gin.Context) {
+	alert, ok := s.findAlert(c, RoleViewer)
+	if !ok {
+		return
+	}
+	var events []models.AlertEvent
+	if err := s.DB.Where("alert_id = ?", alert.ID).Order("id").Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"alert": alert, "events": events})
+}
+
+// EvaluateAlerts 立即评估规则并同步告警状态，不必等待下一个评估周期。
+// 与后台任务使用同一个租约，租约由其他实例持有时返回 409
+func (s *AlertService) EvaluateAlerts(c *gin.Context) {
+	leader, err := s.lock.Acquire(time.Now(), s.store.Current().Alerts.LockTTL.Duration)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !leader {
+		c.JSON(http.StatusConflict, gin.H{"error": "alerts are evaluated by another instance"})
+		return
+	}
+	if err := s.Sync(time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Alerts evaluated successfully"})
+}
+
+type AlertActionInput struct {
+	Actor   string `json:"actor" binding:"max=100"`
+	Comment string `json:"comment" binding:"max=1000"`
+}
+
+type AlertSilenceInput struct {
+	AlertActionInput
+	Until time.Time `json:"until" binding:"required"`
+}
+
+// act 校验告警当前状态后执行状态变化，状态不允许时返回 409
+func (s *AlertService) act(c *gin.Context, in AlertActionInput, allowed []string, to, action string, mutate func(*models.Alert, string)) {
+	alert, ok := s.findAlert(c, RoleOperator)
+	if !ok {
+		return
+	}
+	if !contains(allowed, alert.State) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("alert in state %s cannot be %s", alert.State, action), "state": alert.State})
+		return
+	}
+	actor := requestActor(c, in.Actor)
+	from := alert.State
+	err := s.transition(alert, to, action, actor, in.Comment, func(a *models.Alert) {
+		if mutate != nil {
+			mutate(a, actor)
+		}
+	})
+	if errors.Is(err, errAlertStateChanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("alert is no longer %s, reload and retry", from)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, alert)
+}
+
+func bindActionInput(c *gin.Context) (AlertActionInput, bool) {
+	var in AlertActionInput
+	// 请求体可以为空
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return in, false
+		}
+	}
+	return in, true
+}
+
+func (s *AlertService) AcknowledgeAlert(c *gin.Context) {
+	in, ok := bindActionInput(c)
+	if !ok {
+		return
+	}
+	s.act(c, in, []string{AlertFiring, AlertSilenced}, AlertAcknowledged, "acknowledged", func(a *models.Alert, actor string) {
+		now := time.Now()
+		a.AcknowledgedAt, a.AcknowledgedBy = &now, actor
+		a.SilencedUntil, a.SilencedBy = nil, ""
+	})
+}
+
+func (s *AlertService) UnacknowledgeAlert(c *gin.Context) {
+	in, ok := bindActionInput(c)
+	if !ok {
+		return
+	}
+	s.act(c, in, []string{AlertAcknowledged}, AlertFiring, "unacknowledged", func(a *models.Alert, _ string) {
+		a.AcknowledgedAt, a.AcknowledgedBy = nil, ""
+	})
+}
+
+func (s *AlertService) SilenceAlert(c *gin.Context) {
+	var in AlertSilenceInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !in.Until.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "until must be in the future"})
+		return
+	}
+	s.act(c, in.AlertActionInput, []string{AlertFiring, AlertAcknowledged, AlertSilenced}, AlertSilenced, "silenced", func(a *models.Alert, actor string) {
+		until := in.Until.UTC()
+		a.SilencedUntil, a.SilencedBy = &until, actor
+	})
+}
+
+func (s *AlertService) UnsilenceAlert(c *gin.Context) {
+	in, ok := bindActionInput(c)
+	if !ok {
+		return
+	}
+	s.act(c, in, []string{AlertSilenced}, AlertFiring, "unsilenced", func(a *models.Alert, _ string) {
+		endSilence(a)
+	})
+}
+
+// CommentAlert 为告警添加评论，任何状态（包括已恢复）的告警都可以评论
+func (s *AlertService) CommentAlert(c *gin.Context) {
+	alert, ok := s.findAlert(c, RoleOperator)
+	if !ok {
+		return
+	}
+	var in AlertActionInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if in.Comment == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "comment is required"})
+		return
+	}
+	event := models.AlertEvent{
+		AlertID:   alert.ID,
+		Action:    "commented",
+		Actor:     requestActor(c, in.Actor),
+		FromState: alert.State,
+		ToState:   alert.State,
+		Comment:   in.Comment,
+	}
+	if err := s.DB.Create(&event).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, event)
+}
+
+// AlertHistoryItem 为告警历史中的一条记录，Duration 为告警从开始到恢复（未恢复时到现在）的时长
+type AlertHistoryItem struct {
+	models.Alert
+	DurationSeconds int64    `json:"duration_seconds"`
+	Open            bool     `json:"open"`
+	Actors          []string `json:"actors"`
+}
+
+// GetAlertHistory 分页返回时间范围内开始的告警（包括已恢复的），以及持续时长和处理过的人
+// 参数：from/to 默认最近 7 天，其余过滤条件与告警列表相同
+func (s *AlertService) GetAlertHistory(c *gin.Context) {
+	page, pageSize, ok := pagination(c)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	to, from := now, now.Add(-7*24*time.Hour)
+	for key, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := c.Query(key); v != "" {
+			t, err := parseTimeParam(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + key})
+				return
+			}
+			*target = t
+		}
+	}
+
+	query := alertFilters(c, s.DB.Model(&models.Alert{})).
+		Where("starts_at >= ? AND starts_at < ?", from.UTC(), to.UTC())
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var alerts []models.Alert
+	if err := query.Order("starts_at desc, id desc").Offset((page - 1) * pageSize).Limit(pageSize).
+		Find(&alerts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ids := make([]uint, len(alerts))
+	for i, a := range alerts {
+		ids[i] = a.ID
+	}
+	var events []models.AlertEvent
+	if len(ids) > 0 {
+		if err := s.DB.Where("alert_id IN ? AND actor <> ?", ids, systemActor).Order("id").Find(&events).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	actors := make(map[uint][]string)
+	for _, e := range events {
+		if !contains(actors[e.AlertID], e.Actor) {
+			actors[e.AlertID] = append(actors[e.AlertID], e.Actor)
+		}
+	}
+
+	result := Page[AlertHistoryItem]{Items: []AlertHistoryItem{}, Total: total, Page: page, PageSize: pageSize}
+	for _, a := range alerts {
+		end := now
+		if a.ResolvedAt != nil {
+			end = *a.ResolvedAt
+		}
+		item := AlertHistoryItem{
+			Alert:           a,
+			DurationSeconds: int64(end.Sub(a.StartsAt).Seconds()),
+			Open:            a.ResolvedAt == nil,
+			Actors:          actors[a.ID],
+		}
+		if item.Actors == nil {
+			item.Actors = []string{}
+		}
+		result.Items = append(result.Items, item)
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,489 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"cmdb/config"
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 告警级别
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// 告警规则的作用范围
+const (
+	ScopeAll        = "all"
+	ScopeGroup      = "group"
+	ScopeCluster    = "cluster"
+	ScopeDepartment = "department"
+	ScopeServerType = "server_type"
+	ScopeHost       = "host"
+)
+
+// 持续时长判断依赖原始采样，不能超过原始采样的常见保留时长
+const maxRuleFor = 24 * time.Hour
+
+var severityRank = map[string]int{SeverityInfo: 1, SeverityWarning: 2, SeverityCritical: 3}
+
+const alertEvaluationLock = "alert_evaluation"
+
+type AlertService struct {
+	DB            *gorm.DB
+	Forecast      *ForecastService
+	Notifications *NotificationService
+	store         *config.Store
+	lock          *LeaderLock
+	// syncMu 保证同一实例中后台任务和 /alerts/evaluate 不会同时同步告警
+	syncMu sync.Mutex
+}
+
+func NewAlertService(db *gorm.DB, forecast *ForecastService, notifications *NotificationService, store *config.Store) *AlertService {
+	return &AlertService{DB: db, Forecast: forecast, Notifications: notifications, store: store, lock: NewLeaderLock(db, alertEvaluationLock)}
+}
+
+// FiringAlert 为某条规则在某个实例上触发的告警，Since 为条件开始持续成立的时间
+type FiringAlert struct {
+	RuleID         uint         `json:"rule_id"`
+	RuleName       string       `json:"rule_name"`
+	Severity       string       `json:"severity"`
+	Expression     string       `json:"expression"`
+	For            string       `json:"for"`
+	PoolID         uint         `json:"pool_id"`
+	IP             string       `json:"ip"`
+	Port           uint         `json:"port"`
+	ClusterName    string       `json:"cluster_name"`
+	GroupName      string       `json:"group_name"`
+	DepartmentName string       `json:"department_name"`
+	Values         AlertMetrics `json:"values"`
+	Since          time.Time    `json:"since"`
+}
+
+// alertInstance 为参与评估的实例及其当前指标
+type alertInstance struct {
+	models.ServerResource
+	DepartmentName string
+	ServerTypes    map[string]bool
+	Metrics        AlertMetrics
+}
+
+type compiledRule struct {
+	models.AlertRule
+	expr *AlertExpr
+	dur  time.Duration
+}
+
+func (r compiledRule) matches(inst *alertInstance) bool {
+	switch r.ScopeType {
+	case ScopeGroup:
+		return inst.GroupName == r.ScopeValue
+	case ScopeCluster:
+		return inst.ClusterName == r.ScopeValue
+	case ScopeDepartment:
+		return inst.DepartmentName == r.ScopeValue
+	case ScopeServerType:
+		return inst.ServerTypes[r.ScopeValue]
+	case ScopeHost:
+		return strconv.FormatUint(uint64(inst.PoolID), 10) == r.ScopeValue
+	}
+	return true
+}
+
+func resourceMetrics(r models.ServerResource) AlertMetrics {
+	return AlertMetrics{
+		MemoryUsage: percent(r.UsedMemory, r.TotalMemory),
+		DiskUsage:   percent(r.UsedDisk, r.TotalDisk),
+		CPULoad:     r.CPULoad,
+		DaysToFull:  math.NaN(),
+	}
+}
+
+func sampleMetrics(s models.MetricSample, daysToFull float64) AlertMetrics {
+	return AlertMetrics{
+		MemoryUsage: percent(s.UsedMemory, s.TotalMemory),
+		DiskUsage:   percent(s.UsedDisk, s.TotalDisk),
+		CPULoad:     s.CPULoad,
+		DaysToFull:  daysToFull,
+	}
+}
+
+// compileRules 解析已启用的规则，表达式无效的规则记录日志后跳过
+func (s *AlertService) compileRules(ruleID uint) ([]compiledRule, error) {
+	query := s.DB.Where("enabled = ?", true)
+	if ruleID != 0 {
+		query = query.Where("id = ?", ruleID)
+	}
+	var rules []models.AlertRule
+	if err := query.Order("id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		expr, err := ParseAlertExpr(rule.Expression)
+		if err != nil {
+			log.Printf("skipping alert rule %d: %v", rule.ID, err)
+			continue
+		}
+		dur, err := parseRuleFor(rule.For)
+		if err != nil {
+			log.Printf("skipping alert rule %d: %v", rule.ID, err)
+			continue
+		}
+		compiled = append(compiled, compiledRule{AlertRule: rule, expr: expr, dur: dur})
+	}
+	return compiled, nil
+}
+
+func parseRuleFor(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid for %q: %w", value, err)
+	}
+	if d < 0 || d > maxRuleFor {
+		return 0, fmt.Errorf("for must be between 0 and %s", maxRuleFor)
+	}
+	return d, nil
+}
+
+// loadInstances 读取未删除主机上每个实例的最新资源记录，并补充部门和主机上的应用类型
+func (s *AlertService) loadInstances() ([]*alertInstance, error) {
+	var resources []models.ServerResource
+	if err := s.DB.Joins("JOIN hosts_pool ON hosts_pool.id = server_resources.pool_id").Scopes(NotDeletedHosts).
+		Order("server_resources.date_time desc, server_resources.id desc").Find(&resources).Error; err != nil {
+		return nil, err
+	}
+	departments, err := departmentsByCluster(s.DB)
+	if err != nil {
+		return nil, err
+	}
+	var apps []models.HostApplication
+	if err := s.DB.Select("pool_id", "server_type").Find(&apps).Error; err != nil {
+		return nil, err
+	}
+	serverTypes := make(map[uint]map[string]bool)
+	for _, app := range apps {
+		if serverTypes[app.PoolID] == nil {
+			serverTypes[app.PoolID] = make(map[string]bool)
+		}
+		serverTypes[app.PoolID][app.ServerType] = true
+	}
+
+	seen := make(map[instanceKey]bool)
+	var instances []*alertInstance
+	for _, r := range resources {
+		key := instanceKey{r.PoolID, r.IP, r.Port}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		instances = append(instances, &alertInstance{
+			ServerResource: r,
+			DepartmentName: departments[r.ClusterName],
+			ServerTypes:    serverTypes[r.PoolID],
+			Metrics:        resourceMetrics(r),
+		})
+	}
+	return instances, nil
+}
+
+// Evaluate 在服务端评估全部已启用的规则（ruleID 不为 0 时只评估这一条），返回当前触发的告警。
+// 设置了 For 的规则要求最近的原始采样在这段时间内持续满足条件；days_to_full 取当前的线性预测值。
+func (s *AlertService) Evaluate(now time.Time, ruleID uint) ([]FiringAlert, error) {
+	rules, err := s.compileRules(ruleID)
+	if err != nil {
+		return nil, err
+	}
+	instances, err := s.loadInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	var needForecast bool
+	var longest time.Duration
+	for _, r := range rules {
+		needForecast = needForecast || r.expr.Uses(MetricDaysToFull)
+		longest = max(longest, r.dur)
+	}
+	daysToFull := make(map[instanceKey]float64)
+	if needForecast {
+		forecasts, err := s.Forecast.ForecastInstances(ModelLinear, defaultForecastDays, now, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range forecasts {
+			if f.Forecast.DaysToFull != nil {
+				daysToFull[instanceKey{f.PoolID, f.IP, f.Port}] = *f.Forecast.DaysToFull
+			}
+		}
+	}
+	for _, inst := range instances {
+		if d, ok := daysToFull[instanceKey{inst.PoolID, inst.IP, inst.Port}]; ok {
+			inst.Metrics.DaysToFull = d
+		}
+	}
+
+	// 多取一个小时的采样，用来判断条件是从窗口之前就已成立
+	history := make(map[instanceKey][]models.MetricSample)
+	if longest > 0 {
+		var samples []models.MetricSample
+		if err := s.DB.Where("sampled_at >= ?", now.Add(-longest-time.Hour).UTC()).
+			Order("sampled_at, id").Find(&samples).Error; err != nil {
+			return nil, err
+		}
+		for _, sample := range samples {
+			key := instanceKey{sample.PoolID, sample.IP, sample.Port}
+			history[key] = append(history[key], sample)
+		}
+	}
+
+	var alerts []FiringAlert
+	for _, rule := range rules {
+		for _, inst := range instances {
+			if !rule.matches(inst) || !rule.expr.Eval(inst.Metrics) {
+				continue
+			}
+			since := inst.DateTime
+			if rule.dur > 0 {
+				var ok bool
+				since, ok = holdingSince(rule.expr, history[instanceKey{inst.PoolID, inst.IP, inst.Port}], inst.Metrics.DaysToFull)
+				if !ok || now.Sub(since) < rule.dur {
+					continue
+				}
+			}
+			alerts = append(alerts, FiringAlert{
+				RuleID:         rule.ID,
+				RuleName:       rule.Name,
+				Severity:       rule.Severity,
+				Expression:     rule.Expression,
+				For:            rule.For,
+				PoolID:         inst.PoolID,
+				IP:             inst.IP,
+				Port:           inst.Port,
+				ClusterName:    inst.ClusterName,
+				GroupName:      inst.GroupName,
+				DepartmentName: inst.DepartmentName,
+				Values:         inst.Metrics,
+				Since:          since,
+			})
+		}
+	}
+
+	sort.SliceStable(alerts, func(i, j int) bool {
+		if severityRank[alerts[i].Severity] != severityRank[alerts[j].Severity] {
+			return severityRank[alerts[i].Severity] > severityRank[alerts[j].Severity]
+		}
+		return alerts[i].Since.Before(alerts[j].Since)
+	})
+	return alerts, nil
+}
+
+// holdingSince 从最新的采样往前找，返回条件连续成立的最早采样时间；最新采样不满足条件时返回 false
+func holdingSince(expr *AlertExpr, samples []models.MetricSample, daysToFull float64) (time.Time, bool) {
+	var since time.Time
+	for i := len(samples) - 1; i >= 0; i-- {
+		if !expr.Eval(sampleMetrics(samples[i], daysToFull)) {
+			break
+		}
+		since = samples[i].SampledAt
+	}
+	return since, !since.IsZero()
+}
+
+// GetResourceAlerts 返回按规则评估出的当前告警
+// 参数：severity、group_name、cluster_name、department_name 均可多选，rule_id 只评估一条规则
+func (s *AlertService) GetResourceAlerts(c *gin.Context) {
+	var ruleID uint64
+	if v := c.Query("rule_id"); v != "" {
+		var err error
+		if ruleID, err = strconv.ParseUint(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule_id"})
+			return
+		}
+	}
+	alerts, err := s.Evaluate(time.Now(), uint(ruleID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	filters := map[string]func(FiringAlert) string{
+		"severity":        func(a FiringAlert) string { return a.Severity },
+		"group_name":      func(a FiringAlert) string { return a.GroupName },
+		"cluster_name":    func(a FiringAlert) string { return a.ClusterName },
+		"department_name": func(a FiringAlert) string { return a.DepartmentName },
+	}
+	access := CurrentAccess(c)
+	result := make([]FiringAlert, 0, len(alerts))
+	for _, a := range alerts {
+		keep := true
+		for key, field := range filters {
+			if values := QueryList(c, key); len(values) > 0 && !contains(values, field(a)) {
+				keep = false
+				break
+			}
+		}
+		if keep && access.Allows(a.DepartmentName, RoleViewer) {
+			result = append(result, a)
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+type AlertRuleInput struct {
+	Name        *string `json:"name" binding:"omitempty,min=1,max=100"`
+	Expression  *string `json:"expression" binding:"omitempty,min=1,max=500"`
+	Severity    *string `json:"severity" binding:"omitempty,oneof=info warning critical"`
+	For         *string `json:"for" binding:"omitempty,max=32"`
+	ScopeType   *string `json:"scope_type" binding:"omitempty,oneof=all group cluster department server_type host"`
+	ScopeValue  *string `json:"scope_value" binding:"omitempty,max=100"`
+	Enabled     *bool   `json:"enabled"`
+	Description *string `json:"description" binding:"omitempty,max=255"`
+}
+
+// applyTo 写入字段并校验表达式、持续时长和作用范围
+func (in *AlertRuleInput) applyTo(rule *models.AlertRule) error {
+	setIf(&rule.Name, in.Name)
+	setIf(&rule.Expression, in.Expression)
+	setIf(&rule.Severity, in.Severity)
+	setIf(&rule.For, in.For)
+	setIf(&rule.ScopeType, in.ScopeType)
+	setIf(&rule.ScopeValue, in.ScopeValue)
+	if in.Enabled != nil {
+		rule.Enabled = in.Enabled
+	}
+	setIf(&rule.Description, in.Description)
+
+	if _, err := ParseAlertExpr(rule.Expression); err != nil {
+		return err
+	}
+	if _, err := parseRuleFor(rule.For); err != nil {
+		return err
+	}
+	if rule.ScopeType == "" {
+		rule.ScopeType = ScopeAll
+	}
+	switch {
+	case rule.ScopeType == ScopeAll:
+		rule.ScopeValue = ""
+	case rule.ScopeValue == "":
+		return fmt.Errorf("scope_value is required for scope_type %s", rule.ScopeType)
+	case rule.ScopeType == ScopeHost:
+		if _, err := strconv.ParseUint(rule.ScopeValue, 10, 64); err != nil {
+			return fmt.Errorf("scope_value must be a host id for scope_type host")
+		}
+	}
+	return nil
+}
+
+func (s *AlertService) findRule(c *gin.Context) (*models.AlertRule, bool) {
+	var rule models.AlertRule
+	err := s.DB.First(&rule, c.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert rule not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &rule, true
+}
+
+func (s *AlertService) ListAlertRules(c *gin.Context) {
+	var rules []models.AlertRule
+	if err := s.DB.Order("id").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+func (s *AlertService) GetAlertRule(c *gin.Context) {
+	if rule, ok := s.findRule(c); ok {
+		c.JSON(http.StatusOK, rule)
+	}
+}
+
+func (s *AlertService) CreateAlertRule(c *gin.Context) {
+	var in AlertRuleInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if in.Name == nil || in.Expression == nil || in.Severity == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name, expression and severity are required"})
+		return
+	}
+
+	// 未指定 enabled 时由 GORM 填入默认值 true
+	var rule models.AlertRule
+	if err := in.applyTo(&rule); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.DB.WithContext(c).Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+func (s *AlertService) UpdateAlertRule(c *gin.Context) {
+	rule, ok := s.findRule(c)
+	if !ok {
+		return
+	}
+	var in AlertRuleInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := in.applyTo(rule); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.DB.WithContext(c).Save(rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+func (s *AlertService) DeleteAlertRule(c *gin.Context) {
+	rule, ok := s.findRule(c)
+	if !ok {
+		return
+	}
+	if err := s.DB.WithContext(c).Delete(rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Alert rule deleted successfully"})
+}
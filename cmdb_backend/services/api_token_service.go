@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"cmdb/audit"
+	"cmdb/auth"
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// API 令牌的权限范围
+const (
+	ScopeMetricsWrite   = "metrics:write"
+	ScopeInventoryRead  = "inventory:read"
+	ScopeInventoryWrite = "inventory:write"
+	ScopeAlertsRead     = "alerts:read"
+	ScopeAlertsWrite    = "alerts:write"
+	ScopeReportsRead    = "reports:read"
+	ScopeReportsSend    = "reports:send"
+)
+
+var knownScopes = []string{
+	ScopeMetricsWrite, ScopeInventoryRead, ScopeInventoryWrite,
+	ScopeAlertsRead, ScopeAlertsWrite, ScopeReportsRead, ScopeReportsSend,
+}
+
+// routeScopes 列出 API 令牌可以调用的接口及需要的权限范围，未列出的接口（用户、角色、系统配置等）只能由登录用户调用
+var routeScopes = map[string]string{
+	"POST /api/cmdb/v1/insert-server-resource": ScopeMetricsWrite,
+
+	"GET /api/cmdb/v1/get_hosts_pool_detail":             ScopeInventoryRead,
+	"GET /api/cmdb/v1/host-filter-options":               ScopeInventoryRead,
+	"GET /api/cmdb/v1/get_host_detail/:id":               ScopeInventoryRead,
+	"GET /api/cmdb/v1/inventory/diff":                    ScopeInventoryRead,
+	"GET /api/cmdb/v1/hosts/:id/applications":            ScopeInventoryRead,
+	"GET /api/cmdb/v1/get_application_detail/:id":        ScopeInventoryRead,
+	"GET /api/cmdb/v1/cluster-groups":                    ScopeInventoryRead,
+	"GET /api/cmdb/v1/cluster-groups/:id":                ScopeInventoryRead,
+	"GET /api/cmdb/v1/idcs":                              ScopeInventoryRead,
+	"GET /api/cmdb/v1/idcs/resolve":                      ScopeInventoryRead,
+	"GET /api/cmdb/v1/server-resources":                  ScopeInventoryRead,
+	"GET /api/cmdb/v1/get_cluster_usage":                 ScopeInventoryRead,
+	"GET /api/cmdb/v1/cluster-resource-usage":            ScopeInventoryRead,
+	"GET /api/cmdb/v1/metrics/series":                    ScopeInventoryRead,
+	"GET /api/cmdb/v1/disk-full-prediction":              ScopeInventoryRead,
+	"POST /api/cmdb/v1/hosts":                            ScopeInventoryWrite,
+	"PUT /api/cmdb/v1/hosts/:id":                         ScopeInventoryWrite,
+	"PATCH /api/cmdb/v1/hosts/:id":                       ScopeInventoryWrite,
+	"DELETE /api/cmdb/v1/hosts/:id":                      ScopeInventoryWrite,
+	"POST /api/cmdb/v1/hosts/:id/applications":           ScopeInventoryWrite,
+	"PUT /api/cmdb/v1/hosts/:id/applications/:app_id":    ScopeInventoryWrite,
+	"PATCH /api/cmdb/v1/hosts/:id/applications/:app_id":  ScopeInventoryWrite,
+	"DELETE /api/cmdb/v1/hosts/:id/applications/:app_id": ScopeInventoryWrite,
+	"POST /api/cmdb/v1/cluster-groups":                   ScopeInventoryWrite,
+	"PATCH /api/cmdb/v1/cluster-groups/:id":              ScopeInventoryWrite,
+	"POST /api/cmdb/v1/cluster-groups/:id/merge":         ScopeInventoryWrite,
+	"DELETE /api/cmdb/v1/cluster-groups/:id":             ScopeInventoryWrite,
+
+	"GET /api/cmdb/v1/alerts":                ScopeAlertsRead,
+	"GET /api/cmdb/v1/alerts/:id":            ScopeAlertsRead,
+	"GET /api/cmdb/v1/alerts/history":        ScopeAlertsRead,
+	"GET /api/cmdb/v1/resource-alerts":       ScopeAlertsRead,
+	"GET /api/cmdb/v1/alert-rules":           ScopeAlertsRead,
+	"GET /api/cmdb/v1/alert-rules/:id":       ScopeAlertsRead,
+	"POST /api/cmdb/v1/alerts/evaluate":      ScopeAlertsWrite,
+	"POST /api/cmdb/v1/alerts/:id/ack":       ScopeAlertsWrite,
+	"POST /api/cmdb/v1/alerts/:id/unack":     ScopeAlertsWrite,
+	"POST /api/cmdb/v1/alerts/:id/silence":   ScopeAlertsWrite,
+	"DELETE /api/cmdb/v1/alerts/:id/silence": ScopeAlertsWrite,
+	"POST /api/cmdb/v1/alerts/:id/comments":  ScopeAlertsWrite,
+
+	"GET /api/cmdb/v1/cluster-group-report":          ScopeReportsRead,
+	"GET /api/cmdb/v1/idc-report":                    ScopeReportsRead,
+	"GET /api/cmdb/v1/alert-report":                  ScopeReportsRead,
+	"GET /api/cmdb/v1/usage-report":                  ScopeReportsRead,
+	"GET /api/cmdb/v1/report-archives":               ScopeReportsRead,
+	"GET /api/cmdb/v1/report-archives/compare":       ScopeReportsRead,
+	"GET /api/cmdb/v1/report-archives/:id":           ScopeReportsRead,
+	"GET /api/cmdb/v1/report-archives/:id/download":  ScopeReportsRead,
+	"POST /api/cmdb/v1/send-report":                  ScopeReportsSend,
+	"POST /api/cmdb/v1/generate-and-send-report":     ScopeReportsSend,
+	"POST /api/cmdb/v1/trigger-report":               ScopeReportsSend,
+	"POST /api/cmdb/v1/report-subscriptions/:id/run": ScopeReportsSend,
+}
+
+// apiTokenPrefix 为 API 令牌的固定前缀，中间件据此区分 API 令牌和登录得到的 JWT
+const apiTokenPrefix = "cmdb_"
+
+// lastUsedInterval 内重复使用令牌不再更新 last_used_at，避免采集器每次上报都写一次数据库
+const lastUsedInterval = time.Minute
+
+var errTokenNameTaken = errors.New("an active token with this name already exists")
+
+// parseScopes 拆分并校验逗号分隔的权限范围
+func parseScopes(value string) ([]string, error) {
+	scopes := splitList(value)
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(knownScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q, expected one of %s", scope, strings.Join(knownScopes, ", "))
+		}
+	}
+	return scopes, nil
+}
+
+// tokenHasScope 判断令牌是否拥有 scope
+func tokenHasScope(token *models.APIToken, scope string) bool {
+	return slices.Contains(splitList(token.Scopes), scope)
+}
+
+// IssueAPIToken 创建 API 令牌，返回的明文令牌只在这里出现一次。expiresAt 为空时永不过期
+func (s *AuthService) IssueAPIToken(name, scopes string, expiresAt *time.Time, createdBy string) (*models.APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", errors.New("name is required")
+	}
+	list, err := parseScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", errors.New("expires_at must be in the future")
+	}
+	var taken int64
+	if err := s.DB.Model(&models.APIToken{}).Where("name = ? AND revoked_at IS NULL", name).Count(&taken).Error; err != nil {
+		return nil, "", err
+	}
+	if taken > 0 {
+		return nil, "", errTokenNameTaken
+	}
+
+	secret := apiTokenPrefix + auth.RandomToken(24)
+	token := &models.APIToken{
+		Name:      name,
+		Prefix:    secret[:len(apiTokenPrefix)+8],
+		TokenHash: auth.HashToken(secret),
+		Scopes:    strings.Join(list, ","),
+		ExpiresAt: expiresAt,
+		CreatedBy: createdBy,
+	}
+	if err := s.DB.WithContext(audit.WithActor(context.Background(), createdBy)).Create(token).Error; err != nil {
+		return nil, "", err
+	}
+	return token, secret, nil
+}
+
+// authenticateAPIToken 校验 API 令牌，把令牌写入上下文（键 api_token），请求者记为 token:<名称>
+func (s *AuthService) authenticateAPIToken(c *gin.Context, secret string) {
+	var token models.APIToken
+	if err := s.DB.Where("token_hash = ?", auth.HashToken(secret)).Limit(1).Find(&token).Error; err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	switch {
+	case token.ID == 0:
+		unauthorized(c, "invalid API token")
+		return
+	case token.RevokedAt != nil:
+		unauthorized(c, "API token has been revoked")
+		return
+	case token.ExpiresAt != nil && !token.ExpiresAt.After(now):
+		unauthorized(c, "API token has expired")
+		return
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= lastUsedInterval || token.LastUsedIP != c.ClientIP() {
+		token.LastUsedAt, token.LastUsedIP = &now, c.ClientIP()
+		err := s.DB.Model(&token).UpdateColumns(map[string]any{"last_used_at": now, "last_used_ip": token.LastUsedIP}).Error
+		if err != nil {
+			log.Printf("Failed to record use of API token %d: %v", token.ID, err)
+		}
+	}
+	c.Set("api_token", &token)
+	c.Set("actor", "token:"+token.Name)
+	c.Next()
+}
+
+// CurrentAPIToken 返回中间件写入的 API 令牌，登录用户的请求返回 nil
+func CurrentAPIToken(c *gin.Context) *models.APIToken {
+	if v, ok := c.Get("api_token"); ok {
+		if token, ok := v.(*models.APIToken); ok {
+			return token
+		}
+	}
+	return nil
+}
+
+func (s *AuthService) findAPIToken(c *gin.Context) (*models.APIToken, bool) {
+	var token models.APIToken
+	err := s.DB.First(&token, c.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API token not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &token, true
+}
+
+// ListAPITokens 返回 API 令牌，默认不包括已吊销的，include_revoked=true 时全部返回
+func (s *AuthService) ListAPITokens(c *gin.Context) {
+	query := s.DB.Model(&models.APIToken{})
+	if c.Query("include_revoked") != "true" {
+		query = query.Where("revoked_at IS NULL")
+	}
+	tokens := []models.APIToken{}
+	if err := query.Order("name, id").Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (s *AuthService) GetAPIToken(c *gin.Context) {
+	if token, ok := s.findAPIToken(c); ok {
+		c.JSON(http.StatusOK, token)
+	}
+}
+
+// CreateAPIToken 创建 API 令牌。请求体：name，scopes 为逗号分隔的权限范围，expires_at 为空时永不过期。
+// 响应中的 token 为明文令牌，之后无法再次查看
+func (s *AuthService) CreateAPIToken(c *gin.Context) {
+	var req struct {
+		Name      string     `json:"name" binding:"required,max=100"`
+		Scopes    string     `json:"scopes" binding:"required,max=255"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	token, secret, err := s.IssueAPIToken(req.Name, req.Scopes, req.ExpiresAt, requestActor(c, ""))
+	if errors.Is(err, errTokenNameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"token": secret, "api_token": token})
+}
+
+// RevokeAPIToken 吊销 API 令牌，立即生效。记录保留，已吊销时返回 409
+func (s *AuthService) RevokeAPIToken(c *gin.Context) {
+	token, ok := s.findAPIToken(c)
+	if !ok {
+		return
+	}
+	if token.RevokedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "API token is already revoked"})
+		return
+	}
+	now := time.Now()
+	token.RevokedAt, token.RevokedBy = &now, requestActor(c, "")
+	if err := s.DB.WithContext(c).Model(token).Select("revoked_at", "revoked_by").Updates(token).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, token)
+}
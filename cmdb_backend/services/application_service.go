@@ -0,0 +1,325 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ApplicationService struct {
+	DB *gorm.DB
+}
+
+func NewApplicationService(db *gorm.DB) *ApplicationService {
+	return &ApplicationService{DB: db}
+}
+
+// ApplicationInput 是创建和修改应用时接受的字段，PATCH 时未出现的字段保持不变
+type ApplicationInput struct {
+	ServerType     *string `json:"server_type" binding:"omitempty,max=30"`
+	ServerVersion  *string `json:"server_version" binding:"omitempty,max=30"`
+	ServerSubtitle *string `json:"server_subtitle" binding:"omitempty,max=30"`
+	ClusterName    *string `json:"cluster_name" binding:"omitempty,max=64"`
+	ServerProtocol *string `json:"server_protocol" binding:"omitempty,min=1,max=64"`
+	ServerAddr     *string `json:"server_addr" binding:"omitempty,min=1,max=100"`
+	ServerPort     *int    `json:"server_port" binding:"omitempty,min=0,max=65535"`
+	ServerRole     *string `json:"server_role" binding:"omitempty,max=100"`
+	ServerStatus   *string `json:"server_status" binding:"omitempty,max=100"`
+	DepartmentName *string `json:"department_name" binding:"omitempty,max=100"`
+}
+
+func (in *ApplicationInput) applyTo(app *models.HostApplication) {
+	setIf(&app.ServerType, in.ServerType)
+	setIf(&app.ServerVersion, in.ServerVersion)
+	setIf(&app.ServerSubtitle, in.ServerSubtitle)
+	setIf(&app.ClusterName, in.ClusterName)
+	setIf(&app.ServerProtocol, in.ServerProtocol)
+	setIf(&app.ServerAddr, in.ServerAddr)
+	setIf(&app.ServerPort, in.ServerPort)
+	setIf(&app.ServerRole, in.ServerRole)
+	setIf(&app.ServerStatus, in.ServerStatus)
+	setIf(&app.DepartmentName, in.DepartmentName)
+}
+
+// ApplicationDetail 是应用详情接口的返回结构
+type ApplicationDetail struct {
+	models.HostApplication
+	Host *models.HostPool `json:"host"`
+}
+
+func bindApplicationInput(c *gin.Context, full bool) (*ApplicationInput, bool) {
+	var in ApplicationInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if full && (in.ServerAddr == nil || in.ServerProtocol == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "server_addr and server_protocol are required"})
+		return nil, false
+	}
+	return &in, true
+}
+
+// findHost 查找路径中 :id 对应的、当前用户可见的未删除主机
+func (s *ApplicationService) findHost(c *gin.Context) (*models.HostPool, bool) {
+	var host models.HostPool
+	err := s.DB.Scopes(NotDeletedHosts, CurrentAccess(c).HostScope).First(&host, c.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Host not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &host, true
+}
+
+// findApplication 查找主机上当前用户可见的应用，并检查当前用户在应用所属部门中至少拥有 role
+func (s *ApplicationService) findApplication(c *gin.Context, host *models.HostPool, role string) (*models.HostApplication, bool) {
+	var app models.HostApplication
+	err := s.DB.Where("pool_id = ?", host.ID).Scopes(CurrentAccess(c).ApplicationScope).First(&app, c.Param("app_id")).Error
+	if err == nil {
+		err = s.syncDepartment(&app)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Application not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if !requireDepartment(c, app.DepartmentName, role) {
+		return nil, false
+	}
+	return &app, true
+}
+
+// syncDepartment 按集群登记的部门修正应用的 department_name
+func (s *ApplicationService) syncDepartment(app *models.HostApplication) error {
+	var group models.ClusterGroup
+	if err := s.DB.Where("cluster_name = ?", app.ClusterName).Limit(1).Find(&group).Error; err != nil {
+		return err
+	}
+	if group.ID != 0 {
+		app.DepartmentName = group.DepartmentName
+	}
+	return nil
+}
+
+// findDuplicate 按 (pool_id, server_addr, server_protocol) 查找其他应用，包括已软删除的
+func (s *ApplicationService) findDuplicate(app *models.HostApplication) (*models.HostApplication, error) {
+	var existing models.HostApplication
+	err := s.DB.Unscoped().
+		Where("pool_id = ? AND server_addr = ? AND server_protocol = ? AND id <> ?", app.PoolID, app.ServerAddr, app.ServerProtocol, app.ID).
+		First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// attachCluster 校验应用引用的集群，并同步所属部门。写入后的部门同样要求当前用户拥有 operator 角色
+func (s *ApplicationService) attachCluster(c *gin.Context, app *models.HostApplication) bool {
+	group, ok := resolveCluster(c, s.DB, app.ClusterName)
+	if !ok {
+		return false
+	}
+	if group != nil {
+		app.DepartmentName = group.DepartmentName
+	}
+	return requireDepartment(c, app.DepartmentName, RoleOperator)
+}
+
+// respondSaveError 返回写入应用失败的错误。查重之后其他请求抢先写入了相同的应用时，唯一索引会拒绝写入，同样返回 409
+func (s *ApplicationService) respondSaveError(c *gin.Context, app *models.HostApplication, err error) {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		if existing, findErr := s.findDuplicate(app); findErr == nil && existing != nil {
+			respondDuplicateApplication(c, existing)
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "application with the same pool_id, server_addr and server_protocol already exists"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+func respondDuplicateApplication(c *gin.Context, existing *models.HostApplication) {
+	c.JSON(http.StatusConflict, gin.H{
+		"error": "application with the same pool_id, server_addr and server_protocol already exists",
+		"id":    existing.ID,
+	})
+}
+
+func (s *ApplicationService) ListApplications(c *gin.Context) {
+	host, ok := s.findHost(c)
+	if !ok {
+		return
+	}
+
+	var apps []models.HostApplication
+	if err := s.DB.Where("pool_id = ?", host.ID).Scopes(CurrentAccess(c).ApplicationScope).Find(&apps).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, apps)
+}
+
+func (s *ApplicationService) CreateApplication(c *gin.Context) {
+	host, ok := s.findHost(c)
+	if !ok {
+		return
+	}
+	in, ok := bindApplicationInput(c, true)
+	if !ok {
+		return
+	}
+
+	app := models.HostApplication{PoolID: host.ID}
+	in.applyTo(&app)
+	if !s.attachCluster(c, &app) {
+		return
+	}
+
+	existing, err := s.findDuplicate(&app)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if existing != nil && !existing.DeletedAt.Valid {
+		respondDuplicateApplication(c, existing)
+		return
+	}
+	// 之前删除过的同一应用直接复用原来的记录，避免与唯一索引冲突
+	if existing != nil {
+		app.ID = existing.ID
+		app.CreatedAt = existing.CreatedAt
+		app.CreateTime = existing.CreateTime
+	}
+
+	if err := s.DB.WithContext(c).Unscoped().Save(&app).Error; err != nil {
+		s.respondSaveError(c, &app, err)
+		return
+	}
+	c.JSON(http.StatusCreated, app)
+}
+
+func (s *ApplicationService) saveApplication(c *gin.Context, app *models.HostApplication) {
+	if !s.attachCluster(c, app) {
+		return
+	}
+	existing, err := s.findDuplicate(app)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if existing != nil {
+		respondDuplicateApplication(c, existing)
+		return
+	}
+
+	if err := s.DB.WithContext(c).Save(app).Error; err != nil {
+		s.respondSaveError(c, app, err)
+		return
+	}
+	c.JSON(http.StatusOK, app)
+}
+
+// UpdateApplication 用请求体整体替换应用信息，未提供的字段会被清空
+func (s *ApplicationService) UpdateApplication(c *gin.Context) {
+	host, ok := s.findHost(c)
+	if !ok {
+		return
+	}
+	existing, ok := s.findApplication(c, host, RoleOperator)
+	if !ok {
+		return
+	}
+	in, ok := bindApplicationInput(c, true)
+	if !ok {
+		return
+	}
+
+	app := models.HostApplication{
+		ID:         existing.ID,
+		PoolID:     host.ID,
+		CreatedAt:  existing.CreatedAt,
+		CreateTime: existing.CreateTime,
+	}
+	in.applyTo(&app)
+	s.saveApplication(c, &app)
+}
+
+func (s *ApplicationService) PatchApplication(c *gin.Context) {
+	host, ok := s.findHost(c)
+	if !ok {
+		return
+	}
+	app, ok := s.findApplication(c, host, RoleOperator)
+	if !ok {
+		return
+	}
+	in, ok := bindApplicationInput(c, false)
+	if !ok {
+		return
+	}
+
+	in.applyTo(app)
+	s.saveApplication(c, app)
+}
+
+func (s *ApplicationService) DeleteApplication(c *gin.Context) {
+	host, ok := s.findHost(c)
+	if !ok {
+		return
+	}
+	app, ok := s.findApplication(c, host, RoleOperator)
+	if !ok {
+		return
+	}
+
+	if err := s.DB.WithContext(c).Delete(app).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Application deleted successfully"})
+}
+
+// GetApplicationDetail 根据应用ID获取应用的详细信息以及所在主机
+func (s *ApplicationService) GetApplicationDetail(c *gin.Context) {
+	var app models.HostApplication
+	err := s.DB.Scopes(CurrentAccess(c).ApplicationScope).First(&app, c.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Application not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := s.DB
+	if !IncludeDeleted(c) {
+		query = query.Scopes(NotDeletedHosts)
+	}
+	var host models.HostPool
+	if err := query.First(&host, app.PoolID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Application not found"})
+		return
+	}
+
+	var group models.ClusterGroup
+	if err := s.DB.Where("cluster_name = ?", app.ClusterName).First(&group).Error; err == nil {
+		app.DepartmentName = group.DepartmentName
+	}
+
+	c.JSON(http.StatusOK, ApplicationDetail{HostApplication: app, Host: &host})
+}
@@ -0,0 +1,541 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cmdb/audit"
+	"cmdb/auth"
+	"cmdb/config"
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AuthService 负责登录、令牌刷新和校验。本地用户用 bcrypt 校验密码，LDAP 和 OIDC 用户第一次登录时自动创建
+type AuthService struct {
+	DB    *gorm.DB
+	store *config.Store
+
+	mu   sync.Mutex
+	oidc *auth.OIDC
+}
+
+func NewAuthService(db *gorm.DB, store *config.Store) *AuthService {
+	s := &AuthService{DB: db, store: store}
+	// OIDC 缓存了提供方的地址和公钥，配置变化后重新发现
+	store.OnChange(func(*config.Config) {
+		s.mu.Lock()
+		s.oidc = nil
+		s.mu.Unlock()
+	})
+	return s
+}
+
+// 不需要登录即可访问的接口
+var publicPaths = map[string]bool{
+	"/api/cmdb/v1/auth/login":         true,
+	"/api/cmdb/v1/auth/refresh":       true,
+	"/api/cmdb/v1/auth/logout":        true,
+	"/api/cmdb/v1/auth/oidc/login":    true,
+	"/api/cmdb/v1/auth/oidc/callback": true,
+}
+
+const oidcCookie = "cmdb_oidc"
+
+var errUsernameTaken = errors.New("username is already taken")
+
+// Middleware 要求 /api/cmdb/v1 下除登录接口外的请求带有有效的访问令牌或 API 令牌，并写入上下文：
+// 访问令牌的 "user" 为 *models.User，"actor" 为用户名；API 令牌的 "api_token" 为 *models.APIToken，
+// "actor" 为 token:<名称>。按匹配到的路由判断，未匹配的请求照常返回 404
+func (s *AuthService) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if !strings.HasPrefix(route, "/api/cmdb/v1/") || publicPaths[route] || c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			unauthorized(c, "authentication required")
+			return
+		}
+		if strings.HasPrefix(token, apiTokenPrefix) {
+			s.authenticateAPIToken(c, token)
+			return
+		}
+		settings := s.store.Current().Auth
+		claims, err := auth.Verify(token, []byte(settings.JWTSecret.Value()), settings.Issuer, time.Now())
+		if errors.Is(err, auth.ErrTokenExpired) {
+			unauthorized(c, "access token has expired")
+			return
+		}
+		if err != nil {
+			unauthorized(c, "invalid access token")
+			return
+		}
+		// 每次请求都重新读取用户，停用和删除立即生效
+		var user models.User
+		if err := s.DB.Where("id = ?", claims.Subject).Limit(1).Find(&user).Error; err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if user.ID == 0 || user.Disabled {
+			unauthorized(c, "user is disabled or no longer exists")
+			return
+		}
+		c.Set("user", &user)
+		c.Set("actor", user.Username)
+		c.Next()
+	}
+}
+
+func unauthorized(c *gin.Context, message string) {
+	c.Header("WWW-Authenticate", `Bearer realm="cmdb"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": message})
+}
+
+// CurrentUser 返回中间件写入的当前用户
+func CurrentUser(c *gin.Context) *models.User {
+	if v, ok := c.Get("user"); ok {
+		if user, ok := v.(*models.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// TokenResponse 为登录和刷新返回的令牌
+type TokenResponse struct {
+	AccessToken  string       `json:"access_token"`
+	RefreshToken string       `json:"refresh_token"`
+	TokenType    string       `json:"token_type"`
+	ExpiresIn    int          `json:"expires_in"`
+	User         *models.User `json:"user"`
+}
+
+// issueTokens 签发访问令牌和新的刷新令牌。tx 用于刷新时和吊销旧令牌放在同一事务中
+func (s *AuthService) issueTokens(tx *gorm.DB, c *gin.Context, user *models.User, now time.Time) (*TokenResponse, *models.RefreshToken, error) {
+	settings := s.store.Current().Auth
+	access, err := auth.Sign(auth.Claims{
+		Issuer:    settings.Issuer,
+		Subject:   strconv.FormatUint(uint64(user.ID), 10),
+		Username:  user.Username,
+		Provider:  user.Provider,
+		ID:        auth.RandomToken(16),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(settings.AccessTTL.Duration).Unix(),
+	}, []byte(settings.JWTSecret.Value()))
+	if err != nil {
+		return nil, nil, err
+	}
+	refresh := auth.RandomToken(32)
+	record := &models.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: auth.HashToken(refresh),
+		ExpiresAt: now.Add(settings.RefreshTTL.Duration).UTC(),
+		ClientIP:  c.ClientIP(),
+		UserAgent: truncate(c.Request.UserAgent(), 255),
+	}
+	if err := tx.Create(record).Error; err != nil {
+		return nil, nil, err
+	}
+	return &TokenResponse{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(settings.AccessTTL.Seconds()),
+		User:         user,
+	}, record, nil
+}
+
+// login 记录登录时间并签发令牌，停用的用户返回 403
+func (s *AuthService) login(c *gin.Context, user *models.User) (*TokenResponse, bool) {
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "user is disabled"})
+		return nil, false
+	}
+	now := time.Now()
+	user.LastLoginAt = &now
+	if err := s.DB.Model(user).Update("last_login_at", now).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	tokens, _, err := s.issueTokens(s.DB, c, user, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	log.Printf("User %s logged in via %s from %s", user.Username, user.Provider, c.ClientIP())
+	return tokens, true
+}
+
+// dummyHash 用于用户不存在时也执行一次 bcrypt 比较，避免通过响应时间判断用户名是否存在
+var dummyHash, _ = auth.HashPassword("cmdb-dummy-password")
+
+// Login 用用户名和密码登录。本地用户校验 bcrypt 密码；其他用户在启用 LDAP 时交给 LDAP 验证
+func (s *AuthService) Login(c *gin.Context) {
+	var req struct {
+		Username string `json:"username" binding:"required,max=100"`
+		Password string `json:"password" binding:"required,max=1024"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := s.DB.Where("username = ?", req.Username).Limit(1).Find(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ldapSettings := s.store.Current().Auth.LDAP
+	switch {
+	case user.ID != 0 && user.Provider == auth.ProviderLocal:
+		if err := auth.CheckPassword(user.PasswordHash, req.Password); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+	case ldapSettings.Enabled && (user.ID == 0 || user.Provider == auth.ProviderLDAP):
+		identity, err := s.ldap(ldapSettings).Authenticate(req.Username, req.Password)
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("LDAP login for %s failed: %v", req.Username, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "LDAP server is unavailable"})
+			return
+		}
+		found, err := s.upsertUser(identity)
+		if errors.Is(err, errUsernameTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		user = *found
+	default:
+		auth.CheckPassword(dummyHash, req.Password)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": auth.ErrInvalidCredentials.Error()})
+		return
+	}
+
+	if tokens, ok := s.login(c, &user); ok {
+		c.JSON(http.StatusOK, tokens)
+	}
+}
+
+func (s *AuthService) ldap(settings config.LDAPConfig) *auth.LDAP {
+	l := &auth.LDAP{
+		URL:             settings.URL,
+		StartTLS:        settings.StartTLS,
+		BindDN:          settings.BindDN,
+		BindPassword:    settings.BindPassword.Value(),
+		BaseDN:          settings.BaseDN,
+		UserFilter:      settings.UserFilter,
+		DisplayNameAttr: settings.DisplayNameAttribute,
+		EmailAttr:       settings.EmailAttribute,
+		Timeout:         settings.Timeout.Duration,
+	}
+	if settings.CAFile != "" {
+		l.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+		if pem, err := os.ReadFile(settings.CAFile); err != nil {
+			log.Printf("Failed to read auth.ldap.ca_file: %v", err)
+		} else {
+			roots, err := x509.SystemCertPool()
+			if err != nil {
+				roots = x509.NewCertPool()
+			}
+			roots.AppendCertsFromPEM(pem)
+			l.TLS.RootCAs = roots
+		}
+	}
+	return l
+}
+
+// upsertUser 返回外部身份对应的用户，第一次登录时创建，之后同步显示名和邮箱。
+// 用户名已被其他提供方的用户使用时返回 errUsernameTaken
+func (s *AuthService) upsertUser(identity *auth.Identity) (*models.User, error) {
+	// 同步和自动创建记在登录的用户名下
+	tx := s.DB.WithContext(audit.WithActor(context.Background(), identity.Username))
+	var user models.User
+	if err := s.DB.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).Limit(1).Find(&user).Error; err != nil {
+		return nil, err
+	}
+	if user.ID != 0 {
+		updates := map[string]any{}
+		if identity.DisplayName != "" && identity.DisplayName != user.DisplayName {
+			updates["display_name"] = identity.DisplayName
+		}
+		if identity.Email != "" && identity.Email != user.Email {
+			updates["email"] = identity.Email
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(&user).Updates(updates).Error; err != nil {
+				return nil, err
+			}
+		}
+		return &user, nil
+	}
+
+	var taken int64
+	if err := s.DB.Model(&models.User{}).Where("username = ?", identity.Username).Count(&taken).Error; err != nil {
+		return nil, err
+	}
+	if taken > 0 {
+		return nil, fmt.Errorf("%w: %s", errUsernameTaken, identity.Username)
+	}
+	user = models.User{
+		Username:    identity.Username,
+		DisplayName: identity.DisplayName,
+		Email:       identity.Email,
+		Provider:    identity.Provider,
+		Subject:     identity.Subject,
+	}
+	if err := tx.Create(&user).Error; err != nil {
+		return nil, err
+	}
+	log.Printf("Created %s user %s", user.Provider, user.Username)
+	return &user, nil
+}
+
+// Refresh 用刷新令牌换取新的访问令牌和刷新令牌，旧的刷新令牌随即失效。
+// 已经换过的刷新令牌再次出现说明可能被盗用，此时吊销该用户的全部会话
+func (s *AuthService) Refresh(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	var tokens *TokenResponse
+	var reused *models.RefreshToken
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var old models.RefreshToken
+		if err := tx.Where("token_hash = ?", auth.HashToken(req.RefreshToken)).Limit(1).Find(&old).Error; err != nil {
+			return err
+		}
+		if old.ID == 0 || !now.Before(old.ExpiresAt) {
+			return auth.ErrInvalidToken
+		}
+		if old.RevokedAt != nil {
+			if old.ReplacedByID != nil {
+				reused = &old
+			}
+			return auth.ErrInvalidToken
+		}
+		var user models.User
+		if err := tx.Where("id = ?", old.UserID).Limit(1).Find(&user).Error; err != nil {
+			return err
+		}
+		if user.ID == 0 || user.Disabled {
+			return auth.ErrInvalidToken
+		}
+
+		var record *models.RefreshToken
+		var err error
+		if tokens, record, err = s.issueTokens(tx, c, &user, now); err != nil {
+			return err
+		}
+		// 只有仍未吊销时才更新，两个并发的刷新请求中只有一个成功
+		result := tx.Model(&models.RefreshToken{}).Where("id = ? AND revoked_at IS NULL", old.ID).
+			Updates(map[string]any{"revoked_at": now.UTC(), "replaced_by_id": record.ID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return auth.ErrInvalidToken
+		}
+		return nil
+	})
+	if reused != nil {
+		log.Printf("Refresh token %d of user %d was reused, revoking all sessions", reused.ID, reused.UserID)
+		if err := s.revokeSessions(reused.UserID, now); err != nil {
+			log.Printf("Failed to revoke sessions of user %d: %v", reused.UserID, err)
+		}
+	}
+	if errors.Is(err, auth.ErrInvalidToken) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// revokeSessions 吊销用户全部未失效的刷新令牌
+func (s *AuthService) revokeSessions(userID uint, now time.Time) error {
+	return s.DB.Model(&models.RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now.UTC()).Error
+}
+
+// Logout 吊销请求中的刷新令牌。访问令牌在过期前仍然有效，客户端应一并丢弃
+func (s *AuthService) Logout(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := s.DB.Model(&models.RefreshToken{}).
+		Where("token_hash = ? AND revoked_at IS NULL", auth.HashToken(req.RefreshToken)).
+		Update("revoked_at", time.Now().UTC()).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// Me 返回当前登录的用户及其角色绑定
+func (s *AuthService) Me(c *gin.Context) {
+	user := *CurrentUser(c)
+	if err := s.DB.Where("user_id = ?", user.ID).Order("department_name").Find(&user.Roles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// ChangePassword 修改当前本地用户的密码，并吊销该用户的全部刷新令牌
+func (s *AuthService) ChangePassword(c *gin.Context) {
+	var req struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := CurrentUser(c)
+	if user.Provider != auth.ProviderLocal {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("password of %s users is managed by the identity provider", user.Provider)})
+		return
+	}
+	if err := auth.CheckPassword(user.PasswordHash, req.CurrentPassword); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "current password is incorrect"})
+		return
+	}
+	if err := s.SetPassword(c, user, req.NewPassword); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, please log in again on other devices"})
+}
+
+func (s *AuthService) oidcProvider() (*auth.OIDC, bool) {
+	settings := s.store.Current().Auth.OIDC
+	if !settings.Enabled {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oidc == nil {
+		s.oidc = &auth.OIDC{
+			Issuer:        settings.Issuer,
+			ClientID:      settings.ClientID,
+			ClientSecret:  settings.ClientSecret.Value(),
+			RedirectURL:   settings.RedirectURL,
+			Scopes:        settings.Scopes,
+			UsernameClaim: settings.UsernameClaim,
+			Client:        &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+	return s.oidc, true
+}
+
+// OIDCLogin 跳转到 OIDC 提供方登录。state 和 nonce 保存在只用于回调路径的 cookie 中，回调时校验
+func (s *AuthService) OIDCLogin(c *gin.Context) {
+	provider, ok := s.oidcProvider()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not enabled"})
+		return
+	}
+	state, nonce := auth.RandomToken(16), auth.RandomToken(16)
+	target, err := provider.AuthCodeURL(state, nonce)
+	if err != nil {
+		log.Printf("OIDC login: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "OIDC provider is unavailable"})
+		return
+	}
+	s.setOIDCCookie(c, state+"."+nonce, 600)
+	c.Redirect(http.StatusFound, target)
+}
+
+func (s *AuthService) setOIDCCookie(c *gin.Context, value string, maxAge int) {
+	secure := strings.HasPrefix(s.store.Current().Auth.OIDC.RedirectURL, "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcCookie, value, maxAge, "/api/cmdb/v1/auth/oidc", "", secure, true)
+}
+
+// OIDCCallback 处理提供方的回调：校验 state，用 code 换取 ID 令牌，创建或更新用户并签发令牌。
+// 配置了 post_login_redirect 时把令牌放在 URL 片段中跳转到前端，否则返回 JSON
+func (s *AuthService) OIDCCallback(c *gin.Context) {
+	provider, ok := s.oidcProvider()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not enabled"})
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("OIDC login failed: %s %s", e, c.Query("error_description"))})
+		return
+	}
+	cookie, _ := c.Cookie(oidcCookie)
+	s.setOIDCCookie(c, "", -1)
+	state, nonce, _ := strings.Cut(cookie, ".")
+	if state == "" || c.Query("state") != state || c.Query("code") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid OIDC state, please start the login again"})
+		return
+	}
+
+	identity, err := provider.Exchange(c.Query("code"), nonce, time.Now())
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "OIDC login failed"})
+		return
+	}
+	user, err := s.upsertUser(identity)
+	if errors.Is(err, errUsernameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tokens, ok := s.login(c, user)
+	if !ok {
+		return
+	}
+	if redirect := s.store.Current().Auth.OIDC.PostLoginRedirect; redirect != "" {
+		fragment := url.Values{
+			"access_token":  {tokens.AccessToken},
+			"refresh_token": {tokens.RefreshToken},
+			"expires_in":    {strconv.Itoa(tokens.ExpiresIn)},
+		}
+		c.Redirect(http.StatusFound, redirect+"#"+fragment.Encode())
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
@@ -0,0 +1,391 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"cmdb/models"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// UsageStats 为一组实例使用率的平均值、P95 和最大值
+type UsageStats struct {
+	Avg float64 `json:"avg"`
+	P95 float64 `json:"p95"`
+	Max float64 `json:"max"`
+}
+
+// ClusterStats 为一个集群（或一个组内全部集群）的规模和使用率。
+// Hosts 为不同主机数，Servers 为上报资源的实例数，Applications 为这些主机上属于该集群的应用数；
+// FullDate 为最早写满的实例的预测日期
+type ClusterStats struct {
+	ClusterName    string     `json:"cluster_name,omitempty"`
+	DepartmentName string     `json:"department_name,omitempty"`
+	ServerTypes    []string   `json:"server_types"`
+	Hosts          int        `json:"hosts"`
+	Servers        int        `json:"servers"`
+	Applications   int        `json:"applications"`
+	CPU            UsageStats `json:"cpu"`
+	Memory         UsageStats `json:"memory"`
+	Disk           UsageStats `json:"disk"`
+	FullDate       *time.Time `json:"full_date,omitempty"`
+	DaysToFull     *float64   `json:"days_to_full,omitempty"`
+}
+
+// GroupStats 为一个集群组的汇总和组内每个集群的统计
+type GroupStats struct {
+	GroupName string         `json:"group_name"`
+	Total     ClusterStats   `json:"total"`
+	Clusters  []ClusterStats `json:"clusters"`
+}
+
+// clusterInstanceRow 为 server_resources 关联 hosts_applications 后的一行，
+// 同一实例所在主机上有多个该集群的应用时会出现多行
+type clusterInstanceRow struct {
+	ID          uint
+	PoolID      uint
+	IP          string
+	Port        uint
+	ClusterName string
+	GroupName   string
+	TotalMemory float64
+	UsedMemory  float64
+	TotalDisk   float64
+	UsedDisk    float64
+	CPULoad     float64
+	AppID       *uint
+	ServerType  *string
+}
+
+// statsBuilder 累计一个集群或组内的实例
+type statsBuilder struct {
+	hosts             map[uint]bool
+	apps              map[uint]bool
+	types             map[string]bool
+	cpu, memory, disk []float64
+	forecast          *InstanceForecast
+}
+
+func newStatsBuilder() *statsBuilder {
+	return &statsBuilder{hosts: map[uint]bool{}, apps: map[uint]bool{}, types: map[string]bool{}}
+}
+
+func (b *statsBuilder) addForecast(f *InstanceForecast) {
+	if f == nil {
+		return
+	}
+	if b.forecast == nil || f.Forecast.FullDate.Before(*b.forecast.Forecast.FullDate) {
+		b.forecast = f
+	}
+}
+
+func (b *statsBuilder) build() ClusterStats {
+	st := ClusterStats{
+		ServerTypes:  make([]string, 0, len(b.types)),
+		Hosts:        len(b.hosts),
+		Servers:      len(b.cpu),
+		Applications: len(b.apps),
+		CPU:          usageStats(b.cpu),
+		Memory:       usageStats(b.memory),
+		Disk:         usageStats(b.disk),
+	}
+	for t := range b.types {
+		st.ServerTypes = append(st.ServerTypes, t)
+	}
+	sort.Strings(st.ServerTypes)
+	if b.forecast != nil {
+		st.FullDate = b.forecast.Forecast.FullDate
+		st.DaysToFull = b.forecast.Forecast.DaysToFull
+	}
+	return st
+}
+
+// usageStats 计算平均值、最大值和 P95（最近秩法：排序后第 ceil(0.95n) 个值）
+func usageStats(values []float64) UsageStats {
+	if len(values) == 0 {
+		return UsageStats{}
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	rank := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+	return UsageStats{
+		Avg: sum / float64(len(sorted)),
+		P95: sorted[max(rank, 0)],
+		Max: sorted[len(sorted)-1],
+	}
+}
+
+// ClusterGroupStats 按组、集群统计 filter 内每个实例最近一次上报的使用率，组和集群按名称排序。
+// 实例来自 server_resources 中未删除的主机，关联 hosts_applications 中同一主机上属于该集群的应用；
+// 组和部门以 cluster_groups 为准，已登记但没有实例的集群也会列出
+func (s *ReportService) ClusterGroupStats(now time.Time, filter ReportFilter) ([]GroupStats, error) {
+	var rows []clusterInstanceRow
+	err := s.DB.Table("server_resources AS sr").
+		Select("sr.id, sr.pool_id, sr.ip, sr.port, sr.cluster_name, sr.group_name, " +
+			"sr.total_memory, sr.used_memory, sr.total_disk, sr.used_disk, sr.cpu_load, " +
+			"ha.id AS app_id, ha.server_type").
+		Joins("LEFT JOIN hosts_applications AS ha ON ha.pool_id = sr.pool_id " +
+			"AND ha.cluster_name = sr.cluster_name AND ha.deleted_at IS NULL").
+		Joins("JOIN hosts_pool ON hosts_pool.id = sr.pool_id").
+		Where("sr.deleted_at IS NULL").
+		Scopes(NotDeletedHosts).
+		Order("sr.date_time desc, sr.id desc").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	var registered []models.ClusterGroup
+	if err := s.DB.Find(&registered).Error; err != nil {
+		return nil, err
+	}
+	forecasts, err := s.clusterForecasts(now)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct{ group, cluster string }
+	groupOf := make(map[string]string, len(registered))
+	departments := make(map[string]string, len(registered))
+	clusters := make(map[key]*statsBuilder)
+	groups := make(map[string]*statsBuilder)
+	add := func(k key) *statsBuilder {
+		b := clusters[k]
+		if b == nil {
+			b = newStatsBuilder()
+			b.addForecast(forecasts[k.cluster])
+			clusters[k] = b
+		}
+		if groups[k.group] == nil {
+			groups[k.group] = newStatsBuilder()
+		}
+		groups[k.group].addForecast(forecasts[k.cluster])
+		return b
+	}
+	for _, g := range registered {
+		groupOf[g.ClusterName] = g.GroupName
+		departments[g.ClusterName] = g.DepartmentName
+		if filter.Match(g.GroupName, g.DepartmentName) {
+			add(key{g.GroupName, g.ClusterName})
+		}
+	}
+
+	// 每个实例只取最新的一条资源记录，同一条记录关联出的多行只累计应用
+	latest := make(map[instanceKey]uint)
+	for _, r := range rows {
+		ik := instanceKey{r.PoolID, r.IP, r.Port}
+		id, seen := latest[ik]
+		if seen && id != r.ID {
+			continue
+		}
+		latest[ik] = r.ID
+		group, ok := groupOf[r.ClusterName]
+		if !ok {
+			group = r.GroupName
+		}
+		if !filter.Match(group, departments[r.ClusterName]) {
+			continue
+		}
+		cb, gb := add(key{group, r.ClusterName}), groups[group]
+		for _, b := range []*statsBuilder{cb, gb} {
+			if !seen {
+				b.hosts[r.PoolID] = true
+				b.cpu = append(b.cpu, r.CPULoad)
+				b.memory = append(b.memory, percent(r.UsedMemory, r.TotalMemory))
+				b.disk = append(b.disk, percent(r.UsedDisk, r.TotalDisk))
+			}
+			if r.AppID != nil {
+				b.apps[*r.AppID] = true
+			}
+			if r.ServerType != nil && *r.ServerType != "" {
+				b.types[*r.ServerType] = true
+			}
+		}
+	}
+
+	keys := make([]key, 0, len(clusters))
+	for k := range clusters {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].group != keys[j].group {
+			return keys[i].group < keys[j].group
+		}
+		return keys[i].cluster < keys[j].cluster
+	})
+	var result []GroupStats
+	for _, k := range keys {
+		if len(result) == 0 || result[len(result)-1].GroupName != k.group {
+			result = append(result, GroupStats{GroupName: k.group, Total: groups[k.group].build()})
+		}
+		st := clusters[k].build()
+		st.ClusterName = k.cluster
+		st.DepartmentName = departments[k.cluster]
+		last := &result[len(result)-1]
+		last.Clusters = append(last.Clusters, st)
+	}
+	return result, nil
+}
+
+// clusterGroupWorkbook 生成集群组报表：第一个工作表为各组的汇总，之后每个组一个工作表、每个集群一行。
+// 表头行和名称列冻结，使用率不低于 threshold 的单元格以及预测在 fullBefore 之前写满的日期高亮显示
+func clusterGroupWorkbook(groups []GroupStats, threshold float64, fullBefore time.Time) (*excelize.File, error) {
+	f := excelize.NewFile()
+	header, err := f.NewStyle(&excelize.Style{
+		Font:      &excelize.Font{Bold: true},
+		Fill:      excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#F2F2F2"}},
+		Alignment: &excelize.Alignment{Vertical: "center", WrapText: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	decimal, err := f.NewStyle(&excelize.Style{NumFmt: 2})
+	if err != nil {
+		return nil, err
+	}
+	dateFormat := "yyyy-mm-dd"
+	date, err := f.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat})
+	if err != nil {
+		return nil, err
+	}
+	warn, err := f.NewConditionalStyle(&excelize.Style{
+		Font: &excelize.Font{Color: "#9C0006"},
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#FFC7CE"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 数值列依次为：主机数、实例数、应用数，CPU、内存、磁盘各自的平均值和 P95，预测写满日期、剩余天数
+	stats := []string{"Hosts", "Servers", "Applications",
+		"Avg CPU (%)", "P95 CPU (%)", "Avg Memory (%)", "P95 Memory (%)", "Avg Disk (%)", "P95 Disk (%)",
+		"Disk Full Date", "Days To Full"}
+	values := func(st ClusterStats) []interface{} {
+		row := []interface{}{st.Hosts, st.Servers, st.Applications,
+			st.CPU.Avg, st.CPU.P95, st.Memory.Avg, st.Memory.P95, st.Disk.Avg, st.Disk.P95, nil, nil}
+		if st.FullDate != nil {
+			local := st.FullDate.Local()
+			row[9] = time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		if st.DaysToFull != nil {
+			row[10] = math.Round(*st.DaysToFull*10) / 10
+		}
+		return row
+	}
+
+	// writeSheet 写一个工作表，前 lead 列为名称等文字列，其后为 stats 列
+	writeSheet := func(sheet string, lead []string, rows [][]interface{}) error {
+		headers := append(append([]string(nil), lead...), stats...)
+		if err := f.SetSheetRow(sheet, "A1", &headers); err != nil {
+			return err
+		}
+		for i, row := range rows {
+			if err := f.SetSheetRow(sheet, fmt.Sprintf("A%d", i+2), &row); err != nil {
+				return err
+			}
+		}
+		col := func(i int) string {
+			name, _ := excelize.ColumnNumberToName(i + 1)
+			return name
+		}
+		first, last := len(lead), len(headers)-1
+		lastRow := max(len(rows)+1, 2)
+		if err := f.SetCellStyle(sheet, "A1", col(last)+"1", header); err != nil {
+			return err
+		}
+		if err := f.SetCellStyle(sheet, col(first+3)+"2", fmt.Sprintf("%s%d", col(first+8), lastRow), decimal); err != nil {
+			return err
+		}
+		if err := f.SetCellStyle(sheet, col(first+9)+"2", fmt.Sprintf("%s%d", col(first+9), lastRow), date); err != nil {
+			return err
+		}
+		usage := fmt.Sprintf("%s2:%s%d", col(first+3), col(first+8), lastRow)
+		if err := f.SetConditionalFormat(sheet, usage, []excelize.ConditionalFormatOptions{
+			{Type: "cell", Criteria: ">=", Value: fmt.Sprint(threshold), Format: warn},
+		}); err != nil {
+			return err
+		}
+		fullCol := col(first + 9)
+		full := fmt.Sprintf("%s2:%s%d", fullCol, fullCol, lastRow)
+		if err := f.SetConditionalFormat(sheet, full, []excelize.ConditionalFormatOptions{{
+			Type:     "formula",
+			Criteria: fmt.Sprintf("AND(ISNUMBER(%s2),%s2<DATE(%d,%d,%d))", fullCol, fullCol, fullBefore.Year(), fullBefore.Month(), fullBefore.Day()),
+			Format:   warn,
+		}}); err != nil {
+			return err
+		}
+		if err := f.SetColWidth(sheet, "A", col(first-1), 24); err != nil {
+			return err
+		}
+		if err := f.SetColWidth(sheet, col(first), col(last), 14); err != nil {
+			return err
+		}
+		if err := f.AutoFilter(sheet, fmt.Sprintf("A1:%s%d", col(last), lastRow), nil); err != nil {
+			return err
+		}
+		return f.SetPanes(sheet, &excelize.Panes{
+			Freeze: true, XSplit: 1, YSplit: 1, TopLeftCell: "B2", ActivePane: "bottomRight",
+		})
+	}
+
+	const summary = "Summary"
+	if err := f.SetSheetName("Sheet1", summary); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{strings.ToLower(summary): true}
+	summaryRows := make([][]interface{}, 0, len(groups))
+	for _, g := range groups {
+		summaryRows = append(summaryRows, append([]interface{}{g.GroupName, len(g.Clusters)}, values(g.Total)...))
+	}
+	if err := writeSheet(summary, []string{"Group Name", "Clusters"}, summaryRows); err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		sheet := sheetName(g.GroupName, names)
+		if _, err := f.NewSheet(sheet); err != nil {
+			return nil, err
+		}
+		rows := make([][]interface{}, 0, len(g.Clusters))
+		for _, c := range g.Clusters {
+			rows = append(rows, append([]interface{}{c.ClusterName, c.DepartmentName, strings.Join(c.ServerTypes, ", ")}, values(c)...))
+		}
+		if err := writeSheet(sheet, []string{"Cluster Name", "Department", "Server Types"}, rows); err != nil {
+			return nil, err
+		}
+	}
+	f.SetActiveSheet(0)
+	return f, nil
+}
+
+// sheetName 把组名转换为合法且不重复的工作表名：去掉 Excel 不允许的字符，最长 31 个字符，
+// 重名（不区分大小写）时加序号。used 记录已使用的名称
+func sheetName(name string, used map[string]bool) string {
+	name = strings.Trim(strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name), "'")
+	if name == "" {
+		name = "(empty)"
+	}
+	base := []rune(name)
+	if len(base) > 31 {
+		base = base[:31]
+	}
+	candidate := string(base)
+	for i := 2; used[strings.ToLower(candidate)]; i++ {
+		suffix := fmt.Sprintf(" (%d)", i)
+		candidate = string(base[:min(len(base), 31-len(suffix))]) + suffix
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
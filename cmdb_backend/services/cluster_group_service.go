@@ -0,0 +1,397 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ClusterGroupService 维护 cluster_groups 表，并保证 hosts_applications、server_resources、
+// 告警和告警规则中引用的集群名称与其保持一致
+type ClusterGroupService struct {
+	DB *gorm.DB
+}
+
+func NewClusterGroupService(db *gorm.DB) *ClusterGroupService {
+	return &ClusterGroupService{DB: db}
+}
+
+// ErrUnknownCluster 表示引用了 cluster_groups 中不存在的集群
+var ErrUnknownCluster = errors.New("unknown cluster")
+
+type ClusterGroupInput struct {
+	GroupName      *string `json:"group_name" binding:"omitempty,min=1,max=64"`
+	ClusterName    *string `json:"cluster_name" binding:"omitempty,min=1,max=64"`
+	DepartmentName *string `json:"department_name" binding:"omitempty,min=1,max=100"`
+}
+
+// ClusterMembers 统计引用某个集群的应用和资源记录数量
+type ClusterMembers struct {
+	Applications int64 `json:"applications"`
+	Resources    int64 `json:"resources"`
+}
+
+func (m ClusterMembers) Empty() bool {
+	return m.Applications == 0 && m.Resources == 0
+}
+
+// resolveCluster 校验应用或资源引用的集群，返回对应的集群记录；
+// 名称为空表示未分配集群，返回 nil
+func resolveCluster(c *gin.Context, db *gorm.DB, name string) (*models.ClusterGroup, bool) {
+	if name == "" {
+		return nil, true
+	}
+	group, err := findClusterByName(db, name)
+	if errors.Is(err, ErrUnknownCluster) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return group, true
+}
+
+// findClusterByName 按名称查找集群，不存在时返回 ErrUnknownCluster
+func findClusterByName(db *gorm.DB, name string) (*models.ClusterGroup, error) {
+	var group models.ClusterGroup
+	err := db.Where("cluster_name = ?", name).First(&group).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w %q", ErrUnknownCluster, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func countMembers(db *gorm.DB, clusterName string) (ClusterMembers, error) {
+	var members ClusterMembers
+	if err := db.Model(&models.HostApplication{}).Where("cluster_name = ?", clusterName).Count(&members.Applications).Error; err != nil {
+		return members, err
+	}
+	if err := db.Model(&models.ServerResource{}).Where("cluster_name = ?", clusterName).Count(&members.Resources).Error; err != nil {
+		return members, err
+	}
+	return members, nil
+}
+
+// moveMembers 把引用 from 集群的应用、资源记录（包括已软删除的）、历史数据和告警改为引用 to 集群，
+// 限定在 from 集群的告警规则改为限定在 to 集群。from 为修改前的集群
+func moveMembers(tx *gorm.DB, from, to *models.ClusterGroup) error {
+	if err := tx.Unscoped().Model(&models.HostApplication{}).Where("cluster_name = ?", from.ClusterName).
+		Updates(map[string]interface{}{"cluster_name": to.ClusterName, "department_name": to.DepartmentName}).Error; err != nil {
+		return err
+	}
+	for _, model := range []interface{}{&models.ServerResource{}, &models.MetricSample{}, &models.MetricRollup{}} {
+		if err := tx.Unscoped().Model(model).Where("cluster_name = ?", from.ClusterName).
+			Updates(map[string]interface{}{"cluster_name": to.ClusterName, "group_name": to.GroupName}).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Model(&models.Alert{}).Where("cluster_name = ?", from.ClusterName).
+		Updates(map[string]interface{}{"cluster_name": to.ClusterName, "group_name": to.GroupName, "department_name": to.DepartmentName}).Error; err != nil {
+		return err
+	}
+	if err := renameRuleScope(tx, ScopeCluster, from.ClusterName, to.ClusterName); err != nil {
+		return err
+	}
+
+	// from 原来所在的组或部门已经没有其他集群时，视为组或部门改名，引用它的规则、通知路由和报告订阅一并修改
+	if from.GroupName != to.GroupName {
+		unused, err := unusedByClusters(tx, "group_name", from.GroupName, from.ID)
+		if err != nil {
+			return err
+		}
+		if unused {
+			if err := renameRuleScope(tx, ScopeGroup, from.GroupName, to.GroupName); err != nil {
+				return err
+			}
+			if err := renameSubscriptionFilter(tx, "groups", from.GroupName, to.GroupName); err != nil {
+				return err
+			}
+		}
+	}
+	if from.DepartmentName != to.DepartmentName {
+		unused, err := unusedByClusters(tx, "department_name", from.DepartmentName, from.ID)
+		if err != nil {
+			return err
+		}
+		if unused {
+			if err := renameRuleScope(tx, ScopeDepartment, from.DepartmentName, to.DepartmentName); err != nil {
+				return err
+			}
+			if err := tx.Model(&models.NotificationRoute{}).Where("department_name = ?", from.DepartmentName).
+				Update("department_name", to.DepartmentName).Error; err != nil {
+				return err
+			}
+			if err := renameSubscriptionFilter(tx, "departments", from.DepartmentName, to.DepartmentName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unusedByClusters 判断除 selfID 以外是否已经没有集群的 column 等于 value
+func unusedByClusters(tx *gorm.DB, column, value string, selfID uint) (bool, error) {
+	var count int64
+	err := tx.Model(&models.ClusterGroup{}).Where(column+" = ? AND id <> ?", value, selfID).Count(&count).Error
+	return count == 0, err
+}
+
+func renameRuleScope(tx *gorm.DB, scopeType, from, to string) error {
+	return tx.Model(&models.AlertRule{}).Where("scope_type = ? AND scope_value = ?", scopeType, from).
+		Update("scope_value", to).Error
+}
+
+// renameSubscriptionFilter 把报告订阅的 column（groups 或 departments）列表中的 from 替换为 to
+func renameSubscriptionFilter(tx *gorm.DB, column, from, to string) error {
+	var subs []models.ReportSubscription
+	if err := tx.Where(column+" LIKE ? ESCAPE '!'", "%"+escapeLike(from)+"%").Find(&subs).Error; err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		list := sub.Groups
+		if column == "departments" {
+			list = sub.Departments
+		}
+		values := splitList(list)
+		if !slices.Contains(values, from) {
+			continue
+		}
+		for i, v := range values {
+			if v == from {
+				values[i] = to
+			}
+		}
+		if err := tx.Model(&sub).Update(column, strings.Join(splitList(strings.Join(values, ",")), ",")).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findGroup 查找集群，并检查当前用户在集群所属部门中至少拥有 role。不可见部门的集群按不存在处理
+func (s *ClusterGroupService) findGroup(c *gin.Context, id string, role string) (*models.ClusterGroup, bool) {
+	var group models.ClusterGroup
+	err := s.DB.Scopes(CurrentAccess(c).DepartmentScope("department_name")).First(&group, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cluster group not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if !requireDepartment(c, group.DepartmentName, role) {
+		return nil, false
+	}
+	return &group, true
+}
+
+// checkClusterName 检查集群名称是否已被其他记录占用
+func (s *ClusterGroupService) checkClusterName(c *gin.Context, name string, selfID uint) bool {
+	var existing models.ClusterGroup
+	err := s.DB.Where("cluster_name = ? AND id <> ?", name, selfID).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": "cluster_name already exists", "id": existing.ID})
+	return false
+}
+
+// respondSaveError 返回写入集群失败的错误。查重之后其他请求抢先写入了相同的集群名称时，唯一索引会拒绝写入，同样返回 409
+func (s *ClusterGroupService) respondSaveError(c *gin.Context, group *models.ClusterGroup, err error) {
+	if !errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if s.checkClusterName(c, group.ClusterName, group.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "cluster_name already exists"})
+	}
+}
+
+func (s *ClusterGroupService) GetClusterGroup(c *gin.Context) {
+	group, ok := s.findGroup(c, c.Param("id"), RoleViewer)
+	if !ok {
+		return
+	}
+	members, err := countMembers(s.DB, group.ClusterName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cluster_group": group, "members": members})
+}
+
+func (s *ClusterGroupService) CreateClusterGroup(c *gin.Context) {
+	var in ClusterGroupInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if in.GroupName == nil || in.ClusterName == nil || in.DepartmentName == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group_name, cluster_name and department_name are required"})
+		return
+	}
+	if !requireDepartment(c, *in.DepartmentName, RoleOperator) {
+		return
+	}
+	if !s.checkClusterName(c, *in.ClusterName, 0) {
+		return
+	}
+
+	group := models.ClusterGroup{GroupName: *in.GroupName, ClusterName: *in.ClusterName, DepartmentName: *in.DepartmentName}
+
+	// 之前删除过的同名集群直接复用原来的记录，避免与唯一索引冲突
+	var deleted models.ClusterGroup
+	if err := s.DB.Unscoped().Where("cluster_name = ? AND deleted_at IS NOT NULL", group.ClusterName).First(&deleted).Error; err == nil {
+		group.ID = deleted.ID
+		group.CreatedAt = deleted.CreatedAt
+	}
+
+	if err := s.DB.WithContext(c).Unscoped().Save(&group).Error; err != nil {
+		s.respondSaveError(c, &group, err)
+		return
+	}
+	c.JSON(http.StatusCreated, group)
+}
+
+// UpdateClusterGroup 修改集群信息；集群改名或换组时同步修改引用它的应用和资源记录
+func (s *ClusterGroupService) UpdateClusterGroup(c *gin.Context) {
+	group, ok := s.findGroup(c, c.Param("id"), RoleOperator)
+	if !ok {
+		return
+	}
+	var in ClusterGroupInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// 把集群转给其他部门时，在目标部门也需要 operator 角色
+	if in.DepartmentName != nil && !requireDepartment(c, *in.DepartmentName, RoleOperator) {
+		return
+	}
+	if in.ClusterName != nil && !s.checkClusterName(c, *in.ClusterName, group.ID) {
+		return
+	}
+
+	before := *group
+	setIf(&group.GroupName, in.GroupName)
+	setIf(&group.ClusterName, in.ClusterName)
+	setIf(&group.DepartmentName, in.DepartmentName)
+
+	err := s.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		// 新名称属于已删除的集群时，删除旧记录以释放唯一索引中的名称，与新建同名集群时复用旧记录一致
+		if group.ClusterName != before.ClusterName {
+			if err := tx.Unscoped().Where("cluster_name = ? AND deleted_at IS NOT NULL", group.ClusterName).
+				Delete(&models.ClusterGroup{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Save(group).Error; err != nil {
+			return err
+		}
+		return moveMembers(tx, &before, group)
+	})
+	if err != nil {
+		s.respondSaveError(c, group, err)
+		return
+	}
+	c.JSON(http.StatusOK, group)
+}
+
+// MergeClusterGroup 把 :id 集群的所有成员并入 into 指定的集群，然后删除 :id
+func (s *ClusterGroupService) MergeClusterGroup(c *gin.Context) {
+	var req struct {
+		Into uint `json:"into" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	source, ok := s.findGroup(c, c.Param("id"), RoleOperator)
+	if !ok {
+		return
+	}
+	target, ok := s.findGroup(c, fmt.Sprint(req.Into), RoleOperator)
+	if !ok {
+		return
+	}
+	if source.ID == target.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot merge a cluster into itself"})
+		return
+	}
+
+	err := s.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := moveMembers(tx, source, target); err != nil {
+			return err
+		}
+		return tx.Delete(source).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, target)
+}
+
+// DeleteClusterGroup 删除集群；仍有成员时需要通过 reassign_to 指定接收成员的集群
+func (s *ClusterGroupService) DeleteClusterGroup(c *gin.Context) {
+	group, ok := s.findGroup(c, c.Param("id"), RoleOperator)
+	if !ok {
+		return
+	}
+
+	var target *models.ClusterGroup
+	if reassignTo := c.Query("reassign_to"); reassignTo != "" {
+		if target, ok = s.findGroup(c, reassignTo, RoleOperator); !ok {
+			return
+		}
+		if target.ID == group.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot reassign members to the cluster being deleted"})
+			return
+		}
+	}
+
+	members, err := countMembers(s.DB, group.ClusterName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !members.Empty() && target == nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "cluster still has members, pass reassign_to to move them first",
+			"members": members,
+		})
+		return
+	}
+
+	err = s.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if target != nil {
+			if err := moveMembers(tx, group, target); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(group).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Cluster group deleted successfully", "reassigned": members})
+}
@@ -1,31 +1,72 @@
 package services
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/textproto"
+	"strings"
 	"time"
 
-	"crypto/tls"
+	"cmdb/config"
+	"cmdb/models"
 
 	"github.com/gin-gonic/gin"
-	"gopkg.in/gomail.v2"
 	"gopkg.in/mail.v2"
+	"gorm.io/gorm"
 )
 
+// 发件队列中邮件的状态
+const (
+	EmailQueued  = "queued"
+	EmailSent    = "sent"
+	EmailFailed  = "failed"  // 重试次数用完
+	EmailBounced = "bounced" // 服务器以 5xx 拒收，不再重试
+)
+
+// 每轮最多投递的邮件数
+const outboxBatchSize = 20
+
 type EmailService struct {
-	SMTPHost     string
-	SMTPPort     int
-	SMTPUser     string
-	SMTPPassword string
+	DB        *gorm.DB
+	store     *config.Store
+	transport *mailTransport
+}
+
+// NewEmailService 每次投递时都从 store 读取 SMTP 配置，以便配置热加载后立即生效
+func NewEmailService(db *gorm.DB, store *config.Store) *EmailService {
+	return &EmailService{DB: db, store: store, transport: newMailTransport()}
+}
+
+func (s *EmailService) settings() config.SMTPConfig {
+	return s.store.Current().SMTP
 }
 
 type EmailRequest struct {
 	To      string `json:"to"`
 	Subject string `json:"subject"`
 	Content string `json:"content"`
+	// Profile 为发件账号，为空时按 Department 选择，都为空时使用默认账号
+	Profile    string `json:"profile"`
+	Department string `json:"department"`
+}
+
+// OutboundEmail 为写入发件队列的邮件
+type OutboundEmail struct {
+	// Profile 为发件账号名称，为空时按 Department 选择账号
+	Profile    string
+	Department string
+	To         []string
+	Subject    string
+	HTML       string
+	// Text 不为空时作为纯文本备选正文，和 HTML 一起以 multipart/alternative 发送
+	Text        string
+	Attachments []models.EmailAttachment
 }
 
+// SendEmail 把邮件写入发件队列，立即返回队列中的 message_id
 func (s *EmailService) SendEmail(c *gin.Context) {
 	var req EmailRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -34,39 +75,308 @@ func (s *EmailService) SendEmail(c *gin.Context) {
 		return
 	}
 
+	to, err := parseRecipients(req.To)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	id, err := s.Enqueue(OutboundEmail{
+		Profile:    req.Profile,
+		Department: req.Department,
+		To:         to,
+		Subject:    req.Subject,
+		HTML:       req.Content,
+	})
+	if errors.Is(err, errUnknownProfile) {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error queueing email: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "message_id": id})
+}
+
+var errUnknownProfile = errors.New("unknown smtp profile")
+
+// Enqueue 把邮件写入发件队列，由后台任务投递。发件账号在写入时确定
+func (s *EmailService) Enqueue(e OutboundEmail) (uint, error) {
+	if len(e.To) == 0 {
+		return 0, errors.New("at least one recipient is required")
+	}
+	smtp := s.settings()
+	profile := e.Profile
+	if profile == "" {
+		profile = smtp.ProfileForDepartment(e.Department)
+	}
+	if _, ok := smtp.Account(profile); !ok {
+		return 0, fmt.Errorf("%w %q", errUnknownProfile, profile)
+	}
+	for i := range e.Attachments {
+		e.Attachments[i].Size = len(e.Attachments[i].Content)
+	}
+	msg := models.EmailMessage{
+		Profile:       profile,
+		To:            strings.Join(e.To, ","),
+		Subject:       e.Subject,
+		Body:          e.HTML,
+		Text:          e.Text,
+		Status:        EmailQueued,
+		NextAttemptAt: time.Now().UTC(),
+		Attachments:   e.Attachments,
+	}
+	if err := s.DB.Create(&msg).Error; err != nil {
+		return 0, err
+	}
+	return msg.ID, nil
+}
+
+// Run 按配置的周期投递到期的邮件，直到 stop 被关闭
+func (s *EmailService) Run(stop <-chan struct{}) {
+	for {
+		if err := s.DeliverDue(time.Now()); err != nil {
+			log.Printf("email outbox delivery failed: %v", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(s.settings().Outbox.PollInterval.Duration):
+		}
+	}
+}
+
+// DeliverDue 投递 NextAttemptAt 已到的 queued 邮件。每封邮件先推迟 NextAttemptAt 来占用，
+// 多个实例同时运行时同一封邮件只会被一个实例投递
+func (s *EmailService) DeliverDue(now time.Time) error {
+	now = now.UTC()
+	var due []models.EmailMessage
+	if err := s.DB.Select("id", "next_attempt_at").
+		Where("status = ? AND next_attempt_at <= ?", EmailQueued, now).
+		Order("next_attempt_at, id").Limit(outboxBatchSize).Find(&due).Error; err != nil {
+		return err
+	}
+
+	lease := now.Add(s.settings().Timeout.Duration + time.Minute)
+	for _, d := range due {
+		claim := s.DB.Model(&models.EmailMessage{}).
+			Where("id = ? AND status = ? AND next_attempt_at = ?", d.ID, EmailQueued, d.NextAttemptAt).
+			Update("next_attempt_at", lease)
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+		var msg models.EmailMessage
+		if err := s.DB.Preload("Attachments").First(&msg, d.ID).Error; err != nil {
+			return err
+		}
+		if err := s.record(&msg, s.deliver(&msg), time.Now().UTC()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record 保存一次投递的结果：成功为 sent，5xx 拒收为 bounced，发件账号已从配置中删除时直接为 failed，
+// 其余错误按指数退避重试，次数用完为 failed
+func (s *EmailService) record(msg *models.EmailMessage, sendErr error, now time.Time) error {
+	outbox := s.settings().Outbox
+	msg.Attempts++
+	switch {
+	case sendErr == nil:
+		msg.Status = EmailSent
+		msg.SentAt = &now
+		msg.LastError = ""
+	case permanentSMTPError(sendErr):
+		msg.Status = EmailBounced
+		msg.LastError = truncate(sendErr.Error(), 1000)
+	case errors.Is(sendErr, errUnknownProfile), msg.Attempts >= outbox.MaxAttempts:
+		msg.Status = EmailFailed
+		msg.LastError = truncate(sendErr.Error(), 1000)
+	default:
+		msg.LastError = truncate(sendErr.Error(), 1000)
+		msg.NextAttemptAt = now.Add(outboxBackoff(outbox, msg.Attempts))
+	}
+	if sendErr != nil {
+		log.Printf("email %d attempt %d: %v", msg.ID, msg.Attempts, sendErr)
+	}
+	return s.DB.Model(msg).Select("status", "attempts", "sent_at", "last_error", "next_attempt_at", "updated_at").Updates(msg).Error
+}
+
+// outboxBackoff 返回第 attempts 次失败后的等待时长
+func outboxBackoff(outbox config.OutboxConfig, attempts int) time.Duration {
+	wait := outbox.Backoff.Duration
+	for i := 1; i < attempts && wait < outbox.MaxBackoff.Duration; i++ {
+		wait *= 2
+	}
+	return min(wait, outbox.MaxBackoff.Duration)
+}
+
+// permanentSMTPError 判断服务器是否以 5xx 拒收了邮件。连接、认证阶段的错误都按临时错误重试
+func permanentSMTPError(err error) bool {
+	var sendErr *mail.SendError
+	if !errors.As(err, &sendErr) {
+		return false
+	}
+	var protoErr *textproto.Error
+	return errors.As(sendErr.Cause, &protoErr) && protoErr.Code >= 500
+}
+
+func (s *EmailService) deliver(msg *models.EmailMessage) error {
+	smtp := s.settings()
+	account, ok := smtp.Account(msg.Profile)
+	if !ok {
+		return fmt.Errorf("%w %q", errUnknownProfile, msg.Profile)
+	}
 	m := mail.NewMessage()
-	m.SetHeader("From", s.SMTPUser)
-	m.SetHeader("To", req.To)
-	m.SetHeader("Subject", req.Subject)
-	m.SetBody("text/html", req.Content)
+	m.SetHeader("From", account.From)
+	m.SetHeader("To", strings.Split(msg.To, ",")...)
+	m.SetHeader("Subject", msg.Subject)
+	if msg.Text != "" {
+		m.SetBody("text/plain", msg.Text)
+		m.AddAlternative("text/html", msg.Body)
+	} else {
+		m.SetBody("text/html", msg.Body)
+	}
+	for _, a := range msg.Attachments {
+		content := a.Content
+		copyFunc := mail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write(content)
+			return err
+		})
+		header := mail.SetHeader(map[string][]string{"Content-Type": {a.ContentType}})
+		if a.Inline {
+			m.Embed(a.Filename, copyFunc, header)
+		} else {
+			m.Attach(a.Filename, copyFunc, header)
+		}
+	}
 
-	d := mail.NewDialer(s.SMTPHost, s.SMTPPort, s.SMTPUser, s.SMTPPassword)
-	d.SSL = true
-	d.Timeout = 20 * time.Second // 增加超时时间
+	return s.transport.Send(account, smtp.Timeout.Duration, m)
+}
 
-	if err := d.DialAndSend(m); err != nil {
-		log.Printf("Error sending email: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": err.Error()})
+func (s *EmailService) findMessage(c *gin.Context) (*models.EmailMessage, bool) {
+	var msg models.EmailMessage
+	err := s.DB.Preload("Attachments").First(&msg, c.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &msg, true
+}
+
+// ListEmails 分页返回发件队列中的邮件（不含正文），可按 status 过滤、按 to 模糊匹配收件人
+func (s *EmailService) ListEmails(c *gin.Context) {
+	page, pageSize, ok := pagination(c)
+	if !ok {
 		return
 	}
+	query := s.DB.Model(&models.EmailMessage{})
+	if statuses := QueryList(c, "status"); len(statuses) > 0 {
+		query = query.Where("status IN ?", statuses)
+	}
+	if to := c.Query("to"); to != "" {
+		query = query.Where("recipients LIKE ? ESCAPE '!'", "%"+escapeLike(to)+"%")
+	}
 
-	log.Println("Email sent successfully")
-	c.JSON(http.StatusOK, gin.H{"success": true})
+	result := Page[models.EmailMessage]{Items: []models.EmailMessage{}, Page: page, PageSize: pageSize}
+	if err := query.Session(&gorm.Session{}).Count(&result.Total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := query.Omit("body", "text_body").Order("id desc").Offset((page - 1) * pageSize).Limit(pageSize).
+		Find(&result.Items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
 }
 
-func (s *EmailService) SendEmailWithAttachment(to, subject, body string, attachment []byte) error {
-	m := gomail.NewMessage()
-	m.SetHeader("From", s.SMTPUser)
-	m.SetHeader("To", to)
-	m.SetHeader("Subject", subject)
-	m.SetBody("text/html", body)
-	m.Attach("screenshot.png", gomail.SetCopyFunc(func(w io.Writer) error {
-		_, err := w.Write(attachment)
-		return err
-	}))
+func (s *EmailService) GetEmail(c *gin.Context) {
+	if msg, ok := s.findMessage(c); ok {
+		c.JSON(http.StatusOK, msg)
+	}
+}
 
-	d := gomail.NewDialer(s.SMTPHost, s.SMTPPort, s.SMTPUser, s.SMTPPassword)
-	d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+// ResendEmail 把已发送、失败或被拒收的邮件重新放回队列并清零重试次数；仍在队列中的邮件返回 409
+func (s *EmailService) ResendEmail(c *gin.Context) {
+	msg, ok := s.findMessage(c)
+	if !ok {
+		return
+	}
+	if msg.Status == EmailQueued {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("email %d is already queued", msg.ID)})
+		return
+	}
+	msg.Status = EmailQueued
+	msg.Attempts = 0
+	msg.NextAttemptAt = time.Now().UTC()
+	msg.LastError = ""
+	msg.SentAt = nil
+	if err := s.DB.Model(msg).Select("status", "attempts", "sent_at", "last_error", "next_attempt_at", "updated_at").Updates(msg).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"success": true, "message_id": msg.ID})
+}
 
-	return d.DialAndSend(m)
+// EmailProfile 为发件账号的公开信息，不包含密码和令牌
+type EmailProfile struct {
+	Name        string   `json:"name"`
+	Host        string   `json:"host"`
+	Port        int      `json:"port"`
+	User        string   `json:"user"`
+	From        string   `json:"from"`
+	Security    string   `json:"security"`
+	Auth        string   `json:"auth"`
+	Departments []string `json:"departments"`
+}
+
+// ListProfiles 返回默认账号和所有命名发件账号
+func (s *EmailService) ListProfiles(c *gin.Context) {
+	smtp := s.settings()
+	names := []string{config.DefaultSMTPProfile}
+	departments := map[string][]string{config.DefaultSMTPProfile: {}}
+	for _, p := range smtp.Profiles {
+		names = append(names, p.Name)
+		departments[p.Name] = append([]string{}, p.Departments...)
+	}
+	profiles := make([]EmailProfile, 0, len(names))
+	for _, name := range names {
+		account, _ := smtp.Account(name)
+		profiles = append(profiles, EmailProfile{
+			Name:        name,
+			Host:        account.Host,
+			Port:        account.Port,
+			User:        account.User,
+			From:        account.From,
+			Security:    account.Security,
+			Auth:        account.Auth,
+			Departments: departments[name],
+		})
+	}
+	c.JSON(http.StatusOK, profiles)
+}
+
+// VerifyProfile 连接发件账号的服务器并认证，不发送邮件；失败时返回 502
+func (s *EmailService) VerifyProfile(c *gin.Context) {
+	smtp := s.settings()
+	account, ok := smtp.Account(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SMTP profile not found"})
+		return
+	}
+	if err := s.transport.Verify(account, smtp.Timeout.Duration); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"success": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
 }
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"math"
+	"time"
+)
+
+// 预测模型
+const (
+	ModelLinear      = "linear"
+	ModelHoltWinters = "holt_winters"
+)
+
+// 预测状态
+const (
+	ForecastOK               = "ok"
+	ForecastInsufficientData = "insufficient_data" // 数据点太少或时间跨度太短
+	ForecastNotGrowing       = "not_growing"       // 用量没有增长趋势
+	ForecastFull             = "full"              // 已经写满
+	ForecastBeyondHorizon    = "beyond_horizon"    // 预测写满时间超出 forecastHorizon
+)
+
+const (
+	// 至少需要的数据点数和时间跨度
+	minForecastPoints = 6
+	minForecastSpan   = 24 * time.Hour
+	// 超过这个时长的预测没有参考意义
+	forecastHorizon = 3 * 365 * 24 * time.Hour
+	// Holt-Winters 的季节周期（按小时数据计为一天）和平滑系数
+	hwSeasonLength = 24
+	hwAlpha        = 0.3
+	hwBeta         = 0.05
+	hwGamma        = 0.1
+	// 置信区间为 95%
+	forecastConfidence = 0.95
+	z95                = 1.96
+)
+
+// UsagePoint 为某个时刻的磁盘用量
+type UsagePoint struct {
+	Time  time.Time
+	Used  float64
+	Total float64
+}
+
+// DiskForecast 为一个序列的磁盘写满预测，FullDateLower/FullDateUpper 为 95% 置信区间，
+// FullDateUpper 为空表示在预测范围内不一定会写满
+type DiskForecast struct {
+	Model         string     `json:"model"`
+	Status        string     `json:"status"`
+	DataPoints    int        `json:"data_points"`
+	DataFrom      *time.Time `json:"data_from,omitempty"`
+	DataTo        *time.Time `json:"data_to,omitempty"`
+	UsedDisk      float64    `json:"used_disk"`
+	TotalDisk     float64    `json:"total_disk"`
+	GrowthPerDay  float64    `json:"growth_per_day"`
+	DaysToFull    *float64   `json:"days_to_full"`
+	FullDate      *time.Time `json:"full_date"`
+	FullDateLower *time.Time `json:"full_date_lower"`
+	FullDateUpper *time.Time `json:"full_date_upper"`
+	Confidence    float64    `json:"confidence"`
+}
+
+// ForecastDiskFull 用指定模型预测 points（按时间升序）何时写满，容量取最后一个点的值
+func ForecastDiskFull(model string, points []UsagePoint) DiskForecast {
+	f := DiskForecast{Model: model, DataPoints: len(points), Confidence: forecastConfidence}
+	if len(points) == 0 {
+		f.Status = ForecastInsufficientData
+		return f
+	}
+	first, last := points[0], points[len(points)-1]
+	f.DataFrom, f.DataTo = &first.Time, &last.Time
+	f.UsedDisk, f.TotalDisk = last.Used, last.Total
+
+	if len(points) < minForecastPoints || last.Time.Sub(first.Time) < minForecastSpan || last.Total <= 0 {
+		f.Status = ForecastInsufficientData
+		return f
+	}
+	if last.Used >= last.Total {
+		f.Status = ForecastFull
+		zero := 0.0
+		f.DaysToFull, f.FullDate = &zero, &last.Time
+		return f
+	}
+
+	switch model {
+	case ModelHoltWinters:
+		forecastHoltWinters(&f, points)
+	default:
+		f.Model = ModelLinear
+		forecastLinear(&f, points)
+	}
+	return f
+}
+
+// days 返回 t 相对 origin 的天数
+func days(t, origin time.Time) float64 {
+	return t.Sub(origin).Hours() / 24
+}
+
+// addDays 把天数换算为时间点，超出预测范围时返回 nil
+func addDays(origin time.Time, d float64) *time.Time {
+	if d < 0 || math.IsInf(d, 0) || math.IsNaN(d) || d*24*float64(time.Hour) > float64(forecastHorizon) {
+		return nil
+	}
+	t := origin.Add(time.Duration(d * 24 * float64(time.Hour)))
+	return &t
+}
+
+// forecastLinear 对用量做最小二乘直线拟合，置信区间由斜率的标准误差推出
+func forecastLinear(f *DiskForecast, points []UsagePoint) {
+	origin := points[0].Time
+	n := float64(len(points))
+	var sumX, sumY float64
+	for _, p := range points {
+		sumX += days(p.Time, origin)
+		sumY += p.Used
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var sxx, sxy float64
+	for _, p := range points {
+		dx := days(p.Time, origin) - meanX
+		sxx += dx * dx
+		sxy += dx * (p.Used - meanY)
+	}
+	// 所有点在同一时刻时无法拟合
+	if sxx == 0 {
+		f.Status = ForecastInsufficientData
+		return
+	}
+	slope := sxy / sxx
+	intercept := meanY - slope*meanX
+
+	var sse float64
+	for _, p := range points {
+		r := p.Used - (intercept + slope*days(p.Time, origin))
+		sse += r * r
+	}
+	se := math.Sqrt(sse/(n-2)) / math.Sqrt(sxx)
+	margin := tQuantile975(len(points)-2) * se
+
+	f.GrowthPerDay = slope
+	if slope <= 0 {
+		f.Status = ForecastNotGrowing
+		return
+	}
+
+	// 从拟合直线在最后一个点的位置出发计算剩余天数。增长放缓时拟合值可能已经超过容量，此时按即将写满处理
+	last := points[len(points)-1]
+	remaining := max(last.Total-(intercept+slope*days(last.Time, origin)), 0)
+	setFullDates(f, last.Time, remaining/slope, remaining/(slope+margin), remaining/(slope-margin), slope-margin > 0)
+}
+
+// forecastHoltWinters 使用加法 Holt-Winters 模型（数据不足两个季节周期时退化为 Holt 线性趋势模型），
+// 置信区间按一步预测残差的标准差随预测步数的平方根扩大
+func forecastHoltWinters(f *DiskForecast, points []UsagePoint) {
+	values := make([]float64, len(points))
+	for i, p := range points {
+		values[i] = p.Used
+	}
+	// 平均采样间隔（天），用于把步数换算为天数
+	last := points[len(points)-1]
+	step := days(last.Time, points[0].Time) / float64(len(points)-1)
+
+	seasonLen := hwSeasonLength
+	if len(values) < 2*seasonLen {
+		seasonLen = 0
+	}
+	level, trend, season, sigma := holtWinters(values, seasonLen)
+
+	f.GrowthPerDay = trend / step
+	if trend <= 0 {
+		f.Status = ForecastNotGrowing
+		return
+	}
+
+	seasonal := func(h int) float64 {
+		if seasonLen == 0 {
+			return 0
+		}
+		return season[(len(values)+h-1)%seasonLen]
+	}
+	maxSteps := int(float64(forecastHorizon) / (step * 24 * float64(time.Hour)))
+	crossing := func(offset float64) float64 {
+		for h := 1; h <= maxSteps; h++ {
+			forecast := level + float64(h)*trend + seasonal(h)
+			if forecast+offset*sigma*math.Sqrt(float64(h)) >= last.Total {
+				return float64(h) * step
+			}
+		}
+		return math.Inf(1)
+	}
+	expected, earliest, latest := crossing(0), crossing(z95), crossing(-z95)
+	setFullDates(f, last.Time, expected, earliest, latest, !math.IsInf(latest, 1))
+}
+
+// holtWinters 返回平滑后的水平、趋势、季节分量以及一步预测残差的标准差，seasonLen 为 0 时不含季节分量
+func holtWinters(values []float64, seasonLen int) (level, trend float64, season []float64, sigma float64) {
+	start := 1
+	level = values[0]
+	trend = values[1] - values[0]
+	if seasonLen > 0 {
+		// 用前两个周期的均值差初始化趋势，用第一个周期去掉趋势后相对均值的偏差初始化季节分量。
+		// 周期均值对应周期的中点，水平需要沿趋势推到第一个周期的最后一个点
+		var first, second float64
+		for i := 0; i < seasonLen; i++ {
+			first += values[i]
+			second += values[seasonLen+i]
+		}
+		first /= float64(seasonLen)
+		second /= float64(seasonLen)
+		trend = (second - first) / float64(seasonLen)
+		mid := float64(seasonLen-1) / 2
+		level = first + mid*trend
+		season = make([]float64, seasonLen)
+		for i := 0; i < seasonLen; i++ {
+			season[i] = values[i] - (first + (float64(i)-mid)*trend)
+		}
+		start = seasonLen
+	}
+
+	var sse float64
+	var n int
+	for i := start; i < len(values); i++ {
+		var s float64
+		if seasonLen > 0 {
+			s = season[i%seasonLen]
+		}
+		predicted := level + trend + s
+		err := values[i] - predicted
+		sse += err * err
+		n++
+
+		prevLevel := level
+		level = hwAlpha*(values[i]-s) + (1-hwAlpha)*(level+trend)
+		trend = hwBeta*(level-prevLevel) + (1-hwBeta)*trend
+		if seasonLen > 0 {
+			season[i%seasonLen] = hwGamma*(values[i]-level) + (1-hwGamma)*s
+		}
+	}
+	if n > 0 {
+		sigma = math.Sqrt(sse / float64(n))
+	}
+	return level, trend, season, sigma
+}
+
+// setFullDates 填充预测结果，bounded 为 false 时置信区间上限为空
+func setFullDates(f *DiskForecast, from time.Time, expected, earliest, latest float64, bounded bool) {
+	f.FullDate = addDays(from, expected)
+	if f.FullDate == nil {
+		f.Status = ForecastBeyondHorizon
+		return
+	}
+	f.Status = ForecastOK
+	f.DaysToFull = &expected
+	f.FullDateLower = addDays(from, earliest)
+	if bounded {
+		f.FullDateUpper = addDays(from, latest)
+	}
+}
+
+// tQuantile975 返回自由度为 df 的 t 分布 97.5% 分位数，df 大于 30 时近似为正态分布
+func tQuantile975(df int) float64 {
+	table := []float64{
+		12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+		2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+		2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+	}
+	if df < 1 {
+		return math.Inf(1)
+	}
+	if df <= len(table) {
+		return table[df-1]
+	}
+	return z95
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 默认使用最近 30 天的小时数据做预测
+const defaultForecastDays = 30
+
+type ForecastService struct {
+	DB *gorm.DB
+}
+
+func NewForecastService(db *gorm.DB) *ForecastService {
+	return &ForecastService{DB: db}
+}
+
+// InstanceForecast 为单个实例的磁盘写满预测
+type InstanceForecast struct {
+	PoolID      uint         `json:"pool_id"`
+	IP          string       `json:"ip"`
+	Port        uint         `json:"port"`
+	ClusterName string       `json:"cluster_name"`
+	GroupName   string       `json:"group_name"`
+	Forecast    DiskForecast `json:"forecast"`
+}
+
+// ForecastResult 为主机、集群、组或全部实例的预测结果，Earliest 为最早写满的实例
+type ForecastResult struct {
+	Scope            string             `json:"scope"`
+	Target           string             `json:"target,omitempty"`
+	Model            string             `json:"model"`
+	LookbackDays     int                `json:"lookback_days"`
+	GeneratedAt      time.Time          `json:"generated_at"`
+	Instances        []InstanceForecast `json:"instances"`
+	Earliest         *InstanceForecast  `json:"earliest"`
+	InsufficientData int                `json:"insufficient_data"`
+}
+
+// ForecastInstances 按小时数据为 filter 范围内的每个实例做预测，filter 为空时包括全部实例，已删除主机上的实例除外
+func (s *ForecastService) ForecastInstances(model string, lookbackDays int, now time.Time, filter func(*gorm.DB) *gorm.DB) ([]InstanceForecast, error) {
+	from := now.Add(-time.Duration(lookbackDays) * 24 * time.Hour)
+	points, err := loadMetricPoints(s.DB, Resolution1h, from, now, chainScopes(filter, OnLiveHosts("pool_id")))
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[instanceKey]int)
+	var forecasts []InstanceForecast
+	var usage [][]UsagePoint
+	for _, p := range points {
+		key := instanceKey{p.PoolID, p.IP, p.Port}
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(forecasts)
+			forecasts = append(forecasts, InstanceForecast{PoolID: p.PoolID, IP: p.IP, Port: p.Port})
+			usage = append(usage, nil)
+			i = len(forecasts) - 1
+		}
+		forecasts[i].ClusterName, forecasts[i].GroupName = p.ClusterName, p.GroupName
+		usage[i] = append(usage[i], UsagePoint{Time: p.BucketStart, Used: p.AvgUsedDisk, Total: p.TotalDisk})
+	}
+	for i := range forecasts {
+		forecasts[i].Forecast = ForecastDiskFull(model, usage[i])
+	}
+	sort.Slice(forecasts, func(i, j int) bool {
+		a, b := forecasts[i], forecasts[j]
+		if a.PoolID != b.PoolID {
+			return a.PoolID < b.PoolID
+		}
+		if a.IP != b.IP {
+			return a.IP < b.IP
+		}
+		return a.Port < b.Port
+	})
+	return forecasts, nil
+}
+
+// EarliestFull 返回最早写满的实例，已写满的实例排在最前；没有可预测的实例时返回 nil
+func EarliestFull(forecasts []InstanceForecast) *InstanceForecast {
+	var earliest *InstanceForecast
+	for i := range forecasts {
+		f := &forecasts[i]
+		if f.Forecast.FullDate == nil {
+			continue
+		}
+		if earliest == nil || f.Forecast.FullDate.Before(*earliest.Forecast.FullDate) {
+			earliest = f
+		}
+	}
+	return earliest
+}
+
+// PredictDiskFullDate 预测磁盘写满时间
+// 参数：scope=host|cluster|group 和 id（不传时预测全部实例），model=linear|holt_winters，days 为使用的历史天数
+func (s *ForecastService) PredictDiskFullDate(c *gin.Context) {
+	result := ForecastResult{
+		Scope:        c.DefaultQuery("scope", "all"),
+		Target:       c.Query("id"),
+		Model:        c.DefaultQuery("model", ModelLinear),
+		LookbackDays: defaultForecastDays,
+		GeneratedAt:  time.Now().UTC(),
+	}
+	if result.Model != ModelLinear && result.Model != ModelHoltWinters {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown model %q, expected linear or holt_winters", result.Model)})
+		return
+	}
+	if v := c.Query("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 365 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 365"})
+			return
+		}
+		result.LookbackDays = n
+	}
+
+	var filter func(*gorm.DB) *gorm.DB
+	if result.Scope != "all" {
+		var err error
+		if filter, err = scopeFilter(result.Scope, result.Target); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	filter = chainScopes(filter, CurrentAccess(c).ClusterScope("cluster_name"))
+	forecasts, err := s.ForecastInstances(result.Model, result.LookbackDays, result.GeneratedAt, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	result.Instances = forecasts
+	if result.Instances == nil {
+		result.Instances = []InstanceForecast{}
+	}
+	result.Earliest = EarliestFull(forecasts)
+	for _, f := range forecasts {
+		if f.Forecast.Status == ForecastInsufficientData {
+			result.InsufficientData++
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+var forecastOrigin = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// series 生成 n 个间隔为 step 的点，容量为 1000，used 的参数为相对第一个点的天数
+func series(n int, step time.Duration, used func(d float64) float64) []UsagePoint {
+	points := make([]UsagePoint, n)
+	for i := range points {
+		t := forecastOrigin.Add(time.Duration(i) * step)
+		points[i] = UsagePoint{Time: t, Used: used(days(t, forecastOrigin)), Total: 1000}
+	}
+	return points
+}
+
+func values(used ...float64) []UsagePoint {
+	points := make([]UsagePoint, len(used))
+	for i, u := range used {
+		points[i] = UsagePoint{Time: forecastOrigin.Add(time.Duration(i) * 24 * time.Hour), Used: u, Total: 1000}
+	}
+	return points
+}
+
+type forecastCase struct {
+	name   string
+	points []UsagePoint
+	status string
+	// 以下只在 status 为 ok 时检查，tolerance 为天数的允许误差
+	growth    float64
+	daysLeft  float64
+	tolerance float64
+}
+
+func checkForecast(t *testing.T, model string, cases []forecastCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := ForecastDiskFull(model, tc.points)
+			if f.Status != tc.status {
+				t.Fatalf("status = %s, want %s", f.Status, tc.status)
+			}
+			if f.Status != ForecastOK {
+				return
+			}
+			if math.Abs(f.GrowthPerDay-tc.growth) > 1e-6 {
+				t.Errorf("growth = %v, want %v", f.GrowthPerDay, tc.growth)
+			}
+			if math.Abs(*f.DaysToFull-tc.daysLeft) > tc.tolerance {
+				t.Errorf("days to full = %v, want %v±%v", *f.DaysToFull, tc.daysLeft, tc.tolerance)
+			}
+			if f.FullDateLower == nil || f.FullDateLower.After(*f.FullDate) {
+				t.Errorf("lower bound %v after full date %v", f.FullDateLower, f.FullDate)
+			}
+			if f.FullDateUpper != nil && f.FullDateUpper.Before(*f.FullDate) {
+				t.Errorf("upper bound %v before full date %v", f.FullDateUpper, f.FullDate)
+			}
+		})
+	}
+}
+
+func TestForecastLinear(t *testing.T) {
+	checkForecast(t, ModelLinear, []forecastCase{
+		{name: "steady growth", points: series(73, time.Hour, func(d float64) float64 { return 100 + 10*d }),
+			status: ForecastOK, growth: 10, daysLeft: 87, tolerance: 1e-6},
+		{name: "daily points", points: values(100, 110, 120, 130, 140, 150),
+			status: ForecastOK, growth: 10, daysLeft: 85, tolerance: 1e-6},
+		{name: "flat", points: series(73, time.Hour, func(float64) float64 { return 500 }), status: ForecastNotGrowing},
+		{name: "shrinking", points: values(600, 550, 500, 450, 400, 350), status: ForecastNotGrowing},
+		{name: "too few points", points: values(100, 110, 120, 130, 140), status: ForecastInsufficientData},
+		{name: "span under a day", points: series(20, time.Hour, func(d float64) float64 { return 100 + 10*d }), status: ForecastInsufficientData},
+		{name: "already full", points: values(900, 950, 980, 990, 1000, 1000), status: ForecastFull},
+		{name: "beyond horizon", points: values(100, 100.1, 100.2, 100.3, 100.4, 100.5), status: ForecastBeyondHorizon},
+		// 增长放缓，拟合直线在最后一个点已经超过容量
+		{name: "fit above capacity", points: values(0, 600, 900, 980, 990, 995),
+			status: ForecastOK, growth: 3112.5 / 17.5, daysLeft: 0, tolerance: 1e-6},
+	})
+
+	// 带噪声时区间应当包含预测值且不为空
+	noisy := series(73, time.Hour, func(d float64) float64 { return 100 + 10*d + 20*math.Sin(37*d) })
+	f := ForecastDiskFull(ModelLinear, noisy)
+	if f.Status != ForecastOK || f.FullDateUpper == nil || !f.FullDateLower.Before(*f.FullDateUpper) {
+		t.Errorf("noisy: status %s, interval %v - %v", f.Status, f.FullDateLower, f.FullDateUpper)
+	}
+}
+
+func TestForecastLinearSameTime(t *testing.T) {
+	points := make([]UsagePoint, minForecastPoints)
+	for i := range points {
+		points[i] = UsagePoint{Time: forecastOrigin, Used: float64(100 + i), Total: 1000}
+	}
+	var f DiskForecast
+	forecastLinear(&f, points)
+	if f.Status != ForecastInsufficientData {
+		t.Errorf("status = %s, want %s", f.Status, ForecastInsufficientData)
+	}
+}
+
+func TestForecastHoltWinters(t *testing.T) {
+	hour := 1.0 / 24
+	checkForecast(t, ModelHoltWinters, []forecastCase{
+		// 不足两个周期，不含季节分量
+		{name: "holt linear", points: series(30, time.Hour, func(d float64) float64 { return 100 + 10*d }),
+			status: ForecastOK, growth: 10, daysLeft: (1000 - (100 + 10*29*hour)) / 10, tolerance: hour},
+		{name: "seasonal steady growth", points: series(73, time.Hour, func(d float64) float64 { return 100 + 10*d }),
+			status: ForecastOK, growth: 10, daysLeft: 87, tolerance: hour},
+		// 每天 +-50 的波动，写满发生在趋势线到达 950 后的第一个波峰附近
+		{name: "daily cycle", points: series(7*24+1, time.Hour, func(d float64) float64 { return 100 + 10*d + 50*math.Sin(2*math.Pi*d) }),
+			status: ForecastOK, growth: 10, daysLeft: 78.21, tolerance: hour},
+		{name: "shrinking", points: series(73, time.Hour, func(d float64) float64 { return 900 - 10*d }), status: ForecastNotGrowing},
+		{name: "too few points", points: series(5, 6*time.Hour, func(d float64) float64 { return 100 + 10*d }), status: ForecastInsufficientData},
+	})
+
+	noisy := series(7*24+1, time.Hour, func(d float64) float64 { return 100 + 10*d + 20*math.Sin(37*d) })
+	f := ForecastDiskFull(ModelHoltWinters, noisy)
+	if f.Status != ForecastOK || f.FullDateUpper == nil || !f.FullDateLower.Before(*f.FullDateUpper) {
+		t.Errorf("noisy: status %s, interval %v - %v", f.Status, f.FullDateLower, f.FullDateUpper)
+	}
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 500
+)
+
+// Page 是分页列表接口统一的返回结构
+type Page[T any] struct {
+	Items    []T   `json:"items"`
+	Total    int64 `json:"total"`
+	Page     int   `json:"page"`
+	PageSize int   `json:"page_size"`
+}
+
+// QueryList 读取可多选的查询参数，既支持 ?k=a&k=b 也支持 ?k=a,b
+func QueryList(c *gin.Context, key string) []string {
+	var values []string
+	for _, raw := range c.QueryArray(key) {
+		for _, v := range strings.Split(raw, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// pagination 解析 page/page_size 参数
+func pagination(c *gin.Context) (page, pageSize int, ok bool) {
+	page, pageSize = 1, defaultPageSize
+	if raw := c.Query("page"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive integer"})
+			return 0, 0, false
+		}
+		page = n
+	}
+	if raw := c.Query("page_size"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "page_size must be between 1 and 500"})
+			return 0, 0, false
+		}
+		pageSize = n
+	}
+	return page, pageSize, true
+}
+
+// sortClause 把 sort/order 参数转换为排序子句，只允许模型中存在的列
+func sortClause(c *gin.Context, db *gorm.DB, model interface{}, table string) (clause.OrderBy, bool) {
+	orderBy := clause.OrderBy{}
+	column := c.Query("sort")
+	if column == "" {
+		column = "id"
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return orderBy, false
+	}
+	if _, exists := stmt.Schema.FieldsByDBName[column]; !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown sort column " + strconv.Quote(column)})
+		return orderBy, false
+	}
+
+	var desc bool
+	switch strings.ToLower(c.DefaultQuery("order", "asc")) {
+	case "asc", "ascend":
+	case "desc", "descend":
+		desc = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return orderBy, false
+	}
+
+	orderBy.Columns = append(orderBy.Columns, clause.OrderByColumn{Column: clause.Column{Table: table, Name: column}, Desc: desc})
+	if column != "id" {
+		// 追加主键保证分页顺序稳定
+		orderBy.Columns = append(orderBy.Columns, clause.OrderByColumn{Column: clause.Column{Table: table, Name: "id"}})
+	}
+	return orderBy, true
+}
+
+// HostFilterOptions 是主机列表各个筛选下拉框的可选值
+type HostFilterOptions struct {
+	IDCs            []string `json:"idcs"`
+	ServerTypes     []string `json:"server_types"`
+	DepartmentNames []string `json:"department_names"`
+}
+
+// ListHosts 分页返回主机及其应用，支持 ip 模糊匹配、idc/server_type/department_name 多选筛选和任意列排序，
+// 指定 as_of 时返回该时刻的主机
+func (s *HostService) ListHosts(c *gin.Context) {
+	page, pageSize, ok := pagination(c)
+	if !ok {
+		return
+	}
+	orderBy, ok := sortClause(c, s.DB, &models.HostPool{}, "hosts_pool")
+	if !ok {
+		return
+	}
+	if at, present, ok := asOfParam(c); !ok {
+		return
+	} else if present {
+		s.listHostsAsOf(c, at, page, pageSize, orderBy)
+		return
+	}
+
+	query := s.DB.Model(&models.HostPool{})
+	if !IncludeDeleted(c) {
+		query = query.Scopes(NotDeletedHosts)
+	}
+
+	if ip := c.Query("ip"); ip != "" {
+		query = query.Where("hosts_pool.host_ip LIKE ? ESCAPE '!'", "%"+escapeLike(ip)+"%")
+	}
+
+	if idcs := QueryList(c, "idc"); len(idcs) > 0 {
+		query = query.Where("hosts_pool.idc_name IN ?", idcs)
+	}
+
+	if types := QueryList(c, "server_type"); len(types) > 0 {
+		query = query.Where(`EXISTS (SELECT 1 FROM hosts_applications ha
+			WHERE ha.pool_id = hosts_pool.id AND ha.deleted_at IS NULL AND ha.server_type IN ?)`, types)
+	}
+
+	if departments := QueryList(c, "department_name"); len(departments) > 0 {
+		query = query.Scopes(hostsInDepartments(departments))
+	}
+	access := CurrentAccess(c)
+	query = query.Scopes(access.HostScope)
+
+	// 统计总数和查询当前页共用同一组条件
+	query = query.Session(&gorm.Session{})
+	result := Page[models.HostPool]{Page: page, PageSize: pageSize, Items: []models.HostPool{}}
+	if err := query.Count(&result.Total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := query.Preload("HostApplications").Clauses(orderBy).
+		Offset((page - 1) * pageSize).Limit(pageSize).Find(&result.Items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	departments, err := departmentsByCluster(s.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range result.Items {
+		result.Items[i].HostApplications = visibleApplications(access, result.Items[i].HostApplications, departments)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// hostsInDepartments 筛选部署了指定部门应用的主机。部门以集群登记的部门为准，集群未登记时回退到应用上的 department_name
+func hostsInDepartments(departments []string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(`EXISTS (SELECT 1 FROM hosts_applications ha
+			LEFT JOIN cluster_groups cg ON cg.cluster_name = ha.cluster_name AND cg.deleted_at IS NULL
+			WHERE ha.pool_id = hosts_pool.id AND ha.deleted_at IS NULL
+			AND COALESCE(cg.department_name, ha.department_name) IN ?)`, departments)
+	}
+}
+
+// visibleApplications 按集群登记的部门修正应用的 department_name，并去掉当前用户不可见部门的应用
+func visibleApplications(access *Access, apps []models.HostApplication, departments map[string]string) []models.HostApplication {
+	visible := make([]models.HostApplication, 0, len(apps))
+	for _, app := range apps {
+		if dept, ok := departments[app.ClusterName]; ok {
+			app.DepartmentName = dept
+		}
+		if access.Allows(app.DepartmentName, RoleViewer) {
+			visible = append(visible, app)
+		}
+	}
+	return visible
+}
+
+// GetHostFilterOptions 返回主机列表筛选条件的可选值
+func (s *HostService) GetHostFilterOptions(c *gin.Context) {
+	options := HostFilterOptions{IDCs: []string{}, ServerTypes: []string{}, DepartmentNames: []string{}}
+	access := CurrentAccess(c)
+
+	if err := s.DB.Model(&models.HostPool{}).Scopes(NotDeletedHosts, access.HostScope).
+		Distinct().Order("idc_name").Pluck("idc_name", &options.IDCs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.DB.Model(&models.HostApplication{}).Scopes(access.ApplicationScope).Where("server_type <> ''").
+		Distinct().Order("server_type").Pluck("server_type", &options.ServerTypes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.DB.Model(&models.ClusterGroup{}).Scopes(access.DepartmentScope("department_name")).
+		Distinct().Order("department_name").Pluck("department_name", &options.DepartmentNames).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+// departmentsByCluster 返回集群名到部门的映射
+func departmentsByCluster(db *gorm.DB) (map[string]string, error) {
+	var groups []models.ClusterGroup
+	if err := db.Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	departments := make(map[string]string, len(groups))
+	for _, g := range groups {
+		departments[g.ClusterName] = g.DepartmentName
+	}
+	return departments, nil
+}
+
+// escapeLike 转义 LIKE 中的通配符；使用 ! 作为转义符，MySQL 和 SQLite 写法一致
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
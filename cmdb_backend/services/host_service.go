@@ -0,0 +1,246 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type HostService struct {
+	DB *gorm.DB
+	// IDCName 根据 IP 判断主机所属机房，写入主机时保存到 idc_name 列
+	IDCName func(ip string) string
+}
+
+func NewHostService(db *gorm.DB, idcName func(ip string) string) *HostService {
+	return &HostService{DB: db, IDCName: idcName}
+}
+
+// HostInput 是创建和修改主机时接受的字段，PATCH 时未出现的字段保持不变
+type HostInput struct {
+	HostName        *string `json:"host_name" binding:"omitempty,min=1,max=50"`
+	HostIP          *string `json:"host_ip" binding:"omitempty,ip"`
+	HostType        *string `json:"host_type" binding:"omitempty,oneof=0 1"`
+	H3cID           *string `json:"h3c_id" binding:"omitempty,max=50"`
+	H3cStatus       *string `json:"h3c_status" binding:"omitempty,max=20"`
+	DiskSize        *uint   `json:"disk_size"`
+	RAM             *uint   `json:"ram"`
+	VCPUs           *uint   `json:"vcpus"`
+	IfH3cSync       *string `json:"if_h3c_sync" binding:"omitempty,max=10"`
+	H3cImgID        *string `json:"h3c_img_id" binding:"omitempty,max=50"`
+	H3cHmName       *string `json:"h3c_hm_name" binding:"omitempty,max=1000"`
+	LeafNumber      *string `json:"leaf_number" binding:"omitempty,max=50"`
+	RackNumber      *string `json:"rack_number" binding:"omitempty,max=10"`
+	RackHeight      *uint   `json:"rack_height"`
+	RackStartNumber *uint   `json:"rack_start_number"`
+	FromFactor      *uint   `json:"from_factor"`
+	SerialNumber    *string `json:"serial_number" binding:"omitempty,max=50"`
+	IsStatic        *bool   `json:"is_static"`
+	// IsDeleted 只在 PATCH 中生效，用于恢复被软删除的主机
+	IsDeleted *bool `json:"is_deleted"`
+}
+
+func (in *HostInput) applyTo(host *models.HostPool) {
+	setIf(&host.HostName, in.HostName)
+	if in.HostIP != nil {
+		// 统一 IPv6 的写法，避免同一地址以不同形式重复录入
+		host.HostIP = net.ParseIP(*in.HostIP).String()
+	}
+	setIf(&host.HostType, in.HostType)
+	setIf(&host.H3cID, in.H3cID)
+	setIf(&host.H3cStatus, in.H3cStatus)
+	setIf(&host.DiskSize, in.DiskSize)
+	setIf(&host.RAM, in.RAM)
+	setIf(&host.VCPUs, in.VCPUs)
+	setIf(&host.IfH3cSync, in.IfH3cSync)
+	setIf(&host.H3cImgID, in.H3cImgID)
+	setIf(&host.H3cHmName, in.H3cHmName)
+	setIf(&host.LeafNumber, in.LeafNumber)
+	setIf(&host.RackNumber, in.RackNumber)
+	setIf(&host.RackHeight, in.RackHeight)
+	setIf(&host.RackStartNumber, in.RackStartNumber)
+	setIf(&host.FromFactor, in.FromFactor)
+	setIf(&host.SerialNumber, in.SerialNumber)
+	setIf(&host.IsStatic, in.IsStatic)
+}
+
+func setIf[T any](dst *T, src *T) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+// NotDeletedHosts 过滤掉已软删除的主机，所有读取主机的接口默认都应使用
+func NotDeletedHosts(db *gorm.DB) *gorm.DB {
+	return db.Where("hosts_pool.is_deleted = ?", false)
+}
+
+// OnLiveHosts 只保留 column（pool_id 列）指向未软删除主机的行，用于 server_resources、metric_samples 等按主机记录的数据
+func OnLiveHosts(column string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		hosts := db.Session(&gorm.Session{NewDB: true}).Model(&models.HostPool{}).Select("hosts_pool.id").Scopes(NotDeletedHosts)
+		return db.Where(column+" IN (?)", hosts)
+	}
+}
+
+// IncludeDeleted 判断请求是否显式要求返回已软删除的主机
+func IncludeDeleted(c *gin.Context) bool {
+	include, _ := strconv.ParseBool(c.Query("include_deleted"))
+	return include
+}
+
+// checkHostIP 检查 host_ip 是否已被其他主机（包括已软删除的主机）占用
+func (s *HostService) checkHostIP(c *gin.Context, ip string, selfID uint) bool {
+	var existing models.HostPool
+	err := s.DB.Where("host_ip = ? AND id <> ?", ip, selfID).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	c.JSON(http.StatusConflict, gin.H{
+		"error":      "host_ip already exists",
+		"id":         existing.ID,
+		"is_deleted": existing.IsDeleted,
+	})
+	return false
+}
+
+// respondSaveError 返回写入主机失败的错误。查重之后其他请求抢先写入了相同 host_ip 时，唯一索引会拒绝写入，同样返回 409
+func (s *HostService) respondSaveError(c *gin.Context, host *models.HostPool, err error) {
+	if !errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if s.checkHostIP(c, host.HostIP, host.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "host_ip already exists"})
+	}
+}
+
+func (s *HostService) findHost(c *gin.Context, includeDeleted bool) (*models.HostPool, bool) {
+	query := s.DB
+	if !includeDeleted {
+		query = query.Scopes(NotDeletedHosts)
+	}
+
+	var host models.HostPool
+	err := query.First(&host, c.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Host not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &host, true
+}
+
+func bindHostInput(c *gin.Context, full bool) (*HostInput, bool) {
+	var in HostInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if full && (in.HostName == nil || in.HostIP == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "host_name and host_ip are required"})
+		return nil, false
+	}
+	return &in, true
+}
+
+func (s *HostService) CreateHost(c *gin.Context) {
+	in, ok := bindHostInput(c, true)
+	if !ok {
+		return
+	}
+
+	var host models.HostPool
+	in.applyTo(&host)
+	host.IDCName = s.IDCName(host.HostIP)
+	if !s.checkHostIP(c, host.HostIP, 0) {
+		return
+	}
+
+	if err := s.DB.WithContext(c).Create(&host).Error; err != nil {
+		s.respondSaveError(c, &host, err)
+		return
+	}
+	c.JSON(http.StatusCreated, host)
+}
+
+// UpdateHost 用请求体整体替换主机信息，未提供的字段会被清空
+func (s *HostService) UpdateHost(c *gin.Context) {
+	existing, ok := s.findHost(c, false)
+	if !ok {
+		return
+	}
+	in, ok := bindHostInput(c, true)
+	if !ok {
+		return
+	}
+
+	host := models.HostPool{ID: existing.ID, CreateTime: existing.CreateTime}
+	in.applyTo(&host)
+	host.IDCName = s.IDCName(host.HostIP)
+	if !s.checkHostIP(c, host.HostIP, host.ID) {
+		return
+	}
+
+	if err := s.DB.WithContext(c).Save(&host).Error; err != nil {
+		s.respondSaveError(c, &host, err)
+		return
+	}
+	c.JSON(http.StatusOK, host)
+}
+
+func (s *HostService) PatchHost(c *gin.Context) {
+	host, ok := s.findHost(c, true)
+	if !ok {
+		return
+	}
+	in, ok := bindHostInput(c, false)
+	if !ok {
+		return
+	}
+	// 已删除的主机只允许通过 is_deleted=false 恢复
+	if host.IsDeleted && (in.IsDeleted == nil || *in.IsDeleted) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Host not found"})
+		return
+	}
+
+	in.applyTo(host)
+	host.IDCName = s.IDCName(host.HostIP)
+	setIf(&host.IsDeleted, in.IsDeleted)
+	if in.HostIP != nil && !s.checkHostIP(c, host.HostIP, host.ID) {
+		return
+	}
+
+	if err := s.DB.WithContext(c).Save(host).Error; err != nil {
+		s.respondSaveError(c, host, err)
+		return
+	}
+	c.JSON(http.StatusOK, host)
+}
+
+// DeleteHost 通过 is_deleted 标记软删除主机
+func (s *HostService) DeleteHost(c *gin.Context) {
+	host, ok := s.findHost(c, false)
+	if !ok {
+		return
+	}
+
+	if err := s.DB.WithContext(c).Model(host).Update("is_deleted", true).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Host deleted successfully"})
+}
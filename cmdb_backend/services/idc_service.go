@@ -0,0 +1,331 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// UnassignedIDC 是没有匹配到任何网段的主机所归属的机房
+const UnassignedIDC = "unassigned"
+
+type idcNetwork struct {
+	prefix netip.Prefix
+	idc    models.IDC
+}
+
+// IDCResolver 根据 idc 表中的网段判断 IP 所属机房，按最长前缀匹配，支持 IPv6
+type IDCResolver struct {
+	db *gorm.DB
+
+	mu       sync.RWMutex
+	networks []idcNetwork
+	// version 为加载网段时 idc 表的版本，见 tableVersion
+	version string
+}
+
+func NewIDCResolver(db *gorm.DB) (*IDCResolver, error) {
+	r := &IDCResolver{db: db}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// tableVersion 用行数和最后修改时间标识 idc 表的内容，新增、修改和删除网段都会改变它
+func (r *IDCResolver) tableVersion() (string, error) {
+	var v struct {
+		Count  int64
+		Latest *string
+	}
+	if err := r.db.Model(&models.IDC{}).Select("COUNT(*) AS count, MAX(updated_at) AS latest").Scan(&v).Error; err != nil {
+		return "", err
+	}
+	if v.Latest == nil {
+		return fmt.Sprint(v.Count), nil
+	}
+	return fmt.Sprintf("%d/%s", v.Count, *v.Latest), nil
+}
+
+// Reload 从数据库重新加载网段。无法解析的网段（只可能是绕过接口直接写入的）记录日志后忽略
+func (r *IDCResolver) Reload() error {
+	version, err := r.tableVersion()
+	if err != nil {
+		return err
+	}
+	var rows []models.IDC
+	if err := r.db.Find(&rows).Error; err != nil {
+		return err
+	}
+
+	networks := make([]idcNetwork, 0, len(rows))
+	for _, row := range rows {
+		prefix, err := netip.ParsePrefix(row.CIDR)
+		if err != nil {
+			log.Printf("IDC network %d (%s) ignored: invalid cidr %q: %v", row.ID, row.Name, row.CIDR, err)
+			continue
+		}
+		networks = append(networks, idcNetwork{prefix: prefix, idc: row})
+	}
+	// 前缀越长越优先
+	sort.SliceStable(networks, func(i, j int) bool {
+		return networks[i].prefix.Bits() > networks[j].prefix.Bits()
+	})
+
+	r.mu.Lock()
+	r.networks = networks
+	r.version = version
+	r.mu.Unlock()
+	return nil
+}
+
+// Refresh 在 idc 表有变化时重新加载网段，用于发现其他实例对网段的修改
+func (r *IDCResolver) Refresh() error {
+	version, err := r.tableVersion()
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	unchanged := version == r.version
+	r.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	return r.Reload()
+}
+
+// Run 每隔 interval 检查一次 idc 表，interval 不大于 0 时只在本实例修改网段后重新加载
+func (r *IDCResolver) Run(interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := r.Refresh(); err != nil {
+				log.Printf("IDC networks refresh: %v", err)
+			}
+		}
+	}
+}
+
+// Lookup 返回 IP 命中的网段，未命中时 ok 为 false
+func (r *IDCResolver) Lookup(ip string) (models.IDC, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return models.IDC{}, false
+	}
+	addr = addr.Unmap()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, n := range r.networks {
+		if n.prefix.Contains(addr) {
+			return n.idc, true
+		}
+	}
+	return models.IDC{}, false
+}
+
+// Resolve 返回 IP 所属机房名称，未命中时返回 UnassignedIDC
+func (r *IDCResolver) Resolve(ip string) string {
+	if idc, ok := r.Lookup(ip); ok {
+		return idc.Name
+	}
+	return UnassignedIDC
+}
+
+// AssignHosts 按当前网段重新计算主机的 idc_name，只更新所属机房有变化的主机
+func (r *IDCResolver) AssignHosts(db *gorm.DB) error {
+	changed := make(map[string][]uint)
+	var hosts []models.HostPool
+	err := db.Model(&models.HostPool{}).Select("id", "host_ip", "idc_name").
+		FindInBatches(&hosts, 1000, func(*gorm.DB, int) error {
+			for _, host := range hosts {
+				if name := r.Resolve(host.HostIP); name != host.IDCName {
+					changed[name] = append(changed[name], host.ID)
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	for name, ids := range changed {
+		for start := 0; start < len(ids); start += 500 {
+			batch := ids[start:min(start+500, len(ids))]
+			// idc_name 由 host_ip 推导而来，不更新 update_time
+			if err := db.Model(&models.HostPool{}).Where("id IN ?", batch).UpdateColumn("idc_name", name).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type IDCService struct {
+	DB       *gorm.DB
+	Resolver *IDCResolver
+}
+
+func NewIDCService(db *gorm.DB, resolver *IDCResolver) *IDCService {
+	return &IDCService{DB: db, Resolver: resolver}
+}
+
+type IDCInput struct {
+	Name        *string `json:"name" binding:"omitempty,min=1,max=64"`
+	CIDR        *string `json:"cidr" binding:"omitempty,min=1,max=64"`
+	Description *string `json:"description" binding:"omitempty,max=255"`
+}
+
+func (in *IDCInput) applyTo(idc *models.IDC) error {
+	if in.CIDR != nil {
+		prefix, err := netip.ParsePrefix(*in.CIDR)
+		if err != nil {
+			return err
+		}
+		// 统一保存为网络地址形式，例如 192.1.3.4/16 保存为 192.1.0.0/16
+		idc.CIDR = prefix.Masked().String()
+	}
+	setIf(&idc.Name, in.Name)
+	setIf(&idc.Description, in.Description)
+	return nil
+}
+
+func (s *IDCService) findIDC(c *gin.Context) (*models.IDC, bool) {
+	var idc models.IDC
+	err := s.DB.First(&idc, c.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "IDC network not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &idc, true
+}
+
+func (s *IDCService) save(c *gin.Context, idc *models.IDC, status int) {
+	var existing models.IDC
+	err := s.DB.Where("cidr = ? AND id <> ?", idc.CIDR, idc.ID).First(&existing).Error
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "cidr already exists", "id": existing.ID})
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.DB.WithContext(c).Save(idc).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.reassign(c); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(status, idc)
+}
+
+// reassign 在网段变化后重新加载网段并更新主机的所属机房
+func (s *IDCService) reassign(c *gin.Context) error {
+	if err := s.Resolver.Reload(); err != nil {
+		return err
+	}
+	return s.Resolver.AssignHosts(s.DB.WithContext(c))
+}
+
+func (s *IDCService) ListIDCs(c *gin.Context) {
+	var idcs []models.IDC
+	if err := s.DB.Order("name, cidr").Find(&idcs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, idcs)
+}
+
+func (s *IDCService) CreateIDC(c *gin.Context) {
+	var in IDCInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if in.Name == nil || in.CIDR == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name and cidr are required"})
+		return
+	}
+
+	var idc models.IDC
+	if err := in.applyTo(&idc); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.save(c, &idc, http.StatusCreated)
+}
+
+func (s *IDCService) UpdateIDC(c *gin.Context) {
+	idc, ok := s.findIDC(c)
+	if !ok {
+		return
+	}
+	var in IDCInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := in.applyTo(idc); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.save(c, idc, http.StatusOK)
+}
+
+func (s *IDCService) DeleteIDC(c *gin.Context) {
+	idc, ok := s.findIDC(c)
+	if !ok {
+		return
+	}
+	if err := s.DB.WithContext(c).Delete(idc).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.reassign(c); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "IDC network deleted successfully"})
+}
+
+// ResolveIP 返回指定 IP 所属的机房和命中的网段
+func (s *IDCService) ResolveIP(c *gin.Context) {
+	ip := c.Query("ip")
+	if _, err := netip.ParseAddr(ip); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ip"})
+		return
+	}
+	idc, ok := s.Resolver.Lookup(ip)
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"ip": ip, "idc": UnassignedIDC})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ip": ip, "idc": idc.Name, "cidr": idc.CIDR})
+}
@@ -0,0 +1,432 @@
+package services
+
+import (
+	"cmp"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cmdb/audit"
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// inventoryTables 保存历史版本的表，用于查询某一时刻的主机、应用和集群
+var inventoryTables = []string{"hosts_pool", "hosts_applications", "cluster_groups"}
+
+// BaselineInventory 为还没有历史版本的主机、应用和集群（启用版本记录之前录入的数据）补上从现在开始的版本
+func BaselineInventory(db *gorm.DB) error {
+	n, err := audit.Baseline(db, &models.HostPool{}, &models.HostApplication{}, &models.ClusterGroup{})
+	if n > 0 {
+		log.Printf("Recorded baseline versions of %d inventory rows", n)
+	}
+	return err
+}
+
+// inventory 为某一时刻的主机、应用和集群，都按 id 排列
+type inventory struct {
+	hosts  []models.HostPool
+	apps   []models.HostApplication
+	groups []models.ClusterGroup
+}
+
+// latestVersions 按 entity_id 归并版本，同一行有多个版本时以最后开始的为准（versions 按开始时间先后排列）
+func latestVersions(versions []models.RowVersion) map[string]models.RowVersion {
+	result := make(map[string]models.RowVersion, len(versions))
+	for _, v := range versions {
+		result[v.EntityID] = v
+	}
+	return result
+}
+
+// inventoryScope 为下推到版本表的主机条件：hostID 为单台主机，ip 为 IP 中包含的片段。
+// 有条件时只加载符合条件的主机的应用，以及这些应用所在集群的登记
+type inventoryScope struct {
+	hostID string
+	ip     string
+}
+
+// scopedVersions 返回 table 在 at 时刻有效的版本中 column 的值属于 values 的部分，values 分批查询
+func scopedVersions(db *gorm.DB, at time.Time, table, column string, values []interface{}) ([]models.RowVersion, error) {
+	var versions []models.RowVersion
+	for start := 0; start < len(values); start += 500 {
+		var batch []models.RowVersion
+		err := audit.AsOf(db, at, table).Where(audit.Value(db, column)+" IN ?", values[start:min(start+500, len(values))]).
+			Order("valid_from, id").Find(&batch).Error
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, batch...)
+	}
+	return versions, nil
+}
+
+// loadInventory 由历史版本还原 at 时刻 scope 内的主机、应用和集群
+func loadInventory(db *gorm.DB, at time.Time, scope inventoryScope) (*inventory, error) {
+	inv := &inventory{}
+	hostQuery := audit.AsOf(db, at, "hosts_pool")
+	if scope.hostID != "" {
+		hostQuery = hostQuery.Where("entity_id = ?", scope.hostID)
+	}
+	if scope.ip != "" {
+		hostQuery = hostQuery.Where(audit.Value(db, "host_ip")+" LIKE ? ESCAPE '!'", "%"+escapeLike(scope.ip)+"%")
+	}
+	var hostVersions []models.RowVersion
+	if err := hostQuery.Order("valid_from, id").Find(&hostVersions).Error; err != nil {
+		return nil, err
+	}
+	for _, v := range latestVersions(hostVersions) {
+		var host models.HostPool
+		if err := audit.Decode(db, v, &host); err != nil {
+			return nil, err
+		}
+		inv.hosts = append(inv.hosts, host)
+	}
+
+	scoped := scope != inventoryScope{}
+	var appVersions []models.RowVersion
+	var err error
+	if scoped {
+		hostIDs := make([]interface{}, len(inv.hosts))
+		for i, host := range inv.hosts {
+			hostIDs[i] = host.ID
+		}
+		appVersions, err = scopedVersions(db, at, "hosts_applications", "pool_id", hostIDs)
+	} else {
+		appVersions, err = audit.Versions(db, at, "hosts_applications")
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range latestVersions(appVersions) {
+		var app models.HostApplication
+		if err := audit.Decode(db, v, &app); err != nil {
+			return nil, err
+		}
+		inv.apps = append(inv.apps, app)
+	}
+
+	var groupVersions []models.RowVersion
+	if scoped {
+		var clusters []interface{}
+		seen := make(map[string]bool)
+		for _, app := range inv.apps {
+			if !seen[app.ClusterName] {
+				seen[app.ClusterName] = true
+				clusters = append(clusters, app.ClusterName)
+			}
+		}
+		groupVersions, err = scopedVersions(db, at, "cluster_groups", "cluster_name", clusters)
+	} else {
+		groupVersions, err = audit.Versions(db, at, "cluster_groups")
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range latestVersions(groupVersions) {
+		var group models.ClusterGroup
+		if err := audit.Decode(db, v, &group); err != nil {
+			return nil, err
+		}
+		inv.groups = append(inv.groups, group)
+	}
+	sort.Slice(inv.hosts, func(i, j int) bool { return inv.hosts[i].ID < inv.hosts[j].ID })
+	sort.Slice(inv.apps, func(i, j int) bool { return inv.apps[i].ID < inv.apps[j].ID })
+	sort.Slice(inv.groups, func(i, j int) bool { return inv.groups[i].ID < inv.groups[j].ID })
+	return inv, nil
+}
+
+// departments 返回集群名到部门的映射
+func (inv *inventory) departments() map[string]string {
+	departments := make(map[string]string, len(inv.groups))
+	for _, g := range inv.groups {
+		departments[g.ClusterName] = g.DepartmentName
+	}
+	return departments
+}
+
+// applications 返回按主机分组的应用，部门按当时集群登记的部门修正
+func (inv *inventory) applications() map[uint][]models.HostApplication {
+	departments := inv.departments()
+	apps := make(map[uint][]models.HostApplication)
+	for _, app := range inv.apps {
+		if dept, ok := departments[app.ClusterName]; ok {
+			app.DepartmentName = dept
+		}
+		apps[app.PoolID] = append(apps[app.PoolID], app)
+	}
+	return apps
+}
+
+// asOfParam 解析 as_of 参数（RFC3339 或 2006-01-02），present 为 false 表示查询当前数据，格式无效时返回 400
+func asOfParam(c *gin.Context) (at time.Time, present, ok bool) {
+	value := c.Query("as_of")
+	if value == "" {
+		return time.Time{}, false, true
+	}
+	at, err := parseTimeParam(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid as_of"})
+		return time.Time{}, true, false
+	}
+	return at, true, true
+}
+
+// listHostsAsOf 按 ListHosts 的筛选、排序和分页规则返回 at 时刻的主机，机房按当前的网段划分
+func (s *HostService) listHostsAsOf(c *gin.Context, at time.Time, page, pageSize int, orderBy clause.OrderBy) {
+	ip := c.Query("ip")
+	inv, err := loadInventory(s.DB, at, inventoryScope{ip: ip})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	apps := inv.applications()
+	access := CurrentAccess(c)
+	visibleDepartments, all := access.Visible()
+
+	includeDeleted := IncludeDeleted(c)
+	idcs := make(map[string]bool)
+	for _, idc := range QueryList(c, "idc") {
+		idcs[idc] = true
+	}
+	types := QueryList(c, "server_type")
+	departments := QueryList(c, "department_name")
+
+	hosts := []models.HostPool{}
+	for _, host := range inv.hosts {
+		switch {
+		case !includeDeleted && host.IsDeleted,
+			ip != "" && !strings.Contains(host.HostIP, ip),
+			len(idcs) > 0 && !idcs[s.IDCName(host.HostIP)],
+			len(types) > 0 && !anyApplication(apps[host.ID], func(a models.HostApplication) string { return a.ServerType }, types),
+			len(departments) > 0 && !anyApplication(apps[host.ID], func(a models.HostApplication) string { return a.DepartmentName }, departments),
+			!all && !anyApplication(apps[host.ID], func(a models.HostApplication) string { return a.DepartmentName }, visibleDepartments):
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	if err := sortHosts(s.DB, hosts, orderBy.Columns[0]); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := Page[models.HostPool]{Page: page, PageSize: pageSize, Items: []models.HostPool{}, Total: int64(len(hosts))}
+	start := min((page-1)*pageSize, len(hosts))
+	end := min(start+pageSize, len(hosts))
+	for _, host := range hosts[start:end] {
+		host.HostApplications = visibleApplications(access, apps[host.ID], nil)
+		result.Items = append(result.Items, host)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// anyApplication 判断 apps 中是否有应用的 value 属于 values
+func anyApplication(apps []models.HostApplication, value func(models.HostApplication) string, values []string) bool {
+	for _, app := range apps {
+		for _, v := range values {
+			if value(app) == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sortHosts 按 sortClause 校验过的列排序，相同时按 id 排列
+func sortHosts(db *gorm.DB, hosts []models.HostPool, column clause.OrderByColumn) error {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&models.HostPool{}); err != nil {
+		return err
+	}
+	field := stmt.Schema.FieldsByDBName[column.Column.Name]
+	sort.SliceStable(hosts, func(i, j int) bool {
+		a, _ := field.ValueOf(db.Statement.Context, reflect.ValueOf(hosts[i]))
+		b, _ := field.ValueOf(db.Statement.Context, reflect.ValueOf(hosts[j]))
+		if n := compareValues(a, b); n != 0 {
+			return (n < 0) != column.Desc
+		}
+		return hosts[i].ID < hosts[j].ID
+	})
+	return nil
+}
+
+// compareValues 比较同一列的两个值
+func compareValues(a, b interface{}) int {
+	if ta, ok := a.(time.Time); ok {
+		return ta.Compare(b.(time.Time))
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch va.Kind() {
+	case reflect.String:
+		return strings.Compare(va.String(), vb.String())
+	case reflect.Bool:
+		switch {
+		case va.Bool() == vb.Bool():
+			return 0
+		case vb.Bool():
+			return -1
+		}
+		return 1
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(va.Int(), vb.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cmp.Compare(va.Uint(), vb.Uint())
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(va.Float(), vb.Float())
+	}
+	return 0
+}
+
+// GetHostAsOf 返回主机在 as_of 时刻的信息和应用，当时不存在或不可见时返回 404
+func (s *HostService) GetHostAsOf(c *gin.Context) {
+	at, _, ok := asOfParam(c)
+	if !ok {
+		return
+	}
+	inv, err := loadInventory(s.DB, at, inventoryScope{hostID: c.Param("id")})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	access := CurrentAccess(c)
+	_, all := access.Visible()
+	apps := inv.applications()
+	for _, host := range inv.hosts {
+		if strconv.FormatUint(uint64(host.ID), 10) != c.Param("id") || (host.IsDeleted && !IncludeDeleted(c)) {
+			continue
+		}
+		host.HostApplications = visibleApplications(access, apps[host.ID], nil)
+		if !all && len(host.HostApplications) == 0 {
+			break
+		}
+		c.JSON(http.StatusOK, host)
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Host not found"})
+}
+
+// InventoryChange 为一行数据在两个时刻之间的变化。Change 为 added、removed 或 changed，
+// 新增时 After 为整行，移除时 Before 为整行，修改时只包括变化的列
+type InventoryChange struct {
+	Entity   string                     `json:"entity"`
+	EntityID string                     `json:"entity_id"`
+	Change   string                     `json:"change"`
+	Before   map[string]json.RawMessage `json:"before,omitempty"`
+	After    map[string]json.RawMessage `json:"after,omitempty"`
+}
+
+// InventoryDiff 为两个时刻之间主机、应用和集群的全部变化
+type InventoryDiff struct {
+	From    time.Time                 `json:"from"`
+	To      time.Time                 `json:"to"`
+	Summary map[string]map[string]int `json:"summary"`
+	Changes []InventoryChange         `json:"changes"`
+}
+
+// DiffInventory 比较 from 和 to（默认为当前时间）两个时刻的主机、应用和集群，可用 entity 只比较部分表
+func (s *HostService) DiffInventory(c *gin.Context) {
+	if c.Query("from") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from is required"})
+		return
+	}
+	from, err := parseTimeParam(c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+		return
+	}
+	to := time.Now()
+	if value := c.Query("to"); value != "" {
+		if to, err = parseTimeParam(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"})
+			return
+		}
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "from must be earlier than to"})
+		return
+	}
+	tables := inventoryTables
+	if entities := QueryList(c, "entity"); len(entities) > 0 {
+		for _, e := range entities {
+			if !slices.Contains(inventoryTables, e) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "entity must be one of " + strings.Join(inventoryTables, ", ")})
+				return
+			}
+		}
+		tables = entities
+	}
+
+	// 只加载两个时刻之间有过写入的行，版本相同的行不会出现在结果中
+	changedFrom, changedTo, err := audit.Changes(s.DB, from, to, tables...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	before := make(map[string]map[string]models.RowVersion, len(tables))
+	after := make(map[string]map[string]models.RowVersion, len(tables))
+	for _, table := range tables {
+		before[table] = make(map[string]models.RowVersion)
+		after[table] = make(map[string]models.RowVersion)
+	}
+	for _, v := range changedFrom {
+		before[v.Entity][v.EntityID] = v
+	}
+	for _, v := range changedTo {
+		after[v.Entity][v.EntityID] = v
+	}
+
+	result := InventoryDiff{From: from, To: to, Summary: make(map[string]map[string]int), Changes: []InventoryChange{}}
+	for _, table := range tables {
+		result.Summary[table] = map[string]int{"added": 0, "removed": 0, "changed": 0}
+		var changes []InventoryChange
+		for id, old := range before[table] {
+			current, exists := after[table][id]
+			if !exists {
+				changes = append(changes, InventoryChange{Entity: table, EntityID: id, Change: "removed", Before: rowValues(old)})
+				continue
+			}
+			if old.ID == current.ID {
+				continue
+			}
+			changedFrom, changedTo, err := audit.Diff(old.Values, current.Values)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if len(changedTo) > 0 {
+				changes = append(changes, InventoryChange{Entity: table, EntityID: id, Change: "changed", Before: changedFrom, After: changedTo})
+			}
+		}
+		for id, current := range after[table] {
+			if _, existed := before[table][id]; !existed {
+				changes = append(changes, InventoryChange{Entity: table, EntityID: id, Change: "added", After: rowValues(current)})
+			}
+		}
+		sort.Slice(changes, func(i, j int) bool {
+			a, _ := strconv.Atoi(changes[i].EntityID)
+			b, _ := strconv.Atoi(changes[j].EntityID)
+			return a < b
+		})
+		for _, change := range changes {
+			result.Summary[table][change.Change]++
+		}
+		result.Changes = append(result.Changes, changes...)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// rowValues 返回版本中保存的各列的值
+func rowValues(v models.RowVersion) map[string]json.RawMessage {
+	var values map[string]json.RawMessage
+	json.Unmarshal(v.Values, &values)
+	return values
+}
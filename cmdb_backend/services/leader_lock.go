@@ -0,0 +1,51 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"cmdb/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LeaderLock 为保存在 scheduler_locks 表中的租约锁。多个实例同时运行时只有持有租约的实例执行后台任务，
+// 持有者需要在租约到期前续期，失联后租约到期即可被其他实例接管
+type LeaderLock struct {
+	DB     *gorm.DB
+	Name   string
+	Holder string
+}
+
+// NewLeaderLock 以 "主机名:进程号" 作为持有者
+func NewLeaderLock(db *gorm.DB, name string) *LeaderLock {
+	host, _ := os.Hostname()
+	return &LeaderLock{DB: db, Name: name, Holder: fmt.Sprintf("%s:%d", host, os.Getpid())}
+}
+
+// Acquire 获取或续期租约，返回当前实例是否持有租约
+func (l *LeaderLock) Acquire(now time.Time, ttl time.Duration) (bool, error) {
+	now = now.UTC()
+	renew := l.DB.Model(&models.SchedulerLock{}).
+		Where("name = ? AND (holder = ? OR expires_at < ?)", l.Name, l.Holder, now).
+		Updates(map[string]any{"holder": l.Holder, "expires_at": now.Add(ttl)})
+	if renew.Error != nil {
+		return false, renew.Error
+	}
+	if renew.RowsAffected > 0 {
+		return true, nil
+	}
+	create := l.DB.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.SchedulerLock{Name: l.Name, Holder: l.Holder, ExpiresAt: now.Add(ttl)})
+	if create.Error != nil {
+		return false, create.Error
+	}
+	return create.RowsAffected > 0, nil
+}
+
+// Release 放弃租约，其他实例下一次检查时即可接管
+func (l *LeaderLock) Release() error {
+	return l.DB.Where("name = ? AND holder = ?", l.Name, l.Holder).Delete(&models.SchedulerLock{}).Error
+}
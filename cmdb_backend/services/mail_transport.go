@@ -0,0 +1,200 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cmdb/config"
+
+	"gopkg.in/mail.v2"
+)
+
+// mailTransport 为唯一的邮件发送实现：按发件账号使用隐式 TLS 或强制 STARTTLS 连接并校验服务器证书，
+// 再用密码或 XOAUTH2 认证。OAuth2 访问令牌在过期前缓存复用
+type mailTransport struct {
+	client *http.Client
+	mu     sync.Mutex
+	tokens map[string]oauthToken
+}
+
+type oauthToken struct {
+	value  string
+	expiry time.Time
+}
+
+func newMailTransport() *mailTransport {
+	return &mailTransport{client: &http.Client{Timeout: 30 * time.Second}, tokens: make(map[string]oauthToken)}
+}
+
+// Send 连接账号对应的服务器并发送邮件
+func (t *mailTransport) Send(account config.SMTPAccount, timeout time.Duration, m *mail.Message) error {
+	d, err := t.dialer(account, timeout)
+	if err != nil {
+		return err
+	}
+	return d.DialAndSend(m)
+}
+
+// Verify 只建立连接并认证，用于检查发件账号配置
+func (t *mailTransport) Verify(account config.SMTPAccount, timeout time.Duration) error {
+	d, err := t.dialer(account, timeout)
+	if err != nil {
+		return err
+	}
+	conn, err := d.Dial()
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (t *mailTransport) dialer(account config.SMTPAccount, timeout time.Duration) (*mail.Dialer, error) {
+	tlsConfig, err := smtpTLSConfig(account)
+	if err != nil {
+		return nil, err
+	}
+	d := &mail.Dialer{
+		Host:         account.Host,
+		Port:         account.Port,
+		TLSConfig:    tlsConfig,
+		Timeout:      timeout,
+		RetryFailure: true,
+	}
+	switch account.Security {
+	case config.SMTPSecurityTLS:
+		d.SSL = true
+	case config.SMTPSecurityStartTLS:
+		d.StartTLSPolicy = mail.MandatoryStartTLS
+	case config.SMTPSecurityNone:
+		d.StartTLSPolicy = mail.NoStartTLS
+	}
+
+	switch account.Auth {
+	case config.SMTPAuthPassword:
+		// 由 mail 按服务器支持的机制选择 CRAM-MD5、LOGIN 或 PLAIN
+		d.Username, d.Password = account.User, account.Password.Value()
+	case config.SMTPAuthXOAUTH2:
+		token, err := t.accessToken(account.OAuth2)
+		if err != nil {
+			return nil, fmt.Errorf("oauth2: %w", err)
+		}
+		d.Auth = &xoauth2Auth{user: account.User, token: token}
+	}
+	return d, nil
+}
+
+// smtpTLSConfig 使用系统 CA 以及 CAFile 中的证书校验服务器
+func smtpTLSConfig(account config.SMTPAccount) (*tls.Config, error) {
+	serverName := account.ServerName
+	if serverName == "" {
+		serverName = account.Host
+	}
+	tlsConfig := &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	if account.CAFile == "" {
+		return tlsConfig, nil
+	}
+	pem, err := os.ReadFile(account.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read smtp ca_file: %w", err)
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("smtp ca_file %s contains no certificates", account.CAFile)
+	}
+	tlsConfig.RootCAs = roots
+	return tlsConfig, nil
+}
+
+// xoauth2Auth 实现 XOAUTH2 认证（Gmail、Microsoft 365 等使用）
+type xoauth2Auth struct {
+	user, token string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLoopback(server.Name) {
+		return "", nil, errors.New("xoauth2 requires an encrypted connection")
+	}
+	return "XOAUTH2", []byte("user=" + a.user + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// 认证失败时服务器先返回 JSON 格式的错误详情，回复空行后服务器给出最终的错误码
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// accessToken 返回 XOAUTH2 使用的访问令牌。没有配置 TokenURL 时直接使用 AccessToken
+func (t *mailTransport) accessToken(cfg config.OAuth2Config) (string, error) {
+	if cfg.TokenURL == "" {
+		return cfg.AccessToken.Value(), nil
+	}
+	key := cfg.TokenURL + "\x00" + cfg.ClientID + "\x00" + cfg.RefreshToken.Value()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if token, ok := t.tokens[key]; ok && time.Now().Before(token.expiry) {
+		return token.value, nil
+	}
+
+	form := url.Values{"client_id": {cfg.ClientID}, "client_secret": {cfg.ClientSecret.Value()}}
+	if cfg.RefreshToken != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", cfg.RefreshToken.Value())
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+	if len(cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(cfg.Scopes, " "))
+	}
+	resp, err := t.client.PostForm(cfg.TokenURL, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return "", fmt.Errorf("token endpoint returned status %d: %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+
+	// 提前一分钟过期，避免使用即将失效的令牌；没有返回有效期时缓存五分钟
+	lifetime := 5 * time.Minute
+	if body.ExpiresIn > 0 {
+		expiresIn := time.Duration(body.ExpiresIn) * time.Second
+		lifetime = max(expiresIn-time.Minute, expiresIn/2)
+	}
+	t.tokens[key] = oauthToken{value: body.AccessToken, expiry: time.Now().Add(lifetime)}
+	return body.AccessToken, nil
+}
@@ -0,0 +1,321 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 单次查询最多返回的桶数，超出时需要选择更粗的精度
+const maxSeriesBuckets = 5000
+
+// raw 精度的点数取决于上报频率和实例数，单次查询最多返回的采样数，超出时需要缩小范围或选择更粗的精度
+const maxRawSeriesSamples = 20000
+
+// ErrTooManySamples 表示 raw 精度下查询范围内的采样数超过 maxRawSeriesSamples
+var ErrTooManySamples = fmt.Errorf("more than %d raw samples in range, narrow the range or choose a coarser resolution", maxRawSeriesSamples)
+
+type MetricsService struct {
+	DB *gorm.DB
+}
+
+func NewMetricsService(db *gorm.DB) *MetricsService {
+	return &MetricsService{DB: db}
+}
+
+// SeriesPoint 为一个时间桶内的数据，使用率为 0-100 的百分比，按容量加权
+type SeriesPoint struct {
+	Time           time.Time `json:"time"`
+	Samples        int       `json:"samples"`
+	CPULoad        float64   `json:"cpu_load"`
+	MaxCPULoad     float64   `json:"max_cpu_load"`
+	MemoryUsage    float64   `json:"memory_usage"`
+	MaxMemoryUsage float64   `json:"max_memory_usage"`
+	DiskUsage      float64   `json:"disk_usage"`
+	MaxDiskUsage   float64   `json:"max_disk_usage"`
+	UsedMemory     float64   `json:"used_memory"`
+	TotalMemory    float64   `json:"total_memory"`
+	UsedDisk       float64   `json:"used_disk"`
+	TotalDisk      float64   `json:"total_disk"`
+}
+
+// InstanceSeries 为单个实例的时间序列
+type InstanceSeries struct {
+	PoolID      uint          `json:"pool_id"`
+	IP          string        `json:"ip"`
+	Port        uint          `json:"port"`
+	ClusterName string        `json:"cluster_name"`
+	GroupName   string        `json:"group_name"`
+	Points      []SeriesPoint `json:"points"`
+}
+
+// SeriesQuery 描述一次历史数据查询，Scope 为 host/cluster/group，Target 为主机 ID、集群名或组名，
+// Restrict 不为空时进一步限制可以查询的实例（例如当前用户可见的部门）
+type SeriesQuery struct {
+	Scope      string
+	Target     string
+	Resolution string
+	From       time.Time
+	To         time.Time
+	Restrict   func(*gorm.DB) *gorm.DB
+}
+
+type SeriesResult struct {
+	Scope      string           `json:"scope"`
+	Target     string           `json:"target"`
+	Resolution string           `json:"resolution"`
+	From       time.Time        `json:"from"`
+	To         time.Time        `json:"to"`
+	Aggregate  []SeriesPoint    `json:"aggregate"`
+	Instances  []InstanceSeries `json:"instances"`
+}
+
+// autoResolution 按时间跨度选择精度，让返回的点数保持在几百个左右
+func autoResolution(span time.Duration) string {
+	switch {
+	case span <= 6*time.Hour:
+		return ResolutionRaw
+	case span <= 3*24*time.Hour:
+		return Resolution5m
+	case span <= 60*24*time.Hour:
+		return Resolution1h
+	default:
+		return Resolution1d
+	}
+}
+
+// parseTimeParam 接受 RFC3339 时间或 2006-01-02 日期
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// scopeFilter 返回按 scope 过滤 metric_samples/metric_rollups 的条件
+func scopeFilter(scope, target string) (func(*gorm.DB) *gorm.DB, error) {
+	switch scope {
+	case "host":
+		id, err := strconv.ParseUint(target, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("host id must be a number, got %q", target)
+		}
+		return func(db *gorm.DB) *gorm.DB { return db.Where("pool_id = ?", id) }, nil
+	case "cluster":
+		return func(db *gorm.DB) *gorm.DB { return db.Where("cluster_name = ?", target) }, nil
+	case "group":
+		return func(db *gorm.DB) *gorm.DB { return db.Where("group_name = ?", target) }, nil
+	}
+	return nil, fmt.Errorf("unknown scope %q, expected host, cluster or group", scope)
+}
+
+// chainScopes 把多个查询条件合并为一个，nil 会被忽略
+func chainScopes(scopes ...func(*gorm.DB) *gorm.DB) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		for _, scope := range scopes {
+			if scope != nil {
+				db = scope(db)
+			}
+		}
+		return db
+	}
+}
+
+// Series 查询某个主机、集群或组在时间范围内的历史数据；raw 精度下各实例采样时间不对齐，不提供 aggregate
+func (s *MetricsService) Series(q SeriesQuery) (*SeriesResult, error) {
+	filter, err := scopeFilter(q.Scope, q.Target)
+	if err != nil {
+		return nil, err
+	}
+	// 已删除主机的历史数据保留在库中，但不再出现在查询结果里
+	filter = chainScopes(filter, q.Restrict, OnLiveHosts("pool_id"))
+	if q.Resolution == ResolutionRaw {
+		var n int64
+		if err := s.DB.Model(&models.MetricSample{}).Where("sampled_at >= ? AND sampled_at < ?", q.From.UTC(), q.To.UTC()).
+			Scopes(filter).Count(&n).Error; err != nil {
+			return nil, err
+		}
+		if n > maxRawSeriesSamples {
+			return nil, ErrTooManySamples
+		}
+	}
+	points, err := loadMetricPoints(s.DB, q.Resolution, q.From, q.To, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SeriesResult{
+		Scope:      q.Scope,
+		Target:     q.Target,
+		Resolution: q.Resolution,
+		From:       q.From.UTC(),
+		To:         q.To.UTC(),
+		Aggregate:  []SeriesPoint{},
+		Instances:  []InstanceSeries{},
+	}
+	index := make(map[instanceKey]int)
+	for _, p := range points {
+		key := instanceKey{p.PoolID, p.IP, p.Port}
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(result.Instances)
+			result.Instances = append(result.Instances, InstanceSeries{PoolID: p.PoolID, IP: p.IP, Port: p.Port})
+			i = len(result.Instances) - 1
+		}
+		series := &result.Instances[i]
+		series.ClusterName, series.GroupName = p.ClusterName, p.GroupName
+		series.Points = append(series.Points, seriesPoint(p))
+	}
+	sort.Slice(result.Instances, func(i, j int) bool {
+		a, b := result.Instances[i], result.Instances[j]
+		if a.PoolID != b.PoolID {
+			return a.PoolID < b.PoolID
+		}
+		if a.IP != b.IP {
+			return a.IP < b.IP
+		}
+		return a.Port < b.Port
+	})
+
+	if q.Resolution != ResolutionRaw {
+		result.Aggregate = aggregateSeries(points)
+	}
+	return result, nil
+}
+
+func seriesPoint(p models.MetricRollup) SeriesPoint {
+	return SeriesPoint{
+		Time:           p.BucketStart,
+		Samples:        p.Samples,
+		CPULoad:        p.AvgCPULoad,
+		MaxCPULoad:     p.MaxCPULoad,
+		MemoryUsage:    percent(p.AvgUsedMemory, p.TotalMemory),
+		MaxMemoryUsage: percent(p.MaxUsedMemory, p.TotalMemory),
+		DiskUsage:      percent(p.AvgUsedDisk, p.TotalDisk),
+		MaxDiskUsage:   percent(p.MaxUsedDisk, p.TotalDisk),
+		UsedMemory:     p.AvgUsedMemory,
+		TotalMemory:    p.TotalMemory,
+		UsedDisk:       p.AvgUsedDisk,
+		TotalDisk:      p.TotalDisk,
+	}
+}
+
+// aggregateSeries 把同一时间桶内所有实例合并为一个点：容量和用量求和，CPU 负载按样本数加权平均
+func aggregateSeries(points []models.MetricRollup) []SeriesPoint {
+	type acc struct {
+		models.MetricRollup
+		maxMemoryUsage, maxDiskUsage float64
+	}
+	index := make(map[int64]int)
+	var buckets []acc
+	for _, p := range points {
+		i, ok := index[p.BucketStart.Unix()]
+		if !ok {
+			index[p.BucketStart.Unix()] = len(buckets)
+			buckets = append(buckets, acc{MetricRollup: models.MetricRollup{BucketStart: p.BucketStart}})
+			i = len(buckets) - 1
+		}
+		b := &buckets[i]
+		n, w := float64(b.Samples), float64(p.Samples)
+		b.AvgCPULoad = (b.AvgCPULoad*n + p.AvgCPULoad*w) / (n + w)
+		b.Samples += p.Samples
+		b.MaxCPULoad = max(b.MaxCPULoad, p.MaxCPULoad)
+		b.AvgUsedMemory += p.AvgUsedMemory
+		b.TotalMemory += p.TotalMemory
+		b.AvgUsedDisk += p.AvgUsedDisk
+		b.TotalDisk += p.TotalDisk
+		b.maxMemoryUsage = max(b.maxMemoryUsage, percent(p.MaxUsedMemory, p.TotalMemory))
+		b.maxDiskUsage = max(b.maxDiskUsage, percent(p.MaxUsedDisk, p.TotalDisk))
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].BucketStart.Before(buckets[j].BucketStart) })
+
+	series := make([]SeriesPoint, 0, len(buckets))
+	for _, b := range buckets {
+		point := seriesPoint(b.MetricRollup)
+		// 汇总点的峰值取各实例峰值使用率中的最大值
+		point.MaxMemoryUsage, point.MaxDiskUsage = b.maxMemoryUsage, b.maxDiskUsage
+		series = append(series, point)
+	}
+	return series
+}
+
+func percent(used, total float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return used / total * 100
+}
+
+// GetSeries 返回主机、集群或组在时间范围内的历史资源数据
+// 参数：scope=host|cluster|group，id 为主机 ID、集群名或组名，from/to 默认最近 24 小时，
+// resolution=raw|5m|1h|1d，不传时按时间跨度自动选择
+func (s *MetricsService) GetSeries(c *gin.Context) {
+	q := SeriesQuery{
+		Scope:      c.Query("scope"),
+		Target:     c.Query("id"),
+		Resolution: c.Query("resolution"),
+		To:         time.Now(),
+		Restrict:   CurrentAccess(c).ClusterScope("cluster_name"),
+	}
+	if q.Target == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id is required"})
+		return
+	}
+	if _, err := scopeFilter(q.Scope, q.Target); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to"})
+			return
+		}
+		q.To = t
+	}
+	q.From = q.To.Add(-24 * time.Hour)
+	if v := c.Query("from"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from"})
+			return
+		}
+		q.From = t
+	}
+	if !q.From.Before(q.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	if q.Resolution == "" {
+		q.Resolution = autoResolution(q.To.Sub(q.From))
+	}
+	step, ok := ResolutionStep(q.Resolution)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown resolution %q, expected raw, 5m, 1h or 1d", q.Resolution)})
+		return
+	}
+	if step > 0 && q.To.Sub(q.From)/step > maxSeriesBuckets {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("range too large for resolution %s, choose a coarser one", q.Resolution)})
+		return
+	}
+
+	result, err := s.Series(q)
+	if errors.Is(err, ErrTooManySamples) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
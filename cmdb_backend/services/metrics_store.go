@@ -0,0 +1,353 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"cmdb/config"
+	"cmdb/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 历史数据的精度，raw 为原始采样
+const (
+	ResolutionRaw = "raw"
+	Resolution5m  = "5m"
+	Resolution1h  = "1h"
+	Resolution1d  = "1d"
+)
+
+type rollupLevel struct {
+	Name   string
+	Step   time.Duration
+	Source string // 由哪个精度的数据聚合而来
+}
+
+// rollupLevels 按从细到粗的顺序排列，每一级都由上一级聚合，原始采样过期后粗粒度数据仍然完整
+var rollupLevels = []rollupLevel{
+	{Name: Resolution5m, Step: 5 * time.Minute, Source: ResolutionRaw},
+	{Name: Resolution1h, Step: time.Hour, Source: Resolution5m},
+	{Name: Resolution1d, Step: 24 * time.Hour, Source: Resolution1h},
+}
+
+// ResolutionStep 返回精度对应的时间跨度，raw 返回 0
+func ResolutionStep(resolution string) (time.Duration, bool) {
+	if resolution == ResolutionRaw {
+		return 0, true
+	}
+	for _, level := range rollupLevels {
+		if level.Name == resolution {
+			return level.Step, true
+		}
+	}
+	return 0, false
+}
+
+const metricsRollupLock = "metrics_rollup"
+
+// MetricsStore 负责资源采样的写入、降采样和过期清理，所有时间均按 UTC 存储
+type MetricsStore struct {
+	DB    *gorm.DB
+	store *config.Store
+	lock  *LeaderLock
+}
+
+// NewMetricsStore 每次降采样时都从 store 读取保留时长，配置热加载后立即生效
+func NewMetricsStore(db *gorm.DB, store *config.Store) *MetricsStore {
+	return &MetricsStore{DB: db, store: store, lock: NewLeaderLock(db, metricsRollupLock)}
+}
+
+func (m *MetricsStore) settings() config.MetricsConfig {
+	return m.store.Current().Metrics
+}
+
+// Record 写入一条采样：追加到 metric_samples，同时更新 server_resources 中该实例的最新值。
+// 比最新值更早的采样只进入历史，不覆盖当前值。
+func (m *MetricsStore) Record(resource *models.ServerResource) error {
+	if resource.DateTime.IsZero() {
+		resource.DateTime = time.Now()
+	}
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		sample := models.MetricSample{
+			PoolID:      resource.PoolID,
+			IP:          resource.IP,
+			Port:        resource.Port,
+			ClusterName: resource.ClusterName,
+			GroupName:   resource.GroupName,
+			TotalMemory: resource.TotalMemory,
+			UsedMemory:  resource.UsedMemory,
+			TotalDisk:   resource.TotalDisk,
+			UsedDisk:    resource.UsedDisk,
+			CPUCores:    resource.CPUCores,
+			CPULoad:     resource.CPULoad,
+			SampledAt:   resource.DateTime.UTC(),
+		}
+		if err := tx.Create(&sample).Error; err != nil {
+			return err
+		}
+
+		var current models.ServerResource
+		err := tx.Where("pool_id = ? AND ip = ? AND port = ?", resource.PoolID, resource.IP, resource.Port).
+			Order("date_time desc").First(&current).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return tx.Create(resource).Error
+		case err != nil:
+			return err
+		case resource.DateTime.Before(current.DateTime):
+			return nil
+		}
+		resource.ID = current.ID
+		resource.CreatedAt = current.CreatedAt
+		return tx.Save(resource).Error
+	})
+}
+
+// Run 按配置的周期执行降采样和过期清理，直到 stop 被关闭。多个实例中只有持有租约的实例执行
+func (m *MetricsStore) Run(stop <-chan struct{}) {
+	for {
+		settings := m.settings()
+		now := time.Now()
+		leader, err := m.lock.Acquire(now, settings.LockTTL.Duration)
+		if err != nil {
+			log.Printf("metrics rollup lock: %v", err)
+		} else if leader {
+			if err := m.Rollup(now); err != nil {
+				log.Printf("metrics rollup failed: %v", err)
+			}
+			if err := m.Prune(now); err != nil {
+				log.Printf("metrics prune failed: %v", err)
+			}
+		}
+		select {
+		case <-stop:
+			if err := m.lock.Release(); err != nil {
+				log.Printf("release metrics rollup lock: %v", err)
+			}
+			return
+		case <-time.After(settings.RollupInterval.Duration):
+		}
+	}
+}
+
+// Rollup 从每个精度的水位线开始聚合到 now，当前未结束的桶也会生成，下次执行时重新计算。
+// 水位线回退一个桶，以便吸收稍晚到达的采样。
+func (m *MetricsStore) Rollup(now time.Time) error {
+	now = now.UTC()
+	for _, level := range rollupLevels {
+		from, ok, err := m.rollupStart(level)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		until := now.Truncate(level.Step)
+		if err := m.rollupRange(level, from, until.Add(level.Step)); err != nil {
+			return err
+		}
+		mark := models.MetricWatermark{Resolution: level.Name, RolledUntil: until}
+		if err := m.DB.Save(&mark).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rebuild 重新计算 [from, to) 内所有精度的数据，用于导入历史采样后回填，不影响水位线
+func (m *MetricsStore) Rebuild(from, to time.Time) error {
+	for _, level := range rollupLevels {
+		start := from.UTC().Truncate(level.Step)
+		end := to.UTC().Truncate(level.Step).Add(level.Step)
+		if err := m.rollupRange(level, start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollupStart 返回本次聚合的起点；没有水位线时从源数据中最早的时间开始，没有源数据时返回 false
+func (m *MetricsStore) rollupStart(level rollupLevel) (time.Time, bool, error) {
+	var mark models.MetricWatermark
+	result := m.DB.Where("resolution = ?", level.Name).Limit(1).Find(&mark)
+	if result.Error != nil {
+		return time.Time{}, false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return mark.RolledUntil.UTC().Add(-level.Step), true, nil
+	}
+
+	var err error
+	var earliest time.Time
+	if level.Source == ResolutionRaw {
+		var sample models.MetricSample
+		err = m.DB.Order("sampled_at").Limit(1).Find(&sample).Error
+		earliest = sample.SampledAt
+	} else {
+		var rollup models.MetricRollup
+		err = m.DB.Where("resolution = ?", level.Source).Order("bucket_start").Limit(1).Find(&rollup).Error
+		earliest = rollup.BucketStart
+	}
+	if err != nil || earliest.IsZero() {
+		return time.Time{}, false, err
+	}
+	return earliest.UTC().Truncate(level.Step), true, nil
+}
+
+// rollupRange 分段读取源数据，每段覆盖 288 个目标桶，避免一次性加载过多记录
+func (m *MetricsStore) rollupRange(level rollupLevel, from, to time.Time) error {
+	window := level.Step * 288
+	for start := from; start.Before(to); start = start.Add(window) {
+		end := start.Add(window)
+		if end.After(to) {
+			end = to
+		}
+		points, err := loadMetricPoints(m.DB, level.Source, start, end, nil)
+		if err != nil {
+			return err
+		}
+		rows := aggregateBuckets(points, level.Step)
+		if len(rows) == 0 {
+			continue
+		}
+		for i := range rows {
+			rows[i].Resolution = level.Name
+		}
+		err = m.DB.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "resolution"}, {Name: "pool_id"}, {Name: "ip"}, {Name: "port"}, {Name: "bucket_start"}},
+			DoUpdates: clause.AssignmentColumns([]string{
+				"cluster_name", "group_name", "samples", "avg_cpu_load", "max_cpu_load",
+				"avg_used_memory", "max_used_memory", "total_memory",
+				"avg_used_disk", "max_used_disk", "total_disk", "cpu_cores",
+			}),
+		}).CreateInBatches(&rows, 500).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadMetricPoints 读取某个精度在 [from, to) 内的数据，原始采样转换为只含一个样本的桶
+func loadMetricPoints(db *gorm.DB, resolution string, from, to time.Time, scope func(*gorm.DB) *gorm.DB) ([]models.MetricRollup, error) {
+	from, to = from.UTC(), to.UTC()
+	if resolution == ResolutionRaw {
+		query := db.Model(&models.MetricSample{}).Where("sampled_at >= ? AND sampled_at < ?", from, to)
+		if scope != nil {
+			query = query.Scopes(scope)
+		}
+		var samples []models.MetricSample
+		if err := query.Order("sampled_at, id").Find(&samples).Error; err != nil {
+			return nil, err
+		}
+		points := make([]models.MetricRollup, 0, len(samples))
+		for _, s := range samples {
+			points = append(points, models.MetricRollup{
+				Resolution:    ResolutionRaw,
+				PoolID:        s.PoolID,
+				IP:            s.IP,
+				Port:          s.Port,
+				BucketStart:   s.SampledAt.UTC(),
+				ClusterName:   s.ClusterName,
+				GroupName:     s.GroupName,
+				Samples:       1,
+				AvgCPULoad:    s.CPULoad,
+				MaxCPULoad:    s.CPULoad,
+				AvgUsedMemory: s.UsedMemory,
+				MaxUsedMemory: s.UsedMemory,
+				TotalMemory:   s.TotalMemory,
+				AvgUsedDisk:   s.UsedDisk,
+				MaxUsedDisk:   s.UsedDisk,
+				TotalDisk:     s.TotalDisk,
+				CPUCores:      s.CPUCores,
+			})
+		}
+		return points, nil
+	}
+
+	query := db.Model(&models.MetricRollup{}).
+		Where("resolution = ? AND bucket_start >= ? AND bucket_start < ?", resolution, from, to)
+	if scope != nil {
+		query = query.Scopes(scope)
+	}
+	var points []models.MetricRollup
+	if err := query.Order("bucket_start, id").Find(&points).Error; err != nil {
+		return nil, err
+	}
+	for i := range points {
+		points[i].BucketStart = points[i].BucketStart.UTC()
+	}
+	return points, nil
+}
+
+type instanceKey struct {
+	PoolID uint
+	IP     string
+	Port   uint
+}
+
+type bucketKey struct {
+	instanceKey
+	Start int64
+}
+
+// aggregateBuckets 把按时间排序的数据按实例和 step 分桶，平均值按样本数加权，容量和归属取桶内最后一个值
+func aggregateBuckets(points []models.MetricRollup, step time.Duration) []models.MetricRollup {
+	index := make(map[bucketKey]int)
+	var rows []models.MetricRollup
+	for _, p := range points {
+		start := p.BucketStart.Truncate(step)
+		key := bucketKey{instanceKey{p.PoolID, p.IP, p.Port}, start.Unix()}
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(rows)
+			rows = append(rows, models.MetricRollup{
+				PoolID:      p.PoolID,
+				IP:          p.IP,
+				Port:        p.Port,
+				BucketStart: start,
+				MaxCPULoad:  p.MaxCPULoad,
+			})
+			i = len(rows) - 1
+		}
+		row := &rows[i]
+		n, w := float64(row.Samples), float64(p.Samples)
+		row.AvgCPULoad = (row.AvgCPULoad*n + p.AvgCPULoad*w) / (n + w)
+		row.AvgUsedMemory = (row.AvgUsedMemory*n + p.AvgUsedMemory*w) / (n + w)
+		row.AvgUsedDisk = (row.AvgUsedDisk*n + p.AvgUsedDisk*w) / (n + w)
+		row.Samples += p.Samples
+		row.MaxCPULoad = max(row.MaxCPULoad, p.MaxCPULoad)
+		row.MaxUsedMemory = max(row.MaxUsedMemory, p.MaxUsedMemory)
+		row.MaxUsedDisk = max(row.MaxUsedDisk, p.MaxUsedDisk)
+		row.TotalMemory = p.TotalMemory
+		row.TotalDisk = p.TotalDisk
+		row.CPUCores = p.CPUCores
+		row.ClusterName = p.ClusterName
+		row.GroupName = p.GroupName
+	}
+	return rows
+}
+
+// Prune 删除超过保留时长的原始采样和降采样数据
+func (m *MetricsStore) Prune(now time.Time) error {
+	now = now.UTC()
+	retention := m.settings().Retention
+	if err := m.DB.Where("sampled_at < ?", now.Add(-retention.Raw.Duration)).Delete(&models.MetricSample{}).Error; err != nil {
+		return err
+	}
+	limits := map[string]time.Duration{
+		Resolution5m: retention.FiveMinute.Duration,
+		Resolution1h: retention.Hour.Duration,
+		Resolution1d: retention.Day.Duration,
+	}
+	for resolution, keep := range limits {
+		if err := m.DB.Where("resolution = ? AND bucket_start < ?", resolution, now.Add(-keep)).
+			Delete(&models.MetricRollup{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
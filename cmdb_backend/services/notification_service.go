@@ -0,0 +1,417 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"cmdb/config"
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type NotificationService struct {
+	DB    *gorm.DB
+	Email *EmailService
+	store *config.Store
+}
+
+func NewNotificationService(db *gorm.DB, email *EmailService, store *config.Store) *NotificationService {
+	return &NotificationService{DB: db, Email: email, store: store}
+}
+
+func (s *NotificationService) notifier(channel models.NotificationChannel) (Notifier, error) {
+	client := &http.Client{Timeout: s.store.Current().Notifications.Timeout.Duration}
+	return NewNotifier(channel, client, s.Email)
+}
+
+// Send 通过指定渠道发送一条通知
+func (s *NotificationService) Send(ctx context.Context, channel models.NotificationChannel, n Notification) error {
+	notifier, err := s.notifier(channel)
+	if err != nil {
+		return err
+	}
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	return notifier.Notify(ctx, n)
+}
+
+// alertTitle 为告警通知的标题，例如 "[FIRING] 磁盘使用率过高 192.1.1.1:3306"
+func alertTitle(alert models.Alert, event string) string {
+	return fmt.Sprintf("[%s] %s %s:%d", strings.ToUpper(event), alert.RuleName, alert.IP, alert.Port)
+}
+
+// RouteAlert 返回告警应发送到的渠道：路由的部门为空或与告警部门相同、告警级别不低于路由的最低级别，
+// 恢复通知只发送到 send_resolved 的路由。同一渠道只返回一次
+func (s *NotificationService) RouteAlert(alert models.Alert, event string) ([]models.NotificationChannel, error) {
+	var routes []models.NotificationRoute
+	query := s.DB.Where("enabled = ? AND (department_name = '' OR department_name = ?)", true, alert.DepartmentName)
+	if err := query.Order("id").Find(&routes).Error; err != nil {
+		return nil, err
+	}
+	var channelIDs []uint
+	for _, route := range routes {
+		if severityRank[alert.Severity] < severityRank[route.MinSeverity] {
+			continue
+		}
+		if event == NotifyResolved && route.SendResolved != nil && !*route.SendResolved {
+			continue
+		}
+		if !slices.Contains(channelIDs, route.ChannelID) {
+			channelIDs = append(channelIDs, route.ChannelID)
+		}
+	}
+	if len(channelIDs) == 0 {
+		return nil, nil
+	}
+	var channels []models.NotificationChannel
+	if err := s.DB.Where("id IN ? AND enabled = ?", channelIDs, true).Order("id").Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	return channels, nil
+}
+
+// NotifyAlert 把告警的触发或恢复发送到路由匹配的渠道，每个渠道的结果记录为告警事件
+func (s *NotificationService) NotifyAlert(alert models.Alert, event string) {
+	channels, err := s.RouteAlert(alert, event)
+	if err != nil {
+		log.Printf("route alert %d: %v", alert.ID, err)
+		return
+	}
+	n := Notification{
+		Event:    event,
+		Title:    alertTitle(alert, event),
+		Severity: alert.Severity,
+		Alert:    &alert,
+		Time:     time.Now(),
+	}
+	for _, channel := range channels {
+		record := models.AlertEvent{
+			AlertID:   alert.ID,
+			Action:    "notified",
+			Actor:     systemActor,
+			FromState: alert.State,
+			ToState:   alert.State,
+			Comment:   fmt.Sprintf("%s via %s (%s)", event, channel.Name, channel.Type),
+		}
+		if err := s.Send(context.Background(), channel, n); err != nil {
+			log.Printf("notify alert %d via channel %s: %v", alert.ID, channel.Name, err)
+			record.Action = "notify_failed"
+			record.Comment = truncate(fmt.Sprintf("%s: %v", record.Comment, err), 1000)
+		}
+		if err := s.DB.Create(&record).Error; err != nil {
+			log.Printf("record notification for alert %d: %v", alert.ID, err)
+		}
+	}
+}
+
+type NotificationChannelInput struct {
+	Name        *string `json:"name" binding:"omitempty,min=1,max=100"`
+	Type        *string `json:"type" binding:"omitempty,oneof=email webhook dingtalk wecom feishu"`
+	Target      *string `json:"target" binding:"omitempty,min=1,max=1000"`
+	Secret      *string `json:"secret" binding:"omitempty,max=255"`
+	Template    *string `json:"template"`
+	Enabled     *bool   `json:"enabled"`
+	Description *string `json:"description" binding:"omitempty,max=255"`
+}
+
+// applyTo 写入字段并校验地址和模板
+func (in *NotificationChannelInput) applyTo(channel *models.NotificationChannel) error {
+	setIf(&channel.Name, in.Name)
+	setIf(&channel.Type, in.Type)
+	setIf(&channel.Target, in.Target)
+	setIf(&channel.Secret, in.Secret)
+	setIf(&channel.Template, in.Template)
+	if in.Enabled != nil {
+		channel.Enabled = in.Enabled
+	}
+	setIf(&channel.Description, in.Description)
+
+	if err := validateChannelTarget(channel.Type, channel.Target); err != nil {
+		return err
+	}
+	if _, err := ParseNotifyTemplate(channel.Template); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *NotificationService) findChannel(c *gin.Context) (*models.NotificationChannel, bool) {
+	var channel models.NotificationChannel
+	err := s.DB.First(&channel, c.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification channel not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &channel, true
+}
+
+func (s *NotificationService) ListChannels(c *gin.Context) {
+	var channels []models.NotificationChannel
+	if err := s.DB.Order("id").Find(&channels).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, channels)
+}
+
+func (s *NotificationService) GetChannel(c *gin.Context) {
+	if channel, ok := s.findChannel(c); ok {
+		c.JSON(http.StatusOK, channel)
+	}
+}
+
+func (s *NotificationService) CreateChannel(c *gin.Context) {
+	var in NotificationChannelInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if in.Name == nil || in.Type == nil || in.Target == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name, type and target are required"})
+		return
+	}
+
+	var channel models.NotificationChannel
+	if err := in.applyTo(&channel); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.DB.WithContext(c).Create(&channel).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, channel)
+}
+
+func (s *NotificationService) UpdateChannel(c *gin.Context) {
+	channel, ok := s.findChannel(c)
+	if !ok {
+		return
+	}
+	var in NotificationChannelInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := in.applyTo(channel); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.DB.WithContext(c).Save(channel).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, channel)
+}
+
+// DeleteChannel 删除渠道，仍有路由引用时返回 409
+func (s *NotificationService) DeleteChannel(c *gin.Context) {
+	channel, ok := s.findChannel(c)
+	if !ok {
+		return
+	}
+	var routes int64
+	if err := s.DB.Model(&models.NotificationRoute{}).Where("channel_id = ?", channel.ID).Count(&routes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if routes > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("channel is used by %d notification routes", routes)})
+		return
+	}
+	if err := s.DB.WithContext(c).Delete(channel).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notification channel deleted successfully"})
+}
+
+// sampleAlert 为测试发送使用的示例告警
+func sampleAlert(now time.Time) models.Alert {
+	memory, disk, cpu, days := 62.5, 91.3, 35.0, 9.5
+	return models.Alert{
+		RuleName:       "磁盘使用率过高（测试）",
+		Severity:       SeverityCritical,
+		Expression:     "disk_usage > 90",
+		IP:             "192.1.1.1",
+		Port:           3306,
+		ClusterName:    "Cluster1-1",
+		GroupName:      "Group1",
+		DepartmentName: "IT",
+		State:          AlertFiring,
+		MemoryUsage:    &memory,
+		DiskUsage:      &disk,
+		CPULoad:        &cpu,
+		DaysToFull:     &days,
+		StartsAt:       now.Add(-10 * time.Minute),
+		LastSeenAt:     now,
+	}
+}
+
+type TestNotificationInput struct {
+	// Template 不为空时使用它代替渠道保存的模板，便于保存前检查模板效果
+	Template *string `json:"template"`
+}
+
+// TestChannel 用示例告警测试发送，返回渲染结果和发送结果；发送失败时返回 502
+func (s *NotificationService) TestChannel(c *gin.Context) {
+	channel, ok := s.findChannel(c)
+	if !ok {
+		return
+	}
+	var in TestNotificationInput
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&in); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	setIf(&channel.Template, in.Template)
+	tmpl, err := ParseNotifyTemplate(channel.Template)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	alert := sampleAlert(now)
+	n := Notification{Event: NotifyFiring, Title: alertTitle(alert, NotifyFiring), Severity: alert.Severity, Alert: &alert, Time: now}
+	rendered, err := renderNotification(tmpl, n)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.Send(c.Request.Context(), *channel, n); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"success": false, "error": err.Error(), "rendered": rendered})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "rendered": rendered})
+}
+
+type NotificationRouteInput struct {
+	DepartmentName *string `json:"department_name" binding:"omitempty,max=100"`
+	MinSeverity    *string `json:"min_severity" binding:"omitempty,oneof=info warning critical"`
+	ChannelID      *uint   `json:"channel_id"`
+	SendResolved   *bool   `json:"send_resolved"`
+	Enabled        *bool   `json:"enabled"`
+}
+
+func (in *NotificationRouteInput) applyTo(route *models.NotificationRoute) {
+	setIf(&route.DepartmentName, in.DepartmentName)
+	setIf(&route.MinSeverity, in.MinSeverity)
+	setIf(&route.ChannelID, in.ChannelID)
+	if in.SendResolved != nil {
+		route.SendResolved = in.SendResolved
+	}
+	if in.Enabled != nil {
+		route.Enabled = in.Enabled
+	}
+}
+
+func (s *NotificationService) findRoute(c *gin.Context) (*models.NotificationRoute, bool) {
+	var route models.NotificationRoute
+	err := s.DB.First(&route, c.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification route not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &route, true
+}
+
+// checkRouteChannel 路由引用的渠道必须存在，否则返回 422
+func (s *NotificationService) checkRouteChannel(c *gin.Context, route *models.NotificationRoute) bool {
+	var count int64
+	if err := s.DB.Model(&models.NotificationChannel{}).Where("id = ?", route.ChannelID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if count == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("notification channel %d does not exist", route.ChannelID)})
+		return false
+	}
+	return true
+}
+
+func (s *NotificationService) ListRoutes(c *gin.Context) {
+	var routes []models.NotificationRoute
+	if err := s.DB.Order("id").Find(&routes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, routes)
+}
+
+func (s *NotificationService) CreateRoute(c *gin.Context) {
+	var in NotificationRouteInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if in.ChannelID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "channel_id is required"})
+		return
+	}
+
+	route := models.NotificationRoute{MinSeverity: SeverityInfo}
+	in.applyTo(&route)
+	if !s.checkRouteChannel(c, &route) {
+		return
+	}
+	if err := s.DB.WithContext(c).Create(&route).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, route)
+}
+
+func (s *NotificationService) UpdateRoute(c *gin.Context) {
+	route, ok := s.findRoute(c)
+	if !ok {
+		return
+	}
+	var in NotificationRouteInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	in.applyTo(route)
+	if !s.checkRouteChannel(c, route) {
+		return
+	}
+	if err := s.DB.WithContext(c).Save(route).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, route)
+}
+
+func (s *NotificationService) DeleteRoute(c *gin.Context) {
+	route, ok := s.findRoute(c)
+	if !ok {
+		return
+	}
+	if err := s.DB.WithContext(c).Delete(route).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notification route deleted successfully"})
+}
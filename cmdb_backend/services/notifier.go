@@ -0,0 +1,350 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"cmdb/models"
+)
+
+// 通知渠道类型
+const (
+	ChannelEmail    = "email"
+	ChannelWebhook  = "webhook"
+	ChannelDingTalk = "dingtalk"
+	ChannelWeCom    = "wecom"
+	ChannelFeishu   = "feishu"
+)
+
+// 告警通知的事件
+const (
+	NotifyFiring   = "firing"
+	NotifyResolved = "resolved"
+)
+
+// Notification 为一条待发送的通知。告警通知带有 Alert，报告等其他通知只有 Title 和 Text
+type Notification struct {
+	Event    string        `json:"event,omitempty"`
+	Title    string        `json:"title"`
+	Severity string        `json:"severity,omitempty"`
+	Text     string        `json:"text,omitempty"`
+	Alert    *models.Alert `json:"alert,omitempty"`
+	Time     time.Time     `json:"time"`
+}
+
+// Notifier 把通知发送到一个渠道
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// defaultNotifyTemplate 为机器人和邮件使用的默认正文模板（Markdown），渠道可以用自己的模板替换
+const defaultNotifyTemplate = `### {{.Title}}
+{{- with .Alert}}
+- 状态：{{$.Event}}
+- 级别：{{.Severity}}
+- 实例：{{.IP}}:{{.Port}}（{{.ClusterName}} / {{.GroupName}}）
+- 部门：{{.DepartmentName}}
+- 条件：{{.Expression}}
+- 指标：内存 {{num .MemoryUsage}}%，磁盘 {{num .DiskUsage}}%，CPU {{num .CPULoad}}，预计 {{num .DaysToFull}} 天写满
+- 开始时间：{{localtime .StartsAt}}
+{{- with .ResolvedAt}}
+- 恢复时间：{{localtime .}}
+{{- end}}
+{{- else}}
+{{.Text}}
+{{- end}}
+`
+
+var notifyFuncs = template.FuncMap{
+	"num": func(v *float64) string {
+		if v == nil {
+			return "N/A"
+		}
+		return strconv.FormatFloat(*v, 'f', 1, 64)
+	},
+	"localtime": func(t time.Time) string {
+		return t.Local().Format("2006-01-02 15:04:05")
+	},
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// ParseNotifyTemplate 解析渠道模板，为空时使用默认模板
+func ParseNotifyTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = defaultNotifyTemplate
+	}
+	return template.New("notification").Funcs(notifyFuncs).Option("missingkey=error").Parse(text)
+}
+
+func renderNotification(tmpl *template.Template, n Notification) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, n); err != nil {
+		return "", fmt.Errorf("render template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// NewNotifier 按渠道类型创建 Notifier，email 渠道通过 email 发送，其余渠道使用 client 调用 HTTP 接口
+func NewNotifier(channel models.NotificationChannel, client *http.Client, email *EmailService) (Notifier, error) {
+	tmpl, err := ParseNotifyTemplate(channel.Template)
+	if err != nil {
+		return nil, err
+	}
+	hook := webhook{client: client, url: channel.Target, secret: channel.Secret, tmpl: tmpl}
+	switch channel.Type {
+	case ChannelEmail:
+		to, err := parseRecipients(channel.Target)
+		if err != nil {
+			return nil, err
+		}
+		return &emailNotifier{email: email, to: to, tmpl: tmpl}, nil
+	case ChannelWebhook:
+		return &webhookNotifier{hook, channel.Template != ""}, nil
+	case ChannelDingTalk:
+		return &dingTalkNotifier{hook}, nil
+	case ChannelWeCom:
+		return &weComNotifier{hook}, nil
+	case ChannelFeishu:
+		return &feishuNotifier{hook}, nil
+	}
+	return nil, fmt.Errorf("unknown channel type %q", channel.Type)
+}
+
+// parseRecipients 解析逗号分隔的收件人
+func parseRecipients(target string) ([]string, error) {
+	list, err := mail.ParseAddressList(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipients: %w", err)
+	}
+	to := make([]string, len(list))
+	for i, addr := range list {
+		to[i] = addr.Address
+	}
+	return to, nil
+}
+
+// validateChannelTarget 检查渠道地址：email 为收件人列表，其余为 http(s) 地址
+func validateChannelTarget(channelType, target string) error {
+	if channelType == ChannelEmail {
+		_, err := parseRecipients(target)
+		return err
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("target must be an http(s) URL for channel type %s", channelType)
+	}
+	return nil
+}
+
+type emailNotifier struct {
+	email *EmailService
+	to    []string
+	tmpl  *template.Template
+}
+
+func (e *emailNotifier) Notify(ctx context.Context, n Notification) error {
+	text, err := renderNotification(e.tmpl, n)
+	if err != nil {
+		return err
+	}
+	// 告警邮件按告警所属部门选择发件账号
+	var department string
+	if n.Alert != nil {
+		department = n.Alert.DepartmentName
+	}
+	_, err = e.email.Enqueue(OutboundEmail{
+		Department: department,
+		To:         e.to,
+		Subject:    n.Title,
+		HTML:       "<pre>" + html.EscapeString(text) + "</pre>",
+	})
+	return err
+}
+
+// webhook 为 HTTP 类渠道的公共部分
+type webhook struct {
+	client *http.Client
+	url    string
+	secret string
+	tmpl   *template.Template
+}
+
+// post 发送 JSON 请求，非 2xx 响应视为失败；check 不为空时用它检查响应体中的错误码
+func (w *webhook) post(ctx context.Context, target string, body []byte, header http.Header, check func([]byte) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, truncate(string(respBody), 200))
+	}
+	if check != nil {
+		return check(respBody)
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// webhookNotifier 默认发送 Notification 本身的 JSON；配置了模板时模板渲染结果即为请求体。
+// 配置了密钥时在 X-CMDB-Signature 头中附带请求体的 HMAC-SHA256（十六进制）
+type webhookNotifier struct {
+	webhook
+	custom bool
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, n Notification) error {
+	var body []byte
+	var err error
+	if w.custom {
+		var text string
+		if text, err = renderNotification(w.tmpl, n); err != nil {
+			return err
+		}
+		body = []byte(text)
+	} else if body, err = json.Marshal(n); err != nil {
+		return err
+	}
+	header := http.Header{}
+	if w.secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write(body)
+		header.Set("X-CMDB-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return w.post(ctx, w.url, body, header, nil)
+}
+
+// botResponse 兼容钉钉、企业微信（errcode/errmsg）和飞书（code/msg）的响应
+type botResponse struct {
+	ErrCode *int   `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+	Code    *int   `json:"code"`
+	Msg     string `json:"msg"`
+}
+
+func checkBotResponse(body []byte) error {
+	var resp botResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("invalid response: %s", truncate(string(body), 200))
+	}
+	if resp.ErrCode != nil && *resp.ErrCode != 0 {
+		return fmt.Errorf("errcode %d: %s", *resp.ErrCode, resp.ErrMsg)
+	}
+	if resp.Code != nil && *resp.Code != 0 {
+		return fmt.Errorf("code %d: %s", *resp.Code, resp.Msg)
+	}
+	return nil
+}
+
+// dingTalkNotifier 调用钉钉自定义机器人，配置了密钥时按加签方式在地址上附带 timestamp 和 sign
+type dingTalkNotifier struct {
+	webhook
+}
+
+func (d *dingTalkNotifier) Notify(ctx context.Context, n Notification) error {
+	text, err := renderNotification(d.tmpl, n)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]any{
+		"msgtype":  "markdown",
+		"markdown": map[string]string{"title": n.Title, "text": text},
+	})
+	if err != nil {
+		return err
+	}
+	target := d.url
+	if d.secret != "" {
+		timestamp := time.Now().UnixMilli()
+		mac := hmac.New(sha256.New, []byte(d.secret))
+		fmt.Fprintf(mac, "%d\n%s", timestamp, d.secret)
+		sign := url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		sep := "?"
+		if strings.Contains(target, "?") {
+			sep = "&"
+		}
+		target = fmt.Sprintf("%s%stimestamp=%d&sign=%s", target, sep, timestamp, sign)
+	}
+	return d.post(ctx, target, body, nil, checkBotResponse)
+}
+
+// weComNotifier 调用企业微信群机器人
+type weComNotifier struct {
+	webhook
+}
+
+func (w *weComNotifier) Notify(ctx context.Context, n Notification) error {
+	text, err := renderNotification(w.tmpl, n)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]any{
+		"msgtype":  "markdown",
+		"markdown": map[string]string{"content": text},
+	})
+	if err != nil {
+		return err
+	}
+	return w.post(ctx, w.url, body, nil, checkBotResponse)
+}
+
+// feishuNotifier 调用飞书自定义机器人，配置了密钥时在请求体中附带 timestamp 和 sign
+type feishuNotifier struct {
+	webhook
+}
+
+func (f *feishuNotifier) Notify(ctx context.Context, n Notification) error {
+	text, err := renderNotification(f.tmpl, n)
+	if err != nil {
+		return err
+	}
+	payload := map[string]any{
+		"msg_type": "text",
+		"content":  map[string]string{"text": text},
+	}
+	if f.secret != "" {
+		timestamp := time.Now().Unix()
+		// 飞书以 "timestamp\nsecret" 作为密钥对空内容签名
+		mac := hmac.New(sha256.New, []byte(fmt.Sprintf("%d\n%s", timestamp, f.secret)))
+		payload["timestamp"] = strconv.FormatInt(timestamp, 10)
+		payload["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return f.post(ctx, f.url, body, nil, checkBotResponse)
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cmdb/models"
+)
+
+// botServer 为机器人和 webhook 的替身，记录收到的请求并返回 reply
+type botServer struct {
+	*httptest.Server
+	status int
+	reply  string
+	req    *http.Request
+	body   []byte
+}
+
+func newBotServer(t *testing.T) *botServer {
+	b := &botServer{status: http.StatusOK, reply: `{"errcode":0,"errmsg":"ok"}`}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.req = r
+		b.body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(b.status)
+		io.WriteString(w, b.reply)
+	}))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func notify(t *testing.T, channel models.NotificationChannel, n Notification) error {
+	t.Helper()
+	notifier, err := NewNotifier(channel, http.DefaultClient, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return notifier.Notify(context.Background(), n)
+}
+
+func hmacSHA256(key, msg string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	srv := newBotServer(t)
+	n := Notification{Title: "报告", Text: "正文", Time: time.Unix(0, 0).UTC()}
+
+	channel := models.NotificationChannel{Type: ChannelWebhook, Target: srv.URL, Secret: "s3cret"}
+	if err := notify(t, channel, n); err != nil {
+		t.Fatal(err)
+	}
+	var got Notification
+	if err := json.Unmarshal(srv.body, &got); err != nil || got.Title != n.Title || got.Text != n.Text {
+		t.Errorf("body = %s (%v)", srv.body, err)
+	}
+	want := "sha256=" + hex.EncodeToString(hmacSHA256("s3cret", string(srv.body)))
+	if sig := srv.req.Header.Get("X-CMDB-Signature"); sig != want {
+		t.Errorf("X-CMDB-Signature = %q, want %q", sig, want)
+	}
+
+	// 没有密钥时不签名；配置了模板时请求体为模板渲染结果
+	channel = models.NotificationChannel{Type: ChannelWebhook, Target: srv.URL, Template: `{"msg":{{json .Title}}}`}
+	if err := notify(t, channel, n); err != nil {
+		t.Fatal(err)
+	}
+	if string(srv.body) != `{"msg":"报告"}` {
+		t.Errorf("templated body = %s", srv.body)
+	}
+	if sig := srv.req.Header.Get("X-CMDB-Signature"); sig != "" {
+		t.Errorf("X-CMDB-Signature without secret = %q", sig)
+	}
+
+	srv.status = http.StatusBadGateway
+	if err := notify(t, channel, n); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("502 response: err = %v", err)
+	}
+}
+
+func TestDingTalkNotifier(t *testing.T) {
+	srv := newBotServer(t)
+	channel := models.NotificationChannel{Type: ChannelDingTalk, Target: srv.URL + "/robot/send?access_token=abc", Secret: "SECabc"}
+	n := Notification{Title: "报告", Text: "正文"}
+	before := time.Now().UnixMilli()
+	if err := notify(t, channel, n); err != nil {
+		t.Fatal(err)
+	}
+
+	q := srv.req.URL.Query()
+	if q.Get("access_token") != "abc" {
+		t.Errorf("access_token = %q", q.Get("access_token"))
+	}
+	timestamp, err := strconv.ParseInt(q.Get("timestamp"), 10, 64)
+	if err != nil || timestamp < before || timestamp > time.Now().UnixMilli() {
+		t.Fatalf("timestamp = %q", q.Get("timestamp"))
+	}
+	want := base64.StdEncoding.EncodeToString(hmacSHA256("SECabc", fmt.Sprintf("%d\nSECabc", timestamp)))
+	if sign := q.Get("sign"); sign != want {
+		t.Errorf("sign = %q, want %q", sign, want)
+	}
+	var body struct {
+		MsgType  string `json:"msgtype"`
+		Markdown struct{ Title, Text string }
+	}
+	if err := json.Unmarshal(srv.body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.MsgType != "markdown" || body.Markdown.Title != "报告" || body.Markdown.Text != "### 报告\n正文\n" {
+		t.Errorf("body = %s", srv.body)
+	}
+
+	// HTTP 200 但错误码不为 0 时视为失败
+	srv.reply = `{"errcode":310000,"errmsg":"sign not match"}`
+	if err := notify(t, channel, n); err == nil || !strings.Contains(err.Error(), "sign not match") {
+		t.Errorf("errcode 310000: err = %v", err)
+	}
+}
+
+func TestFeishuNotifier(t *testing.T) {
+	srv := newBotServer(t)
+	srv.reply = `{"code":0,"msg":"success"}`
+	channel := models.NotificationChannel{Type: ChannelFeishu, Target: srv.URL, Secret: "fs", Template: "{{.Title}}: {{.Text}}"}
+	before := time.Now().Unix()
+	if err := notify(t, channel, Notification{Title: "报告", Text: "正文"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var body struct {
+		MsgType   string `json:"msg_type"`
+		Content   struct{ Text string }
+		Timestamp string
+		Sign      string
+	}
+	if err := json.Unmarshal(srv.body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.MsgType != "text" || body.Content.Text != "报告: 正文" {
+		t.Errorf("body = %s", srv.body)
+	}
+	timestamp, err := strconv.ParseInt(body.Timestamp, 10, 64)
+	if err != nil || timestamp < before || timestamp > time.Now().Unix() {
+		t.Fatalf("timestamp = %q", body.Timestamp)
+	}
+	if want := base64.StdEncoding.EncodeToString(hmacSHA256(fmt.Sprintf("%d\nfs", timestamp), "")); body.Sign != want {
+		t.Errorf("sign = %q, want %q", body.Sign, want)
+	}
+
+	srv.reply = `{"code":19021,"msg":"sign match fail"}`
+	if err := notify(t, channel, Notification{Title: "报告"}); err == nil || !strings.Contains(err.Error(), "19021") {
+		t.Errorf("code 19021: err = %v", err)
+	}
+}
+
+func TestNotifyTemplate(t *testing.T) {
+	usage := 91.25
+	resolved := time.Date(2024, 1, 2, 4, 0, 0, 0, time.Local)
+	alert := &models.Alert{
+		RuleName: "内存", Severity: SeverityCritical, IP: "10.0.0.1", Port: 3306,
+		ClusterName: "c1", GroupName: "G1", DepartmentName: "IT", Expression: "memory_usage > 90",
+		MemoryUsage: &usage, StartsAt: time.Date(2024, 1, 2, 3, 0, 0, 0, time.Local), ResolvedAt: &resolved,
+	}
+	tmpl, err := ParseNotifyTemplate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := renderNotification(tmpl, Notification{Event: NotifyResolved, Title: "T", Alert: alert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"- 状态：resolved\n",
+		"- 实例：10.0.0.1:3306（c1 / G1）\n",
+		"- 指标：内存 91.2%，磁盘 N/A%，CPU N/A，预计 N/A 天写满\n",
+		"- 开始时间：2024-01-02 03:00:00\n",
+		"- 恢复时间：2024-01-02 04:00:00\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("default template: missing %q in\n%s", want, text)
+		}
+	}
+
+	// 引用不存在的字段在解析或渲染时报错，不会发送残缺的通知
+	if _, err := ParseNotifyTemplate("{{.Title"); err == nil {
+		t.Error("unterminated action: no parse error")
+	}
+	tmpl, err = ParseNotifyTemplate("{{.Missing}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := renderNotification(tmpl, Notification{}); err == nil {
+		t.Error("unknown field: no render error")
+	}
+}
@@ -0,0 +1,375 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"cmdb/blob"
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// reportSnapshot 为生成报告时过滤范围内的主机、集群使用率和未恢复的告警，与报告文件一起存档，用于比较两份报告。
+// 各种报告保存相同结构的快照，不同类型的报告之间也可以比较
+type reportSnapshot struct {
+	Hosts    []string          `json:"hosts"`
+	Clusters []snapshotCluster `json:"clusters"`
+	Alerts   []snapshotAlert   `json:"alerts"`
+}
+
+type snapshotCluster struct {
+	GroupName   string  `json:"group_name"`
+	ClusterName string  `json:"cluster_name"`
+	Servers     int     `json:"servers"`
+	CPUUsage    float64 `json:"cpu_usage"`
+	MemoryUsage float64 `json:"memory_usage"`
+	DiskUsage   float64 `json:"disk_usage"`
+}
+
+type snapshotAlert struct {
+	ID          uint      `json:"id"`
+	RuleName    string    `json:"rule_name"`
+	Severity    string    `json:"severity"`
+	State       string    `json:"state"`
+	GroupName   string    `json:"group_name"`
+	ClusterName string    `json:"cluster_name"`
+	IP          string    `json:"ip"`
+	Port        uint      `json:"port"`
+	StartsAt    time.Time `json:"starts_at"`
+}
+
+// reportSnapshot 收集 filter 内各主机 IP、集群的平均使用率和未恢复的告警
+func (s *ReportService) reportSnapshot(filter ReportFilter) (*reportSnapshot, error) {
+	servers, registered, err := s.serverUsages(filter)
+	if err != nil {
+		return nil, err
+	}
+	var open []models.Alert
+	if err := s.DB.Scopes(filter.scope).Where("state <> ?", AlertResolved).Order("id").Find(&open).Error; err != nil {
+		return nil, err
+	}
+
+	snapshot := &reportSnapshot{Hosts: []string{}, Clusters: []snapshotCluster{}, Alerts: []snapshotAlert{}}
+	for _, server := range servers {
+		if !slices.Contains(snapshot.Hosts, server.IP) {
+			snapshot.Hosts = append(snapshot.Hosts, server.IP)
+		}
+	}
+	sort.Strings(snapshot.Hosts)
+	for _, group := range summarizeGroups(servers, registered, nil) {
+		for _, c := range group.Clusters {
+			snapshot.Clusters = append(snapshot.Clusters, snapshotCluster{
+				GroupName:   group.GroupName,
+				ClusterName: c.ClusterName,
+				Servers:     c.Servers,
+				CPUUsage:    c.CPUUsage,
+				MemoryUsage: c.MemoryUsage,
+				DiskUsage:   c.DiskUsage,
+			})
+		}
+	}
+	for _, a := range open {
+		snapshot.Alerts = append(snapshot.Alerts, snapshotAlert{
+			ID:          a.ID,
+			RuleName:    a.RuleName,
+			Severity:    a.Severity,
+			State:       a.State,
+			GroupName:   a.GroupName,
+			ClusterName: a.ClusterName,
+			IP:          a.IP,
+			Port:        a.Port,
+			StartsAt:    a.StartsAt,
+		})
+	}
+	return snapshot, nil
+}
+
+// archiveParams 为存档中记录的生成参数，只有告警汇总有时间范围
+type archiveParams struct {
+	From        *time.Time `json:"from,omitempty"`
+	To          *time.Time `json:"to,omitempty"`
+	Groups      []string   `json:"groups,omitempty"`
+	Departments []string   `json:"departments,omitempty"`
+}
+
+// archive 把报告文件和数据快照写入 blob 存储并记录元数据。对象按内容的 SHA-256 命名，相同内容只保存一份
+func (s *ReportService) archive(kind, format string, now time.Time, opts ReportOptions, file *ReportFile) (*models.ReportArchive, error) {
+	snapshot, err := s.reportSnapshot(opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+	snapshotData, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	params := archiveParams{Groups: opts.Filter.Groups, Departments: opts.Filter.Departments}
+	if kind == ReportAlertSummary {
+		params.From, params.To = &opts.From, &opts.To
+	}
+	paramsData, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	checksum := sha256Hex(file.Content)
+	prefix := fmt.Sprintf("%s/%s", kind, now.UTC().Format("2006/01"))
+	record := &models.ReportArchive{
+		Report:      kind,
+		Format:      format,
+		Params:      string(paramsData),
+		Parameters:  paramsData,
+		GeneratedAt: now.UTC(),
+		Filename:    file.Filename,
+		ContentType: file.ContentType,
+		Size:        len(file.Content),
+		Checksum:    checksum,
+		ContentKey:  fmt.Sprintf("%s/%s.%s", prefix, checksum, format),
+		SnapshotKey: fmt.Sprintf("%s/%s.json", prefix, sha256Hex(snapshotData)),
+	}
+	if err := s.Blobs.Put(record.ContentKey, file.Content); err != nil {
+		return nil, err
+	}
+	if err := s.Blobs.Put(record.SnapshotKey, snapshotData); err != nil {
+		return nil, err
+	}
+	if err := s.DB.Create(record).Error; err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// findArchive 查找存档，id 来自路径或查询参数
+func (s *ReportService) findArchive(c *gin.Context, id string) (*models.ReportArchive, bool) {
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid report archive id %q", id)})
+		return nil, false
+	}
+	var record models.ReportArchive
+	err = s.DB.First(&record, n).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Report archive %s not found", id)})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &record, true
+}
+
+// readArchive 读取存档对象，存储中缺失的对象返回 404
+func (s *ReportService) readArchive(c *gin.Context, key string) ([]byte, bool) {
+	data, err := s.Blobs.Get(key)
+	if errors.Is(err, blob.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Archived report content is missing from storage"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return data, true
+}
+
+// ListArchives 分页返回存档的报告，最近生成的在前，可按 report、format 和生成时间 from/to 过滤
+func (s *ReportService) ListArchives(c *gin.Context) {
+	page, pageSize, ok := pagination(c)
+	if !ok {
+		return
+	}
+	query := s.DB.Model(&models.ReportArchive{})
+	if reports := QueryList(c, "report"); len(reports) > 0 {
+		query = query.Where("report IN ?", reports)
+	}
+	if formats := QueryList(c, "format"); len(formats) > 0 {
+		query = query.Where("format IN ?", formats)
+	}
+	for _, p := range []struct{ name, cond string }{{"from", "generated_at >= ?"}, {"to", "generated_at < ?"}} {
+		value := c.Query(p.name)
+		if value == "" {
+			continue
+		}
+		t, err := parseTimeParam(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + p.name})
+			return
+		}
+		query = query.Where(p.cond, t.UTC())
+	}
+
+	result := Page[models.ReportArchive]{Items: []models.ReportArchive{}, Page: page, PageSize: pageSize}
+	if err := query.Session(&gorm.Session{}).Count(&result.Total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := query.Order("generated_at desc, id desc").Offset((page - 1) * pageSize).Limit(pageSize).
+		Find(&result.Items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (s *ReportService) GetArchive(c *gin.Context) {
+	if record, ok := s.findArchive(c, c.Param("id")); ok {
+		c.JSON(http.StatusOK, record)
+	}
+}
+
+// DownloadArchive 下载存档的报告文件，内容与记录的校验和不一致时返回 500
+func (s *ReportService) DownloadArchive(c *gin.Context) {
+	record, ok := s.findArchive(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	content, ok := s.readArchive(c, record.ContentKey)
+	if !ok {
+		return
+	}
+	if sha256Hex(content) != record.Checksum {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Archived report content does not match its checksum"})
+		return
+	}
+	c.Header("X-Checksum-SHA256", record.Checksum)
+	sendReportFile(c, &ReportFile{Filename: record.Filename, ContentType: record.ContentType, Content: content})
+}
+
+// ClusterChange 为集群一项使用率在两份报告之间的变化（百分点）
+type ClusterChange struct {
+	GroupName   string  `json:"group_name"`
+	ClusterName string  `json:"cluster_name"`
+	Metric      string  `json:"metric"`
+	Before      float64 `json:"before"`
+	After       float64 `json:"after"`
+	Delta       float64 `json:"delta"`
+}
+
+// ReportComparison 为 Target 相对 Base 的变化
+type ReportComparison struct {
+	Base         *models.ReportArchive `json:"base"`
+	Target       *models.ReportArchive `json:"target"`
+	Threshold    float64               `json:"threshold"`
+	HostsAdded   []string              `json:"hosts_added"`
+	HostsRemoved []string              `json:"hosts_removed"`
+	Clusters     []ClusterChange       `json:"clusters"`
+	NewAlerts    []snapshotAlert       `json:"new_alerts"`
+}
+
+// compareSnapshots 比较两份快照：新增和移除的主机，CPU、内存、磁盘使用率变化超过 threshold 个百分点的集群
+// （只比较两份快照中都有实例的集群），以及 target 中未恢复、在 base 中不存在的告警
+func compareSnapshots(base, target *reportSnapshot, threshold float64) ReportComparison {
+	result := ReportComparison{
+		Threshold:    threshold,
+		HostsAdded:   []string{},
+		HostsRemoved: []string{},
+		Clusters:     []ClusterChange{},
+		NewAlerts:    []snapshotAlert{},
+	}
+	for _, host := range target.Hosts {
+		if !slices.Contains(base.Hosts, host) {
+			result.HostsAdded = append(result.HostsAdded, host)
+		}
+	}
+	for _, host := range base.Hosts {
+		if !slices.Contains(target.Hosts, host) {
+			result.HostsRemoved = append(result.HostsRemoved, host)
+		}
+	}
+
+	before := make(map[string]snapshotCluster, len(base.Clusters))
+	for _, c := range base.Clusters {
+		before[c.ClusterName] = c
+	}
+	for _, after := range target.Clusters {
+		prev, ok := before[after.ClusterName]
+		if !ok || prev.Servers == 0 || after.Servers == 0 {
+			continue
+		}
+		for _, m := range []struct {
+			name          string
+			before, after float64
+		}{
+			{"cpu", prev.CPUUsage, after.CPUUsage},
+			{"memory", prev.MemoryUsage, after.MemoryUsage},
+			{"disk", prev.DiskUsage, after.DiskUsage},
+		} {
+			if delta := m.after - m.before; math.Abs(delta) > threshold {
+				result.Clusters = append(result.Clusters, ClusterChange{
+					GroupName:   after.GroupName,
+					ClusterName: after.ClusterName,
+					Metric:      m.name,
+					Before:      m.before,
+					After:       m.after,
+					Delta:       delta,
+				})
+			}
+		}
+	}
+
+	seen := make(map[uint]bool, len(base.Alerts))
+	for _, a := range base.Alerts {
+		seen[a.ID] = true
+	}
+	for _, a := range target.Alerts {
+		if !seen[a.ID] {
+			result.NewAlerts = append(result.NewAlerts, a)
+		}
+	}
+	return result
+}
+
+// CompareArchives 比较两份存档报告，base、target 为存档 ID，threshold 为集群使用率变化的百分点阈值，默认 10
+func (s *ReportService) CompareArchives(c *gin.Context) {
+	threshold := 10.0
+	if value := c.Query("threshold"); value != "" {
+		t, err := strconv.ParseFloat(value, 64)
+		if err != nil || t < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "threshold must be a non-negative number"})
+			return
+		}
+		threshold = t
+	}
+	if c.Query("base") == "" || c.Query("target") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "base and target are required"})
+		return
+	}
+
+	var records [2]*models.ReportArchive
+	var snapshots [2]reportSnapshot
+	for i, id := range []string{c.Query("base"), c.Query("target")} {
+		record, ok := s.findArchive(c, id)
+		if !ok {
+			return
+		}
+		data, ok := s.readArchive(c, record.SnapshotKey)
+		if !ok {
+			return
+		}
+		if err := json.Unmarshal(data, &snapshots[i]); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		records[i] = record
+	}
+
+	result := compareSnapshots(&snapshots[0], &snapshots[1], threshold)
+	result.Base, result.Target = records[0], records[1]
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,524 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// 可以导出为 PDF 的报告
+const (
+	ReportIDC          = "idc"
+	ReportClusterGroup = "cluster_group"
+	ReportAlertSummary = "alert_summary"
+)
+
+var reportTitles = map[string]string{
+	ReportIDC:          "机房资源使用情况报告",
+	ReportClusterGroup: "集群组资源使用情况报告",
+	ReportAlertSummary: "告警汇总报告",
+}
+
+// 报告的格式
+const (
+	FormatHTML = "html"
+	FormatXLSX = "xlsx"
+	FormatPDF  = "pdf"
+)
+
+var reportContentTypes = map[string]string{
+	FormatHTML: "text/html; charset=utf-8",
+	FormatXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	FormatPDF:  "application/pdf",
+}
+
+// ReportFilter 把报告限定在指定的组和部门内，为空的一项不作限制
+type ReportFilter struct {
+	Groups      []string `json:"groups,omitempty"`
+	Departments []string `json:"departments,omitempty"`
+}
+
+func (f ReportFilter) Match(group, department string) bool {
+	return (len(f.Groups) == 0 || slices.Contains(f.Groups, group)) &&
+		(len(f.Departments) == 0 || slices.Contains(f.Departments, department))
+}
+
+// scope 按 group_name 和 department_name 列过滤查询
+func (f ReportFilter) scope(db *gorm.DB) *gorm.DB {
+	if len(f.Groups) > 0 {
+		db = db.Where("group_name IN ?", f.Groups)
+	}
+	if len(f.Departments) > 0 {
+		db = db.Where("department_name IN ?", f.Departments)
+	}
+	return db
+}
+
+// ReportOptions 为生成报告的参数。From、To 为告警汇总统计的时间范围，其余报告忽略
+type ReportOptions struct {
+	From   time.Time    `json:"from"`
+	To     time.Time    `json:"to"`
+	Filter ReportFilter `json:"filter"`
+}
+
+// ReportFile 为生成好的报告文件，ArchiveID 为存档记录，存档失败时为 0
+type ReportFile struct {
+	Title       string
+	Filename    string
+	ContentType string
+	Content     []byte
+	ArchiveID   uint
+}
+
+// IDCReport 为各机房的使用情况，每个机房下再按组、集群汇总
+type IDCReport struct {
+	Title       string       `json:"title"`
+	GeneratedAt time.Time    `json:"generated_at"`
+	IDCs        []IDCSection `json:"idcs"`
+}
+
+type IDCSection struct {
+	models.IDCUsage
+	Groups []GroupUsage `json:"groups"`
+}
+
+// ClusterGroupReport 为各集群组内每个集群的使用情况，Stats 为 Excel 版本使用的平均值和 P95 统计
+type ClusterGroupReport struct {
+	Title       string       `json:"title"`
+	GeneratedAt time.Time    `json:"generated_at"`
+	Groups      []GroupUsage `json:"groups"`
+	Stats       []GroupStats `json:"stats"`
+}
+
+// AlertSummaryReport 为当前未恢复的告警和时间范围内各规则的触发情况
+type AlertSummaryReport struct {
+	Title       string    `json:"title"`
+	GeneratedAt time.Time `json:"generated_at"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	// Counts 为未恢复告警按级别、状态的数量，级别从高到低
+	Counts []AlertStateCount `json:"counts"`
+	// Groups 为按组归类的未恢复告警
+	Groups []AlertGroup     `json:"groups"`
+	Rules  []AlertRuleStats `json:"rules"`
+}
+
+type AlertStateCount struct {
+	Severity     string `json:"severity"`
+	Firing       int    `json:"firing"`
+	Acknowledged int    `json:"acknowledged"`
+	Silenced     int    `json:"silenced"`
+}
+
+func (c AlertStateCount) Total() int {
+	return c.Firing + c.Acknowledged + c.Silenced
+}
+
+type AlertGroup struct {
+	GroupName string         `json:"group_name"`
+	Alerts    []models.Alert `json:"alerts"`
+}
+
+// AlertRuleStats 为一条规则在时间范围内开始的告警数、其中已恢复的数量和平均持续时长（未恢复的算到报告生成时）
+type AlertRuleStats struct {
+	RuleName    string        `json:"rule_name"`
+	Severity    string        `json:"severity"`
+	Started     int           `json:"started"`
+	Resolved    int           `json:"resolved"`
+	AvgDuration time.Duration `json:"avg_duration"`
+}
+
+// BuildIDCReport 按机房汇总 filter 内实例的平均使用率，机房按名称排序
+func (s *ReportService) BuildIDCReport(now time.Time, filter ReportFilter) (*IDCReport, error) {
+	servers, _, err := s.serverUsages(filter)
+	if err != nil {
+		return nil, err
+	}
+	fullDates, err := s.clusterFullDates(now)
+	if err != nil {
+		return nil, err
+	}
+	byIDC := make(map[string][]ServerUsage)
+	var names []string
+	for _, server := range servers {
+		if _, ok := byIDC[server.IDC]; !ok {
+			names = append(names, server.IDC)
+		}
+		byIDC[server.IDC] = append(byIDC[server.IDC], server)
+	}
+	sort.Strings(names)
+
+	report := &IDCReport{Title: reportTitles[ReportIDC], GeneratedAt: now}
+	for _, name := range names {
+		section := IDCSection{IDCUsage: models.IDCUsage{IDCName: name}}
+		for _, server := range byIDC[name] {
+			section.TotalInstances++
+			section.AvgCPUUsage += server.CPUUsage
+			section.AvgMemoryUsage += server.MemoryUsage
+			section.AvgDiskUsage += server.DiskUsage
+		}
+		n := float64(section.TotalInstances)
+		section.AvgCPUUsage /= n
+		section.AvgMemoryUsage /= n
+		section.AvgDiskUsage /= n
+		section.Groups = summarizeGroups(byIDC[name], nil, fullDates)
+		report.IDCs = append(report.IDCs, section)
+	}
+	return report, nil
+}
+
+func (s *ReportService) BuildClusterGroupReport(now time.Time, filter ReportFilter) (*ClusterGroupReport, error) {
+	servers, registered, err := s.serverUsages(filter)
+	if err != nil {
+		return nil, err
+	}
+	fullDates, err := s.clusterFullDates(now)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := s.ClusterGroupStats(now, filter)
+	if err != nil {
+		return nil, err
+	}
+	return &ClusterGroupReport{
+		Title:       reportTitles[ReportClusterGroup],
+		GeneratedAt: now,
+		Groups:      summarizeGroups(servers, registered, fullDates),
+		Stats:       stats,
+	}, nil
+}
+
+// BuildAlertSummary 汇总 filter 内当前未恢复的告警，以及 [from, to) 内开始的告警按规则的统计
+func (s *ReportService) BuildAlertSummary(from, to, now time.Time, filter ReportFilter) (*AlertSummaryReport, error) {
+	var open []models.Alert
+	if err := s.DB.Scopes(filter.scope).Where("state <> ?", AlertResolved).Order("starts_at, id").Find(&open).Error; err != nil {
+		return nil, err
+	}
+	var started []models.Alert
+	if err := s.DB.Scopes(filter.scope).Where("starts_at >= ? AND starts_at < ?", from.UTC(), to.UTC()).
+		Order("starts_at, id").Find(&started).Error; err != nil {
+		return nil, err
+	}
+
+	report := &AlertSummaryReport{Title: reportTitles[ReportAlertSummary], GeneratedAt: now, From: from, To: to}
+	for _, severity := range []string{SeverityCritical, SeverityWarning, SeverityInfo} {
+		count := AlertStateCount{Severity: severity}
+		for _, a := range open {
+			if a.Severity != severity {
+				continue
+			}
+			switch a.State {
+			case AlertFiring:
+				count.Firing++
+			case AlertAcknowledged:
+				count.Acknowledged++
+			case AlertSilenced:
+				count.Silenced++
+			}
+		}
+		report.Counts = append(report.Counts, count)
+	}
+
+	// 组内的告警按级别从高到低、开始时间从早到晚排列
+	sort.SliceStable(open, func(i, j int) bool { return severityRank[open[i].Severity] > severityRank[open[j].Severity] })
+	byGroup := make(map[string]int)
+	for _, a := range open {
+		i, ok := byGroup[a.GroupName]
+		if !ok {
+			i = len(report.Groups)
+			byGroup[a.GroupName] = i
+			report.Groups = append(report.Groups, AlertGroup{GroupName: a.GroupName})
+		}
+		report.Groups[i].Alerts = append(report.Groups[i].Alerts, a)
+	}
+	sort.Slice(report.Groups, func(i, j int) bool { return report.Groups[i].GroupName < report.Groups[j].GroupName })
+
+	byRule := make(map[uint]*AlertRuleStats)
+	var ruleIDs []uint
+	total := make(map[uint]time.Duration)
+	for _, a := range started {
+		stats := byRule[a.RuleID]
+		if stats == nil {
+			stats = &AlertRuleStats{RuleName: a.RuleName, Severity: a.Severity}
+			byRule[a.RuleID] = stats
+			ruleIDs = append(ruleIDs, a.RuleID)
+		}
+		stats.Started++
+		end := now
+		if a.ResolvedAt != nil {
+			stats.Resolved++
+			end = *a.ResolvedAt
+		}
+		total[a.RuleID] += end.Sub(a.StartsAt)
+	}
+	for _, id := range ruleIDs {
+		stats := byRule[id]
+		stats.AvgDuration = (total[id] / time.Duration(stats.Started)).Truncate(time.Second)
+		report.Rules = append(report.Rules, *stats)
+	}
+	sort.SliceStable(report.Rules, func(i, j int) bool {
+		if report.Rules[i].Started != report.Rules[j].Started {
+			return report.Rules[i].Started > report.Rules[j].Started
+		}
+		return report.Rules[i].RuleName < report.Rules[j].RuleName
+	})
+	return report, nil
+}
+
+// reportRenderers 为一份报告的各种格式
+type reportRenderers struct {
+	pdf  func() []byte
+	xlsx func() (*excelize.File, error)
+	html func() tableReport
+}
+
+// Render 生成 kind 报告的 format 格式文件并存档。告警汇总统计 [opts.From, opts.To) 内开始的告警。
+// 存档失败只记录日志，不影响下载和发送
+func (s *ReportService) Render(kind, format string, now time.Time, opts ReportOptions) (*ReportFile, error) {
+	file, err := s.render(kind, format, now, opts)
+	if err != nil {
+		return nil, err
+	}
+	record, err := s.archive(kind, format, now, opts, file)
+	if err != nil {
+		log.Printf("Failed to archive %s report: %v", kind, err)
+		return file, nil
+	}
+	file.ArchiveID = record.ID
+	return file, nil
+}
+
+func (s *ReportService) render(kind, format string, now time.Time, opts ReportOptions) (*ReportFile, error) {
+	if _, ok := reportContentTypes[format]; !ok {
+		return nil, fmt.Errorf("%w %q", errUnknownFormat, format)
+	}
+	var r reportRenderers
+	switch kind {
+	case ReportIDC:
+		report, err := s.BuildIDCReport(now, opts.Filter)
+		if err != nil {
+			return nil, err
+		}
+		r = reportRenderers{
+			pdf:  func() []byte { return idcReportPDF(report) },
+			xlsx: func() (*excelize.File, error) { return idcWorkbook(report) },
+			html: func() tableReport { return idcReportTables(report) },
+		}
+	case ReportClusterGroup:
+		report, err := s.BuildClusterGroupReport(now, opts.Filter)
+		if err != nil {
+			return nil, err
+		}
+		settings := s.store.Current().Reports
+		r = reportRenderers{
+			pdf: func() []byte { return clusterGroupReportPDF(report) },
+			xlsx: func() (*excelize.File, error) {
+				return clusterGroupWorkbook(report.Stats, float64(settings.UsageThreshold), now.Add(settings.FullWithin.Duration))
+			},
+			html: func() tableReport { return clusterGroupReportTables(report) },
+		}
+	case ReportAlertSummary:
+		report, err := s.BuildAlertSummary(opts.From, opts.To, now, opts.Filter)
+		if err != nil {
+			return nil, err
+		}
+		r = reportRenderers{
+			pdf:  func() []byte { return alertSummaryPDF(report) },
+			xlsx: func() (*excelize.File, error) { return alertSummaryWorkbook(report) },
+			html: func() tableReport { return alertSummaryTables(report) },
+		}
+	default:
+		return nil, fmt.Errorf("%w %q", errUnknownReport, kind)
+	}
+
+	var content []byte
+	switch format {
+	case FormatPDF:
+		content = r.pdf()
+	case FormatXLSX:
+		f, err := r.xlsx()
+		if err != nil {
+			return nil, err
+		}
+		buf, err := f.WriteToBuffer()
+		if err != nil {
+			return nil, err
+		}
+		content = buf.Bytes()
+	case FormatHTML:
+		var err error
+		if content, err = r.html().HTML(); err != nil {
+			return nil, err
+		}
+	}
+	return &ReportFile{
+		Title:       reportTitles[kind],
+		Filename:    fmt.Sprintf("%s_report_%s.%s", kind, now.Local().Format("20060102"), format),
+		ContentType: reportContentTypes[format],
+		Content:     content,
+	}, nil
+}
+
+var (
+	errUnknownReport = errors.New("unknown report")
+	errUnknownFormat = errors.New("unknown report format")
+)
+
+// reportRange 读取告警汇总的时间范围，默认为 now 之前的 7 天
+func reportRange(fromValue, toValue string, now time.Time) (time.Time, time.Time, error) {
+	to, from := now, now.Add(-7*24*time.Hour)
+	for _, p := range []struct {
+		name, value string
+		target      *time.Time
+	}{{"from", fromValue, &from}, {"to", toValue, &to}} {
+		if p.value == "" {
+			continue
+		}
+		t, err := parseTimeParam(p.value)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid %s", p.name)
+		}
+		*p.target = t
+	}
+	if !from.Before(to) {
+		return from, to, errors.New("from must be before to")
+	}
+	return from, to, nil
+}
+
+func sendReportFile(c *gin.Context, file *ReportFile) {
+	if file.ArchiveID != 0 {
+		c.Header("X-Report-Archive-Id", strconv.FormatUint(uint64(file.ArchiveID), 10))
+	}
+	c.Header("Content-Disposition", "attachment; filename="+file.Filename)
+	c.Data(http.StatusOK, file.ContentType, file.Content)
+}
+
+// reportRequest 读取报表下载的 format 参数和 groups、departments 过滤条件，format 默认为 def
+func reportRequest(c *gin.Context, def string) (string, ReportFilter, bool) {
+	format := c.DefaultQuery("format", def)
+	if _, ok := reportContentTypes[format]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be html, xlsx or pdf"})
+		return "", ReportFilter{}, false
+	}
+	return format, ReportFilter{Groups: QueryList(c, "groups"), Departments: QueryList(c, "departments")}, true
+}
+
+// downloadReport 生成并下载报告，opts 中的过滤条件由 reportRequest 读取
+func (s *ReportService) downloadReport(c *gin.Context, kind, defaultFormat string, opts ReportOptions) {
+	format, filter, ok := reportRequest(c, defaultFormat)
+	if !ok {
+		return
+	}
+	if opts.Filter, ok = scopedReportFilter(c, filter); !ok {
+		return
+	}
+	file, err := s.Render(kind, format, time.Now(), opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sendReportFile(c, file)
+}
+
+// GenerateAlertReport 下载告警汇总，format 默认为 pdf，from/to 为统计的时间范围，默认最近 7 天
+func (s *ReportService) GenerateAlertReport(c *gin.Context) {
+	from, to, err := reportRange(c.Query("from"), c.Query("to"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.downloadReport(c, ReportAlertSummary, FormatPDF, ReportOptions{From: from, To: to})
+}
+
+// SendReport 生成报告并写入发件队列。
+// 请求体：report 为 idc、cluster_group 或 alert_summary，format 为 pdf（默认）、xlsx 或 html，
+// email 为逗号分隔的收件人，告警汇总可以传 from/to，groups、departments 为过滤条件，profile 为发件账号
+func (s *ReportService) SendReport(c *gin.Context) {
+	var req struct {
+		Report      string   `json:"report" binding:"required"`
+		Format      string   `json:"format"`
+		Email       string   `json:"email" binding:"required"`
+		From        string   `json:"from"`
+		To          string   `json:"to"`
+		Groups      []string `json:"groups"`
+		Departments []string `json:"departments"`
+		Profile     string   `json:"profile"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to, err := parseRecipients(req.Email)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	from, until, err := reportRange(req.From, req.To, now)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Format == "" {
+		req.Format = FormatPDF
+	}
+	filter, ok := scopedReportFilter(c, ReportFilter{Groups: req.Groups, Departments: req.Departments})
+	if !ok {
+		return
+	}
+	opts := ReportOptions{From: from, To: until, Filter: filter}
+	file, err := s.Render(req.Report, req.Format, now, opts)
+	if errors.Is(err, errUnknownReport) || errors.Is(err, errUnknownFormat) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	id, err := s.Email.Enqueue(reportFileEmail(file, to, req.Profile, now))
+	if errors.Is(err, errUnknownProfile) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to queue %s report: %v", req.Report, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue report"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Report queued for sending", "message_id": id, "archive_id": file.ArchiveID})
+}
+
+// reportFileEmail 为发送报告文件的邮件：HTML 报告直接作为正文，其余格式作为附件
+func reportFileEmail(file *ReportFile, to []string, profile string, now time.Time) OutboundEmail {
+	if file.ContentType == reportContentTypes[FormatHTML] {
+		return OutboundEmail{Profile: profile, To: to, Subject: file.Title, HTML: string(file.Content)}
+	}
+	text := fmt.Sprintf("%s见附件 %s，生成时间 %s。", file.Title, file.Filename, now.Local().Format("2006-01-02 15:04:05"))
+	return OutboundEmail{
+		Profile: profile,
+		To:      to,
+		Subject: file.Title,
+		HTML:    "<p>" + html.EscapeString(text) + "</p>",
+		Text:    text,
+		Attachments: []models.EmailAttachment{{
+			Filename:    file.Filename,
+			ContentType: file.ContentType,
+			Content:     file.Content,
+		}},
+	}
+}
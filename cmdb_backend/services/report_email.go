@@ -0,0 +1,335 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	texttemplate "text/template"
+	"time"
+
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// usageReportHTML 为报告邮件的 HTML 正文。图表通过 Charts 中的地址引用：邮件中为 cid:，预览时为 data:
+const usageReportHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+  body { font-family: Arial, "Microsoft YaHei", sans-serif; font-size: 14px; color: #222; }
+  table { border-collapse: collapse; width: 100%; margin-bottom: 16px; }
+  th, td { border: 1px solid #ddd; padding: 6px 8px; text-align: left; }
+  th { background-color: #f2f2f2; }
+  td.num { text-align: right; }
+  .muted { color: #666; }
+  .legend span { display: inline-block; width: 10px; height: 10px; margin: 0 4px 0 12px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="muted">生成时间：{{localtime .GeneratedAt}}，共 {{len .Servers}} 个实例，{{len .Alerts}} 条告警</p>
+
+<h2>资源告警</h2>
+{{- if .Alerts}}
+<table border="1" cellspacing="0" cellpadding="6">
+<tr><th>级别</th><th>规则</th><th>实例</th><th>集群</th><th>组</th><th>部门</th><th>内存</th><th>磁盘</th><th>CPU</th><th>开始时间</th></tr>
+{{- range .Alerts}}
+<tr style="background-color: {{severityColor .Severity}}">
+<td>{{.Severity}}</td><td>{{.RuleName}}</td><td>{{.IP}}:{{.Port}}</td><td>{{.ClusterName}}</td><td>{{.GroupName}}</td><td>{{.DepartmentName}}</td>
+<td class="num">{{pct .Values.MemoryUsage}}</td><td class="num">{{pct .Values.DiskUsage}}</td><td class="num">{{pct .Values.CPULoad}}</td><td>{{localtime .Since}}</td>
+</tr>
+{{- end}}
+</table>
+{{- else}}
+<p>当前没有触发的告警。</p>
+{{- end}}
+
+<h2>集群资源使用情况</h2>
+<p class="legend muted">图例：<span style="background-color: {{.Colors.Memory}}"></span>内存<span style="background-color: {{.Colors.Disk}}"></span>磁盘<span style="background-color: {{.Colors.CPU}}"></span>CPU</p>
+{{- range .Groups}}
+<h3>{{.GroupName}}</h3>
+<table border="1" cellspacing="0" cellpadding="6">
+<tr><th>集群</th><th>实例数</th><th>内存</th><th>磁盘</th><th>CPU</th><th>预计磁盘写满</th><th>使用率（0-100%）</th></tr>
+{{- range .Clusters}}
+<tr>
+<td>{{.ClusterName}}</td><td class="num">{{.Servers}}</td>
+{{- if .Servers}}
+<td class="num">{{pct .MemoryUsage}}</td><td class="num">{{pct .DiskUsage}}</td><td class="num">{{pct .CPUUsage}}</td>
+{{- else}}
+<td class="num">-</td><td class="num">-</td><td class="num">-</td>
+{{- end}}
+<td>{{or .FullDate "N/A"}}</td>
+<td>{{with index $.Charts .ClusterName}}<img src="{{.}}" width="{{$.ChartWidth}}" height="{{$.ChartHeight}}" alt="使用率">{{end}}</td>
+</tr>
+{{- end}}
+</table>
+{{- end}}
+
+<h2>服务器资源详情</h2>
+<table border="1" cellspacing="0" cellpadding="6">
+<tr><th>IP</th><th>端口</th><th>集群</th><th>组</th><th>部门</th><th>CPU</th><th>内存</th><th>磁盘</th></tr>
+{{- range .Servers}}
+<tr><td>{{.IP}}</td><td class="num">{{.Port}}</td><td>{{.ClusterName}}</td><td>{{.GroupName}}</td><td>{{.DepartmentName}}</td>
+<td class="num">{{pct .CPUUsage}}</td><td class="num">{{pct .MemoryUsage}}</td><td class="num">{{pct .DiskUsage}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`
+
+// usageReportText 为报告邮件的纯文本部分
+const usageReportText = `{{.Title}}
+生成时间：{{localtime .GeneratedAt}}，共 {{len .Servers}} 个实例，{{len .Alerts}} 条告警
+
+== 资源告警 ==
+{{range .Alerts -}}
+[{{.Severity}}] {{.RuleName}} {{.IP}}:{{.Port}}（{{.ClusterName}} / {{.GroupName}}）内存 {{pct .Values.MemoryUsage}}，磁盘 {{pct .Values.DiskUsage}}，CPU {{pct .Values.CPULoad}}，开始于 {{localtime .Since}}
+{{else -}}
+当前没有触发的告警。
+{{end}}
+== 集群资源使用情况 ==
+{{range .Groups -}}
+[{{.GroupName}}]
+{{range .Clusters -}}
+- {{.ClusterName}}：{{.Servers}} 个实例{{if .Servers}}，内存 {{pct .MemoryUsage}}，磁盘 {{pct .DiskUsage}}，CPU {{pct .CPUUsage}}{{end}}，预计磁盘写满 {{or .FullDate "N/A"}}
+{{end}}
+{{end -}}
+== 服务器资源详情 ==
+{{range .Servers -}}
+{{.IP}}:{{.Port}}（{{.ClusterName}} / {{.GroupName}}）CPU {{pct .CPUUsage}}，内存 {{pct .MemoryUsage}}，磁盘 {{pct .DiskUsage}}
+{{end -}}
+`
+
+var reportFuncs = map[string]any{
+	"pct": func(v float64) string {
+		return fmt.Sprintf("%.2f%%", v)
+	},
+	"localtime": func(t time.Time) string {
+		return t.Local().Format("2006-01-02 15:04:05")
+	},
+	"severityColor": func(severity string) template.CSS {
+		switch severity {
+		case SeverityCritical:
+			return "#f8d7da"
+		case SeverityWarning:
+			return "#fff3cd"
+		}
+		return "#ffffff"
+	},
+}
+
+var (
+	usageReportHTMLTemplate = template.Must(template.New("usage_report.html").Funcs(reportFuncs).Parse(usageReportHTML))
+	usageReportTextTemplate = texttemplate.Must(texttemplate.New("usage_report.txt").Funcs(reportFuncs).Parse(usageReportText))
+)
+
+// 集群使用率小图的尺寸：三根横条依次为内存、磁盘、CPU，刻度为 0-100%
+const (
+	chartWidth  = 200
+	chartHeight = 30
+)
+
+// usageReportView 为 HTML 模板的数据，Charts 为集群名到图表地址的映射
+type usageReportView struct {
+	*UsageReport
+	Charts      map[string]template.URL
+	ChartWidth  int
+	ChartHeight int
+	Colors      struct{ Memory, Disk, CPU template.CSS }
+}
+
+func hexColor(c color.RGBA) template.CSS {
+	return template.CSS(fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B))
+}
+
+// usageChartPNG 画一个集群的内存、磁盘、CPU 使用率横条
+func usageChartPNG(cluster ClusterUsage) ([]byte, error) {
+	palette := color.Palette{color.White, color.RGBA{221, 221, 221, 255}, memoryColor, diskColor, cpuColor}
+	img := image.NewPaletted(image.Rect(0, 0, chartWidth, chartHeight), palette)
+	// 0、25%、50%、75%、100% 处的刻度线
+	for i := 0; i <= 4; i++ {
+		x := min(i*chartWidth/4, chartWidth-1)
+		for y := 0; y < chartHeight; y++ {
+			img.SetColorIndex(x, y, 1)
+		}
+	}
+	for i, v := range []float64{cluster.MemoryUsage, cluster.DiskUsage, cluster.CPUUsage} {
+		w := int(max(0, min(v, 100)) / 100 * chartWidth)
+		top := 2 + i*9
+		for y := top; y < top+8; y++ {
+			for x := 0; x < w; x++ {
+				img.SetColorIndex(x, y, uint8(2+i))
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderUsageReportHTML 渲染 HTML 正文。inline 为 true 时图表作为邮件内嵌附件返回并以 cid: 引用，
+// 否则以 data: 地址直接写在页面中
+func renderUsageReportHTML(r *UsageReport, inline bool) (string, []models.EmailAttachment, error) {
+	view := usageReportView{UsageReport: r, Charts: make(map[string]template.URL), ChartWidth: chartWidth, ChartHeight: chartHeight}
+	view.Colors.Memory, view.Colors.Disk, view.Colors.CPU = hexColor(memoryColor), hexColor(diskColor), hexColor(cpuColor)
+
+	var attachments []models.EmailAttachment
+	for _, group := range r.Groups {
+		for _, cluster := range group.Clusters {
+			if cluster.Servers == 0 {
+				continue
+			}
+			chart, err := usageChartPNG(cluster)
+			if err != nil {
+				return "", nil, err
+			}
+			if !inline {
+				view.Charts[cluster.ClusterName] = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(chart))
+				continue
+			}
+			name := fmt.Sprintf("usage-%d.png", len(attachments)+1)
+			attachments = append(attachments, models.EmailAttachment{Filename: name, ContentType: "image/png", Inline: true, Content: chart})
+			view.Charts[cluster.ClusterName] = template.URL("cid:" + name)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := usageReportHTMLTemplate.Execute(&buf, view); err != nil {
+		return "", nil, fmt.Errorf("render template: %w", err)
+	}
+	return buf.String(), attachments, nil
+}
+
+func renderUsageReportText(r *UsageReport) (string, error) {
+	var buf bytes.Buffer
+	if err := usageReportTextTemplate.Execute(&buf, r); err != nil {
+		return "", fmt.Errorf("render template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// UsageReportEmail 把报告渲染为带纯文本备选正文和内嵌图表的邮件，withPDF 时附带完整的 PDF 版本
+func UsageReportEmail(r *UsageReport, to []string, withPDF bool) (OutboundEmail, error) {
+	body, attachments, err := renderUsageReportHTML(r, true)
+	if err != nil {
+		return OutboundEmail{}, err
+	}
+	text, err := renderUsageReportText(r)
+	if err != nil {
+		return OutboundEmail{}, err
+	}
+	if withPDF {
+		attachments = append(attachments, models.EmailAttachment{
+			Filename:    "usage_report_" + r.GeneratedAt.Local().Format("20060102") + ".pdf",
+			ContentType: "application/pdf",
+			Content:     usageReportPDF(r),
+		})
+	}
+	return OutboundEmail{
+		To:          to,
+		Subject:     r.Title,
+		HTML:        body,
+		Text:        text,
+		Attachments: attachments,
+	}, nil
+}
+
+// SendUsageReport 生成资源使用情况报告并写入发件队列。
+// 请求体：email 为逗号分隔的收件人，pdf 为 true 时附带 PDF 版本，profile 为发件账号
+func (s *ReportService) SendUsageReport(c *gin.Context) {
+	var req struct {
+		Email   string `json:"email" binding:"required"`
+		PDF     bool   `json:"pdf"`
+		Profile string `json:"profile"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to, err := parseRecipients(req.Email)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter, ok := scopedReportFilter(c, ReportFilter{})
+	if !ok {
+		return
+	}
+	report, err := s.BuildUsageReport(time.Now(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	email, err := UsageReportEmail(report, to, req.PDF)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	email.Profile = req.Profile
+	id, err := s.Email.Enqueue(email)
+	if errors.Is(err, errUnknownProfile) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to queue usage report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue report"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Report queued for sending", "message_id": id})
+}
+
+// PreviewUsageReport 返回报告本身，format 为 html（默认，图表内联在页面中）、text、pdf 或 json
+func (s *ReportService) PreviewUsageReport(c *gin.Context) {
+	format := c.DefaultQuery("format", "html")
+	switch format {
+	case "html", "text", "pdf", "json":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be one of html, text, pdf, json"})
+		return
+	}
+	filter, ok := scopedReportFilter(c, ReportFilter{})
+	if !ok {
+		return
+	}
+	report, err := s.BuildUsageReport(time.Now(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch format {
+	case "html":
+		body, _, err := renderUsageReportHTML(report, false)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(body))
+	case "text":
+		text, err := renderUsageReportText(report)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(text))
+	case "pdf":
+		c.Header("Content-Disposition", "attachment; filename=usage_report.pdf")
+		c.Data(http.StatusOK, "application/pdf", usageReportPDF(report))
+	case "json":
+		c.JSON(http.StatusOK, report)
+	}
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"time"
+
+	"cmdb/pdf"
+)
+
+// tableReport 为只包含标题、说明和表格的报告，用于生成机房、集群组和告警汇总报告的 HTML 版本。
+// 表格与 PDF 版本相同，不包含图表
+type tableReport struct {
+	Title       string
+	GeneratedAt time.Time
+	Lines       []string
+	Sections    []tableSection
+}
+
+// tableSection 为报告中的一节，Level 为 2 或 3，Table 为空时只有标题和说明
+type tableSection struct {
+	Level int
+	Title string
+	Note  string
+	Table *pdf.Table
+}
+
+const tableReportHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+  body { font-family: Arial, "Microsoft YaHei", sans-serif; font-size: 14px; color: #222; }
+  table { border-collapse: collapse; width: 100%; margin-bottom: 16px; }
+  th, td { border: 1px solid #ddd; padding: 6px 8px; text-align: left; }
+  th { background-color: #f2f2f2; }
+  td.num { text-align: right; }
+  .muted { color: #666; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="muted">生成时间：{{localtime .GeneratedAt}}</p>
+{{- range .Lines}}
+<p class="muted">{{.}}</p>
+{{- end}}
+{{- range .Sections}}
+{{if eq .Level 3}}<h3>{{.Title}}</h3>{{else}}<h2>{{.Title}}</h2>{{end}}
+{{- with .Note}}
+<p class="muted">{{.}}</p>
+{{- end}}
+{{- with .Table}}
+{{- $table := .}}
+<table border="1" cellspacing="0" cellpadding="6">
+<tr>{{range .Columns}}<th>{{.Title}}</th>{{end}}</tr>
+{{- range $i, $row := .Rows}}
+<tr{{with rowStyle $table $i}} style="{{.}}"{{end}}>{{range $j, $cell := $row}}<td{{if rightAligned $table $j}} class="num"{{end}}>{{$cell}}</td>{{end}}</tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`
+
+var tableReportTemplate = template.Must(template.New("table_report.html").Funcs(reportFuncs).Funcs(template.FuncMap{
+	// rowStyle 把 PDF 表格中的行文字颜色转换为 CSS，黑色不输出
+	"rowStyle": func(t *pdf.Table, i int) template.CSS {
+		if t.RowColor == nil {
+			return ""
+		}
+		c := t.RowColor(i)
+		if c == pdf.Black || c.A == 0 {
+			return ""
+		}
+		return template.CSS(fmt.Sprintf("color: #%02x%02x%02x", c.R, c.G, c.B))
+	},
+	"rightAligned": func(t *pdf.Table, j int) bool {
+		return j < len(t.Columns) && t.Columns[j].Align == pdf.AlignRight
+	},
+}).Parse(tableReportHTML))
+
+// HTML 渲染报告为完整的 HTML 文档
+func (r tableReport) HTML() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tableReportTemplate.Execute(&buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func idcReportTables(r *IDCReport) tableReport {
+	overview := idcTable(r)
+	report := tableReport{
+		Title:       r.Title,
+		GeneratedAt: r.GeneratedAt,
+		Lines:       []string{fmt.Sprintf("机房数：%d", len(r.IDCs))},
+		Sections:    []tableSection{{Level: 2, Title: "概览", Table: &overview}},
+	}
+	for _, idc := range r.IDCs {
+		table := clusterTable(idcClusters(idc))
+		report.Sections = append(report.Sections, tableSection{Level: 3, Title: idc.IDCName, Note: idcNote(idc), Table: &table})
+	}
+	return report
+}
+
+func clusterGroupReportTables(r *ClusterGroupReport) tableReport {
+	totals := make([]ClusterUsage, len(r.Groups))
+	for i, group := range r.Groups {
+		totals[i] = groupTotals(group)
+	}
+	overview := clusterTable(totals)
+	report := tableReport{
+		Title:       r.Title,
+		GeneratedAt: r.GeneratedAt,
+		Lines:       []string{fmt.Sprintf("集群组：%d", len(r.Groups))},
+		Sections: []tableSection{{Level: 2, Title: "概览", Table: &overview,
+			Note: "组的使用率按实例数加权平均，预计磁盘写满取组内最早的集群。"}},
+	}
+	for _, group := range r.Groups {
+		table := clusterTable(group.Clusters)
+		report.Sections = append(report.Sections, tableSection{Level: 3, Title: group.GroupName, Table: &table})
+	}
+	return report
+}
+
+func alertSummaryTables(r *AlertSummaryReport) tableReport {
+	counts := alertCountTable(r.Counts)
+	report := tableReport{
+		Title:       r.Title,
+		GeneratedAt: r.GeneratedAt,
+		Lines: []string{fmt.Sprintf("统计范围：%s 至 %s",
+			r.From.Local().Format("2006-01-02 15:04"), r.To.Local().Format("2006-01-02 15:04"))},
+		Sections: []tableSection{{Level: 2, Title: "概览", Table: &counts}},
+	}
+	open := tableSection{Level: 2, Title: "未恢复的告警"}
+	if len(r.Groups) == 0 {
+		open.Note = "当前没有未恢复的告警。"
+	}
+	report.Sections = append(report.Sections, open)
+	for _, group := range r.Groups {
+		table := openAlertTable(group.Alerts, r.GeneratedAt)
+		report.Sections = append(report.Sections, tableSection{Level: 3, Title: group.GroupName, Table: &table})
+	}
+	rules := tableSection{Level: 2, Title: "时间范围内的告警", Note: "时间范围内没有开始的告警。"}
+	if len(r.Rules) > 0 {
+		table := alertRuleTable(r.Rules)
+		rules.Note, rules.Table = "", &table
+	}
+	report.Sections = append(report.Sections, rules)
+	return report
+}
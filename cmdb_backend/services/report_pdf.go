@@ -0,0 +1,422 @@
+package services
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"time"
+
+	"cmdb/models"
+	"cmdb/pdf"
+)
+
+// reportFooter 在每页底部写报告标题和页码
+func reportFooter(title string) func(p *pdf.Page, n, total int) {
+	return func(p *pdf.Page, n, total int) {
+		font := pdf.Font{Size: 8, Color: pdf.Gray}
+		y := pdf.PageHeight - 24
+		p.Text(48, y, font, title)
+		label := fmt.Sprintf("第 %d / %d 页", n, total)
+		p.Text(pdf.PageWidth-48-pdf.TextWidth(label, font.Size), y, font, label)
+	}
+}
+
+// criticalColor 为 critical 告警行的文字颜色
+var criticalColor = color.RGBA{176, 0, 32, 255}
+
+var usageSeries = []pdf.Series{{Name: "内存", Color: memoryColor}, {Name: "磁盘", Color: diskColor}, {Name: "CPU", Color: cpuColor}}
+
+func pctCell(v float64) string {
+	return fmt.Sprintf("%.2f%%", v)
+}
+
+// usageReportPDF 把资源使用情况报告排版为分页的 PDF，表格跨页时重复表头
+func usageReportPDF(r *UsageReport) []byte {
+	doc := pdf.New()
+	doc.Title = r.Title
+	doc.Created = r.GeneratedAt
+	f := pdf.NewFlow(doc)
+	f.Footer = reportFooter(r.Title)
+
+	f.Heading(1, r.Title)
+	f.Paragraph(pdf.Font{Size: 9, Color: pdf.Gray}, fmt.Sprintf("生成时间：%s，共 %d 个实例，%d 条告警",
+		r.GeneratedAt.Local().Format("2006-01-02 15:04:05"), len(r.Servers), len(r.Alerts)))
+
+	f.Heading(2, "资源告警")
+	if len(r.Alerts) == 0 {
+		f.Paragraph(pdf.Font{Size: 10}, "当前没有触发的告警。")
+	} else {
+		rows := make([][]string, len(r.Alerts))
+		for i, a := range r.Alerts {
+			rows[i] = []string{a.Severity, a.RuleName, a.IP + ":" + strconv.FormatUint(uint64(a.Port), 10), a.ClusterName,
+				pctCell(a.Values.MemoryUsage), pctCell(a.Values.DiskUsage), pctCell(a.Values.CPULoad),
+				a.Since.Local().Format("01-02 15:04")}
+		}
+		f.Table(pdf.Table{
+			Columns: []pdf.Column{{Title: "级别", Width: 7}, {Title: "规则", Width: 16}, {Title: "实例", Width: 16},
+				{Title: "集群", Width: 14}, {Title: "内存", Width: 9, Align: pdf.AlignRight}, {Title: "磁盘", Width: 9, Align: pdf.AlignRight},
+				{Title: "CPU", Width: 9, Align: pdf.AlignRight}, {Title: "开始时间", Width: 11}},
+			Rows: rows,
+			RowColor: func(i int) color.RGBA {
+				if r.Alerts[i].Severity == SeverityCritical {
+					return criticalColor
+				}
+				return pdf.Black
+			},
+		})
+	}
+
+	f.Heading(2, "集群资源使用情况")
+	for _, group := range r.Groups {
+		f.Heading(3, group.GroupName)
+		chart := pdf.BarChart{Series: usageSeries, Unit: "%"}
+		rows := make([][]string, len(group.Clusters))
+		for i, cluster := range group.Clusters {
+			chart.Labels = append(chart.Labels, cluster.ClusterName)
+			chart.Values = append(chart.Values, []float64{cluster.MemoryUsage, cluster.DiskUsage, cluster.CPUUsage})
+			fullDate := cluster.FullDate
+			if fullDate == "" {
+				fullDate = "N/A"
+			}
+			rows[i] = []string{cluster.ClusterName, strconv.Itoa(cluster.Servers), "-", "-", "-", fullDate}
+			if cluster.Servers > 0 {
+				rows[i][2], rows[i][3], rows[i][4] = pctCell(cluster.MemoryUsage), pctCell(cluster.DiskUsage), pctCell(cluster.CPUUsage)
+			}
+		}
+		f.BarChart(chart)
+		f.Space(6)
+		f.Table(pdf.Table{
+			Columns: []pdf.Column{{Title: "集群", Width: 24}, {Title: "实例数", Width: 10, Align: pdf.AlignRight},
+				{Title: "内存", Width: 12, Align: pdf.AlignRight}, {Title: "磁盘", Width: 12, Align: pdf.AlignRight},
+				{Title: "CPU", Width: 12, Align: pdf.AlignRight}, {Title: "预计磁盘写满", Width: 16}},
+			Rows: rows,
+		})
+		f.Space(6)
+	}
+
+	f.Heading(2, "服务器资源详情")
+	rows := make([][]string, len(r.Servers))
+	for i, s := range r.Servers {
+		rows[i] = []string{s.IP, strconv.FormatUint(uint64(s.Port), 10), s.ClusterName, s.GroupName, s.DepartmentName,
+			pctCell(s.CPUUsage), pctCell(s.MemoryUsage), pctCell(s.DiskUsage)}
+	}
+	f.Table(pdf.Table{
+		Columns: []pdf.Column{{Title: "IP", Width: 15}, {Title: "端口", Width: 7, Align: pdf.AlignRight}, {Title: "集群", Width: 15},
+			{Title: "组", Width: 13}, {Title: "部门", Width: 12}, {Title: "CPU", Width: 9, Align: pdf.AlignRight},
+			{Title: "内存", Width: 9, Align: pdf.AlignRight}, {Title: "磁盘", Width: 9, Align: pdf.AlignRight}},
+		Rows: rows,
+	})
+
+	f.Finish()
+	return doc.Bytes()
+}
+
+func generatedLine(t time.Time) string {
+	return "生成时间：" + t.Local().Format("2006-01-02 15:04:05")
+}
+
+// usageRow 为集群使用率表格的一行，没有实例的集群不显示使用率
+func usageRow(name string, c ClusterUsage) []string {
+	fullDate := c.FullDate
+	if fullDate == "" {
+		fullDate = "N/A"
+	}
+	row := []string{name, strconv.Itoa(c.Servers), "-", "-", "-", fullDate}
+	if c.Servers > 0 {
+		row[2], row[3], row[4] = pctCell(c.MemoryUsage), pctCell(c.DiskUsage), pctCell(c.CPUUsage)
+	}
+	return row
+}
+
+var usageColumns = []pdf.Column{{Title: "集群", Width: 24}, {Title: "实例数", Width: 10, Align: pdf.AlignRight},
+	{Title: "内存", Width: 12, Align: pdf.AlignRight}, {Title: "磁盘", Width: 12, Align: pdf.AlignRight},
+	{Title: "CPU", Width: 12, Align: pdf.AlignRight}, {Title: "预计磁盘写满", Width: 16}}
+
+// groupTotals 按实例数加权汇总组内各集群的使用率，FullDate 取最早的日期
+func groupTotals(group GroupUsage) ClusterUsage {
+	total := ClusterUsage{ClusterName: group.GroupName}
+	for _, c := range group.Clusters {
+		n := float64(c.Servers)
+		total.Servers += c.Servers
+		total.MemoryUsage += c.MemoryUsage * n
+		total.DiskUsage += c.DiskUsage * n
+		total.CPUUsage += c.CPUUsage * n
+		if c.FullDate != "" && (total.FullDate == "" || c.FullDate < total.FullDate) {
+			total.FullDate = c.FullDate
+		}
+	}
+	if total.Servers > 0 {
+		n := float64(total.Servers)
+		total.MemoryUsage /= n
+		total.DiskUsage /= n
+		total.CPUUsage /= n
+	}
+	return total
+}
+
+// clusterTable 为集群使用率表格，PDF 和 HTML 报告共用
+func clusterTable(clusters []ClusterUsage) pdf.Table {
+	rows := make([][]string, len(clusters))
+	for i, c := range clusters {
+		rows[i] = usageRow(c.ClusterName, c)
+	}
+	return pdf.Table{Columns: usageColumns, Rows: rows}
+}
+
+// clusterSection 写一组集群的条形图和表格
+func clusterSection(f *pdf.Flow, clusters []ClusterUsage) {
+	chart := pdf.BarChart{Series: usageSeries, Unit: "%"}
+	for _, c := range clusters {
+		chart.Labels = append(chart.Labels, c.ClusterName)
+		chart.Values = append(chart.Values, []float64{c.MemoryUsage, c.DiskUsage, c.CPUUsage})
+	}
+	f.BarChart(chart)
+	f.Space(6)
+	f.Table(clusterTable(clusters))
+	f.Space(10)
+}
+
+// idcTable 为各机房概览表格
+func idcTable(r *IDCReport) pdf.Table {
+	rows := make([][]string, len(r.IDCs))
+	for i, idc := range r.IDCs {
+		rows[i] = []string{idc.IDCName, strconv.Itoa(idc.TotalInstances), pctCell(idc.AvgMemoryUsage),
+			pctCell(idc.AvgDiskUsage), pctCell(idc.AvgCPUUsage)}
+	}
+	return pdf.Table{
+		Columns: []pdf.Column{{Title: "机房", Width: 24}, {Title: "实例数", Width: 12, Align: pdf.AlignRight},
+			{Title: "平均内存", Width: 14, Align: pdf.AlignRight}, {Title: "平均磁盘", Width: 14, Align: pdf.AlignRight},
+			{Title: "平均 CPU", Width: 14, Align: pdf.AlignRight}},
+		Rows: rows,
+	}
+}
+
+// idcNote 为机房明细前的一行说明
+func idcNote(idc IDCSection) string {
+	return fmt.Sprintf("%d 个实例，平均内存 %s，磁盘 %s，CPU %s",
+		idc.TotalInstances, pctCell(idc.AvgMemoryUsage), pctCell(idc.AvgDiskUsage), pctCell(idc.AvgCPUUsage))
+}
+
+// idcClusters 把机房内各组的集群合并为一个列表，集群名前加上组名
+func idcClusters(idc IDCSection) []ClusterUsage {
+	var clusters []ClusterUsage
+	for _, group := range idc.Groups {
+		for _, c := range group.Clusters {
+			c.ClusterName = group.GroupName + " / " + c.ClusterName
+			clusters = append(clusters, c)
+		}
+	}
+	return clusters
+}
+
+// idcReportPDF 排版机房报告：封面、目录、各机房概览，以及每个机房按组、集群的明细
+func idcReportPDF(r *IDCReport) []byte {
+	doc := pdf.New()
+	doc.Created = r.GeneratedAt
+	rep := pdf.NewReport(doc, r.Title)
+	var instances int
+	for _, idc := range r.IDCs {
+		instances += idc.TotalInstances
+	}
+	rep.Cover("按机房汇总的 CPU、内存和磁盘使用率", generatedLine(r.GeneratedAt),
+		fmt.Sprintf("机房数：%d，实例数：%d", len(r.IDCs), instances))
+	rep.Contents(2 + len(r.IDCs))
+
+	rep.Section(1, "概览")
+	chart := pdf.BarChart{Series: usageSeries, Unit: "%"}
+	for _, idc := range r.IDCs {
+		chart.Labels = append(chart.Labels, idc.IDCName)
+		chart.Values = append(chart.Values, []float64{idc.AvgMemoryUsage, idc.AvgDiskUsage, idc.AvgCPUUsage})
+	}
+	rep.BarChart(chart)
+	rep.Space(6)
+	rep.Table(idcTable(r))
+
+	rep.Section(1, "各机房明细")
+	for _, idc := range r.IDCs {
+		rep.Section(2, idc.IDCName)
+		rep.Paragraph(pdf.Font{Size: 9, Color: pdf.Gray}, idcNote(idc))
+		clusterSection(rep.Flow, idcClusters(idc))
+	}
+
+	rep.Finish()
+	return doc.Bytes()
+}
+
+// clusterGroupReportPDF 排版集群组报告：封面、目录、各组概览，以及每个组一节的集群图表和明细
+func clusterGroupReportPDF(r *ClusterGroupReport) []byte {
+	doc := pdf.New()
+	doc.Created = r.GeneratedAt
+	rep := pdf.NewReport(doc, r.Title)
+	totals := make([]ClusterUsage, len(r.Groups))
+	var clusters, instances int
+	for i, group := range r.Groups {
+		totals[i] = groupTotals(group)
+		clusters += len(group.Clusters)
+		instances += totals[i].Servers
+	}
+	rep.Cover("按集群组汇总的 CPU、内存和磁盘使用率及磁盘写满预测", generatedLine(r.GeneratedAt),
+		fmt.Sprintf("集群组：%d，集群：%d，实例：%d", len(r.Groups), clusters, instances))
+	rep.Contents(2 + len(r.Groups))
+
+	rep.Section(1, "概览")
+	rep.Paragraph(pdf.Font{Size: 9, Color: pdf.Gray}, "组的使用率按实例数加权平均，预计磁盘写满取组内最早的集群。")
+	clusterSection(rep.Flow, totals)
+
+	rep.Section(1, "各集群组明细")
+	for _, group := range r.Groups {
+		rep.Section(2, group.GroupName)
+		clusterSection(rep.Flow, group.Clusters)
+	}
+
+	rep.Finish()
+	return doc.Bytes()
+}
+
+var stateNames = map[string]string{
+	AlertFiring:       "告警中",
+	AlertAcknowledged: "已确认",
+	AlertSilenced:     "已静默",
+	AlertResolved:     "已恢复",
+}
+
+func optionalPct(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return pctCell(*v)
+}
+
+// alertSummaryPDF 排版告警汇总：封面、目录、按级别和状态的数量、每个组未恢复的告警，以及时间范围内各规则的统计
+func alertSummaryPDF(r *AlertSummaryReport) []byte {
+	doc := pdf.New()
+	doc.Created = r.GeneratedAt
+	rep := pdf.NewReport(doc, r.Title)
+	var open int
+	for _, c := range r.Counts {
+		open += c.Total()
+	}
+	rep.Cover(fmt.Sprintf("统计范围：%s 至 %s", r.From.Local().Format("2006-01-02 15:04"), r.To.Local().Format("2006-01-02 15:04")),
+		generatedLine(r.GeneratedAt), fmt.Sprintf("未恢复告警：%d，范围内触发的规则：%d", open, len(r.Rules)))
+	rep.Contents(3 + len(r.Groups))
+
+	rep.Section(1, "概览")
+	rep.Table(alertCountTable(r.Counts))
+	if len(r.Groups) > 0 {
+		rep.Space(10)
+		rep.Paragraph(pdf.Font{Size: 10, Bold: true}, "各组未恢复的告警数")
+		chart := pdf.BarChart{
+			Series: []pdf.Series{{Name: SeverityCritical, Color: color.RGBA{214, 39, 40, 255}},
+				{Name: SeverityWarning, Color: color.RGBA{242, 142, 43, 255}}, {Name: SeverityInfo, Color: color.RGBA{78, 121, 167, 255}}},
+			Format: "%.0f",
+		}
+		var most float64
+		for _, group := range r.Groups {
+			counts := map[string]float64{}
+			for _, a := range group.Alerts {
+				counts[a.Severity]++
+			}
+			values := []float64{counts[SeverityCritical], counts[SeverityWarning], counts[SeverityInfo]}
+			for _, v := range values {
+				most = max(most, v)
+			}
+			chart.Labels = append(chart.Labels, group.GroupName)
+			chart.Values = append(chart.Values, values)
+		}
+		chart.Max = pdf.NiceMax(most)
+		rep.BarChart(chart)
+	}
+
+	rep.Section(1, "未恢复的告警")
+	if len(r.Groups) == 0 {
+		rep.Paragraph(pdf.Font{Size: 10}, "当前没有未恢复的告警。")
+	}
+	for _, group := range r.Groups {
+		rep.Section(2, group.GroupName)
+		rep.Table(openAlertTable(group.Alerts, r.GeneratedAt))
+		rep.Space(10)
+	}
+
+	rep.Section(1, "时间范围内的告警")
+	if len(r.Rules) == 0 {
+		rep.Paragraph(pdf.Font{Size: 10}, "时间范围内没有开始的告警。")
+	} else {
+		rep.Table(alertRuleTable(r.Rules))
+	}
+
+	rep.Finish()
+	return doc.Bytes()
+}
+
+// alertCountTable 为未恢复告警按级别、状态的数量，最后一行为合计
+func alertCountTable(counts []AlertStateCount) pdf.Table {
+	row := func(label string, c AlertStateCount) []string {
+		return []string{label, strconv.Itoa(c.Firing), strconv.Itoa(c.Acknowledged), strconv.Itoa(c.Silenced), strconv.Itoa(c.Total())}
+	}
+	rows := make([][]string, 0, len(counts)+1)
+	var sum AlertStateCount
+	for _, c := range counts {
+		rows = append(rows, row(c.Severity, c))
+		sum.Firing += c.Firing
+		sum.Acknowledged += c.Acknowledged
+		sum.Silenced += c.Silenced
+	}
+	rows = append(rows, row("合计", sum))
+	return pdf.Table{
+		Columns: []pdf.Column{{Title: "级别", Width: 20}, {Title: "告警中", Width: 15, Align: pdf.AlignRight},
+			{Title: "已确认", Width: 15, Align: pdf.AlignRight}, {Title: "已静默", Width: 15, Align: pdf.AlignRight},
+			{Title: "合计", Width: 15, Align: pdf.AlignRight}},
+		Rows: rows,
+	}
+}
+
+// openAlertTable 为未恢复的告警，持续时长算到 now，critical 告警标红
+func openAlertTable(alerts []models.Alert, now time.Time) pdf.Table {
+	rows := make([][]string, len(alerts))
+	for i, a := range alerts {
+		rows[i] = []string{a.Severity, a.RuleName, a.IP + ":" + strconv.FormatUint(uint64(a.Port), 10), a.ClusterName,
+			stateNames[a.State], optionalPct(a.MemoryUsage), optionalPct(a.DiskUsage),
+			a.StartsAt.Local().Format("01-02 15:04"), formatDuration(now.Sub(a.StartsAt))}
+	}
+	return pdf.Table{
+		Columns: []pdf.Column{{Title: "级别", Width: 8}, {Title: "规则", Width: 15}, {Title: "实例", Width: 16},
+			{Title: "集群", Width: 12}, {Title: "状态", Width: 8}, {Title: "内存", Width: 9, Align: pdf.AlignRight},
+			{Title: "磁盘", Width: 9, Align: pdf.AlignRight}, {Title: "开始时间", Width: 11}, {Title: "持续", Width: 9, Align: pdf.AlignRight}},
+		Rows: rows,
+		RowColor: func(i int) color.RGBA {
+			if alerts[i].Severity == SeverityCritical {
+				return criticalColor
+			}
+			return pdf.Black
+		},
+	}
+}
+
+// alertRuleTable 为时间范围内各规则的触发统计
+func alertRuleTable(rules []AlertRuleStats) pdf.Table {
+	rows := make([][]string, len(rules))
+	for i, rule := range rules {
+		rows[i] = []string{rule.RuleName, rule.Severity, strconv.Itoa(rule.Started), strconv.Itoa(rule.Resolved),
+			formatDuration(rule.AvgDuration)}
+	}
+	return pdf.Table{
+		Columns: []pdf.Column{{Title: "规则", Width: 30}, {Title: "级别", Width: 12}, {Title: "触发次数", Width: 14, Align: pdf.AlignRight},
+			{Title: "已恢复", Width: 14, Align: pdf.AlignRight}, {Title: "平均持续", Width: 16, Align: pdf.AlignRight}},
+		Rows: rows,
+	}
+}
+
+// formatDuration 以天、小时、分钟显示时长
+func formatDuration(d time.Duration) string {
+	d = d.Truncate(time.Minute)
+	days := d / (24 * time.Hour)
+	hours := (d % (24 * time.Hour)) / time.Hour
+	minutes := (d % time.Hour) / time.Minute
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cmdb/models"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// reportNow 为 golden 报告的生成时间，报告中的本地时间按 UTC+8 显示
+var reportNow = time.Date(2024, 3, 4, 9, 30, 0, 0, time.FixedZone("CST", 8*3600))
+
+func reportGroups() []GroupUsage {
+	return []GroupUsage{
+		{GroupName: "G1", Clusters: []ClusterUsage{
+			{ClusterName: "c1", Servers: 3, MemoryUsage: 72.5, DiskUsage: 88.1, CPUUsage: 0.42, FullDate: "2024-03-20"},
+			{ClusterName: "c2", Servers: 1, MemoryUsage: 35, DiskUsage: 41.3, CPUUsage: 1.5},
+		}},
+		{GroupName: "G2", Clusters: []ClusterUsage{
+			{ClusterName: "c3", Servers: 2, MemoryUsage: 55.2, DiskUsage: 60, CPUUsage: 0.8},
+			// 已登记但没有实例的集群
+			{ClusterName: "c4"},
+		}},
+	}
+}
+
+func TestReportPDFGolden(t *testing.T) {
+	local := time.Local
+	time.Local = reportNow.Location()
+	t.Cleanup(func() { time.Local = local })
+
+	groups := reportGroups()
+	memory, disk := 91.5, 88.1
+	alerts := []models.Alert{
+		{RuleName: "内存使用率过高", Severity: SeverityCritical, IP: "10.1.0.1", Port: 3306, ClusterName: "c1", GroupName: "G1",
+			State: AlertFiring, MemoryUsage: &memory, DiskUsage: &disk, StartsAt: reportNow.Add(-26 * time.Hour)},
+		{RuleName: "磁盘将满", Severity: SeverityWarning, IP: "10.1.0.2", Port: 3306, ClusterName: "c1", GroupName: "G1",
+			State: AlertAcknowledged, DiskUsage: &disk, StartsAt: reportNow.Add(-90 * time.Minute)},
+	}
+
+	cases := []struct {
+		file string
+		pdf  []byte
+	}{
+		{"idc_report.pdf", idcReportPDF(&IDCReport{
+			Title:       "机房资源使用报告",
+			GeneratedAt: reportNow,
+			IDCs: []IDCSection{
+				{IDCUsage: models.IDCUsage{IDCName: "BJ-1", TotalInstances: 4, AvgCPUUsage: 0.69, AvgMemoryUsage: 63.1, AvgDiskUsage: 76.4},
+					Groups: groups[:1]},
+				{IDCUsage: models.IDCUsage{IDCName: "SH-1", TotalInstances: 2, AvgCPUUsage: 0.8, AvgMemoryUsage: 55.2, AvgDiskUsage: 60},
+					Groups: groups[1:]},
+			},
+		})},
+		{"cluster_group_report.pdf", clusterGroupReportPDF(&ClusterGroupReport{
+			Title:       "集群组资源使用报告",
+			GeneratedAt: reportNow,
+			Groups:      groups,
+		})},
+		{"alert_summary.pdf", alertSummaryPDF(&AlertSummaryReport{
+			Title:       "告警汇总",
+			GeneratedAt: reportNow,
+			From:        reportNow.AddDate(0, 0, -7),
+			To:          reportNow,
+			Counts: []AlertStateCount{
+				{Severity: SeverityCritical, Firing: 1},
+				{Severity: SeverityWarning, Acknowledged: 1},
+				{Severity: SeverityInfo},
+			},
+			Groups: []AlertGroup{{GroupName: "G1", Alerts: alerts}},
+			Rules: []AlertRuleStats{
+				{RuleName: "内存使用率过高", Severity: SeverityCritical, Started: 3, Resolved: 2, AvgDuration: 5 * time.Hour},
+				{RuleName: "磁盘将满", Severity: SeverityWarning, Started: 1, AvgDuration: 90 * time.Minute},
+			},
+		})},
+	}
+	for _, tc := range cases {
+		path := filepath.Join("testdata", tc.file)
+		if *updateGolden {
+			if err := os.WriteFile(path, tc.pdf, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(tc.pdf, want) {
+			t.Errorf("%s differs from the golden file (%d bytes, want %d); rerun with -update after checking the output", tc.file, len(tc.pdf), len(want))
+		}
+	}
+}
@@ -1,11 +1,12 @@
 package services
 
 import (
+	"cmdb/blob"
+	"cmdb/config"
 	"cmdb/models"
+	"cmdb/pdf"
 	"fmt"
-	"net/http"
-	"sort"
-	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/xuri/excelize/v2"
@@ -13,90 +14,69 @@ import (
 )
 
 type ReportService struct {
-	DB *gorm.DB
+	DB       *gorm.DB
+	IDC      *IDCResolver
+	Forecast *ForecastService
+	Alerts   *AlertService
+	Email    *EmailService
+	// Blobs 保存存档的报告文件
+	Blobs blob.Store
+	store *config.Store
 }
 
-func (s *ReportService) GenerateClusterGroupReport(c *gin.Context) {
-	f := excelize.NewFile()
+func NewReportService(db *gorm.DB, idc *IDCResolver, forecast *ForecastService, alerts *AlertService, email *EmailService, blobs blob.Store, store *config.Store) *ReportService {
+	return &ReportService{DB: db, IDC: idc, Forecast: forecast, Alerts: alerts, Email: email, Blobs: blobs, store: store}
+}
 
-	var groups []models.ClusterGroup
-	if err := s.DB.Find(&groups).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+// clusterForecasts 返回每个集群中最早写满的实例，与 disk-full-prediction 接口使用相同的模型和参数
+func (s *ReportService) clusterForecasts(now time.Time) (map[string]*InstanceForecast, error) {
+	forecasts, err := s.Forecast.ForecastInstances(ModelLinear, defaultForecastDays, now, nil)
+	if err != nil {
+		return nil, err
 	}
-
-	for _, group := range groups {
-		sheetName := group.GroupName
-		index, err := f.NewSheet(sheetName)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+	byCluster := make(map[string][]InstanceForecast)
+	for _, f := range forecasts {
+		byCluster[f.ClusterName] = append(byCluster[f.ClusterName], f)
+	}
+	earliest := make(map[string]*InstanceForecast)
+	for cluster, list := range byCluster {
+		if e := EarliestFull(list); e != nil {
+			earliest[cluster] = e
 		}
-
-		f.SetCellValue(sheetName, "A1", "Cluster Name")
-		f.SetCellValue(sheetName, "B1", "Server Count")
-		f.SetCellValue(sheetName, "C1", "Avg CPU Usage")
-		f.SetCellValue(sheetName, "D1", "Avg Memory Usage")
-		f.SetCellValue(sheetName, "E1", "Avg Disk Usage")
-		f.SetCellValue(sheetName, "F1", "Estimated Disk Full Date")
-
-		// Add data (this is a simplified version, you'd need to join tables and do more complex queries in reality)
-		f.SetCellValue(sheetName, "A2", "Cluster1")
-		f.SetCellValue(sheetName, "B2", 10)
-		f.SetCellValue(sheetName, "C2", "50%")
-		f.SetCellValue(sheetName, "D2", "60%")
-		f.SetCellValue(sheetName, "E2", "70%")
-		f.SetCellValue(sheetName, "F2", "2023-12-31")
-
-		f.SetActiveSheet(index)
 	}
-
-	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Disposition", "attachment; filename=cluster_group_report.xlsx")
-	c.Header("Content-Transfer-Encoding", "binary")
-
-	f.Write(c.Writer)
+	return earliest, nil
 }
 
-func (s *ReportService) GenerateIDCReport(c *gin.Context) {
-	var serverResources []models.ServerResource
-	if err := s.DB.Find(&serverResources).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+// clusterFullDates 返回每个集群中最早写满的实例的预测日期
+func (s *ReportService) clusterFullDates(now time.Time) (map[string]string, error) {
+	forecasts, err := s.clusterForecasts(now)
+	if err != nil {
+		return nil, err
 	}
-
-	idcStats := make(map[string]*models.IDCUsage)
-
-	for _, resource := range serverResources {
-		idcName := getIDCNameFromIP(resource.IP)
-		if _, exists := idcStats[idcName]; !exists {
-			idcStats[idcName] = &models.IDCUsage{
-				IDCName: idcName,
-			}
-		}
-
-		usage := idcStats[idcName]
-		usage.TotalInstances++
-		usage.AvgCPUUsage += resource.CPULoad
-		usage.AvgMemoryUsage += (resource.UsedMemory / resource.TotalMemory) * 100
-		usage.AvgDiskUsage += (resource.UsedDisk / resource.TotalDisk) * 100
+	dates := make(map[string]string, len(forecasts))
+	for cluster, f := range forecasts {
+		dates[cluster] = f.Forecast.FullDate.Local().Format("2006-01-02")
 	}
+	return dates, nil
+}
 
-	// Calculate averages
-	for _, usage := range idcStats {
-		if usage.TotalInstances > 0 {
-			usage.AvgCPUUsage /= float64(usage.TotalInstances)
-			usage.AvgMemoryUsage /= float64(usage.TotalInstances)
-			usage.AvgDiskUsage /= float64(usage.TotalInstances)
-		}
-	}
+// GenerateClusterGroupReport 下载集群组报告，format 为 xlsx（默认）、pdf 或 html，groups/departments 为过滤条件
+func (s *ReportService) GenerateClusterGroupReport(c *gin.Context) {
+	s.downloadReport(c, ReportClusterGroup, FormatXLSX, ReportOptions{})
+}
+
+// GenerateIDCReport 下载机房报告，format 为 xlsx（默认）、pdf 或 html，groups/departments 为过滤条件
+func (s *ReportService) GenerateIDCReport(c *gin.Context) {
+	s.downloadReport(c, ReportIDC, FormatXLSX, ReportOptions{})
+}
 
+// idcWorkbook 生成机房报告的 Excel 版本，每个机房一行
+func idcWorkbook(report *IDCReport) (*excelize.File, error) {
 	f := excelize.NewFile()
 	sheetName := "IDC Report"
 	index, err := f.NewSheet(sheetName)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return nil, err
 	}
 
 	// Set headers
@@ -108,14 +88,7 @@ func (s *ReportService) GenerateIDCReport(c *gin.Context) {
 
 	// Fill data
 	row := 2
-	var idcNames []string
-	for idcName := range idcStats {
-		idcNames = append(idcNames, idcName)
-	}
-	sort.Strings(idcNames)
-
-	for _, idcName := range idcNames {
-		usage := idcStats[idcName]
+	for _, usage := range report.IDCs {
 		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), usage.IDCName)
 		f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), usage.TotalInstances)
 		f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), fmt.Sprintf("%.2f", usage.AvgCPUUsage))
@@ -125,34 +98,61 @@ func (s *ReportService) GenerateIDCReport(c *gin.Context) {
 	}
 
 	f.SetActiveSheet(index)
+	return f, nil
+}
 
-	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	c.Header("Content-Disposition", "attachment; filename=idc_report.xlsx")
-	if err := f.Write(c.Writer); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+// alertSummaryWorkbook 生成告警汇总的 Excel 版本：按级别和状态的数量、未恢复的告警和各规则的统计各一个工作表
+func alertSummaryWorkbook(report *AlertSummaryReport) (*excelize.File, error) {
+	var alerts []models.Alert
+	for _, group := range report.Groups {
+		alerts = append(alerts, group.Alerts...)
+	}
+	open := openAlertTable(alerts, report.GeneratedAt)
+	open.Columns = append([]pdf.Column{{Title: "组"}}, open.Columns...)
+	for i, a := range alerts {
+		open.Rows[i] = append([]string{a.GroupName}, open.Rows[i]...)
 	}
+
+	f := excelize.NewFile()
+	if err := f.SetSheetName("Sheet1", "Summary"); err != nil {
+		return nil, err
+	}
+	for _, sheet := range []struct {
+		name  string
+		table pdf.Table
+	}{{"Summary", alertCountTable(report.Counts)}, {"Open Alerts", open}, {"Rules", alertRuleTable(report.Rules)}} {
+		if sheet.name != "Summary" {
+			if _, err := f.NewSheet(sheet.name); err != nil {
+				return nil, err
+			}
+		}
+		if err := tableSheet(f, sheet.name, sheet.table); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
 }
 
-func getIDCNameFromIP(ip string) string {
-	parts := strings.Split(ip, ".")
-	if len(parts) >= 2 {
-		switch parts[1] {
-		case "1":
-			return "IDC-1"
-		case "2":
-			return "IDC-2"
-		case "3":
-			return "IDC-3"
-		case "4":
-			return "IDC-4"
-		case "5":
-			return "IDC-5"
-		case "6":
-			return "IDC-6"
-		default:
-			return "Unknown-IDC"
+// tableSheet 把表格写入工作表，表头行冻结
+func tableSheet(f *excelize.File, sheet string, t pdf.Table) error {
+	headers := make([]string, len(t.Columns))
+	for i, col := range t.Columns {
+		headers[i] = col.Title
+	}
+	if err := f.SetSheetRow(sheet, "A1", &headers); err != nil {
+		return err
+	}
+	for i, row := range t.Rows {
+		if err := f.SetSheetRow(sheet, fmt.Sprintf("A%d", i+2), &row); err != nil {
+			return err
 		}
 	}
-	return "Unknown-IDC"
+	last, err := excelize.ColumnNumberToName(max(len(headers), 1))
+	if err != nil {
+		return err
+	}
+	if err := f.SetColWidth(sheet, "A", last, 16); err != nil {
+		return err
+	}
+	return f.SetPanes(sheet, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
 }
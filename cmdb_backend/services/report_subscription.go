@@ -0,0 +1,433 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"cmdb/config"
+	"cmdb/cron"
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 订阅执行记录的状态和触发方式
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+const reportSchedulerLock = "report_scheduler"
+
+// SubscriptionService 管理报告订阅，并在后台按计划生成和发送报告
+type SubscriptionService struct {
+	DB      *gorm.DB
+	Reports *ReportService
+	store   *config.Store
+	lock    *LeaderLock
+}
+
+func NewSubscriptionService(db *gorm.DB, reports *ReportService, store *config.Store) *SubscriptionService {
+	return &SubscriptionService{DB: db, Reports: reports, store: store, lock: NewLeaderLock(db, reportSchedulerLock)}
+}
+
+// Run 按配置的周期检查到期的订阅，直到 stop 被关闭。多个实例同时运行时只有持有调度租约的实例执行
+func (s *SubscriptionService) Run(stop <-chan struct{}) {
+	for {
+		settings := s.store.Current().Reports
+		leader, err := s.lock.Acquire(time.Now(), settings.LockTTL.Duration)
+		if err != nil {
+			log.Printf("report scheduler lock: %v", err)
+		} else if leader {
+			if err := s.RunDue(time.Now()); err != nil {
+				log.Printf("report scheduler failed: %v", err)
+			}
+		}
+		select {
+		case <-stop:
+			if err := s.lock.Release(); err != nil {
+				log.Printf("release report scheduler lock: %v", err)
+			}
+			return
+		case <-time.After(settings.ScheduleInterval.Duration):
+		}
+	}
+}
+
+// RunDue 执行 next_run_at 已到的订阅。每次计划先写入执行记录来占用，(订阅, 计划时间) 已有记录时跳过，
+// 因此即使两个实例同时检查也不会重复发送。停机期间错过的多次计划只补发一次
+func (s *SubscriptionService) RunDue(now time.Time) error {
+	var due []models.ReportSubscription
+	if err := s.DB.Where("enabled = ? AND next_run_at <= ?", true, now.UTC()).
+		Order("next_run_at, id").Find(&due).Error; err != nil {
+		return err
+	}
+	for i := range due {
+		sub := &due[i]
+		scheduled := *sub.NextRunAt
+		next, err := nextRun(sub, now)
+		if err != nil {
+			log.Printf("report subscription %d: %v", sub.ID, err)
+		}
+		if err := s.DB.Model(&models.ReportSubscription{}).Where("id = ?", sub.ID).
+			Update("next_run_at", next).Error; err != nil {
+			return err
+		}
+		run, claimed, err := s.claim(sub, scheduled, TriggerSchedule)
+		if err != nil {
+			return err
+		}
+		if claimed {
+			s.execute(sub, run)
+		}
+	}
+	return nil
+}
+
+// nextRun 返回订阅在 after 之后的下一次执行时间（UTC），表达式不会再满足时返回 nil
+func nextRun(sub *models.ReportSubscription, after time.Time) (*time.Time, error) {
+	schedule, err := cron.Parse(sub.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(sub.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	next := schedule.Next(after.In(loc))
+	if next.IsZero() {
+		return nil, nil
+	}
+	next = next.UTC()
+	return &next, nil
+}
+
+// claim 写入一次执行记录，同一计划时间已有记录时返回 false
+func (s *SubscriptionService) claim(sub *models.ReportSubscription, scheduled time.Time, trigger string) (*models.ReportRun, bool, error) {
+	run := &models.ReportRun{
+		SubscriptionID: sub.ID,
+		ScheduledAt:    scheduled.UTC(),
+		Trigger:        trigger,
+		Instance:       s.lock.Holder,
+		Status:         RunRunning,
+		StartedAt:      time.Now().UTC(),
+	}
+	result := s.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(run)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	return run, result.RowsAffected > 0, nil
+}
+
+// execute 生成并发送报告，把结果写回执行记录
+func (s *SubscriptionService) execute(sub *models.ReportSubscription, run *models.ReportRun) {
+	err := s.send(sub, run)
+	finished := time.Now().UTC()
+	run.FinishedAt = &finished
+	run.Status = RunSucceeded
+	if err != nil {
+		log.Printf("report subscription %d run %d failed: %v", sub.ID, run.ID, err)
+		run.Status = RunFailed
+		run.Error = truncate(err.Error(), 1000)
+	}
+	if err := s.DB.Save(run).Error; err != nil {
+		log.Printf("record report subscription %d run %d: %v", sub.ID, run.ID, err)
+	}
+}
+
+// send 生成订阅的报告并写入发件队列。告警汇总统计从上一次计划执行到本次计划时间之间开始的告警，
+// 第一次执行时统计之前 7 天
+func (s *SubscriptionService) send(sub *models.ReportSubscription, run *models.ReportRun) error {
+	to, err := parseRecipients(sub.Recipients)
+	if err != nil {
+		return err
+	}
+	opts := ReportOptions{
+		From:   run.ScheduledAt.Add(-7 * 24 * time.Hour),
+		To:     run.ScheduledAt,
+		Filter: ReportFilter{Groups: splitList(sub.Groups), Departments: splitList(sub.Departments)},
+	}
+	var previous models.ReportRun
+	// trigger 为 MySQL 保留字，用结构体条件让 GORM 加引号
+	err = s.DB.Where(&models.ReportRun{SubscriptionID: sub.ID, Trigger: TriggerSchedule}).
+		Where("scheduled_at < ?", run.ScheduledAt).Order("scheduled_at desc").Limit(1).Find(&previous).Error
+	if err != nil {
+		return err
+	}
+	if previous.ID != 0 {
+		opts.From = previous.ScheduledAt
+	}
+
+	now := time.Now()
+	file, err := s.Reports.Render(sub.Report, sub.Format, now, opts)
+	if err != nil {
+		return err
+	}
+	email := reportFileEmail(file, to, sub.Profile, now)
+	email.Subject = fmt.Sprintf("%s - %s", file.Title, sub.Name)
+	// 只订阅了一个部门时按部门选择发件账号
+	if len(opts.Filter.Departments) == 1 {
+		email.Department = opts.Filter.Departments[0]
+	}
+	id, err := s.Reports.Email.Enqueue(email)
+	if err != nil {
+		return err
+	}
+	run.MessageID = &id
+	if file.ArchiveID != 0 {
+		run.ArchiveID = &file.ArchiveID
+	}
+	run.Filename = file.Filename
+	run.Size = len(file.Content)
+	return nil
+}
+
+// splitList 拆分逗号分隔的列表，去掉空白和重复项
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" && !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+type ReportSubscriptionInput struct {
+	Name        *string `json:"name" binding:"omitempty,min=1,max=100"`
+	Report      *string `json:"report" binding:"omitempty,oneof=idc cluster_group alert_summary"`
+	Format      *string `json:"format" binding:"omitempty,oneof=html xlsx pdf"`
+	Recipients  *string `json:"recipients" binding:"omitempty,min=1,max=1000"`
+	Groups      *string `json:"groups" binding:"omitempty,max=1000"`
+	Departments *string `json:"departments" binding:"omitempty,max=1000"`
+	Schedule    *string `json:"schedule" binding:"omitempty,min=1,max=100"`
+	Timezone    *string `json:"timezone" binding:"omitempty,max=64"`
+	Profile     *string `json:"profile" binding:"omitempty,max=100"`
+	Enabled     *bool   `json:"enabled"`
+}
+
+// applyTo 写入字段并校验收件人、cron 表达式、时区和发件账号，然后重新计算下一次执行时间
+func (in *ReportSubscriptionInput) applyTo(sub *models.ReportSubscription, smtp config.SMTPConfig, now time.Time) error {
+	setIf(&sub.Name, in.Name)
+	setIf(&sub.Report, in.Report)
+	setIf(&sub.Format, in.Format)
+	setIf(&sub.Recipients, in.Recipients)
+	setIf(&sub.Groups, in.Groups)
+	setIf(&sub.Departments, in.Departments)
+	setIf(&sub.Schedule, in.Schedule)
+	setIf(&sub.Timezone, in.Timezone)
+	setIf(&sub.Profile, in.Profile)
+	if in.Enabled != nil {
+		sub.Enabled = in.Enabled
+	}
+
+	sub.Groups = strings.Join(splitList(sub.Groups), ",")
+	sub.Departments = strings.Join(splitList(sub.Departments), ",")
+	if _, err := parseRecipients(sub.Recipients); err != nil {
+		return err
+	}
+	if sub.Profile != "" {
+		if _, ok := smtp.Account(sub.Profile); !ok {
+			return fmt.Errorf("%w %q", errUnknownProfile, sub.Profile)
+		}
+	}
+	next, err := nextRun(sub, now)
+	if err != nil {
+		return err
+	}
+	sub.NextRunAt = nil
+	if sub.Enabled == nil || *sub.Enabled {
+		sub.NextRunAt = next
+	}
+	return nil
+}
+
+// subscriptionAllows 判断用户在订阅涉及的每个部门中是否都至少拥有 role，不限部门的订阅要求全局角色
+func subscriptionAllows(access *Access, sub *models.ReportSubscription, role string) bool {
+	departments := splitList(sub.Departments)
+	if len(departments) == 0 {
+		return access.Unrestricted(role)
+	}
+	for _, d := range departments {
+		if !access.Allows(d, role) {
+			return false
+		}
+	}
+	return true
+}
+
+// requireSubscription 检查当前用户对订阅涉及的部门是否至少拥有 role，否则返回 403
+func requireSubscription(c *gin.Context, sub *models.ReportSubscription, role string) bool {
+	if subscriptionAllows(CurrentAccess(c), sub, role) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s role in every department of the subscription is required", role)})
+	return false
+}
+
+// findSubscription 查找订阅，并检查当前用户对订阅涉及的部门至少拥有 role。不可见的订阅按不存在处理
+func (s *SubscriptionService) findSubscription(c *gin.Context, role string) (*models.ReportSubscription, bool) {
+	var sub models.ReportSubscription
+	err := s.DB.First(&sub, c.Param("id")).Error
+	if err == nil && !subscriptionAllows(CurrentAccess(c), &sub, RoleViewer) {
+		err = gorm.ErrRecordNotFound
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Report subscription not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if !requireSubscription(c, &sub, role) {
+		return nil, false
+	}
+	return &sub, true
+}
+
+// ListSubscriptions 返回当前用户可见的订阅，即涉及的部门都可见的订阅
+func (s *SubscriptionService) ListSubscriptions(c *gin.Context) {
+	var all []models.ReportSubscription
+	if err := s.DB.Order("id").Find(&all).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	access := CurrentAccess(c)
+	subs := make([]models.ReportSubscription, 0, len(all))
+	for _, sub := range all {
+		if subscriptionAllows(access, &sub, RoleViewer) {
+			subs = append(subs, sub)
+		}
+	}
+	c.JSON(http.StatusOK, subs)
+}
+
+func (s *SubscriptionService) GetSubscription(c *gin.Context) {
+	if sub, ok := s.findSubscription(c, RoleViewer); ok {
+		c.JSON(http.StatusOK, sub)
+	}
+}
+
+// CreateSubscription 创建订阅，timezone 默认为服务器所在时区
+func (s *SubscriptionService) CreateSubscription(c *gin.Context) {
+	var in ReportSubscriptionInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if in.Name == nil || in.Report == nil || in.Format == nil || in.Recipients == nil || in.Schedule == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name, report, format, recipients and schedule are required"})
+		return
+	}
+
+	// 未指定 enabled 时由 GORM 填入默认值 true
+	sub := models.ReportSubscription{Timezone: "Local"}
+	if err := in.applyTo(&sub, s.store.Current().SMTP, time.Now()); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if !requireSubscription(c, &sub, RoleOperator) {
+		return
+	}
+	if err := s.DB.WithContext(c).Create(&sub).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, sub)
+}
+
+func (s *SubscriptionService) UpdateSubscription(c *gin.Context) {
+	sub, ok := s.findSubscription(c, RoleOperator)
+	if !ok {
+		return
+	}
+	var in ReportSubscriptionInput
+	if err := c.ShouldBindJSON(&in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := in.applyTo(sub, s.store.Current().SMTP, time.Now()); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	// 修改了部门时，对新的部门同样需要 operator 角色
+	if !requireSubscription(c, sub, RoleOperator) {
+		return
+	}
+	if err := s.DB.WithContext(c).Save(sub).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sub)
+}
+
+func (s *SubscriptionService) DeleteSubscription(c *gin.Context) {
+	sub, ok := s.findSubscription(c, RoleOperator)
+	if !ok {
+		return
+	}
+	if err := s.DB.WithContext(c).Delete(sub).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Report subscription deleted successfully"})
+}
+
+// RunSubscription 立即执行一次订阅（不影响计划），返回执行记录
+func (s *SubscriptionService) RunSubscription(c *gin.Context) {
+	sub, ok := s.findSubscription(c, RoleOperator)
+	if !ok {
+		return
+	}
+	run, claimed, err := s.claim(sub, time.Now(), TriggerManual)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !claimed {
+		c.JSON(http.StatusConflict, gin.H{"error": "subscription is already running"})
+		return
+	}
+	s.execute(sub, run)
+	c.JSON(http.StatusCreated, run)
+}
+
+// ListRuns 分页返回订阅的执行记录，最近的在前
+func (s *SubscriptionService) ListRuns(c *gin.Context) {
+	sub, ok := s.findSubscription(c, RoleViewer)
+	if !ok {
+		return
+	}
+	page, pageSize, ok := pagination(c)
+	if !ok {
+		return
+	}
+	query := s.DB.Model(&models.ReportRun{}).Where("subscription_id = ?", sub.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	runs := []models.ReportRun{}
+	if err := query.Order("scheduled_at desc, id desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&runs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, Page[models.ReportRun]{Items: runs, Total: total, Page: page, PageSize: pageSize})
+}
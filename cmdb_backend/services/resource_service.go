@@ -13,7 +13,12 @@ import (
 )
 
 type ResourceService struct {
-	DB *gorm.DB
+	DB      *gorm.DB
+	Metrics *MetricsStore
+}
+
+func NewResourceService(db *gorm.DB, metrics *MetricsStore) *ResourceService {
+	return &ResourceService{DB: db, Metrics: metrics}
 }
 
 func (s *ResourceService) GetServerResources(c *gin.Context) {
@@ -23,7 +28,7 @@ func (s *ResourceService) GetServerResources(c *gin.Context) {
 	startDate := c.Query("startDate")
 	endDate := c.Query("endDate")
 
-	query := s.DB
+	query := s.DB.Scopes(CurrentAccess(c).ClusterScope("cluster_name"), OnLiveHosts("pool_id"))
 
 	// 如果提供了时间范围，则添加时间过滤条件
 	if startDate != "" && endDate != "" {
@@ -78,46 +83,50 @@ func (s *ResourceService) GetServerResources(c *gin.Context) {
 
 func (s *ResourceService) GetClusterResourceUsage(c *gin.Context) {
 	var resources []models.ServerResource
-	if err := s.DB.Find(&resources).Error; err != nil {
+	if err := s.DB.Scopes(CurrentAccess(c).ClusterScope("cluster_name"), OnLiveHosts("pool_id")).Find(&resources).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, resources)
 }
 
-func (s *ResourceService) GetResourceAlerts(c *gin.Context) {
-	var alerts []models.ServerResource
-	if err := s.DB.Where("used_memory / total_memory > 0.8 OR used_disk / total_disk > 0.8 OR cpu_load > 80").Find(&alerts).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+func (s *ResourceService) InsertServerResource(c *gin.Context) {
+	var resource models.ServerResource
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, alerts)
-}
 
-func (s *ResourceService) PredictDiskFullDate(c *gin.Context) {
-	var resources []models.ServerResource
-	if err := s.DB.Order("date_time desc").Limit(30).Find(&resources).Error; err != nil {
+	// 主机必须存在且未被删除，已删除主机上的实例不再接收采样
+	var host models.HostPool
+	if err := s.DB.Limit(1).Find(&host, resource.PoolID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-
-	predictions := make(map[uint]string)
-	for _, r := range resources {
-		daysLeft := float64(r.TotalDisk-r.UsedDisk) / (float64(r.UsedDisk) / 30)
-		predictions[r.PoolID] = r.DateTime.AddDate(0, 0, int(daysLeft)).Format("2006-01-02")
+	if host.ID == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("host %d not found", resource.PoolID)})
+		return
+	}
+	if host.IsDeleted {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("host %d is deleted", resource.PoolID)})
+		return
 	}
 
-	c.JSON(http.StatusOK, predictions)
-}
-
-func (s *ResourceService) InsertServerResource(c *gin.Context) {
-	var resource models.ServerResource
-	if err := c.ShouldBindJSON(&resource); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// 集群必须已登记在 cluster_groups 中，组名以集群所属的组为准
+	group, ok := resolveCluster(c, s.DB, resource.ClusterName)
+	if !ok {
 		return
 	}
+	if group != nil {
+		if resource.GroupName != "" && resource.GroupName != group.GroupName {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("cluster %q belongs to group %q, not %q", group.ClusterName, group.GroupName, resource.GroupName)})
+			return
+		}
+		resource.GroupName = group.GroupName
+	}
 
-	if err := s.DB.Create(&resource).Error; err != nil {
+	// 采样进入历史数据，server_resources 中只保留每个实例的最新值
+	if err := s.Metrics.Record(&resource); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -128,7 +137,7 @@ func (s *ResourceService) InsertServerResource(c *gin.Context) {
 // 新增的 GetClusterGroups 方法
 func (s *ResourceService) GetClusterGroups(c *gin.Context) {
 	var clusterGroups []models.ClusterGroup
-	if err := s.DB.Find(&clusterGroups).Error; err != nil {
+	if err := s.DB.Scopes(CurrentAccess(c).DepartmentScope("department_name")).Find(&clusterGroups).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cluster groups"})
 		return
 	}
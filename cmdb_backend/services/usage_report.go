@@ -0,0 +1,196 @@
+package services
+
+import (
+	"image/color"
+	"sort"
+	"time"
+
+	"cmdb/models"
+)
+
+// UsageReport 为服务器资源使用情况报告的数据，邮件的 HTML 正文、纯文本和 PDF 附件都由它渲染
+type UsageReport struct {
+	Title       string        `json:"title"`
+	GeneratedAt time.Time     `json:"generated_at"`
+	Alerts      []FiringAlert `json:"alerts"`
+	Groups      []GroupUsage  `json:"groups"`
+	Servers     []ServerUsage `json:"servers"`
+}
+
+// GroupUsage 为一个集群组内各集群的使用情况
+type GroupUsage struct {
+	GroupName string         `json:"group_name"`
+	Clusters  []ClusterUsage `json:"clusters"`
+}
+
+// ClusterUsage 为集群内实例的平均使用率，FullDate 为最早写满的实例的预测日期
+type ClusterUsage struct {
+	ClusterName string  `json:"cluster_name"`
+	Servers     int     `json:"servers"`
+	MemoryUsage float64 `json:"memory_usage"`
+	DiskUsage   float64 `json:"disk_usage"`
+	CPUUsage    float64 `json:"cpu_usage"`
+	FullDate    string  `json:"full_date,omitempty"`
+}
+
+// ServerUsage 为一个实例最近一次上报的使用率
+type ServerUsage struct {
+	IP             string  `json:"ip"`
+	Port           uint    `json:"port"`
+	IDC            string  `json:"idc"`
+	ClusterName    string  `json:"cluster_name"`
+	GroupName      string  `json:"group_name"`
+	DepartmentName string  `json:"department_name"`
+	CPUUsage       float64 `json:"cpu_usage"`
+	MemoryUsage    float64 `json:"memory_usage"`
+	DiskUsage      float64 `json:"disk_usage"`
+}
+
+const usageReportTitle = "服务器资源使用情况报告"
+
+// 报告图表中内存、磁盘、CPU 三个系列的颜色，PNG 和 PDF 使用同一组
+var (
+	memoryColor = color.RGBA{78, 121, 167, 255}
+	diskColor   = color.RGBA{242, 142, 43, 255}
+	cpuColor    = color.RGBA{89, 161, 79, 255}
+)
+
+// BuildUsageReport 汇总 filter 内当前的告警、各集群的平均使用率和每个实例最近一次上报的数据
+func (s *ReportService) BuildUsageReport(now time.Time, filter ReportFilter) (*UsageReport, error) {
+	firing, err := s.Alerts.Evaluate(now, 0)
+	if err != nil {
+		return nil, err
+	}
+	alerts := make([]FiringAlert, 0, len(firing))
+	for _, a := range firing {
+		if filter.Match(a.GroupName, a.DepartmentName) {
+			alerts = append(alerts, a)
+		}
+	}
+	servers, registered, err := s.serverUsages(filter)
+	if err != nil {
+		return nil, err
+	}
+	fullDates, err := s.clusterFullDates(now)
+	if err != nil {
+		return nil, err
+	}
+	// 告警按级别从高到低排列
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return severityRank[alerts[i].Severity] > severityRank[alerts[j].Severity]
+	})
+	return &UsageReport{
+		Title:       usageReportTitle,
+		GeneratedAt: now,
+		Alerts:      alerts,
+		Groups:      summarizeGroups(servers, registered, fullDates),
+		Servers:     servers,
+	}, nil
+}
+
+// serverUsages 返回 filter 内每个实例最近一次上报的使用率（按组、集群、IP、端口排序）和已登记的集群。
+// 实例的组以 cluster_groups 为准，未登记的集群使用上报数据中的组名
+func (s *ReportService) serverUsages(filter ReportFilter) ([]ServerUsage, []models.ClusterGroup, error) {
+	instances, err := s.Alerts.loadInstances()
+	if err != nil {
+		return nil, nil, err
+	}
+	var all []models.ClusterGroup
+	if err := s.DB.Order("group_name, cluster_name").Find(&all).Error; err != nil {
+		return nil, nil, err
+	}
+	groupOf := make(map[string]string, len(all))
+	var registered []models.ClusterGroup
+	for _, g := range all {
+		groupOf[g.ClusterName] = g.GroupName
+		if filter.Match(g.GroupName, g.DepartmentName) {
+			registered = append(registered, g)
+		}
+	}
+
+	servers := make([]ServerUsage, 0, len(instances))
+	for _, inst := range instances {
+		group, ok := groupOf[inst.ClusterName]
+		if !ok {
+			group = inst.GroupName
+		}
+		if !filter.Match(group, inst.DepartmentName) {
+			continue
+		}
+		servers = append(servers, ServerUsage{
+			IP:             inst.IP,
+			Port:           inst.Port,
+			IDC:            s.IDC.Resolve(inst.IP),
+			ClusterName:    inst.ClusterName,
+			GroupName:      group,
+			DepartmentName: inst.DepartmentName,
+			CPUUsage:       inst.CPULoad,
+			MemoryUsage:    percent(inst.UsedMemory, inst.TotalMemory),
+			DiskUsage:      percent(inst.UsedDisk, inst.TotalDisk),
+		})
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		a, b := servers[i], servers[j]
+		if a.GroupName != b.GroupName {
+			return a.GroupName < b.GroupName
+		}
+		if a.ClusterName != b.ClusterName {
+			return a.ClusterName < b.ClusterName
+		}
+		if a.IP != b.IP {
+			return a.IP < b.IP
+		}
+		return a.Port < b.Port
+	})
+	return servers, registered, nil
+}
+
+// summarizeGroups 按组、集群汇总实例的平均使用率，组和集群按名称排序。
+// registered 中没有实例的集群也会列出，实例数为 0
+func summarizeGroups(servers []ServerUsage, registered []models.ClusterGroup, fullDates map[string]string) []GroupUsage {
+	type key struct{ group, cluster string }
+	clusters := make(map[key]*ClusterUsage)
+	var keys []key
+	add := func(k key) *ClusterUsage {
+		c := clusters[k]
+		if c == nil {
+			c = &ClusterUsage{ClusterName: k.cluster, FullDate: fullDates[k.cluster]}
+			clusters[k] = c
+			keys = append(keys, k)
+		}
+		return c
+	}
+	for _, g := range registered {
+		add(key{g.GroupName, g.ClusterName})
+	}
+	for _, s := range servers {
+		c := add(key{s.GroupName, s.ClusterName})
+		c.Servers++
+		c.MemoryUsage += s.MemoryUsage
+		c.DiskUsage += s.DiskUsage
+		c.CPUUsage += s.CPUUsage
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].group != keys[j].group {
+			return keys[i].group < keys[j].group
+		}
+		return keys[i].cluster < keys[j].cluster
+	})
+
+	var groups []GroupUsage
+	for _, k := range keys {
+		c := clusters[k]
+		if c.Servers > 0 {
+			n := float64(c.Servers)
+			c.MemoryUsage /= n
+			c.DiskUsage /= n
+			c.CPUUsage /= n
+		}
+		if len(groups) == 0 || groups[len(groups)-1].GroupName != k.group {
+			groups = append(groups, GroupUsage{GroupName: k.group})
+		}
+		last := &groups[len(groups)-1]
+		last.Clusters = append(last.Clusters, *c)
+	}
+	return groups
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cmdb/auth"
+	"cmdb/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CreateLocalUser 创建本地用户，用户名已存在时返回 errUsernameTaken。ctx 中的请求者记入审计日志
+func (s *AuthService) CreateLocalUser(ctx context.Context, username, password, displayName, email string) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, errors.New("username is required")
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	var taken int64
+	if err := s.DB.Model(&models.User{}).Where("username = ?", username).Count(&taken).Error; err != nil {
+		return nil, err
+	}
+	if taken > 0 {
+		return nil, fmt.Errorf("%w: %s", errUsernameTaken, username)
+	}
+	user := &models.User{
+		Username:     username,
+		DisplayName:  displayName,
+		Email:        email,
+		Provider:     auth.ProviderLocal,
+		Subject:      username,
+		PasswordHash: hash,
+	}
+	if err := s.DB.WithContext(ctx).Create(user).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// SetPassword 修改本地用户的密码并吊销该用户的全部刷新令牌
+func (s *AuthService) SetPassword(ctx context.Context, user *models.User, password string) error {
+	if user.Provider != auth.ProviderLocal {
+		return fmt.Errorf("password of %s users is managed by the identity provider", user.Provider)
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := s.DB.WithContext(ctx).Model(user).Update("password_hash", hash).Error; err != nil {
+		return err
+	}
+	return s.revokeSessions(user.ID, time.Now())
+}
+
+func (s *AuthService) findUser(c *gin.Context) (*models.User, bool) {
+	var user models.User
+	err := s.DB.Preload("Roles").First(&user, c.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &user, true
+}
+
+// ListUsers 返回全部用户，可按 provider 过滤、按 q 模糊匹配用户名和显示名
+func (s *AuthService) ListUsers(c *gin.Context) {
+	query := s.DB.Model(&models.User{}).Preload("Roles")
+	if providers := QueryList(c, "provider"); len(providers) > 0 {
+		query = query.Where("provider IN ?", providers)
+	}
+	if q := c.Query("q"); q != "" {
+		like := "%" + escapeLike(q) + "%"
+		query = query.Where("username LIKE ? ESCAPE '!' OR display_name LIKE ? ESCAPE '!'", like, like)
+	}
+	users := []models.User{}
+	if err := query.Order("username").Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+func (s *AuthService) GetUser(c *gin.Context) {
+	if user, ok := s.findUser(c); ok {
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+// CreateUser 创建本地用户。LDAP 和 OIDC 用户在第一次登录时自动创建
+func (s *AuthService) CreateUser(c *gin.Context) {
+	var req struct {
+		Username    string `json:"username" binding:"required,max=100"`
+		Password    string `json:"password" binding:"required"`
+		DisplayName string `json:"display_name" binding:"max=100"`
+		Email       string `json:"email" binding:"omitempty,email,max=255"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := s.CreateLocalUser(c, req.Username, req.Password, req.DisplayName, req.Email)
+	if errors.Is(err, errUsernameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, user)
+}
+
+// UpdateUser 修改显示名、邮箱、停用状态或本地用户的密码。停用和改密码会吊销该用户的全部刷新令牌
+func (s *AuthService) UpdateUser(c *gin.Context) {
+	user, ok := s.findUser(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		DisplayName *string `json:"display_name" binding:"omitempty,max=100"`
+		Email       *string `json:"email" binding:"omitempty,max=255"`
+		Disabled    *bool   `json:"disabled"`
+		Password    *string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Disabled != nil && *req.Disabled && CurrentUser(c) != nil && CurrentUser(c).ID == user.ID {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "you cannot disable yourself"})
+		return
+	}
+
+	if req.Password != nil {
+		if err := s.SetPassword(c, user, *req.Password); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	setIf(&user.DisplayName, req.DisplayName)
+	setIf(&user.Email, req.Email)
+	setIf(&user.Disabled, req.Disabled)
+	if err := s.DB.WithContext(c).Model(user).Select("display_name", "email", "disabled").Updates(user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if user.Disabled {
+		if err := s.revokeSessions(user.ID, time.Now()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteUser 删除用户及其刷新令牌和角色绑定，不能删除自己
+func (s *AuthService) DeleteUser(c *gin.Context) {
+	user, ok := s.findUser(c)
+	if !ok {
+		return
+	}
+	if current := CurrentUser(c); current != nil && current.ID == user.ID {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "you cannot delete yourself"})
+		return
+	}
+	err := s.DB.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RefreshToken{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RoleBinding{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(user).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+}
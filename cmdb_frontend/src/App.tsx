@@ -1,7 +1,9 @@
 import React, { useState, useEffect } from 'react';
-import { Layout, Tabs } from 'antd';
+import { Button, Layout, Space, Tabs } from 'antd';
 import HostList from './components/HostList';
 import DatabaseClusterAnalysis from './components/DatabaseClusterAnalysis';
+import Login from './components/Login';
+import { consumeLoginRedirect, isLoggedIn, logout, onAuthChange } from './auth';
 import './App.css';
 
 const { Header, Content } = Layout;
@@ -9,6 +11,13 @@ const { TabPane } = Tabs;
 
 const App: React.FC = () => {
   const [currentTime, setCurrentTime] = useState(new Date());
+  const [loggedIn, setLoggedIn] = useState(isLoggedIn);
+
+  useEffect(() => {
+    const unsubscribe = onAuthChange(setLoggedIn);
+    consumeLoginRedirect();
+    return unsubscribe;
+  }, []);
 
   useEffect(() => {
     const timer = setInterval(() => {
@@ -36,19 +45,30 @@ const App: React.FC = () => {
     <Layout className="layout">
       <Header style={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center' }}>
         <h1 style={{ color: 'white', margin: 0 }}>数据库主机资源池</h1>
-        <span style={{ color: 'white' }}>{formatTime(currentTime)}</span>
+        <Space>
+          <span style={{ color: 'white' }}>{formatTime(currentTime)}</span>
+          {loggedIn && (
+            <Button type="link" style={{ color: 'white' }} onClick={logout}>
+              退出
+            </Button>
+          )}
+        </Space>
       </Header>
       <Content style={{ padding: '0 50px' }}>
-        <div className="site-layout-content">
-          <Tabs defaultActiveKey="1">
-            <TabPane tab="主机资源池" key="1">
-              <HostList />
-            </TabPane>
-            <TabPane tab="主机资源用量分析" key="2">
-              <DatabaseClusterAnalysis />
-            </TabPane>
-          </Tabs>
-        </div>
+        {loggedIn ? (
+          <div className="site-layout-content">
+            <Tabs defaultActiveKey="1">
+              <TabPane tab="主机资源池" key="1">
+                <HostList />
+              </TabPane>
+              <TabPane tab="主机资源用量分析" key="2">
+                <DatabaseClusterAnalysis />
+              </TabPane>
+            </Tabs>
+          </div>
+        ) : (
+          <Login />
+        )}
       </Content>
     </Layout>
   );
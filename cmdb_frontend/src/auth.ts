@@ -0,0 +1,106 @@
+import axios, { AxiosError, InternalAxiosRequestConfig } from 'axios';
+
+// 访问令牌和刷新令牌保存在 localStorage 中，所有 axios 请求自动带上访问令牌，
+// 访问令牌过期（401）时用刷新令牌换一对新令牌后重试一次，刷新失败则回到登录页
+
+const ACCESS_KEY = 'cmdb_access_token';
+const REFRESH_KEY = 'cmdb_refresh_token';
+
+export interface TokenResponse {
+  access_token: string;
+  refresh_token: string;
+  expires_in: number;
+}
+
+type Listener = (loggedIn: boolean) => void;
+const listeners = new Set<Listener>();
+
+export const isLoggedIn = () => !!localStorage.getItem(ACCESS_KEY);
+
+export const onAuthChange = (listener: Listener) => {
+  listeners.add(listener);
+  return () => {
+    listeners.delete(listener);
+  };
+};
+
+export const saveTokens = (tokens: Pick<TokenResponse, 'access_token' | 'refresh_token'>) => {
+  localStorage.setItem(ACCESS_KEY, tokens.access_token);
+  localStorage.setItem(REFRESH_KEY, tokens.refresh_token);
+  listeners.forEach((l) => l(true));
+};
+
+const clearTokens = () => {
+  localStorage.removeItem(ACCESS_KEY);
+  localStorage.removeItem(REFRESH_KEY);
+  listeners.forEach((l) => l(false));
+};
+
+export const login = async (username: string, password: string) => {
+  const response = await axios.post<TokenResponse>('/api/cmdb/v1/auth/login', { username, password });
+  saveTokens(response.data);
+};
+
+export const logout = async () => {
+  const refreshToken = localStorage.getItem(REFRESH_KEY);
+  clearTokens();
+  if (refreshToken) {
+    await axios.post('/api/cmdb/v1/auth/logout', { refresh_token: refreshToken }).catch(() => undefined);
+  }
+};
+
+// OIDC 登录成功后后端把令牌放在 URL 片段中跳回前端
+export const consumeLoginRedirect = () => {
+  const params = new URLSearchParams(window.location.hash.slice(1));
+  const access = params.get('access_token');
+  const refresh = params.get('refresh_token');
+  if (access && refresh) {
+    window.history.replaceState(null, '', window.location.pathname + window.location.search);
+    saveTokens({ access_token: access, refresh_token: refresh });
+  }
+};
+
+// 同时有多个请求遇到 401 时只刷新一次
+let refreshing: Promise<string> | null = null;
+
+const refreshAccessToken = () => {
+  if (!refreshing) {
+    const refreshToken = localStorage.getItem(REFRESH_KEY);
+    refreshing = (refreshToken
+      ? axios.post<TokenResponse>('/api/cmdb/v1/auth/refresh', { refresh_token: refreshToken }).then((r) => {
+          saveTokens(r.data);
+          return r.data.access_token;
+        })
+      : Promise.reject(new Error('not logged in'))
+    ).finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+};
+
+const isAuthRequest = (config?: InternalAxiosRequestConfig) => !!config?.url?.includes('/api/cmdb/v1/auth/');
+
+axios.interceptors.request.use((config) => {
+  const token = localStorage.getItem(ACCESS_KEY);
+  if (token && !isAuthRequest(config)) {
+    config.headers.set('Authorization', `Bearer ${token}`);
+  }
+  return config;
+});
+
+axios.interceptors.response.use(undefined, async (error: AxiosError) => {
+  const config = error.config as (InternalAxiosRequestConfig & { _retried?: boolean }) | undefined;
+  if (error.response?.status !== 401 || !config || config._retried || isAuthRequest(config)) {
+    throw error;
+  }
+  config._retried = true;
+  try {
+    const token = await refreshAccessToken();
+    config.headers.set('Authorization', `Bearer ${token}`);
+    return axios(config);
+  } catch {
+    clearTokens();
+    throw error;
+  }
+});
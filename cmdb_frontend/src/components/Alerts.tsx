@@ -1,110 +1,33 @@
-import React, { useEffect, useState } from 'react';
+import React from 'react';
 import { Alert } from 'antd';
-import { ServerResource } from '../types/ServerResource';
+import { ResourceAlert } from '../types/ResourceAlert';
 
 interface AlertsProps {
-  data: ServerResource[];
-  lowThreshold: number;
-  highThreshold: number;
-  triggerUpdate: number;
-  selectedGroups: string[];
+  alerts: ResourceAlert[];
 }
 
-const Alerts: React.FC<AlertsProps> = ({ data, lowThreshold, highThreshold, triggerUpdate, selectedGroups }) => {
-  const [alerts, setAlerts] = useState<JSX.Element[]>([]);
-
-  useEffect(() => {
-    const filteredData = selectedGroups.length > 0
-      ? data.filter(resource => selectedGroups.includes(resource.group_name))
-      : data;
-
-    const newAlerts = filteredData.flatMap((item, index) => {
-      const memoryUsage = (item.used_memory / item.total_memory) * 100;
-      const diskUsage = (item.used_disk / item.total_disk) * 100;
-      const cpuUsage = item.cpu_load;
-
-      const alertPrefix = `${item.ip} (${item.group_name} ${item.cluster_name})`;
-      const alerts: JSX.Element[] = [];
-
-      // 使用 index 作为 key 的一部分，确保唯一性
-      const uniqueKey = `${index}-${item.ip}`;
-
-      // Memory alerts
-      if (memoryUsage > highThreshold) {
-        alerts.push(
-          <Alert
-            key={`${uniqueKey}-memory-high`}
-            message={`${alertPrefix} | 内存: ${memoryUsage.toFixed(2)}% (${item.used_memory.toFixed(2)}GB/${item.total_memory.toFixed(2)}GB) | 警告：高于${highThreshold}%阈值`}
-            type="error"
-            showIcon
-            banner
-          />
-        );
-      } else if (memoryUsage < lowThreshold) {
-        alerts.push(
-          <Alert
-            key={`${uniqueKey}-memory-low`}
-            message={`${alertPrefix} | 内存: ${memoryUsage.toFixed(2)}% (${item.used_memory.toFixed(2)}GB/${item.total_memory.toFixed(2)}GB) | 提示：低于${lowThreshold}%阈值`}
-            type="warning"
-            showIcon
-            banner
-          />
-        );
-      }
-
-      // Disk alerts
-      if (diskUsage > highThreshold) {
-        alerts.push(
-          <Alert
-            key={`${uniqueKey}-disk-high`}
-            message={`${alertPrefix} | 磁盘: ${diskUsage.toFixed(2)}% (${item.used_disk.toFixed(2)}GB/${item.total_disk.toFixed(2)}GB) | 警告：高于${highThreshold}%阈值`}
-            type="error"
-            showIcon
-            banner
-          />
-        );
-      } else if (diskUsage < lowThreshold) {
-        alerts.push(
-          <Alert
-            key={`${uniqueKey}-disk-low`}
-            message={`${alertPrefix} | 磁盘: ${diskUsage.toFixed(2)}% (${item.used_disk.toFixed(2)}GB/${item.total_disk.toFixed(2)}GB) | 提示：低于${lowThreshold}%阈值`}
-            type="warning"
-            showIcon
-            banner
-          />
-        );
-      }
-
-      // CPU alerts
-      if (cpuUsage > highThreshold) {
-        alerts.push(
-          <Alert
-            key={`${uniqueKey}-cpu-high`}
-            message={`${alertPrefix} | CPU: ${cpuUsage.toFixed(2)}% | 警告：高于${highThreshold}%阈值`}
-            type="error"
-            showIcon
-            banner
-          />
-        );
-      } else if (cpuUsage < lowThreshold) {
-        alerts.push(
-          <Alert
-            key={`${uniqueKey}-cpu-low`}
-            message={`${alertPrefix} | CPU: ${cpuUsage.toFixed(2)}% | 提示：低于${lowThreshold}%阈值`}
-            type="warning"
-            showIcon
-            banner
-          />
-        );
-      }
-
-      return alerts;
-    });
-
-    setAlerts(newAlerts);
-  }, [data, lowThreshold, highThreshold, triggerUpdate, selectedGroups]);
-
-  return <>{alerts}</>;
+const alertType = {
+  critical: 'error',
+  warning: 'warning',
+  info: 'info',
+} as const;
+
+const formatValue = (value: number | null, suffix = '%') => (value === null ? '-' : `${value.toFixed(2)}${suffix}`);
+
+const Alerts: React.FC<AlertsProps> = ({ alerts }) => {
+  return (
+    <>
+      {alerts.map(item => (
+        <Alert
+          key={`${item.rule_id}-${item.pool_id}-${item.ip}-${item.port}`}
+          message={`${item.ip} (${item.group_name} ${item.cluster_name}) | ${item.rule_name}：${item.expression}${item.for ? ` 持续 ${item.for}` : ''} | 内存: ${formatValue(item.values.memory_usage)} | 磁盘: ${formatValue(item.values.disk_usage)} | CPU: ${formatValue(item.values.cpu_load)}`}
+          type={alertType[item.severity]}
+          showIcon
+          banner
+        />
+      ))}
+    </>
+  );
 };
 
-export default Alerts;
\ No newline at end of file
+export default Alerts;
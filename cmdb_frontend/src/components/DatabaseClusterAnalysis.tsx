@@ -1,4 +1,4 @@
-import React, { useState, useEffect, useCallback, useRef } from 'react';
+import React, { useState, useEffect, useRef } from 'react';
 import { Select, Layout, Card, Row, Col, Input, Button, message, DatePicker, ConfigProvider } from 'antd';
 import axios from 'axios';
 import html2canvas from 'html2canvas';
@@ -8,6 +8,7 @@ import ResourceAlerts from './ResourceAlerts';
 import DiskFullPrediction from './DiskFullPrediction';
 import ClusterResourceDetail from './ClusterResourceDetail';
 import { ServerResource } from '../types/ServerResource';
+import { ResourceAlert } from '../types/ResourceAlert';
 import dayjs, { Dayjs } from 'dayjs';
 import zhCN from 'antd/es/locale/zh_CN'; // 引入 antd 的中文本地化
 import 'dayjs/locale/zh-cn'; // 引入 dayjs 的中文本地化
@@ -32,9 +33,7 @@ const DatabaseClusterAnalysis: React.FC = () => {
     const [selectedGroups, setSelectedGroups] = useState<string[]>([]);
     const [selectedDepartments, setSelectedDepartments] = useState<string[]>([]);
     const [serverResources, setServerResources] = useState<ServerResource[]>([]);
-    const [lowThreshold, setLowThreshold] = useState<number>(10);
-    const [highThreshold, setHighThreshold] = useState<number>(80);
-    const [triggerUpdate, setTriggerUpdate] = useState<number>(0);
+    const [resourceAlerts, setResourceAlerts] = useState<ResourceAlert[]>([]);
     const [emailAddress, setEmailAddress] = useState<string>('');
     const [dateRange, setDateRange] = useState<RangeValue>(null);
     const contentRef = useRef<HTMLDivElement>(null);
@@ -51,6 +50,15 @@ const DatabaseClusterAnalysis: React.FC = () => {
 
         // Fetch server resources from the backend
         fetchServerResources();
+
+        // 告警由后端按告警规则统一评估
+        axios.get<ResourceAlert[]>('/api/cmdb/v1/resource-alerts')
+            .then(response => {
+                setResourceAlerts(response.data);
+            })
+            .catch(error => {
+                console.error('Error fetching resource alerts:', error);
+            });
     }, []);
 
     const fetchServerResources = (startDate?: string, endDate?: string) => {
@@ -77,23 +85,6 @@ const DatabaseClusterAnalysis: React.FC = () => {
         setSelectedDepartments(value);
     };
 
-    const handleThresholdChange = useCallback((type: 'low' | 'high', value: number) => {
-        if (type === 'low') {
-            if (value >= highThreshold) {
-                message.error('低阈值不能大于或等于高阈值');
-                return;
-            }
-            setLowThreshold(value);
-        } else {
-            if (value <= lowThreshold) {
-                message.error('高阈值不能小于或等于低阈值');
-                return;
-            }
-            setHighThreshold(value);
-        }
-        setTriggerUpdate(prev => prev + 1);
-    }, [lowThreshold, highThreshold]);
-
     const handleDateRangeChange = (dates: RangeValue, dateStrings: [string, string]) => {
         setDateRange(dates);
         if (dates) {
@@ -109,6 +100,12 @@ const DatabaseClusterAnalysis: React.FC = () => {
         return groupMatch && departmentMatch;
     });
 
+    const filteredAlerts = resourceAlerts.filter(alert => {
+        const groupMatch = selectedGroups.length === 0 || selectedGroups.includes(alert.group_name);
+        const departmentMatch = selectedDepartments.length === 0 || selectedDepartments.includes(alert.department_name);
+        return groupMatch && departmentMatch;
+    });
+
     const clusterResourceData = filteredData.reduce((acc, resource) => {
         const existingCluster = acc.find(item => item.clusterName === resource.cluster_name);
         const memoryUsage = (resource.used_memory / resource.total_memory) * 100;
@@ -190,7 +187,7 @@ const DatabaseClusterAnalysis: React.FC = () => {
                 });
           
                 if (response.data.success) {
-                  message.success('邮件发送成功');
+                  message.success('邮件已加入发送队列');
                 } else {
                   message.error('邮件发送失败');
                 }
@@ -263,45 +260,18 @@ const DatabaseClusterAnalysis: React.FC = () => {
                         <Col span={12} key="send-email-button">
                             <Button onClick={handleSendEmail}>发送页面截图到邮箱</Button>
                         </Col>
-                        <Col span={12} key="low-threshold">
-                            低水位阈值
-                            <Input
-                                type="number"
-                                placeholder="低阈值 (%)"
-                                value={lowThreshold}
-                                onChange={(e) => handleThresholdChange('low', Number(e.target.value))}
-                            />
-                        </Col>
-                        <Col span={12} key="high-threshold">
-                            高水位阈值
-                            <Input
-                                type="number"
-                                placeholder="高阈值 (%)"
-                                value={highThreshold}
-                                onChange={(e) => handleThresholdChange('high', Number(e.target.value))}
-                            />
-                        </Col>
                     </Row>
                     <div ref={contentRef}>
                         <Row gutter={[16, 16]}>
                             <Col span={24} key="resource-alerts">
                                 <Card title="资源警报">
-                                    <Alerts 
-                                        data={serverResources} 
-                                        lowThreshold={lowThreshold} 
-                                        highThreshold={highThreshold}
-                                        triggerUpdate={triggerUpdate}
-                                        selectedGroups={selectedGroups}
-                                    />
+                                    <Alerts alerts={filteredAlerts} />
                                 </Card>
                             </Col>
                             <Col span={24} key="resource-alerts-details">
                                 <Card title="资源警报详情">
                                     <ResourceAlerts 
-                                        data={filteredData} 
-                                        lowThreshold={lowThreshold} 
-                                        highThreshold={highThreshold}
-                                        triggerUpdate={triggerUpdate}
+                                        alerts={filteredAlerts} 
                                         pagination={{
                                             showSizeChanger: true,
                                             showQuickJumper: true,
@@ -1,6 +1,7 @@
 import React, { useState, useEffect, useCallback } from 'react';
 import axios from 'axios';
 import { Table, Input, Select, Button, Space, Modal } from 'antd';
+import type { SorterResult } from 'antd/es/table/interface';
 import HostDetail from './HostDetail';
 
 // 更新 Host 接口
@@ -49,11 +50,28 @@ interface Application {
   update_time: string;
 }
 
+interface HostPage {
+  items: Host[];
+  total: number;
+  page: number;
+  page_size: number;
+}
+
+interface HostFilterOptions {
+  idcs: string[];
+  server_types: string[];
+  department_names: string[];
+}
+
 const { Option } = Select;
 
 const HostList: React.FC = () => {
   const [hosts, setHosts] = useState<Host[]>([]);
-  const [filteredHosts, setFilteredHosts] = useState<Host[]>([]);
+  const [total, setTotal] = useState(0);
+  const [page, setPage] = useState(1);
+  const [pageSize, setPageSize] = useState(10);
+  const [sort, setSort] = useState<{ field?: string; order?: string }>({});
+  const [options, setOptions] = useState<HostFilterOptions>({ idcs: [], server_types: [], department_names: [] });
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState<string | null>(null);
   const [ipFilter, setIpFilter] = useState('');
@@ -63,35 +81,34 @@ const HostList: React.FC = () => {
   const [selectedHost, setSelectedHost] = useState<Host | null>(null);
   const [isModalVisible, setIsModalVisible] = useState(false);
 
-  const getIDCNameFromIP = (ip: string): string => {
-    const parts = ip.split('.');
-    if (parts.length >= 2) {
-      switch (parts[1]) {
-        case '1':
-          return 'P1';
-        case '2':
-          return 'P2';
-        case '3':
-          return 'P3';
-        case '4':
-          return 'P4';
-        case '5':
-          return 'P5';
-        case '6':
-          return 'P6';
-        default:
-          return 'Unknown-IDC';
-      }
-    }
-    return 'Unknown-IDC';
-  };
-
   useEffect(() => {
+    axios.get<HostFilterOptions>('/api/cmdb/v1/host-filter-options')
+      .then(response => setOptions(response.data))
+      .catch(error => console.error('Error fetching filter options:', error));
+  }, []);
+
+  // 筛选、排序和分页都由后端完成
+  const fetchHosts = useCallback(() => {
     setLoading(true);
-    axios.get('/api/cmdb/v1/get_hosts_pool_detail')
+    const params = new URLSearchParams();
+    params.append('page', String(page));
+    params.append('page_size', String(pageSize));
+    if (sort.field && sort.order) {
+      params.append('sort', sort.field);
+      params.append('order', sort.order === 'descend' ? 'desc' : 'asc');
+    }
+    if (ipFilter) {
+      params.append('ip', ipFilter);
+    }
+    datacenterFilter.forEach(v => params.append('idc', v));
+    appTypeFilter.forEach(v => params.append('server_type', v));
+    departmentFilter.forEach(v => params.append('department_name', v));
+
+    axios.get<HostPage>('/api/cmdb/v1/get_hosts_pool_detail', { params })
       .then(response => {
-        setHosts(response.data);
-        setFilteredHosts(response.data);
+        setHosts(response.data.items);
+        setTotal(response.data.total);
+        setError(null);
         setLoading(false);
       })
       .catch(error => {
@@ -99,42 +116,18 @@ const HostList: React.FC = () => {
         setError('Failed to fetch hosts data');
         setLoading(false);
         setHosts([]);
-        setFilteredHosts([]);
+        setTotal(0);
       });
-  }, []);
-
-  const applyFilters = useCallback(() => {
-    let filtered = hosts;
+  }, [page, pageSize, sort, ipFilter, datacenterFilter, appTypeFilter, departmentFilter]);
 
-    if (ipFilter) {
-      filtered = filtered.filter(host => host.host_ip.includes(ipFilter));
-    }
-
-    if (datacenterFilter.length > 0) {
-      filtered = filtered.filter(host => {
-        const idcName = getIDCNameFromIP(host.host_ip);
-        return datacenterFilter.includes(idcName);
-      });
-    }
-
-    if (appTypeFilter.length > 0) {
-      filtered = filtered.filter(host => 
-        host.host_applications.some(app => appTypeFilter.includes(app.server_type))
-      );
-    }
-
-    if (departmentFilter.length > 0) {
-      filtered = filtered.filter(host => 
-        host.host_applications.some(app => departmentFilter.includes(app.department_name))
-      );
-    }
-
-    setFilteredHosts(filtered);
-  }, [hosts, ipFilter, datacenterFilter, appTypeFilter, departmentFilter]);
+  useEffect(() => {
+    fetchHosts();
+  }, [fetchHosts]);
 
+  // 筛选条件变化时回到第一页
   useEffect(() => {
-    applyFilters();
-  }, [ipFilter, datacenterFilter, appTypeFilter, departmentFilter, hosts, applyFilters]);
+    setPage(1);
+  }, [ipFilter, datacenterFilter, appTypeFilter, departmentFilter]);
 
   const showHostDetail = (host: Host) => {
     setSelectedHost(host);
@@ -146,7 +139,7 @@ const HostList: React.FC = () => {
       title: '主机名',
       dataIndex: 'host_name',
       key: 'host_name',
-      sorter: (a: Host, b: Host) => a.host_name.localeCompare(b.host_name),
+      sorter: true,
       render: (text: string, record: Host) => (
         <Button type="link" onClick={() => showHostDetail(record)}>
           {text}
@@ -157,39 +150,35 @@ const HostList: React.FC = () => {
       title: 'IP地址',
       dataIndex: 'host_ip',
       key: 'host_ip',
-      sorter: (a: Host, b: Host) => a.host_ip.localeCompare(b.host_ip),
+      sorter: true,
     },
     {
       title: 'CPU核数',
       dataIndex: 'vcpus',
       key: 'vcpus',
-      sorter: (a: Host, b: Host) => a.vcpus - b.vcpus,
+      sorter: true,
     },
     {
       title: '内存大小(GB)',
       dataIndex: 'ram',
       key: 'ram',
-      sorter: (a: Host, b: Host) => a.ram - b.ram,
+      sorter: true,
     },
     {
       title: '硬盘空间(GB)',
       dataIndex: 'disk_size',
       key: 'disk_size',
-      sorter: (a: Host, b: Host) => a.disk_size - b.disk_size,
+      sorter: true,
     },
     {
       title: '主机类型',
       dataIndex: 'host_type',
       key: 'host_type',
-      sorter: (a: Host, b: Host) => a.host_type.localeCompare(b.host_type),
+      sorter: true,
       render: (text: string) => text === '0' ? '云主机' : '裸金属',
     },
   ];
 
-  if (loading) {
-    return <div>Loading...</div>;
-  }
-
   if (error) {
     return <div>Error: {error}</div>;
   }
@@ -210,12 +199,9 @@ const HostList: React.FC = () => {
           onChange={(value) => setDatacenterFilter(value)}
           style={{ width: 200 }}
         >
-          <Option value="P1">P1</Option>
-          <Option value="P2">P2</Option>
-          <Option value="P3">P3</Option>
-          <Option value="P4">P4</Option>
-          <Option value="P5">P5</Option>
-          <Option value="P6">P6</Option>
+          {options.idcs.map(idc => (
+            <Option key={idc} value={idc}>{idc}</Option>
+          ))}
         </Select>
         <Select
           mode="multiple"
@@ -224,9 +210,7 @@ const HostList: React.FC = () => {
           onChange={(value) => setAppTypeFilter(value)}
           style={{ width: 200 }}
         >
-          {Array.from(new Set(hosts.flatMap(host => 
-            host.host_applications ? host.host_applications.map(app => app.server_type) : []
-          ))).map(type => (
+          {options.server_types.map(type => (
             <Option key={type} value={type}>{type}</Option>
           ))}
         </Select>
@@ -237,9 +221,7 @@ const HostList: React.FC = () => {
           onChange={(value) => setDepartmentFilter(value)}
           style={{ width: 200 }}
         >
-          {Array.from(new Set(hosts.flatMap(host => 
-            host.host_applications ? host.host_applications.map(app => app.department_name) : []
-          ))).map(dept => (
+          {options.department_names.map(dept => (
             <Option key={dept} value={dept}>{dept}</Option>
           ))}
         </Select>
@@ -252,18 +234,22 @@ const HostList: React.FC = () => {
       </Space>
       <Table
         columns={columns}
-        dataSource={filteredHosts.map(host => ({ ...host, key: host.id }))}
+        dataSource={hosts.map(host => ({ ...host, key: host.id }))}
         rowKey="id"
         loading={loading}
         pagination={{
+          current: page,
+          pageSize: pageSize,
+          total: total,
           showSizeChanger: true,
           showQuickJumper: true,
           pageSizeOptions: ['5', '10', '20', '50'],
-          defaultPageSize: 5,
         }}
         onChange={(pagination, filters, sorter) => {
-          // 如果需要，可以在这里处理排序变化
-          console.log('sorter', sorter);
+          const s = sorter as SorterResult<Host>;
+          setPage(pagination.current ?? 1);
+          setPageSize(pagination.pageSize ?? 10);
+          setSort({ field: s.field as string | undefined, order: s.order ?? undefined });
         }}
       />
       <Modal
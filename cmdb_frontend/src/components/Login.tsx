@@ -0,0 +1,49 @@
+import React, { useState } from 'react';
+import { Button, Card, Divider, Form, Input, message } from 'antd';
+import axios from 'axios';
+import { login } from '../auth';
+
+interface LoginForm {
+  username: string;
+  password: string;
+}
+
+const Login: React.FC = () => {
+  const [loading, setLoading] = useState(false);
+
+  const handleSubmit = async (values: LoginForm) => {
+    setLoading(true);
+    try {
+      await login(values.username, values.password);
+    } catch (error) {
+      const text = axios.isAxiosError(error) ? error.response?.data?.error : undefined;
+      message.error(text || '登录失败');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <div style={{ display: 'flex', justifyContent: 'center', paddingTop: 120 }}>
+      <Card title="登录" style={{ width: 360 }}>
+        <Form<LoginForm> layout="vertical" onFinish={handleSubmit}>
+          <Form.Item name="username" label="用户名" rules={[{ required: true, message: '请输入用户名' }]}>
+            <Input autoComplete="username" autoFocus />
+          </Form.Item>
+          <Form.Item name="password" label="密码" rules={[{ required: true, message: '请输入密码' }]}>
+            <Input.Password autoComplete="current-password" />
+          </Form.Item>
+          <Button type="primary" htmlType="submit" loading={loading} block>
+            登录
+          </Button>
+        </Form>
+        <Divider plain>或</Divider>
+        <Button block href="/api/cmdb/v1/auth/oidc/login">
+          单点登录
+        </Button>
+      </Card>
+    </div>
+  );
+};
+
+export default Login;
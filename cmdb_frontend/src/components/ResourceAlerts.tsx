@@ -1,87 +1,81 @@
-import React, { useMemo } from 'react';
-import { Table, TablePaginationConfig } from 'antd';
-import { ServerResource } from '../types/ServerResource';
+import React from 'react';
+import { Table, Tag, TablePaginationConfig } from 'antd';
+import dayjs from 'dayjs';
+import { ResourceAlert } from '../types/ResourceAlert';
 
 interface ResourceAlertsProps {
-  data: ServerResource[];
-  lowThreshold: number;
-  highThreshold: number;
-  triggerUpdate: number;
+  alerts: ResourceAlert[];
   pagination?: TablePaginationConfig; // 添加 pagination 属性
 }
 
-const ResourceAlerts: React.FC<ResourceAlertsProps> = ({ data, lowThreshold, highThreshold, triggerUpdate, pagination }) => {
-  const filteredData = useMemo(() => {
-    return data.filter(item => {
-      const cpuUsage = item.cpu_load;
-      const memoryUsage = (item.used_memory / item.total_memory) * 100;
-      const diskUsage = (item.used_disk / item.total_disk) * 100;
-      
-      return cpuUsage < lowThreshold || cpuUsage > highThreshold ||
-             memoryUsage < lowThreshold || memoryUsage > highThreshold ||
-             diskUsage < lowThreshold || diskUsage > highThreshold;
-    });
-  }, [data, lowThreshold, highThreshold, triggerUpdate]);
+const severityColor = { critical: 'red', warning: 'orange', info: 'blue' };
+const severityRank = { critical: 3, warning: 2, info: 1 };
 
+const compareNullable = (a: number | null, b: number | null) => (a ?? -Infinity) - (b ?? -Infinity);
+const formatValue = (value: number | null) => (value === null ? '-' : `${value.toFixed(2)}%`);
+
+const ResourceAlerts: React.FC<ResourceAlertsProps> = ({ alerts, pagination }) => {
   const columns = [
     {
-      title: 'ID',
-      dataIndex: 'id',
-      key: 'id',
-      sorter: (a: ServerResource, b: ServerResource) => a.id - b.id,
+      title: 'Severity',
+      dataIndex: 'severity',
+      key: 'severity',
+      sorter: (a: ResourceAlert, b: ResourceAlert) => severityRank[a.severity] - severityRank[b.severity],
+      render: (severity: ResourceAlert['severity']) => <Tag color={severityColor[severity]}>{severity}</Tag>,
+    },
+    {
+      title: 'Rule',
+      dataIndex: 'rule_name',
+      key: 'rule_name',
+      sorter: (a: ResourceAlert, b: ResourceAlert) => a.rule_name.localeCompare(b.rule_name),
     },
     {
       title: 'IP',
       dataIndex: 'ip',
       key: 'ip',
-      sorter: (a: ServerResource, b: ServerResource) => a.ip.localeCompare(b.ip),
+      sorter: (a: ResourceAlert, b: ResourceAlert) => a.ip.localeCompare(b.ip),
     },
     {
       title: 'Cluster Name',
       dataIndex: 'cluster_name',
       key: 'cluster_name',
-      sorter: (a: ServerResource, b: ServerResource) => a.cluster_name.localeCompare(b.cluster_name),
+      sorter: (a: ResourceAlert, b: ResourceAlert) => a.cluster_name.localeCompare(b.cluster_name),
     },
     {
       title: 'CPU Usage',
-      dataIndex: 'cpuUsage',
       key: 'cpuUsage',
-      sorter: (a: ServerResource, b: ServerResource) => a.cpu_load - b.cpu_load,
-      render: (text: number, record: ServerResource) => {
-        const usage = record.cpu_load;
-        return <span style={{ color: usage < lowThreshold ? 'green' : usage > highThreshold ? 'red' : 'inherit' }}>{usage.toFixed(2)}%</span>;
-      },
+      sorter: (a: ResourceAlert, b: ResourceAlert) => compareNullable(a.values.cpu_load, b.values.cpu_load),
+      render: (_: unknown, record: ResourceAlert) => formatValue(record.values.cpu_load),
     },
     {
       title: 'Memory Usage',
-      dataIndex: 'memoryUsage',
       key: 'memoryUsage',
-      sorter: (a: ServerResource, b: ServerResource) => (a.used_memory / a.total_memory) - (b.used_memory / b.total_memory),
-      render: (text: number, record: ServerResource) => {
-        const usage = (record.used_memory / record.total_memory) * 100;
-        return <span style={{ color: usage < lowThreshold ? 'green' : usage > highThreshold ? 'red' : 'inherit' }}>{usage.toFixed(2)}%</span>;
-      },
+      sorter: (a: ResourceAlert, b: ResourceAlert) => compareNullable(a.values.memory_usage, b.values.memory_usage),
+      render: (_: unknown, record: ResourceAlert) => formatValue(record.values.memory_usage),
     },
     {
       title: 'Disk Usage',
-      dataIndex: 'diskUsage',
       key: 'diskUsage',
-      sorter: (a: ServerResource, b: ServerResource) => (a.used_disk / a.total_disk) - (b.used_disk / b.total_disk),
-      render: (text: number, record: ServerResource) => {
-        const usage = (record.used_disk / record.total_disk) * 100;
-        return <span style={{ color: usage < lowThreshold ? 'green' : usage > highThreshold ? 'red' : 'inherit' }}>{usage.toFixed(2)}%</span>;
-      },
+      sorter: (a: ResourceAlert, b: ResourceAlert) => compareNullable(a.values.disk_usage, b.values.disk_usage),
+      render: (_: unknown, record: ResourceAlert) => formatValue(record.values.disk_usage),
+    },
+    {
+      title: 'Since',
+      dataIndex: 'since',
+      key: 'since',
+      sorter: (a: ResourceAlert, b: ResourceAlert) => dayjs(a.since).valueOf() - dayjs(b.since).valueOf(),
+      render: (since: string) => dayjs(since).format('YYYY-MM-DD HH:mm'),
     },
   ];
 
   return (
-    <Table columns={columns} dataSource={filteredData} rowKey={(record) => `${record.instance_id}-${record.ip}`} pagination={{
-      showSizeChanger: true,
-      showQuickJumper: true,
-      pageSizeOptions: ['5', '10', '20', '50'],
-      defaultPageSize: 5,
-    }} />
+    <Table
+      columns={columns}
+      dataSource={alerts}
+      rowKey={(record) => `${record.rule_id}-${record.pool_id}-${record.ip}-${record.port}`}
+      pagination={pagination}
+    />
   );
 }
 
-export default ResourceAlerts;
\ No newline at end of file
+export default ResourceAlerts;
@@ -0,0 +1,21 @@
+// 后端按告警规则评估出的告警，对应 GET /api/cmdb/v1/resource-alerts
+export interface ResourceAlert {
+  rule_id: number;
+  rule_name: string;
+  severity: 'info' | 'warning' | 'critical';
+  expression: string;
+  for: string;
+  pool_id: number;
+  ip: string;
+  port: number;
+  cluster_name: string;
+  group_name: string;
+  department_name: string;
+  values: {
+    memory_usage: number | null;
+    disk_usage: number | null;
+    cpu_load: number | null;
+    days_to_full: number | null;
+  };
+  since: string;
+}